@@ -838,7 +838,7 @@ func createNodeInstance(consulStore consulutil.ConsulStore, deploymentID, nodeNa
 
 // DoesNodeExist checks if a given node exist in a deployment
 func DoesNodeExist(ctx context.Context, deploymentID, nodeName string) (bool, error) {
-	exist, err := storage.GetStore(types.StoreTypeDeployment).Exist(path.Join(consulutil.DeploymentKVPrefix, deploymentID, "topology/nodes", nodeName))
+	exist, err := storage.GetStore(types.StoreTypeDeployment).Exist(ctx, path.Join(consulutil.DeploymentKVPrefix, deploymentID, "topology/nodes", nodeName))
 	if err != nil {
 		return false, err
 	}
@@ -32,6 +32,9 @@ const DockerHubURL = "https://hub.docker.com/"
 // SingularityHubURL is the official URL for the docker hub
 const SingularityHubURL = "https://singularity-hub.org/"
 
+// SylabsLibraryURL is the official URL for the Sylabs cloud library
+const SylabsLibraryURL = "https://library.sylabs.io/"
+
 func getRepository(ctx context.Context, deploymentID, repoName string) (bool, *tosca.Repository, error) {
 	repository := new(tosca.Repository)
 	repoPath := path.Join(consulutil.DeploymentKVPrefix, deploymentID, "topology", "repositories", repoName)
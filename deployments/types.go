@@ -234,7 +234,7 @@ func getTypeArtifacts(ctx context.Context, deploymentID, typeName string) (tosca
 }
 
 func checkIfTypeExists(typePath string) (bool, error) {
-	return storage.GetStore(types.StoreTypeDeployment).Exist(typePath)
+	return storage.GetStore(types.StoreTypeDeployment).Exist(context.Background(), typePath)
 }
 
 func locateTypeKey(deploymentID, typeName string) (string, error) {
@@ -84,5 +84,8 @@ func TestRunConsulEventsPackageTests(t *testing.T) {
 		t.Run("TestPurgeDeploymentLogs", func(t *testing.T) {
 			testPurgeDeploymentLogs(t)
 		})
+		t.Run("TestLogsEventsFromLevel", func(t *testing.T) {
+			testLogsEventsFromLevel(t)
+		})
 	})
 }
@@ -27,7 +27,6 @@ import (
 	"github.com/ystia/yorc/v4/helper/consulutil"
 	"github.com/ystia/yorc/v4/log"
 	"github.com/ystia/yorc/v4/storage"
-	"github.com/ystia/yorc/v4/storage/store"
 	"github.com/ystia/yorc/v4/storage/types"
 )
 
@@ -227,19 +226,19 @@ func PublishAndLogWorkflowStatusChange(ctx context.Context, deploymentID, taskID
 	return id, nil
 }
 
-func getLogsOrEvents(ctx context.Context, deploymentID string, waitIndex uint64, timeout time.Duration, isEvents bool) ([]json.RawMessage, uint64, error) {
+func getLogsOrEvents(ctx context.Context, deploymentID string, levels []string, waitIndex uint64, timeout time.Duration, isEvents bool) ([]json.RawMessage, uint64, error) {
 	logsOrEvents := make([]json.RawMessage, 0)
 
 	var pathPrefix string
-	var usedStore store.Store
+	var storeType types.StoreType
 	var data string
 	if isEvents {
 		pathPrefix = path.Clean(consulutil.EventsPrefix)
-		usedStore = storage.GetStore(types.StoreTypeEvent)
+		storeType = types.StoreTypeEvent
 		data = "events"
 	} else {
 		pathPrefix = path.Clean(consulutil.LogsPrefix)
-		usedStore = storage.GetStore(types.StoreTypeLog)
+		storeType = types.StoreTypeLog
 		data = "logs"
 	}
 
@@ -248,7 +247,7 @@ func getLogsOrEvents(ctx context.Context, deploymentID string, waitIndex uint64,
 		pathPrefix = path.Join(pathPrefix, deploymentID)
 	}
 	pathPrefix = pathPrefix + "/"
-	kvps, lastIndex, err := usedStore.List(ctx, pathPrefix, waitIndex, timeout)
+	kvps, lastIndex, err := storage.ListLevels(ctx, storeType, pathPrefix, levels, waitIndex, timeout)
 	if err != nil || lastIndex == 0 {
 		return logsOrEvents, 0, err
 	}
@@ -263,12 +262,19 @@ func getLogsOrEvents(ctx context.Context, deploymentID string, waitIndex uint64,
 
 // StatusEvents return a list of events (StatusUpdate instances) for all, or a given deployment
 func StatusEvents(ctx context.Context, deploymentID string, waitIndex uint64, timeout time.Duration) ([]json.RawMessage, uint64, error) {
-	return getLogsOrEvents(ctx, deploymentID, waitIndex, timeout, true)
+	return getLogsOrEvents(ctx, deploymentID, nil, waitIndex, timeout, true)
 }
 
 // LogsEvents allows to return logs from Consul KV storage for all, or a given deployment
 func LogsEvents(ctx context.Context, deploymentID string, waitIndex uint64, timeout time.Duration) ([]json.RawMessage, uint64, error) {
-	return getLogsOrEvents(ctx, deploymentID, waitIndex, timeout, false)
+	return getLogsOrEvents(ctx, deploymentID, nil, waitIndex, timeout, false)
+}
+
+// LogsEventsFromLevel works as LogsEvents but only returns the logs having the given level or a higher one,
+// levels being ordered as DEBUG < INFO < WARN < ERROR.
+// The filter is applied by the logs store when supported, so that other logs are not retrieved.
+func LogsEventsFromLevel(ctx context.Context, deploymentID string, minLevel LogLevel, waitIndex uint64, timeout time.Duration) ([]json.RawMessage, uint64, error) {
+	return getLogsOrEvents(ctx, deploymentID, levelsFrom(minLevel), waitIndex, timeout, false)
 }
 
 // GetStatusEventsIndex returns the latest index of InstanceStatus events for a given deployment
@@ -699,6 +699,29 @@ func testPurgeDeploymentLogs(t *testing.T) {
 	require.Len(t, rawEvents, 1)
 }
 
+func testLogsEventsFromLevel(t *testing.T) {
+	ctx := context.Background()
+	deploymentID := "testLogsEventsFromLevel"
+	SimpleLogEntry(ctx, LogLevelDEBUG, deploymentID).RegisterAsString("debug")
+	SimpleLogEntry(ctx, LogLevelINFO, deploymentID).RegisterAsString("info")
+	SimpleLogEntry(ctx, LogLevelWARN, deploymentID).RegisterAsString("warn")
+	SimpleLogEntry(ctx, LogLevelERROR, deploymentID).RegisterAsString("error")
+
+	logs, lastIndex, err := LogsEventsFromLevel(ctx, deploymentID, LogLevelWARN, 0, 5*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, logs, 2)
+	require.Equal(t, "warn", getLogContent(t, logs[0]))
+	require.Equal(t, "error", getLogContent(t, logs[1]))
+	// The last index is the one of all the logs, not only the returned ones
+	_, allLastIndex, err := LogsEvents(ctx, deploymentID, 0, 5*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, allLastIndex, lastIndex)
+
+	logs, _, err = LogsEventsFromLevel(ctx, deploymentID, LogLevelDEBUG, 0, 5*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, logs, 4)
+}
+
 func getLogContent(t *testing.T, log []byte) string {
 	var data map[string]interface{}
 	err := json.Unmarshal(log, &data)
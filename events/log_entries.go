@@ -76,6 +76,12 @@ const (
 
 	// TaskExecutionID is the field type representing the task execution ID in log entry
 	TaskExecutionID
+
+	// WorkflowStepID is the field type representing the workflow step name in log entry
+	WorkflowStepID
+
+	// JobID is the field type representing the ID of a job submitted to an infrastructure scheduler in log entry
+	JobID
 )
 
 // String allows to stringify the field type enumeration in JSON standard
@@ -97,6 +103,10 @@ func (ft FieldType) String() string {
 		return "type"
 	case TaskExecutionID:
 		return "alienTaskId"
+	case WorkflowStepID:
+		return "stepId"
+	case JobID:
+		return "jobId"
 	}
 	return ""
 }
@@ -116,6 +126,23 @@ ERROR
 */
 type LogLevel int
 
+// Log levels from the least to the most severe
+var logLevelsBySeverity = []LogLevel{LogLevelDEBUG, LogLevelINFO, LogLevelWARN, LogLevelERROR}
+
+// Returns the names of the given level and of the more severe ones.
+func levelsFrom(minLevel LogLevel) []string {
+	levels := make([]string, 0, len(logLevelsBySeverity))
+	for i, level := range logLevelsBySeverity {
+		if level == minLevel {
+			for _, l := range logLevelsBySeverity[i:] {
+				levels = append(levels, l.String())
+			}
+			break
+		}
+	}
+	return levels
+}
+
 // SimpleLogEntry allows to return a LogEntry instance with log level and deploymentID
 func SimpleLogEntry(ctx context.Context, level LogLevel, deploymentID string) *LogEntry {
 	return &LogEntry{
@@ -178,3 +178,23 @@ func TestContext(t *testing.T) {
 	assert.Len(t, rootLogOpts, 1)
 
 }
+
+func TestLevelsFrom(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		minLevel LogLevel
+		want     []string
+	}{
+		{"Debug", LogLevelDEBUG, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{"Info", LogLevelINFO, []string{"INFO", "WARN", "ERROR"}},
+		{"Warn", LogLevelWARN, []string{"WARN", "ERROR"}},
+		{"Error", LogLevelERROR, []string{"ERROR"}},
+		{"Unknown", LogLevel(42), []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, levelsFrom(tt.minLevel))
+		})
+	}
+}
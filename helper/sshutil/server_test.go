@@ -34,6 +34,8 @@ func defaultExecCommandHandler(command string) (string, uint32) {
 type serverConfig struct {
 	*ssh.ServerConfig
 	execCommandHandler execCommandHandler
+	// If it returns true, the connection is closed instead of running the command
+	dropConnection func(string) bool
 }
 
 type sshServerConfigCallback func(*serverConfig)
@@ -117,7 +119,11 @@ func newServer(ctx context.Context, cb sshServerConfigCallback) net.Addr {
 				for {
 					var newChannel ssh.NewChannel
 					select {
-					case newChannel = <-chans:
+					case nc, ok := <-chans:
+						if !ok {
+							return
+						}
+						newChannel = nc
 					case <-ctx.Done():
 						return
 					}
@@ -148,6 +154,10 @@ func newServer(ctx context.Context, cb sshServerConfigCallback) net.Addr {
 
 								ssh.Unmarshal(req.Payload, &payload)
 
+								if serverConfig.dropConnection != nil && serverConfig.dropConnection(payload.Command) {
+									conn.Close()
+									return
+								}
 								result, status := serverConfig.execCommandHandler(payload.Command)
 								channel.Write([]byte(result))
 
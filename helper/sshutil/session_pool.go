@@ -178,6 +178,21 @@ func (p *pool) removeConn(k string, c1 *conn) {
 	}
 }
 
+// discardConn removes the connection of the given client from the pool and tears it down,
+// so that a new connection is opened for the next sessions.
+func (p *pool) discardConn(client *SSHClient, c *conn) {
+	k := getUserKey(fmt.Sprintf("%s:%d", client.Host, client.Port), client.Config)
+	p.mu.Lock()
+	pc, ok := p.tab[k]
+	if ok && pc == c {
+		delete(p.tab, k)
+	}
+	p.mu.Unlock()
+	if ok && pc == c {
+		c.teardown()
+	}
+}
+
 func (p *pool) dial(ctx context.Context, network, addr string, config *ssh.ClientConfig) (net.Conn, *ssh.Client, error) {
 	dialer := net.Dialer{}
 	netC, err := dialer.DialContext(ctx, network, addr)
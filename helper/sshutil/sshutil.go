@@ -25,6 +25,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,6 +47,12 @@ type Client interface {
 	CopyFile(source io.Reader, remotePath string, permissions string) error
 }
 
+// ErrCommandTimeout is returned when a command run by SSHClient.RunCommand is not completed within the client command timeout
+var ErrCommandTimeout = errors.New("ssh command timed out")
+
+// Values of sensitive variables or options are redacted from logged commands
+var reSensitiveCommandValue = regexp.MustCompile(`(?i)([A-Za-z0-9_.-]*(?:password|passwd|token|secret|credential|private_key)[A-Za-z0-9_.-]*[=:]\s*)('[^']*'|"[^"]*"|\S+)`)
+
 // TODO(loicalbertin) sshSession and SSHSessionWrapper may be merged
 
 // SSHSessionWrapper is a wrapper with a piped SSH session
@@ -85,6 +92,11 @@ type SSHClient struct {
 	Port         int
 	RetryBackoff time.Duration
 	MaxRetries   uint64
+	// RetryMaxBackoff enables an exponential backoff starting at RetryBackoff and limited to RetryMaxBackoff,
+	// the backoff is constant if not set
+	RetryMaxBackoff time.Duration
+	// CommandTimeout is the maximum duration of a command run by RunCommand, there is no limit if not set
+	CommandTimeout time.Duration
 }
 
 // SSHAgent is an SSH agent
@@ -104,7 +116,13 @@ func (client *SSHClient) makeRetryFunc(f func(ctx context.Context) error) func()
 	if backoffDuration <= 0 {
 		backoffDuration = 1
 	}
-	b, _ := retry.NewConstant(backoffDuration)
+	var b retry.Backoff
+	if client.RetryMaxBackoff > backoffDuration {
+		b, _ = retry.NewExponential(backoffDuration)
+		b = retry.WithCappedDuration(client.RetryMaxBackoff, b)
+	} else {
+		b, _ = retry.NewConstant(backoffDuration)
+	}
 	b = retry.WithMaxRetries(client.MaxRetries, b)
 	return func() error {
 		err := retry.Do(context.Background(), b, func(ctx context.Context) error {
@@ -154,23 +172,33 @@ func (client *SSHClient) GetSessionWrapper() (*SSHSessionWrapper, error) {
 func (client *SSHClient) RunCommand(cmd string) (string, error) {
 
 	var res string
+	var attempt uint64
 
 	retryRunCommand := client.makeRetryFunc(func(ctx context.Context) error {
 		var rerr error
+		attempt++
 		res, rerr = client.runCommand(ctx, cmd)
-		if rerr == nil {
-			return nil
-		}
-		var eerr *ssh.ExitError
-		if goerr.As(rerr, &eerr) {
+		if rerr == nil || !isConnectionError(rerr) {
 			return rerr
 		}
+		if attempt <= client.MaxRetries {
+			log.Printf("[SSHSession] command on %s:%d failed with a connection error, retrying (%d/%d): %v",
+				client.Host, client.Port, attempt, client.MaxRetries, rerr)
+		}
 		return retry.RetryableError(rerr)
 	})
 	err := retryRunCommand()
 	return res, errors.WithStack(err)
 }
 
+// Checks whether the error of a command is due to the SSH connection rather than to the command itself.
+// The command may be retried on a new connection in this case. Commands that exited with a non-zero
+// status are not retried, neither are timed out commands as they may have been partially run.
+func isConnectionError(err error) bool {
+	var eerr *ssh.ExitError
+	return !goerr.As(err, &eerr) && !goerr.Is(err, ErrCommandTimeout)
+}
+
 func (client *SSHClient) runCommand(ctx context.Context, cmd string) (string, error) {
 	session, err := client.newSession(ctx)
 	if err != nil {
@@ -179,12 +207,52 @@ func (client *SSHClient) runCommand(ctx context.Context, cmd string) (string, er
 	defer session.Close()
 
 	log.Debugf("[SSHSession] cmd: %q", cmd)
-	stdOutErrBytes, err := session.CombinedOutput(cmd)
+	var stdOutErrBytes []byte
+	if client.CommandTimeout > 0 {
+		stdOutErrBytes, err = client.runCommandWithTimeout(session, cmd)
+	} else {
+		stdOutErrBytes, err = session.CombinedOutput(cmd)
+	}
 	stdOutErrStr := strings.Trim(string(stdOutErrBytes[:]), "\x00")
 	log.Debugf("[SSHSession] stdout/stderr: %q", stdOutErrStr)
+	if err != nil && isConnectionError(err) {
+		// The connection may be broken, a new one is opened by the next session
+		sessionsPool.discardConn(client, session.conn)
+	}
 	return stdOutErrStr, errors.WithStack(err)
 }
 
+// Runs the command on the given session, the remote process is killed if it is not completed within the client command timeout
+func (client *SSHClient) runCommandWithTimeout(session *sshSession, cmd string) ([]byte, error) {
+	type result struct {
+		out []byte
+		err error
+	}
+	chRes := make(chan result, 1)
+	go func() {
+		out, err := session.CombinedOutput(cmd)
+		chRes <- result{out, err}
+	}()
+	timer := time.NewTimer(client.CommandTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-chRes:
+		return res.out, res.err
+	case <-timer.C:
+		log.Printf("[SSHSession] command on %s:%d not completed after %s, a sigkill signal is sent to remote process: %q",
+			client.Host, client.Port, client.CommandTimeout, redactCommand(cmd))
+		session.Signal(ssh.SIGKILL)
+		// The pooled session is released by the caller
+		session.Session.Close()
+		return nil, errors.Wrapf(ErrCommandTimeout, "command on %s:%d not completed after %s", client.Host, client.Port, client.CommandTimeout)
+	}
+}
+
+// Returns the command with the values of sensitive variables or options redacted
+func redactCommand(cmd string) string {
+	return reSensitiveCommandValue.ReplaceAllString(cmd, "${1}<redacted>")
+}
+
 func (client *SSHClient) newSession(ctx context.Context) (*sshSession, error) {
 	session, err := sessionsPool.openSession(ctx, client)
 	if err != nil {
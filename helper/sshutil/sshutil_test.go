@@ -96,13 +96,16 @@ func TestSSHClient_RunCommand(t *testing.T) {
 
 	var trackAttempts int
 	type fields struct {
-		clientConfig *ssh.ClientConfig
-		RetryBackoff time.Duration
-		MaxRetries   uint64
+		clientConfig    *ssh.ClientConfig
+		RetryBackoff    time.Duration
+		MaxRetries      uint64
+		CommandTimeout  time.Duration
+		RetryMaxBackoff time.Duration
 	}
 	type testServerConfig struct {
 		enableAuth bool
 		ech        execCommandHandler
+		drop       func(string) bool
 
 		pkc func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
 	}
@@ -187,6 +190,59 @@ func TestSSHClient_RunCommand(t *testing.T) {
 			Timeout:         2 * time.Second,
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		}}, testServerConfig{}, args{"echo toto"}, "echo toto", false, 0},
+
+		{"CommandTimeoutNotRetried", fields{
+			clientConfig: &ssh.ClientConfig{
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			MaxRetries:     3,
+			CommandTimeout: 100 * time.Millisecond,
+		}, testServerConfig{
+			ech: func(s string) (string, uint32) {
+				trackAttempts++
+				time.Sleep(time.Second)
+				return s, 0
+			},
+		}, args{"sleep 1"}, "", true, 1},
+
+		{"CommandTimeoutNotReached", fields{
+			clientConfig: &ssh.ClientConfig{
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			CommandTimeout: 2 * time.Second,
+		}, testServerConfig{
+			ech: func(s string) (string, uint32) {
+				trackAttempts++
+				return s, 0
+			},
+		}, args{"echo toto"}, "echo toto", false, 1},
+
+		{"ConnectionLostRetriedThenOK", fields{
+			clientConfig: &ssh.ClientConfig{
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			MaxRetries:   3,
+			RetryBackoff: 10 * time.Millisecond,
+		}, testServerConfig{
+			drop: func(s string) bool {
+				trackAttempts++
+				return trackAttempts == 1
+			},
+		}, args{"echo toto"}, "echo toto", false, 2},
+
+		{"ConnectionLostRetriesExhausted", fields{
+			clientConfig: &ssh.ClientConfig{
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			MaxRetries:      2,
+			RetryBackoff:    10 * time.Millisecond,
+			RetryMaxBackoff: 50 * time.Millisecond,
+		}, testServerConfig{
+			drop: func(s string) bool {
+				trackAttempts++
+				return true
+			},
+		}, args{"echo toto"}, "", true, 3},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -201,16 +257,19 @@ func TestSSHClient_RunCommand(t *testing.T) {
 				if tt.serverConfig.pkc != nil {
 					s.PublicKeyCallback = tt.serverConfig.pkc
 				}
+				s.dropConnection = tt.serverConfig.drop
 			})
 			hostPort := strings.Split(addr.String(), ":")
 			port, err := strconv.Atoi(hostPort[1])
 			assert.NilError(t, err)
 			client := &SSHClient{
-				Config:       tt.fields.clientConfig,
-				Host:         hostPort[0],
-				Port:         port,
-				MaxRetries:   tt.fields.MaxRetries,
-				RetryBackoff: tt.fields.RetryBackoff,
+				Config:          tt.fields.clientConfig,
+				Host:            hostPort[0],
+				Port:            port,
+				MaxRetries:      tt.fields.MaxRetries,
+				RetryBackoff:    tt.fields.RetryBackoff,
+				RetryMaxBackoff: tt.fields.RetryMaxBackoff,
+				CommandTimeout:  tt.fields.CommandTimeout,
 			}
 			got, err := client.RunCommand(tt.args.cmd)
 			if (err != nil) != tt.wantErr {
@@ -385,3 +444,21 @@ func TestSSHSessionWrapper_RunCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestRedactCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want string
+	}{
+		{"NothingToRedact", "sbatch -D ~ --job-name='MyJob' ~/b-1.batch", "sbatch -D ~ --job-name='MyJob' ~/b-1.batch"},
+		{"EnvVars", "export SINGULARITY_DOCKER_PASSWORD='my secret';export SINGULARITY_DOCKER_USERNAME=john; sbatch b.batch",
+			"export SINGULARITY_DOCKER_PASSWORD=<redacted>;export SINGULARITY_DOCKER_USERNAME=john; sbatch b.batch"},
+		{"Options", "curl --header token:abc https://host --data api_token=\"x y\"", "curl --header token:<redacted> https://host --data api_token=<redacted>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, redactCommand(tt.cmd), tt.want)
+		})
+	}
+}
@@ -74,11 +74,56 @@ func TestRunConsulSlurmPackageTests(t *testing.T) {
 		t.Run("ExecutionCommonBuildJobInfo", func(t *testing.T) {
 			testExecutionCommonBuildJobInfo(t)
 		})
+		t.Run("ExecutionCommonResumeSubmittedJob", func(t *testing.T) {
+			testExecutionCommonResumeSubmittedJob(t)
+		})
 		t.Run("ExecutionCommonPrepareAndSubmitJob", func(t *testing.T) {
 			testExecutionCommonPrepareAndSubmitJob(t)
 		})
+		t.Run("ExecutionSingularityPrepareAndSubmitJob", func(t *testing.T) {
+			testExecutionSingularityPrepareAndSubmitJob(t)
+		})
+		t.Run("testExecutionSingularityPrepareAndSubmitJobWithEnvFile", func(t *testing.T) {
+			testExecutionSingularityPrepareAndSubmitJobWithEnvFile(t)
+		})
+		t.Run("testExecutionSingularityPrepareAndSubmitJobDryRun", func(t *testing.T) {
+			testExecutionSingularityPrepareAndSubmitJobDryRun(t)
+		})
+		t.Run("testExecutionSingularityPrepareAndSubmitJobBlocking", func(t *testing.T) {
+			testExecutionSingularityPrepareAndSubmitJobBlocking(t)
+		})
+		t.Run("testExecutionSingularityPrepareOverlay", func(t *testing.T) {
+			testExecutionSingularityPrepareOverlay(t)
+		})
+		t.Run("testExecutionSingularityResolveImageURI", func(t *testing.T) {
+			testExecutionSingularityResolveImageURI(t)
+		})
+		t.Run("testExecutionSingularityResolveImageCache", func(t *testing.T) {
+			testExecutionSingularityResolveImageCache(t)
+		})
+		t.Run("testExecutionSingularityAllocation", func(t *testing.T) {
+			testExecutionSingularityAllocation(t)
+		})
+		t.Run("testExecutionSingularityMPIProp", func(t *testing.T) {
+			testExecutionSingularityMPIProp(t)
+		})
 		t.Run("ActionOperatorAnalyzeJob", func(t *testing.T) {
 			testActionOperatorAnalyzeJob(t, srv, cfg)
 		})
+		t.Run("ActionOperatorMonitorPendingJob", func(t *testing.T) {
+			testActionOperatorMonitorPendingJob(t, srv, cfg)
+		})
+		t.Run("ActionOperatorMonitorRequeuedJob", func(t *testing.T) {
+			testActionOperatorMonitorRequeuedJob(t, srv, cfg)
+		})
+		t.Run("ActionOperatorLogFile", func(t *testing.T) {
+			testActionOperatorLogFile(t, srv, cfg)
+		})
+		t.Run("ActionOperatorLogJobSeparateErrorOutput", func(t *testing.T) {
+			testActionOperatorLogJobSeparateErrorOutput(t, srv, cfg)
+		})
+		t.Run("ActionOperatorCheckInstance", func(t *testing.T) {
+			testActionOperatorCheckInstance(t, srv, cfg)
+		})
 	})
 }
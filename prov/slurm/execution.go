@@ -23,6 +23,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +33,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ystia/yorc/v4/config"
@@ -46,9 +49,34 @@ import (
 )
 
 const home = "~"
+
+var reUmask = regexp.MustCompile(`^[0-7]{3,4}$`)
+
 const batchScript = "b-%s.batch"
 const srunCommand = "srun"
 
+// Job cleanup policies
+const (
+	cleanupAlways    = "always"
+	cleanupOnSuccess = "on-success"
+	cleanupNever     = "never"
+)
+
+// Encodings of the job output files content set as attributes
+const (
+	outputFilesText   = "text"
+	outputFilesBase64 = "base64"
+)
+
+// Default names of the job output files: Slurm replaces %j by the job ID, %A by the array job ID and %a by the array task ID.
+// The error output is written to a dedicated file if required, with the same name than the output and another extension.
+const (
+	defaultOutputPattern      = "slurm-%j"
+	defaultArrayOutputPattern = "slurm-%A_%a"
+	outputExt                 = ".out"
+	errorOutputExt            = ".err"
+)
+
 type execution interface {
 	resolveExecution(ctx context.Context) error
 	executeAsync(ctx context.Context) (*prov.Action, time.Duration, error)
@@ -88,6 +116,11 @@ type executionCommon struct {
 	jobInfo        *jobInfo
 	stepName       string
 	isSingularity  bool
+	envVarsInFile  bool
+	// Batch script content which would have been uploaded in dry run mode
+	dryRunScript string
+	// File mode creation mask of the remote session submitting the job, the session default is used if empty
+	umask string
 }
 
 func newExecution(ctx context.Context, cfg config.Configuration, taskID, deploymentID, nodeName, stepName string, operation prov.Operation) (execution, error) {
@@ -165,11 +198,17 @@ func (e *executionCommon) execute(ctx context.Context) error {
 	switch strings.ToLower(e.operation.Name) {
 	case strings.ToLower(tosca.RunnableSubmitOperationName):
 		log.Debugf("Submit the job: %s", e.operation.Name)
+		if resumed, err := e.resumeSubmittedJob(ctx); err != nil || resumed {
+			return err
+		}
 		// Build Job Information
 		if err := e.buildJobInfo(ctx); err != nil {
 			return errors.Wrap(err, "failed to build job information")
 		}
-		if e.jobInfo.ExecutionOptions.Command != "" && e.Primary != "" {
+		if err := e.resolveOutputFileName(ctx, time.Now()); err != nil {
+			return err
+		}
+		if e.hasCommand() && e.Primary != "" {
 			// If both primary artifact is provided (script) and command: return an error
 			return errors.Errorf("Either a script artifact or a command must be provided, but not both.")
 		}
@@ -205,6 +244,9 @@ func (e *executionCommon) execute(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		if e.jobInfo.DryRun {
+			return nil
+		}
 		// Set the JobID attribute
 		// TODO(should be contextual to the current workflow)
 		err = deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "job_id", e.jobInfo.ID)
@@ -212,31 +254,67 @@ func (e *executionCommon) execute(ctx context.Context) error {
 			return errors.Wrap(err, "failed to retrieve job id an manual cleanup may be necessary: ")
 		}
 	case strings.ToLower(tosca.RunnableCancelOperationName):
-		var jobID string
-		if jobInfo, err := e.getJobInfoFromTaskContext(); err != nil {
-			if !tasks.IsTaskDataNotFoundError(err) {
-				return err
-			}
-			// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
-			// Not cancelling within the same task try to get jobID from attribute
-			id, err := deployments.GetInstanceAttributeValue(ctx, e.deploymentID, e.NodeName, "0", "job_id")
-			if err != nil {
-				return err
-			} else if id != nil && id.RawString() != "" {
-				jobID = id.String()
-			}
-			events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelDEBUG, e.deploymentID).Registerf(
-				"Slurm job cancellation called from a dedicated \"cancel\" workflow. JobID retrieved from node %q attribute. This may cause issues if multiple workflows are running in parallel. Prefer using a workflow cancellation.", e.NodeName)
-		} else {
-			jobID = jobInfo.ID
-		}
-		return cancelJobID(jobID, e.client)
+		return e.cancelJob(ctx)
 	default:
 		return errors.Errorf("Unsupported operation %q", e.operation.Name)
 	}
 	return nil
 }
 
+// Cancels the Slurm job submitted by the current task, or the job referenced by the "job_id" attribute
+// if cancellation is called from another task.
+func (e *executionCommon) cancelJob(ctx context.Context) error {
+	var jobID string
+	if jobInfo, err := e.getJobInfoFromTaskContext(); err != nil {
+		if !tasks.IsTaskDataNotFoundError(err) {
+			return err
+		}
+		// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
+		// Not cancelling within the same task try to get jobID from attribute
+		id, err := deployments.GetInstanceAttributeValue(ctx, e.deploymentID, e.NodeName, "0", "job_id")
+		if err != nil {
+			return err
+		} else if id != nil && id.RawString() != "" {
+			jobID = id.String()
+		}
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelDEBUG, e.deploymentID).Registerf(
+			"Slurm job cancellation called from a dedicated \"cancel\" workflow. JobID retrieved from node %q attribute. This may cause issues if multiple workflows are running in parallel. Prefer using a workflow cancellation.", e.NodeName)
+	} else {
+		jobID = jobInfo.ID
+	}
+	if jobID == "" {
+		log.Printf("No Slurm job to cancel for node %q in deployment %q", e.NodeName, e.deploymentID)
+		return nil
+	}
+	ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{events.JobID: jobID})
+	if err := cancelJobID(jobID, e.client); err != nil {
+		return err
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf("Slurm job %q of node %q cancelled", jobID, e.NodeName)
+	return nil
+}
+
+// Checks if the job of the node was already submitted by the current task, which happens when the submit operation
+// is run again after a restart of Yorc. In this case the job is not submitted again, its information stored
+// at submission is used to monitor it.
+func (e *executionCommon) resumeSubmittedJob(ctx context.Context) (bool, error) {
+	jobInfo, err := e.getJobInfoFromTaskContext()
+	if err != nil {
+		if tasks.IsTaskDataNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if jobInfo.ID == "" {
+		return false, nil
+	}
+	e.jobInfo = jobInfo
+	ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{events.JobID: jobInfo.ID})
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+		"Job %q of node %q was already submitted by this task before a restart, resuming its monitoring", jobInfo.ID, e.NodeName)
+	return true, deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "job_id", jobInfo.ID)
+}
+
 func (e *executionCommon) getJobInfoFromTaskContext() (*jobInfo, error) {
 	jobInfoJSON, err := tasks.GetTaskData(e.taskID, e.NodeName+"-jobInfo")
 	if err != nil {
@@ -260,6 +338,40 @@ func (e *executionCommon) buildJobMonitoringAction() *prov.Action {
 	data["nodeName"] = e.NodeName
 	data["workingDir"] = e.jobInfo.WorkingDir
 	data["artifacts"] = strings.Join(e.jobInfo.Artifacts, ",")
+	if e.jobInfo.ErrorOutputLines > 0 {
+		data["errorOutputLines"] = strconv.Itoa(e.jobInfo.ErrorOutputLines)
+	}
+	if e.jobInfo.CleanupPolicy != "" {
+		data["cleanupPolicy"] = e.jobInfo.CleanupPolicy
+	}
+	if e.jobInfo.DryRun {
+		data["dryRun"] = "true"
+	}
+	if e.jobInfo.Blocking {
+		data["blocking"] = "true"
+	}
+	if e.jobInfo.Requeue {
+		data["requeue"] = "true"
+	}
+	if e.jobInfo.SeparateErrorOutput {
+		data["separateErrorOutput"] = "true"
+	}
+	if e.jobInfo.OutputFileName != "" {
+		data["outputFileName"] = e.jobInfo.OutputFileName
+	}
+	if len(e.jobInfo.OutputFiles) > 0 {
+		outputFiles, _ := json.Marshal(e.jobInfo.OutputFiles)
+		data["outputFiles"] = string(outputFiles)
+		data["outputFilesEncoding"] = e.jobInfo.OutputFilesEncoding
+	}
+	if e.jobInfo.PendingTimeout > 0 {
+		data["pendingTimeout"] = e.jobInfo.PendingTimeout.String()
+	}
+	if e.jobInfo.MonitoringMaxTimeInterval > 0 {
+		// Adaptive monitoring: checks are spaced out while the job is running
+		data["monitoringTimeInterval"] = e.jobInfo.MonitoringTimeInterval.String()
+		data["monitoringMaxTimeInterval"] = e.jobInfo.MonitoringMaxTimeInterval.String()
+	}
 
 	return &prov.Action{ActionType: "job-monitoring", Data: data}
 }
@@ -288,6 +400,14 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		}
 	}
 
+	if tpn, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "ntasks_per_node"); err != nil {
+		return err
+	} else if tpn != nil && tpn.RawString() != "" {
+		if e.jobInfo.TasksPerNode, err = strconv.Atoi(tpn.RawString()); err != nil {
+			return err
+		}
+	}
+
 	var nodes = 1
 	if ns, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "nodes"); err != nil {
 		return err
@@ -320,12 +440,35 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		e.jobInfo.MaxTime = maxTime.RawString()
 	}
 
+	if memory, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "memory"); err != nil {
+		return err
+	} else if memory != nil && memory.RawString() != "" {
+		if e.jobInfo.Mem != "" {
+			return errors.Errorf("memory and mem_per_node slurm options can't be both set for node %q", e.NodeName)
+		}
+		if e.jobInfo.Mem, err = parseMemorySize(memory.RawString()); err != nil {
+			return errors.Wrapf(err, "invalid memory slurm option for node %q", e.NodeName)
+		}
+	}
+
+	if walltime, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "walltime"); err != nil {
+		return err
+	} else if walltime != nil && walltime.RawString() != "" {
+		if e.jobInfo.MaxTime != "" {
+			return errors.Errorf("walltime and time slurm options can't be both set for node %q", e.NodeName)
+		}
+		d, err := parsePositiveDuration(walltime.RawString())
+		if err != nil {
+			return errors.Wrapf(err, "invalid walltime slurm option for node %q, expecting a duration (ex: \"1h30m\")", e.NodeName)
+		}
+		e.jobInfo.MaxTime = toSlurmTimeFormat(d)
+	}
+
 	if monitoringTime, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "monitoring_time_interval"); err != nil {
 		return err
 	} else if monitoringTime != nil && monitoringTime.RawString() != "" {
-		e.jobInfo.MonitoringTimeInterval, err = time.ParseDuration(monitoringTime.RawString())
-		if err != nil {
-			return err
+		if e.jobInfo.MonitoringTimeInterval, err = parsePositiveDuration(monitoringTime.RawString()); err != nil {
+			return errors.Wrapf(err, "invalid monitoring_time_interval for node %q", e.NodeName)
 		}
 	}
 	if e.jobInfo.MonitoringTimeInterval == 0 {
@@ -336,6 +479,28 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		}
 	}
 
+	if maxMonitoringTime, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "monitoring_max_time_interval"); err != nil {
+		return err
+	} else if maxMonitoringTime != nil && maxMonitoringTime.RawString() != "" {
+		if e.jobInfo.MonitoringMaxTimeInterval, err = parsePositiveDuration(maxMonitoringTime.RawString()); err != nil {
+			return errors.Wrapf(err, "invalid monitoring_max_time_interval for node %q", e.NodeName)
+		}
+		if e.jobInfo.MonitoringMaxTimeInterval < e.jobInfo.MonitoringTimeInterval {
+			return errors.Errorf("monitoring_max_time_interval (%s) should be greater than the monitoring time interval (%s) for node %q",
+				e.jobInfo.MonitoringMaxTimeInterval, e.jobInfo.MonitoringTimeInterval, e.NodeName)
+		}
+	}
+
+	if pendingTimeout, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "pending_timeout"); err != nil {
+		return err
+	} else if pendingTimeout != nil && pendingTimeout.RawString() != "" {
+		if e.jobInfo.PendingTimeout, err = parsePositiveDuration(pendingTimeout.RawString()); err != nil {
+			return errors.Wrapf(err, "invalid pending_timeout for node %q", e.NodeName)
+		}
+	} else {
+		e.jobInfo.PendingTimeout = e.locationProps.GetDuration("job_pending_timeout")
+	}
+
 	if extra, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "extra_options"); err != nil {
 		return err
 	} else if extra != nil && extra.RawString() != "" {
@@ -368,6 +533,22 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		return errors.Errorf("Job account must be set as configuration enforces accounting")
 	}
 
+	// Quality of service
+	if qos, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "qos"); err != nil {
+		return err
+	} else if qos != nil && qos.RawString() != "" {
+		e.jobInfo.QOS = qos.RawString()
+	} else if e.locationProps.GetBool("enforce_qos") {
+		return errors.Errorf("Job QOS must be set as configuration enforces QOS")
+	}
+
+	// Partition
+	if partition, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "partition"); err != nil {
+		return err
+	} else if partition != nil && partition.RawString() != "" {
+		e.jobInfo.Partition = partition.RawString()
+	}
+
 	// Reservation
 	if res, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "reservation"); err != nil {
 		return err
@@ -375,6 +556,37 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		e.jobInfo.Reservation = res.RawString()
 	}
 
+	// These values are passed as is to Slurm commands
+	for _, opt := range [][2]string{{"partition", e.jobInfo.Partition}, {"qos", e.jobInfo.QOS}, {"account", e.jobInfo.Account}, {"reservation", e.jobInfo.Reservation}} {
+		if opt[1] != "" && !reSlurmName.MatchString(opt[1]) {
+			return errors.Errorf("invalid %s %q for node %q, only letters, digits and the \"_.-\" characters are allowed, several values may be separated by commas", opt[0], opt[1], e.NodeName)
+		}
+	}
+
+	// Node features constraint
+	if constraint, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "constraint"); err != nil {
+		return err
+	} else if constraint != nil && constraint.RawString() != "" {
+		e.jobInfo.Constraint = constraint.RawString()
+		if !reSlurmConstraint.MatchString(e.jobInfo.Constraint) {
+			return errors.Errorf("invalid constraint %q for node %q, expecting features combined by the \"&\", \"|\", \",\", \"*\", brackets or parentheses operators (ex: intel&ib or haswell|broadwell)", e.jobInfo.Constraint, e.NodeName)
+		}
+	}
+
+	// Generic resources
+	if gres, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "gres"); err != nil {
+		return err
+	} else if gres != nil && gres.RawString() != "" {
+		e.jobInfo.Gres = gres.RawString()
+	}
+
+	// Job array
+	if array, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "slurm_options", "array"); err != nil {
+		return err
+	} else if array != nil && array.RawString() != "" {
+		e.jobInfo.Array = array.RawString()
+	}
+
 	// Execution options
 	eo, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "execution_options")
 	if err != nil {
@@ -387,7 +599,10 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		}
 	}
 
-	if e.jobInfo.ExecutionOptions.Command == "" && e.Primary == "" {
+	if e.jobInfo.ExecutionOptions.Command != "" && len(e.jobInfo.ExecutionOptions.CommandLine) > 0 {
+		return errors.Errorf("Either the command or the command_line execution option of node %q must be provided, but not both", e.NodeName)
+	}
+	if !e.hasCommand() && e.Primary == "" {
 		return errors.Errorf("Either job command property must be filled or batch script must be provided")
 	}
 
@@ -400,6 +615,62 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 		e.jobInfo.WorkingDir = home
 	}
 
+	if m, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "modules"); err != nil {
+		return err
+	} else if m != nil && m.RawString() != "" {
+		if err = json.Unmarshal([]byte(m.RawString()), &e.jobInfo.Modules); err != nil {
+			return errors.Wrapf(err, `invalid modules list for node %q`, e.NodeName)
+		}
+	}
+
+	if e.jobInfo.ScriptDirectives, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "batch_script_directives"); err != nil {
+		return err
+	}
+	if e.jobInfo.KeepScript, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "keep_batch_script"); err != nil {
+		return err
+	}
+	if e.jobInfo.DryRun, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "dry_run"); err != nil {
+		return err
+	}
+	if e.jobInfo.CleanupPolicy, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "cleanup_policy", false); err != nil {
+		return err
+	}
+	if e.jobInfo.CleanupPolicy == "" {
+		e.jobInfo.CleanupPolicy = e.locationProps.GetString("job_cleanup_policy")
+	}
+	switch e.jobInfo.CleanupPolicy {
+	case "", cleanupAlways, cleanupOnSuccess, cleanupNever:
+	default:
+		return errors.Errorf("invalid cleanup policy %q for node %q, expecting one of %q, %q or %q", e.jobInfo.CleanupPolicy, e.NodeName, cleanupAlways, cleanupOnSuccess, cleanupNever)
+	}
+	e.umask = e.locationProps.GetString("job_umask")
+	if e.umask != "" && !reUmask.MatchString(e.umask) {
+		return errors.Errorf("invalid job_umask location property value %q, expecting an octal mask such as 0077", e.umask)
+	}
+	if l, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "error_output_lines"); err != nil {
+		return err
+	} else if l != nil && l.RawString() != "" {
+		if e.jobInfo.ErrorOutputLines, err = strconv.Atoi(l.RawString()); err != nil || e.jobInfo.ErrorOutputLines < 0 {
+			return errors.Errorf("invalid error_output_lines property value %q for node %q, expecting a positive integer", l.RawString(), e.NodeName)
+		}
+	}
+
+	if err = e.getSeparateErrorOutputProp(ctx); err != nil {
+		return err
+	}
+
+	if err = e.getOutputFileNameProp(ctx); err != nil {
+		return err
+	}
+
+	if err = e.getDependencyProp(ctx); err != nil {
+		return err
+	}
+
+	if err = e.getOutputFilesProps(ctx); err != nil {
+		return err
+	}
+
 	envFile, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "environment_file")
 	if err != nil {
 		return err
@@ -410,53 +681,211 @@ func (e *executionCommon) buildJobInfo(ctx context.Context) error {
 	return nil
 }
 
+// Retrieves whether the job error output is written to a dedicated file, the output file names are then set by Yorc
+// so they should not be set as extra options
+func (e *executionCommon) getSeparateErrorOutputProp(ctx context.Context) error {
+	var err error
+	if e.jobInfo.SeparateErrorOutput, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "separate_error_output"); err != nil {
+		return err
+	}
+	if !e.jobInfo.SeparateErrorOutput {
+		return nil
+	}
+	if opt, ok := findOutputOpt(e.jobInfo.Opts); ok {
+		return errors.Errorf("node %q writes its error output to a dedicated file, its output files can't be set by the %q extra option", e.NodeName, opt)
+	}
+	return nil
+}
+
+// Retrieves the job output files whose content is set as attributes once the job is completed
+func (e *executionCommon) getOutputFilesProps(ctx context.Context) error {
+	o, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "output_files")
+	if err != nil {
+		return err
+	}
+	if o == nil || o.RawString() == "" {
+		return nil
+	}
+	if err = json.Unmarshal([]byte(o.RawString()), &e.jobInfo.OutputFiles); err != nil {
+		return errors.Wrapf(err, "invalid output_files property for node %q", e.NodeName)
+	}
+	for name, p := range e.jobInfo.OutputFiles {
+		if p == "" {
+			return errors.Errorf("invalid output_files property for node %q, the path of output %q is empty", e.NodeName, name)
+		}
+	}
+	if e.jobInfo.OutputFilesEncoding, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "output_files_encoding", false); err != nil {
+		return err
+	}
+	switch e.jobInfo.OutputFilesEncoding {
+	case "":
+		e.jobInfo.OutputFilesEncoding = outputFilesText
+	case outputFilesText, outputFilesBase64:
+	default:
+		return errors.Errorf("invalid output files encoding %q for node %q, expecting %q or %q", e.jobInfo.OutputFilesEncoding, e.NodeName, outputFilesText, outputFilesBase64)
+	}
+	return nil
+}
+
 func (e *executionCommon) buildJobOpts() string {
 	var opts string
-	opts += fmt.Sprintf(" --job-name='%s'", e.jobInfo.Name)
+	for _, opt := range e.buildJobOptsList(true) {
+		opts += " " + opt
+	}
+	log.Debugf("opts=%q", opts)
+	return opts
+}
+
+// Returns the sbatch job options, string values are single-quoted if quote is true or if they contain spaces
+func (e *executionCommon) buildJobOptsList(quote bool) []string {
+	q := func(v string) string {
+		if quote || strings.ContainsAny(v, " \t") {
+			return "'" + v + "'"
+		}
+		return v
+	}
+	opts := make([]string, 0)
+	opts = append(opts, fmt.Sprintf("--job-name=%s", q(e.jobInfo.Name)))
 	if e.jobInfo.Tasks > 1 {
-		opts += fmt.Sprintf(" --ntasks=%d", e.jobInfo.Tasks)
+		opts = append(opts, fmt.Sprintf("--ntasks=%d", e.jobInfo.Tasks))
+	}
+	opts = append(opts, fmt.Sprintf("--nodes=%d", e.jobInfo.Nodes))
+	if e.jobInfo.TasksPerNode > 0 {
+		opts = append(opts, fmt.Sprintf("--ntasks-per-node=%d", e.jobInfo.TasksPerNode))
 	}
-	opts += fmt.Sprintf(" --nodes=%d", e.jobInfo.Nodes)
 	if e.jobInfo.Mem != "" {
-		opts += fmt.Sprintf(" --mem='%s'", e.jobInfo.Mem)
+		opts = append(opts, fmt.Sprintf("--mem=%s", q(e.jobInfo.Mem)))
 	}
 	if e.jobInfo.Cpus != 0 {
-		opts += fmt.Sprintf(" --cpus-per-task=%d", e.jobInfo.Cpus)
+		opts = append(opts, fmt.Sprintf("--cpus-per-task=%d", e.jobInfo.Cpus))
 	}
 	if e.jobInfo.MaxTime != "" {
-		opts += fmt.Sprintf(" --time='%s'", e.jobInfo.MaxTime)
+		opts = append(opts, fmt.Sprintf("--time=%s", q(e.jobInfo.MaxTime)))
+	}
+	if e.jobInfo.Gres != "" {
+		opts = append(opts, fmt.Sprintf("--gres=%s", q(e.jobInfo.Gres)))
+	}
+	if e.jobInfo.Constraint != "" {
+		opts = append(opts, fmt.Sprintf("--constraint=%s", q(e.jobInfo.Constraint)))
+	}
+	if e.jobInfo.Array != "" {
+		opts = append(opts, fmt.Sprintf("--array=%s", q(e.jobInfo.Array)))
+	}
+	if e.jobInfo.Requeue {
+		opts = append(opts, "--requeue")
+	}
+	if e.jobInfo.Signal != "" {
+		opts = append(opts, fmt.Sprintf("--signal=%s", q(e.jobInfo.Signal)))
+	}
+	if e.jobInfo.OutputFileName != "" || e.jobInfo.SeparateErrorOutput {
+		outputPattern := e.jobInfo.OutputFileName
+		if outputPattern == "" && e.jobInfo.Array != "" {
+			outputPattern = defaultArrayOutputPattern + outputExt
+		} else if outputPattern == "" {
+			outputPattern = defaultOutputPattern + outputExt
+		}
+		opts = append(opts, fmt.Sprintf("--output=%s", q(outputPattern)))
+		if e.jobInfo.SeparateErrorOutput {
+			opts = append(opts, fmt.Sprintf("--error=%s", q(errorOutputPattern(outputPattern))))
+		}
+	}
+	opts = append(opts, e.jobInfo.Opts...)
+	if e.jobInfo.Partition != "" {
+		opts = append(opts, fmt.Sprintf("--partition=%s", q(e.jobInfo.Partition)))
 	}
-	if e.jobInfo.Opts != nil && len(e.jobInfo.Opts) > 0 {
-		opts += fmt.Sprintf(" %s", strings.Join(e.jobInfo.Opts, " "))
+	if e.jobInfo.QOS != "" {
+		opts = append(opts, fmt.Sprintf("--qos=%s", q(e.jobInfo.QOS)))
 	}
 	if e.jobInfo.Reservation != "" {
-		opts += fmt.Sprintf(" --reservation='%s'", e.jobInfo.Reservation)
+		opts = append(opts, fmt.Sprintf("--reservation=%s", q(e.jobInfo.Reservation)))
 	}
 	if e.jobInfo.Account != "" {
-		opts += fmt.Sprintf(" --account='%s'", e.jobInfo.Account)
+		opts = append(opts, fmt.Sprintf("--account=%s", q(e.jobInfo.Account)))
+	}
+	if e.jobInfo.Dependency != "" {
+		// A job whose dependency can never be satisfied is cancelled instead of staying pending
+		opts = append(opts, fmt.Sprintf("--dependency=%s", q(e.jobInfo.Dependency)), "--kill-on-invalid-dep=yes")
+	}
+	// The options above are the ones of the first component of a heterogeneous job
+	for _, c := range e.jobInfo.HetComponents {
+		opts = append(opts, hetJobSeparator)
+		opts = append(opts, c.buildJobOptsList(q)...)
 	}
-	log.Debugf("opts=%q", opts)
 	return opts
 }
 
 func (e *executionCommon) prepareAndSubmitJob(ctx context.Context) error {
 	var cmd string
-	if e.jobInfo.ExecutionOptions.Command != "" {
+	if e.hasCommand() {
 		if strings.HasPrefix(strings.TrimSpace(e.jobInfo.ExecutionOptions.Command), srunCommand+" ") {
 			e.jobInfo.ExecutionOptions.Command = e.jobInfo.ExecutionOptions.Command[5:]
 		}
-		inner := fmt.Sprintf("%s %s %s", srunCommand, e.jobInfo.ExecutionOptions.Command, quoteArgs(e.jobInfo.ExecutionOptions.Args))
+		if cl := e.jobInfo.ExecutionOptions.CommandLine; len(cl) > 1 && cl[0] == srunCommand {
+			e.jobInfo.ExecutionOptions.CommandLine = cl[1:]
+		}
+		inner := fmt.Sprintf("%s%s %s", srunCommand, e.buildSrunExportOpt(), e.buildCommand())
 		var err error
 		cmd, err = e.wrapCommand(inner)
 		if err != nil {
 			return err
 		}
 	} else {
-		cmd = fmt.Sprintf("%s%s%ssbatch -D %s%s %s", e.sourceEnvFile(), e.addWorkingDirCmd(), e.buildEnvVars(), e.jobInfo.WorkingDir, e.buildJobOpts(), path.Join(e.jobInfo.WorkingDir, e.PrimaryFile))
+		// Modules environment is exported to the job by sbatch
+		cmd = fmt.Sprintf("%s%s%s%ssbatch -D %s%s %s", e.sourceEnvFile(), e.loadModules(), e.addWorkingDirCmd(), e.buildEnvVars(), e.jobInfo.WorkingDir, e.buildJobOpts(), path.Join(e.jobInfo.WorkingDir, e.PrimaryFile))
 	}
 	return e.submitJob(ctx, cmd)
 }
 
+// Returns the srun --export option defined by the export_env execution option, or an empty string if not set
+// so that srun exports the whole environment (its default behavior).
+// Unless the whole environment is exported, the job environment variables (env_vars and operation inputs) are added
+// to the exported variables: NONE only exports them, a list of variables exports them in addition to the listed ones.
+func (e *executionCommon) buildSrunExportOpt() string {
+	exportEnv := strings.TrimSpace(e.jobInfo.ExecutionOptions.ExportEnv)
+	if exportEnv == "" {
+		return ""
+	}
+	exported := make([]string, 0)
+	names := make(map[string]bool)
+	for _, v := range strings.Split(exportEnv, ",") {
+		v = strings.TrimSpace(v)
+		if strings.EqualFold(v, "ALL") {
+			return " --export=" + shellQuote(exportEnv)
+		}
+		if v == "" || strings.EqualFold(v, "NONE") {
+			continue
+		}
+		exported = append(exported, v)
+		names[strings.SplitN(v, "=", 2)[0]] = true
+	}
+	for _, v := range e.listEnvVars() {
+		if !names[v[0]] {
+			exported = append(exported, v[0])
+			names[v[0]] = true
+		}
+	}
+	if len(exported) == 0 {
+		return " --export=NONE"
+	}
+	return " --export=" + shellQuote(strings.Join(exported, ","))
+}
+
+// Returns true if the job runs a command, given as a string or as a command line
+func (e *executionCommon) hasCommand() bool {
+	return e.jobInfo.ExecutionOptions.Command != "" || len(e.jobInfo.ExecutionOptions.CommandLine) > 0
+}
+
+// Returns the command run by the job followed by its arguments. A command line is shell-escaped word by word
+// while a command string is used unchanged.
+func (e *executionCommon) buildCommand() string {
+	opts := e.jobInfo.ExecutionOptions
+	if len(opts.CommandLine) > 0 {
+		words := append(append([]string{}, opts.CommandLine...), opts.Args...)
+		return quoteWords(words)
+	}
+	return fmt.Sprintf("%s %s", opts.Command, quoteArgs(opts.Args))
+}
+
 func (e *executionCommon) wrapCommand(innerCmd string) (string, error) {
 	// Generate a random UUID to add it to the sbatch wrapper script name
 	// this will prevent collisions when running several jobs in parallel
@@ -467,17 +896,72 @@ func (e *executionCommon) wrapCommand(innerCmd string) (string, error) {
 	}
 	scriptName := fmt.Sprintf(batchScript, id.String())
 	pathScript := path.Join(e.jobInfo.WorkingDir, scriptName)
-	// Add the script to the artifact's list
-	e.jobInfo.Artifacts = append(e.jobInfo.Artifacts, scriptName)
-	// Write script
-	cat := fmt.Sprintf(`cat <<'EOF' > %s
+	// Modules are loaded within the job
+	innerCmd = e.loadModules() + innerCmd
+	// Ensure generated script removal after its submission unless it should be kept for debugging purpose
+	var removeScript string
+	if e.jobInfo.KeepScript {
+		log.Printf("Generated batch script %s of job %q will be kept", pathScript, e.jobInfo.Name)
+	} else {
+		// Add the script to the artifact's list
+		e.jobInfo.Artifacts = append(e.jobInfo.Artifacts, scriptName)
+		removeScript = fmt.Sprintf("; rm -f %s", pathScript)
+	}
+
+	if e.jobInfo.ScriptDirectives {
+		// Upload a script with job options as sbatch directives
+		var b strings.Builder
+		b.WriteString("#!/bin/bash\n")
+		for _, opt := range e.buildJobOptsList(false) {
+			if opt == hetJobSeparator {
+				// Components of a heterogeneous job are separated by a hetjob directive
+				opt = "hetjob"
+			}
+			fmt.Fprintf(&b, "#SBATCH %s\n", opt)
+		}
+		b.WriteString(e.buildInlineSBatchoptions())
+		b.WriteString(innerCmd)
+		b.WriteString("\n")
+		if e.jobInfo.DryRun {
+			e.dryRunScript = b.String()
+		} else {
+			if err = e.client.CopyFile(strings.NewReader(b.String()), pathScript, "0700"); err != nil {
+				return "", errors.Wrapf(err, "failed to upload generated batch script %s", pathScript)
+			}
+			if err = e.restrictPermissions(pathScript, "700"); err != nil {
+				return "", err
+			}
+			log.Printf("Generated batch script %s uploaded for job %q", pathScript, e.jobInfo.Name)
+		}
+		return fmt.Sprintf("%s%s%s%ssbatch -D %s %s%s", e.umaskCmd(), e.sourceEnvFile(), e.addWorkingDirCmd(), e.buildEnvVars(), e.jobInfo.WorkingDir, pathScript, removeScript), nil
+	}
+
+	// Write script, its permissions are restricted before writing its content
+	cat := fmt.Sprintf(`touch %s && chmod 700 %s || { echo failed to restrict permissions of %s >&2 ; exit 1 ; } ;cat <<'EOF' > %s
 #!/bin/bash
 %s
 %s
 EOF
-`, pathScript, e.buildInlineSBatchoptions(), innerCmd)
-	// Ensure generated script removal after its submission
-	return fmt.Sprintf("%s%s%s%ssbatch -D %s%s %s; rm -f %s", e.sourceEnvFile(), e.addWorkingDirCmd(), e.buildEnvVars(), cat, e.jobInfo.WorkingDir, e.buildJobOpts(), pathScript, pathScript), nil
+`, pathScript, pathScript, pathScript, pathScript, e.buildInlineSBatchoptions(), innerCmd)
+	return fmt.Sprintf("%s%s%s%s%ssbatch -D %s%s %s%s", e.umaskCmd(), e.sourceEnvFile(), e.addWorkingDirCmd(), e.buildEnvVars(), cat, e.jobInfo.WorkingDir, e.buildJobOpts(), pathScript, removeScript), nil
+}
+
+// Sets the file mode creation mask of the session, it is propagated by sbatch to the job so that
+// the job output files are also created with it
+func (e *executionCommon) umaskCmd() string {
+	if e.umask == "" {
+		return ""
+	}
+	return fmt.Sprintf("umask %s;", e.umask)
+}
+
+// Explicitly sets the permissions of an uploaded file as the ones given at upload are not applied
+// to an existing file and are filtered by the session umask
+func (e *executionCommon) restrictPermissions(filePath, mode string) error {
+	if out, err := e.client.RunCommand(fmt.Sprintf("chmod %s %s", mode, filePath)); err != nil {
+		return errors.Wrapf(err, "failed to restrict permissions of %s: %s", filePath, out)
+	}
+	return nil
 }
 
 func (e *executionCommon) buildInlineSBatchoptions() string {
@@ -492,6 +976,18 @@ func (e *executionCommon) buildInlineSBatchoptions() string {
 	return b.String()
 }
 
+// Loads environment modules, the first module that fails to load stops the execution with an explicit error message
+func (e *executionCommon) loadModules() string {
+	var cmd string
+	for _, m := range e.jobInfo.Modules {
+		if strings.TrimSpace(m) == "" {
+			continue
+		}
+		cmd += fmt.Sprintf("module load %s || { echo failed to load module %s >&2 ; exit 1 ; } ;", shellQuote(m), shellQuote(m))
+	}
+	return cmd
+}
+
 func (e *executionCommon) addWorkingDirCmd() string {
 	var cmd string
 	if e.jobInfo.WorkingDir != home {
@@ -509,30 +1005,80 @@ func (e *executionCommon) sourceEnvFile() string {
 }
 
 func (e *executionCommon) buildEnvVars() string {
+	if e.envVarsInFile {
+		return ""
+	}
 	var exports string
+	for _, v := range e.listEnvVars() {
+		exports += fmt.Sprintf("export %s=%s;", v[0], shellQuote(v[1]))
+	}
+	return exports
+}
+
+// Returns the job environment variables as key/value pairs, from execution options then from operation inputs
+func (e *executionCommon) listEnvVars() [][2]string {
+	envVars := make([][2]string, 0)
 	for _, v := range e.jobInfo.ExecutionOptions.EnvVars {
 		if is, key, val := parseKeyValue(v); is {
 			log.Debugf("Add env var with key:%q and value:%q", key, val)
-			export := fmt.Sprintf("export %s='%s';", key, val)
-			exports += export
+			envVars = append(envVars, [2]string{key, val})
 		}
 	}
-	for k, v := range e.jobInfo.Inputs {
+	keys := make([]string, 0, len(e.jobInfo.Inputs))
+	for k := range e.jobInfo.Inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := e.jobInfo.Inputs[k]
 		log.Debugf("Add env var with key:%q and value:%q", k, v)
 		if strings.TrimSpace(k) != "" && strings.TrimSpace(v) != "" {
-			export := fmt.Sprintf("export %s='%s';", k, v)
-			exports += export
+			envVars = append(envVars, [2]string{k, v})
 		}
 	}
-	return exports
+	return envVars
+}
+
+// Uploads a file readable only by the user into the job working directory and returns its path.
+// If cleanup is true, the file is added to the job artifacts to be removed at the end of the job.
+func (e *executionCommon) uploadJobFile(nameFormat, content string, cleanup bool) (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate UUID for job file name")
+	}
+	fileName := fmt.Sprintf(nameFormat, id.String())
+	filePath := path.Join(e.jobInfo.WorkingDir, fileName)
+	if cleanup {
+		e.jobInfo.Artifacts = append(e.jobInfo.Artifacts, fileName)
+	}
+	if e.jobInfo.DryRun {
+		log.Debugf("Dry run: job file %s not uploaded", filePath)
+		return filePath, nil
+	}
+	if err = e.client.CopyFile(strings.NewReader(content), filePath, "0600"); err != nil {
+		return "", errors.Wrapf(err, "failed to upload job file %s", filePath)
+	}
+	if err = e.restrictPermissions(filePath, "600"); err != nil {
+		return "", err
+	}
+	return filePath, nil
 }
 
 func (e *executionCommon) submitJob(ctx context.Context, cmd string) error {
+	if e.jobInfo.DryRun {
+		// The command is fully rendered but not run
+		msg := fmt.Sprintf("Dry run of node %q, the job would be submitted with the command: %s", e.NodeName, cmd)
+		if e.dryRunScript != "" {
+			msg += fmt.Sprintf("\nusing the generated batch script:\n%s", e.dryRunScript)
+		}
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).RegisterAsString(msg)
+		return nil
+	}
 	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelDEBUG, e.deploymentID).RegisterAsString(fmt.Sprintf("Run the command: %s", cmd))
 	out, err := e.client.RunCommand(cmd)
 	if err != nil {
 		log.Debugf("stderr:%q", out)
-		return errors.Wrap(err, out)
+		return parseSlurmError(err, out)
 	}
 	out = strings.Trim(out, "\n")
 	if e.jobInfo.ID, err = retrieveJobID(out); err != nil {
@@ -542,6 +1088,37 @@ func (e *executionCommon) submitJob(ctx context.Context, cmd string) error {
 	return nil
 }
 
+// Runs the given command as a blocking job: the command is run synchronously in the job working directory,
+// srun allocating the job resources and returning once the job is completed. There is no job to monitor afterwards.
+// The job output is registered as a log and an error is returned if the job fails. If the output is written to a file,
+// it is read from this file once the job is completed.
+func (e *executionCommon) runBlockingJob(ctx context.Context, innerCmd string) error {
+	cmd := fmt.Sprintf("%s%s%s%scd %s && bash -c %s", e.umaskCmd(), e.sourceEnvFile(), e.addWorkingDirCmd(), e.buildEnvVars(), e.jobInfo.WorkingDir, shellQuote(e.loadModules()+innerCmd))
+	if e.jobInfo.DryRun {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+			"Dry run of node %q, the blocking job would be run with the command: %s", e.NodeName, cmd)
+		return nil
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelDEBUG, e.deploymentID).RegisterAsString(fmt.Sprintf("Run the blocking job command: %s", cmd))
+	// The command lasts as long as the job
+	out, err := withoutCommandTimeout(e.client).RunCommand(cmd)
+	if e.jobInfo.OutputFileName != "" {
+		out = e.readBlockingJobOutput(out)
+	}
+	if out = strings.TrimRight(out, "\n"); out != "" {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+			"Output of blocking job %q:\n%s", e.jobInfo.Name, out)
+	}
+	if err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return errors.Errorf("blocking job %q failed with exit status %d", e.jobInfo.Name, exitErr.ExitStatus())
+		}
+		return errors.Wrapf(err, "failed to run blocking job %q", e.jobInfo.Name)
+	}
+	return nil
+}
+
 func (e *executionCommon) uploadArtifacts(ctx context.Context) error {
 	log.Debugf("Upload artifacts to remote host")
 	// Add artifact to job artifact's list for monitoring actions
@@ -603,6 +1180,10 @@ func (e *executionCommon) uploadArtifact(ctx context.Context, pathFile, artifact
 	}
 
 	remotePath := path.Join(e.jobInfo.WorkingDir, relPath)
+	if e.jobInfo.DryRun {
+		log.Debugf("Dry run: artifact file %q not uploaded to:%q", pathFile, remotePath)
+		return nil
+	}
 	log.Debugf("uploadArtifact file from source path:%q to:%q", pathFile, remotePath)
 	return e.client.CopyFile(bytes.NewReader(source), remotePath, "0755")
 }
@@ -0,0 +1,116 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+)
+
+// The reason of a job pending until its dependencies are satisfied
+const dependencyPendingReason = "Dependency"
+
+// A Slurm job ID, possibly of a job array task (123_4) or of a heterogeneous job component (123+1)
+var reDependencyJobID = regexp.MustCompile(`^[0-9]+([_+][0-9]+)?$`)
+
+// The dependency types taking job IDs
+var dependencyTypes = map[string]bool{
+	"after": true, "afterany": true, "afterburstbuffer": true, "aftercorr": true, "afternotok": true, "afterok": true,
+}
+
+// Retrieves the dependency of the job on other jobs, the dependency can't be set by extra options then.
+// Jobs referenced by their node name are resolved to their Slurm job ID.
+func (e *executionCommon) getDependencyProp(ctx context.Context) error {
+	dependency, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "dependency", false)
+	if err != nil || dependency == "" {
+		return err
+	}
+	for _, opt := range e.jobInfo.Opts {
+		if strings.HasPrefix(opt, "--dependency") || strings.HasPrefix(opt, "-d") {
+			return errors.Errorf("node %q sets its job dependency with the dependency property, it can't be set by the %q extra option", e.NodeName, opt)
+		}
+	}
+	e.jobInfo.Dependency, err = e.resolveDependency(ctx, dependency)
+	return err
+}
+
+// Checks a Slurm dependency expression and replaces the node names it references by the job IDs of these nodes.
+// Dependencies are separated by ',' if all of them should be satisfied or by '?' if any of them should be satisfied.
+func (e *executionCommon) resolveDependency(ctx context.Context, dependency string) (string, error) {
+	var b strings.Builder
+	start := 0
+	for i := 0; i <= len(dependency); i++ {
+		if i < len(dependency) && dependency[i] != ',' && dependency[i] != '?' {
+			continue
+		}
+		d, err := e.resolveSingleDependency(ctx, dependency[start:i])
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid dependency %q for node %q", dependency, e.NodeName)
+		}
+		b.WriteString(d)
+		if i < len(dependency) {
+			b.WriteByte(dependency[i])
+		}
+		start = i + 1
+	}
+	return b.String(), nil
+}
+
+// Resolves a dependency of the form "type:job[:job...]", each job being a Slurm job ID or a job node name
+func (e *executionCommon) resolveSingleDependency(ctx context.Context, dependency string) (string, error) {
+	if dependency == "singleton" {
+		return dependency, nil
+	}
+	parts := strings.Split(dependency, ":")
+	if len(parts) < 2 || !dependencyTypes[parts[0]] {
+		return "", errors.Errorf("expecting dependencies of the form type:job[:job...], type being one of after, afterany, afterburstbuffer, aftercorr, afternotok or afterok, or singleton")
+	}
+	for i, job := range parts[1:] {
+		if reDependencyJobID.MatchString(job) {
+			continue
+		}
+		jobID, err := e.getNodeJobID(ctx, job)
+		if err != nil {
+			return "", err
+		}
+		parts[i+1] = jobID
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// Returns the Slurm job ID of a job node, an error is returned if the job has not been submitted yet
+func (e *executionCommon) getNodeJobID(ctx context.Context, nodeName string) (string, error) {
+	exist, err := deployments.DoesNodeExist(ctx, e.deploymentID, nodeName)
+	if err != nil {
+		return "", err
+	}
+	if !exist {
+		return "", errors.Errorf("%q is neither a job ID nor a node name", nodeName)
+	}
+	// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
+	id, err := deployments.GetInstanceAttributeValue(ctx, e.deploymentID, nodeName, "0", "job_id")
+	if err != nil {
+		return "", err
+	}
+	if id == nil || id.RawString() == "" {
+		return "", errors.Errorf("the job of node %q has not been submitted yet, it should be run before", nodeName)
+	}
+	return id.RawString(), nil
+}
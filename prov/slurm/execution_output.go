@@ -0,0 +1,164 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/log"
+)
+
+// Layout of the %T placeholder of blocking jobs output file names
+const outputTimestampLayout = "20060102150405"
+
+// Retrieves the template of the job output file name, the output file can't be set by extra options then.
+// Placeholders are checked once the job mode is known by resolveOutputFileName.
+func (e *executionCommon) getOutputFileNameProp(ctx context.Context) error {
+	var err error
+	if e.jobInfo.OutputFileName, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "output_file_name", false); err != nil {
+		return err
+	}
+	if e.jobInfo.OutputFileName == "" {
+		return nil
+	}
+	if opt, ok := findOutputOpt(e.jobInfo.Opts); ok {
+		return errors.Errorf("node %q sets its output file name with the output_file_name property, it can't be set by the %q extra option", e.NodeName, opt)
+	}
+	return nil
+}
+
+// Returns the first extra option setting the job output or error file, if any
+func findOutputOpt(opts []string) (string, bool) {
+	for _, opt := range opts {
+		for _, prefix := range []string{"--output", "--error", "-o", "-e"} {
+			if opt == prefix || strings.HasPrefix(opt, prefix+"=") || (len(prefix) == 2 && strings.HasPrefix(opt, prefix)) {
+				return opt, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Turns the output file name template into the Slurm filename pattern given to sbatch or srun.
+// Slurm replaces the placeholders of batch jobs: %j (job ID) for jobs, %A (array job ID) and %a (task ID) for job arrays,
+// and %t (task rank, 0 for the batch script). Yorc replaces %x by the job name, and the correlation placeholders %D
+// (deployment ID), %W (workflow name) and %S (workflow step name) so that outputs of concurrent steps can be told apart.
+// The job ID of blocking jobs is not known before they are completed so Yorc replaces all their placeholders: %x, the
+// correlation placeholders and %T (submission timestamp), the output file can then be read once the job is completed.
+func (e *executionCommon) resolveOutputFileName(ctx context.Context, now time.Time) error {
+	if e.jobInfo.OutputFileName == "" {
+		return nil
+	}
+	var workflowName string
+	if logFields, ok := events.FromContext(ctx); ok {
+		workflowName, _ = logFields[events.WorkFlowID].(string)
+	}
+	// Values may contain a '%' that should not be interpreted by Slurm
+	values := map[byte]string{
+		'%': "%%",
+		'x': escapeOutputPlaceholders(e.jobInfo.Name),
+		'D': escapeOutputPlaceholders(e.deploymentID),
+		'W': escapeOutputPlaceholders(workflowName),
+		'S': escapeOutputPlaceholders(e.stepName),
+	}
+	switch {
+	case e.jobInfo.Blocking:
+		values['T'] = now.Format(outputTimestampLayout)
+	case e.jobInfo.Array != "":
+		values['A'] = "%A"
+		values['a'] = "%a"
+		values['t'] = "%t"
+	default:
+		values['j'] = "%j"
+		values['t'] = "%t"
+	}
+	pattern, err := expandOutputFileName(e.jobInfo.OutputFileName, values)
+	if err != nil {
+		return errors.Wrapf(err, "invalid output_file_name property for node %q", e.NodeName)
+	}
+	e.jobInfo.OutputFileName = pattern
+	return nil
+}
+
+func escapeOutputPlaceholders(value string) string {
+	return strings.Replace(value, "%", "%%", -1)
+}
+
+// Replaces the placeholders of an output file name by their value, "%%" being a placeholder for "%".
+// An error is returned for placeholders without value.
+func expandOutputFileName(name string, values map[byte]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		i++
+		if i == len(name) {
+			return "", errors.Errorf("output file name %q ends with an incomplete placeholder", name)
+		}
+		v, ok := values[name[i]]
+		if !ok {
+			return "", errors.Errorf("placeholder %%%c is not supported in output file name %q", name[i], name)
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+// Returns the Slurm filename pattern of the job error output written to a dedicated file: the output file name pattern
+// with the error output extension
+func errorOutputPattern(pattern string) string {
+	return strings.TrimSuffix(pattern, outputExt) + errorOutputExt
+}
+
+// Returns the name of an output file of a job, or of a job array task if the task ID is set, resolving the Slurm filename
+// pattern given to sbatch. The default output file name is returned if there is no pattern.
+func jobOutputFile(pattern, jobID, taskID, ext string) string {
+	if pattern == "" {
+		return defaultJobOutputFile(jobID, taskID, ext)
+	}
+	if ext == errorOutputExt {
+		pattern = errorOutputPattern(pattern)
+	}
+	// The pattern placeholders have been checked at submission
+	name, _ := expandOutputFileName(pattern, map[byte]string{'%': "%", 'j': jobID, 'A': jobID, 'a': taskID, 't': "0"})
+	return name
+}
+
+// Returns the content of the output file of a completed blocking job followed by the output of the srun command
+// as srun errors are not written to the job output file. The command output is returned if the file can't be read.
+func (e *executionCommon) readBlockingJobOutput(cmdOut string) string {
+	// The pattern of blocking jobs only contains escaped '%'
+	name, _ := expandOutputFileName(e.jobInfo.OutputFileName, map[byte]string{'%': "%"})
+	p := shellQuote(name)
+	if !path.IsAbs(name) {
+		// The working directory may be relative to the home directory so it is not quoted
+		p = e.jobInfo.WorkingDir + "/" + p
+	}
+	out, err := e.client.RunCommand("cat " + p)
+	if err != nil {
+		log.Debugf("failed to read output file %s of blocking job %q: %v: %s", name, e.jobInfo.Name, err, out)
+		return cmdOut
+	}
+	return out + cmdOut
+}
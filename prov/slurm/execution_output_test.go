@@ -0,0 +1,100 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/helper/sshutil"
+)
+
+func Test_executionCommon_resolveOutputFileName(t *testing.T) {
+	ctx := events.NewContext(context.Background(), events.LogOptionalFields{events.WorkFlowID: "run"})
+	now := time.Date(2024, time.March, 5, 14, 30, 12, 0, time.UTC)
+	tests := []struct {
+		name    string
+		jobInfo *jobInfo
+		want    string
+		wantErr bool
+	}{
+		{"NoTemplate", &jobInfo{Name: "MyJob"}, "", false},
+		{"Batch", &jobInfo{Name: "MyJob", OutputFileName: "result-%j-%t.out"}, "result-%j-%t.out", false},
+		{"BatchJobName", &jobInfo{Name: "My%Job", OutputFileName: "%x-%j.out"}, "My%%Job-%j.out", false},
+		{"BatchEscapedPercent", &jobInfo{Name: "MyJob", OutputFileName: "100%%-%j.out"}, "100%%-%j.out", false},
+		{"JobArray", &jobInfo{Name: "MyJob", Array: "1-4", OutputFileName: "result-%A-%a.out"}, "result-%A-%a.out", false},
+		{"JobArrayWithJobID", &jobInfo{Name: "MyJob", Array: "1-4", OutputFileName: "result-%j.out"}, "", true},
+		{"BatchWithArrayTask", &jobInfo{Name: "MyJob", OutputFileName: "result-%a.out"}, "", true},
+		{"BatchWithTimestamp", &jobInfo{Name: "MyJob", OutputFileName: "result-%T.out"}, "", true},
+		{"Blocking", &jobInfo{Name: "MyJob", Blocking: true, OutputFileName: "%x-%T.out"}, "MyJob-20240305143012.out", false},
+		{"BlockingWithJobID", &jobInfo{Name: "MyJob", Blocking: true, OutputFileName: "result-%j.out"}, "", true},
+		{"BatchCorrelation", &jobInfo{Name: "MyJob", OutputFileName: "%D/%W-%S-%j.out"}, "My%%Deployment/run-Submit-%j.out", false},
+		{"BlockingCorrelation", &jobInfo{Name: "MyJob", Blocking: true, OutputFileName: "%D-%W-%S-%T.out"}, "My%%Deployment-run-Submit-20240305143012.out", false},
+		{"IncompletePlaceholder", &jobInfo{Name: "MyJob", OutputFileName: "result-%"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionCommon{NodeName: "Job", deploymentID: "My%Deployment", stepName: "Submit", jobInfo: tt.jobInfo}
+			err := e.resolveOutputFileName(ctx, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, e.jobInfo.OutputFileName)
+		})
+	}
+}
+
+func Test_jobOutputFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		taskID  string
+		ext     string
+		want    string
+	}{
+		{"Default", "", "", outputExt, "slurm-6260.out"},
+		{"DefaultJobArray", "", "3", errorOutputExt, "slurm-6260_3.err"},
+		{"Templated", "result-%j-%t.out", "", outputExt, "result-6260-0.out"},
+		{"TemplatedErrorOutput", "result-%j.out", "", errorOutputExt, "result-6260.err"},
+		{"TemplatedErrorOutputWithoutExtension", "result-%j", "", errorOutputExt, "result-6260.err"},
+		{"TemplatedJobArray", "result-%A-%a.out", "3", outputExt, "result-6260-3.out"},
+		{"EscapedPercent", "My%%Job-%j.out", "", outputExt, "My%Job-6260.out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, jobOutputFile(tt.pattern, "6260", tt.taskID, tt.ext))
+		})
+	}
+}
+
+func Test_executionCommon_readBlockingJobOutput(t *testing.T) {
+	var cmd string
+	sshClient := &sshutil.MockSSHClient{
+		MockRunCommand: func(input string) (string, error) {
+			cmd = input
+			return "output of MyJob\n", nil
+		},
+	}
+	e := &executionCommon{client: sshClient, jobInfo: &jobInfo{Name: "MyJob", WorkingDir: "~/work", OutputFileName: "My%%Job.out"}}
+	assert.Equal(t, "output of MyJob\n", e.readBlockingJobOutput(""))
+	assert.Equal(t, "cat ~/work/'My%Job.out'", cmd)
+}
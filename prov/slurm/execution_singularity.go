@@ -21,48 +21,97 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 
 	"github.com/ystia/yorc/v4/deployments"
 	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/helper/sshutil"
 	"github.com/ystia/yorc/v4/log"
 	"github.com/ystia/yorc/v4/tasks"
 	"github.com/ystia/yorc/v4/tosca"
 )
 
+const (
+	singularityRuntime = "singularity"
+	apptainerRuntime   = "apptainer"
+)
+
+// Probe command returning the first container runtime binary found on the PATH of the Slurm client node
+const containerRuntimeProbeCmd = "command -v singularity >/dev/null 2>&1 && echo singularity || { command -v apptainer >/dev/null 2>&1 && echo apptainer ; }"
+
+// Name of the file containing registry credentials uploaded in the job working directory
+const registryCredentialsFile = "r-%s.env"
+
+// Name of the file containing job environment variables uploaded in the job working directory
+const jobEnvFile = "e-%s.env"
+
+// Container runtimes detected on Slurm client nodes, indexed by host
+var detectedContainerRuntimes sync.Map
+
 type executionSingularity struct {
 	*executionCommon
 	imageURI       string
 	commandOptions []string
+	extraArgs      []string
 	debug          bool
+	runtime        string
+	gpu            string
+	bindMounts     []string
+	registryUser   string
+	registryToken  string
+	mpi            string
+	envFile        string
+	writableTmpfs  bool
+	overlay        string
+	overlaySize    uint64
+	sandbox        string
+	imageToPull    string
+	fakeroot       bool
+	userns         bool
+	cleanEnv       bool
+	// Isolation of the container from the host: containAll isolates the home and temporary directories as well as the
+	// environment, noHome and noTmp only isolate the home or the temporary directory
+	containAll bool
+	noHome     bool
+	noTmp      bool
+	// Host environment variables passed to the cleaned container environment
+	passthroughEnv []string
+	// If true, the job runs from the node-local scratch directory
+	nodeLocalScratch bool
+	// The script run to checkpoint the job before its preemption, the job is requeued afterwards
+	checkpointScript string
+	// The GPU devices visible in the container
+	gpuDevices string
+	// The MIG profile of the requested GPUs
+	migProfile string
+	// The working directory in the container
+	pwd string
+	// The allocation node the container is run in, the Slurm job ID of its allocation and whether it is released once
+	// the container is completed
+	allocationNode    string
+	allocationJobID   string
+	releaseAllocation bool
 }
 
 func (e *executionSingularity) execute(ctx context.Context) error {
-	// Only runnable operation is currently supported
+	// Runnable operations run a job, Standard start and stop operations manage a long-running instance
 	log.Debugf("Execute the operation:%+v", e.operation)
 	// Fill log optional fields for log registration
 	switch strings.ToLower(e.operation.Name) {
 	case strings.ToLower(tosca.RunnableSubmitOperationName):
 		log.Printf("Submit the job: %s", e.operation.Name)
-		if e.Primary == "" {
-			return errors.New("Image artifact is mandatory and must be filled in the operation implementation")
-		}
-		// Build Job Information
-		if err := e.buildJobInfo(ctx); err != nil {
-			return errors.Wrap(err, "failed to build job information")
-		}
-		// Build singularity information
-		if err := e.resolveImageURI(ctx); err != nil {
-			return errors.Wrap(err, "failed to resolve singularity image URI")
-		}
-		// Retrieve singularity job props
-		if err := e.getSingularityProps(ctx); err != nil {
-			return errors.Wrap(err, "failed to retrieve singularity command options")
+		if resumed, err := e.resumeSubmittedJob(ctx); err != nil || resumed {
+			return err
 		}
-		// Copy the artifacts
-		if err := e.uploadArtifacts(ctx); err != nil {
-			return errors.Wrap(err, "failed to upload artifact")
+		// The allocation is released even if the container can't be run
+		defer e.releaseJobAllocation(ctx)
+		if err := e.prepareExecution(ctx); err != nil {
+			return err
 		}
 		err := e.prepareAndSubmitSingularityJob(ctx)
 		if err != nil {
@@ -77,6 +126,9 @@ func (e *executionSingularity) execute(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		if e.jobInfo.DryRun || e.jobInfo.Blocking {
+			return nil
+		}
 		// Set the JobID attribute
 		// TODO(should be contextual to the current workflow)
 		err = deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "job_id", e.jobInfo.ID)
@@ -84,27 +136,136 @@ func (e *executionSingularity) execute(ctx context.Context) error {
 			return errors.Wrap(err, "failed to retrieve job id an manual cleanup may be necessary: ")
 		}
 	case strings.ToLower(tosca.RunnableCancelOperationName):
-		jobInfo, err := e.getJobInfoFromTaskContext()
-		if err != nil {
+		return e.cancelJob(ctx)
+	case serviceStartOperationName:
+		log.Printf("Start the singularity service: %s", e.operation.Name)
+		if err := e.prepareExecution(ctx); err != nil {
 			return err
 		}
-		return cancelJobID(jobInfo.ID, e.client)
+		return e.startInstance(ctx)
+	case serviceStopOperationName:
+		return e.stopInstance(ctx)
 	default:
 		return errors.Errorf("Unsupported operation %q", e.operation.Name)
 	}
 	return nil
 }
 
+// Retrieves the job and container information and uploads the artifacts required to run the container
+func (e *executionSingularity) prepareExecution(ctx context.Context) error {
+	if e.Primary == "" {
+		return errors.New("Image artifact is mandatory and must be filled in the operation implementation")
+	}
+	// Build Job Information
+	if err := e.buildJobInfo(ctx); err != nil {
+		return errors.Wrap(err, "failed to build job information")
+	}
+	// Build singularity information
+	if err := e.resolveImageURI(ctx); err != nil {
+		return errors.Wrap(err, "failed to resolve singularity image URI")
+	}
+	// Retrieve singularity job props
+	if err := e.getSingularityProps(ctx); err != nil {
+		return errors.Wrap(err, "failed to retrieve singularity command options")
+	}
+	if err := e.resolveOutputFileName(ctx, time.Now()); err != nil {
+		return err
+	}
+	// Resolve the container runtime binary
+	if err := e.resolveContainerRuntime(ctx); err != nil {
+		return errors.Wrap(err, "failed to resolve container runtime")
+	}
+	// Use the image cache if enabled
+	if err := e.resolveImageCache(ctx); err != nil {
+		return errors.Wrap(err, "failed to resolve image cache")
+	}
+	// Check or create the overlay image
+	if err := e.prepareOverlay(ctx); err != nil {
+		return errors.Wrap(err, "failed to prepare overlay")
+	}
+	// Copy the artifacts
+	if err := e.uploadArtifacts(ctx); err != nil {
+		return errors.Wrap(err, "failed to upload artifact")
+	}
+	return nil
+}
+
 func (e *executionSingularity) prepareAndSubmitSingularityJob(ctx context.Context) error {
-	var debug, inner string
+	var debug string
 	if e.debug {
 		debug = "-d -v"
 	}
-	cmdOpts := strings.Join(e.commandOptions, " ")
-	if e.jobInfo.ExecutionOptions.Command != "" {
-		inner = fmt.Sprintf("srun singularity %s exec %s %s %s %s", debug, cmdOpts, e.imageURI, e.jobInfo.ExecutionOptions.Command, quoteArgs(e.jobInfo.ExecutionOptions.Args))
+	runtime := e.containerRuntime()
+	if err := e.checkAllocation(); err != nil {
+		return err
+	}
+	if err := e.uploadEnvFile(); err != nil {
+		return err
+	}
+	cmdOpts := strings.Join(e.buildContainerOptions(), " ")
+	var containerCmd string
+	if e.hasCommand() {
+		containerCmd = fmt.Sprintf("%s %s exec %s %s %s", runtime, debug, cmdOpts, e.containerImage(), e.buildCommand())
 	} else {
-		inner = fmt.Sprintf("srun singularity %s run %s %s", debug, cmdOpts, e.imageURI)
+		containerCmd = fmt.Sprintf("%s %s run %s %s", runtime, debug, cmdOpts, e.containerImage())
+	}
+	containerCmd = e.wrapContainerEnv(runtime, containerCmd)
+	if e.jobInfo.DryRun && e.pwd != "" {
+		msg := fmt.Sprintf("Dry run of node %q, the container would run from %s", e.NodeName, e.pwd)
+		if hostPath := e.pwdHostPath(); hostPath != "" {
+			msg += fmt.Sprintf(" bound to host path %s", hostPath)
+		}
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).RegisterAsString(msg)
+	}
+	if len(e.jobInfo.HetComponents) > 0 {
+		return e.submitContainerJob(ctx, runtime, e.buildHetSrunCommand(runtime, debug, containerCmd))
+	}
+	return e.submitContainerJob(ctx, runtime, fmt.Sprintf("%s%s %s", srunCommand, e.buildSrunOpts(), containerCmd))
+}
+
+// Wraps the container command so that the MPI environment is forwarded to the container if required
+func (e *executionSingularity) wrapContainerEnv(runtime, containerCmd string) string {
+	if e.mpi == "" || !e.hasCleanEnvironment() {
+		return containerCmd
+	}
+	// The MPI/PMI environment is set by srun for each task, it is forwarded to the container using
+	// the runtime environment variables prefix as the container environment is cleaned
+	return fmt.Sprintf(`bash -c %s`, shellQuote(fmt.Sprintf(
+		`for v in ${!PMI*} ${!PMIX*} ${!SLURM*} ${!OMPI*}; do export %sENV_$v="${!v}"; done; exec %s`, strings.ToUpper(runtime), containerCmd)))
+}
+
+// Returns the container runtime binary, singularity if not resolved
+func (e *executionSingularity) containerRuntime() string {
+	if e.runtime == "" {
+		return singularityRuntime
+	}
+	return e.runtime
+}
+
+// Submits a job running the given container command, loading environment variables and registry credentials if any
+func (e *executionSingularity) submitContainerJob(ctx context.Context, runtime, inner string) error {
+	if e.nodeLocalScratch {
+		inner = e.buildScratchPrologue() + inner + e.buildScratchEpilogue()
+	}
+	inner = e.buildImagePullCmd(runtime) + e.buildSandboxCmd(runtime) + inner
+	if e.envFile != "" && !e.isolatesEnvironment() {
+		// Variables are exported to be available for srun, if the container environment is cleaned they are only
+		// passed to the container using the --env-file option
+		inner = fmt.Sprintf("set -a; source %s; set +a\n%s", e.envFile, inner)
+	}
+	if e.registryToken != "" {
+		credsPath, err := e.uploadRegistryCredentials(runtime)
+		if err != nil {
+			return err
+		}
+		// Credentials are only available to the job and removed as soon as they are loaded
+		inner = fmt.Sprintf("source %s; rm -f %s\n%s", credsPath, credsPath, inner)
+	}
+	if e.jobInfo.Blocking {
+		return e.runBlockingJob(ctx, inner)
+	}
+	if e.checkpointScript != "" {
+		inner = e.buildCheckpointWrapper(inner)
 	}
 	cmd, err := e.wrapCommand(inner)
 	if err != nil {
@@ -114,6 +275,11 @@ func (e *executionSingularity) prepareAndSubmitSingularityJob(ctx context.Contex
 }
 
 func (e *executionSingularity) resolveImageURI(ctx context.Context) error {
+	image, err := validateImageName(e.Primary)
+	if err != nil {
+		return errors.Wrapf(err, "invalid image of node %q for operation %q", e.NodeName, e.operation.Name)
+	}
+	e.Primary = image
 	switch {
 	// Docker image
 	case strings.HasPrefix(e.Primary, "docker://"):
@@ -125,11 +291,105 @@ func (e *executionSingularity) resolveImageURI(ctx context.Context) error {
 		if err := e.buildImageURI(ctx, "shub://"); err != nil {
 			return err
 		}
+	// Library image
+	case strings.HasPrefix(e.Primary, "library://"):
+		if err := e.buildImageURI(ctx, "library://"); err != nil {
+			return err
+		}
+	// OCI registry image
+	case strings.HasPrefix(e.Primary, "oras://"):
+		if err := e.buildImageURI(ctx, "oras://"); err != nil {
+			return err
+		}
+	// OCI layout directory or OCI/Docker archive, passed unchanged to singularity
+	case isLocalArchiveImage(e.Primary):
+		if err := e.checkLocalArchiveImage(e.Primary); err != nil {
+			return err
+		}
+		e.imageURI = e.Primary
 	// File image
-	case strings.HasSuffix(e.Primary, ".simg") || strings.HasSuffix(e.Primary, ".img"):
+	case strings.HasSuffix(e.Primary, ".sif") || strings.HasSuffix(e.Primary, ".simg") || strings.HasSuffix(e.Primary, ".img"):
 		e.imageURI = e.Primary
 	default:
-		return errors.Errorf("Unable to resolve image URI from image with name:%q", e.Primary)
+		return errors.Errorf("Unable to resolve image URI from image with name:%q, supported images are docker://, shub://, library:// and oras:// URIs, oci:, oci-archive: and docker-archive: paths or .sif, .simg and .img files", e.Primary)
+	}
+	// Expose the resolved URI to help debugging registry rewrites
+	return deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "image_uri", e.imageURI)
+}
+
+// Registry URI schemes of images pulled by the container runtime
+var registryImageSchemes = []string{"docker://", "shub://", "library://", "oras://"}
+
+// Image file extensions of images run from a file
+var fileImageExtensions = []string{".sif", ".simg", ".img"}
+
+// Checks an image name and returns it without surrounding whitespaces. Images pulled from a registry should
+// have a repository path, and image files can't be referenced using a registry URI scheme.
+func validateImageName(image string) (string, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return "", errors.New("the image name is empty")
+	}
+	if strings.IndexFunc(image, unicode.IsSpace) >= 0 {
+		return "", errors.Errorf("image name %q contains whitespaces", image)
+	}
+	for _, scheme := range registryImageSchemes {
+		if !strings.HasPrefix(image, scheme) {
+			continue
+		}
+		repo := strings.TrimLeft(strings.TrimPrefix(image, scheme), "/")
+		if repo == "" || strings.HasPrefix(repo, ":") || strings.HasPrefix(repo, "@") {
+			return "", errors.Errorf("image %q has no repository path", image)
+		}
+		if strings.HasSuffix(repo, ":") || strings.HasSuffix(repo, "@") {
+			return "", errors.Errorf("image %q has an empty tag or digest", image)
+		}
+		for _, ext := range fileImageExtensions {
+			if strings.HasSuffix(repo, ext) {
+				return "", errors.Errorf("image %q references a %s file using the %s scheme, image files should be referenced by their path", image, ext, scheme)
+			}
+		}
+	}
+	return image, nil
+}
+
+// Returns true if the image is an OCI layout directory (oci:) or an OCI or Docker archive (oci-archive:, docker-archive:)
+func isLocalArchiveImage(image string) bool {
+	for _, prefix := range []string{"oci:", "oci-archive:", "docker-archive:"} {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the path of an OCI layout directory or of an archive image, and true if it should be a directory.
+// An optional reference may follow the path (ie. oci:/path/to/layout:tag or oci-archive:/path/to/image.tar:tag).
+func localArchiveImagePath(image string) (string, bool) {
+	prefix := image[:strings.Index(image, ":")+1]
+	p := strings.TrimPrefix(image, prefix)
+	if prefix == "oci:" {
+		// oci:///path/to/layout and oci:/path/to/layout are both accepted
+		p = strings.TrimPrefix(p, "//")
+	}
+	if i := strings.LastIndex(p, ":"); i > strings.LastIndex(p, "/") {
+		p = p[:i]
+	}
+	return p, prefix == "oci:"
+}
+
+// Checks that the OCI layout directory or the archive referenced by the image exists on the Slurm client node
+func (e *executionSingularity) checkLocalArchiveImage(image string) error {
+	p, isDir := localArchiveImagePath(image)
+	if p == "" {
+		return errors.Errorf("invalid image %q, the path of the OCI layout or archive is missing", image)
+	}
+	test := "-f"
+	if isDir {
+		test = "-d"
+	}
+	if out, err := e.client.RunCommand(fmt.Sprintf("test %s %s", test, shellQuote(p))); err != nil {
+		return errors.Wrapf(err, "image %q not found on the Slurm client node: %s", p, out)
 	}
 	return nil
 }
@@ -147,7 +407,7 @@ func (e *executionSingularity) buildImageURI(ctx context.Context, prefix string)
 			return err
 		}
 		// Just ignore default public Docker and Singularity registries
-		if repoURL == deployments.DockerHubURL || repoURL == deployments.SingularityHubURL {
+		if repoURL == deployments.DockerHubURL || repoURL == deployments.SingularityHubURL || repoURL == deployments.SylabsLibraryURL {
 			e.imageURI = e.Primary
 		} else if repoURL != "" {
 			urlStruct, err := url.Parse(repoURL)
@@ -158,6 +418,12 @@ func (e *executionSingularity) buildImageURI(ctx context.Context, prefix string)
 			imageURI := prefix + path.Join(urlStruct.Host, tabs[1])
 			log.Debugf("imageURI:%q", imageURI)
 			e.imageURI = imageURI
+			// Singularity uses Docker credentials for OCI registries too
+			if prefix == "docker://" || prefix == "oras://" {
+				if e.registryToken, e.registryUser, err = deployments.GetRepositoryTokenUserFromName(ctx, e.deploymentID, repoName); err != nil {
+					return err
+				}
+			}
 		} else {
 			e.imageURI = e.Primary
 		}
@@ -174,8 +440,527 @@ func (e *executionSingularity) getSingularityProps(ctx context.Context) error {
 			return err
 		}
 	}
+	if a, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "extra_args", false); err != nil {
+		return err
+	} else if e.extraArgs, err = splitShellWords(a); err != nil {
+		return errors.Wrapf(err, "invalid extra_args property for node %q", e.NodeName)
+	}
 	if e.debug, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "singularity_debug"); err != nil {
 		return err
 	}
+	if b, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "bind_mounts"); err != nil {
+		return err
+	} else if b != nil && b.RawString() != "" {
+		var specs []string
+		if err = json.Unmarshal([]byte(b.RawString()), &specs); err != nil {
+			return err
+		}
+		if err = checkBindMounts(specs); err != nil {
+			return err
+		}
+		e.bindMounts = specs
+	}
+	if e.pwd, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "pwd", false); err != nil {
+		return err
+	}
+	if err = e.checkPwd(); err != nil {
+		return err
+	}
+	if e.mpi, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "mpi", false); err != nil {
+		return err
+	}
+	if e.mpi != "" && !reMPIPlugin.MatchString(e.mpi) {
+		return errors.Errorf("invalid mpi %q for node %q, expecting a Slurm MPI plugin type such as pmix or pmi2", e.mpi, e.NodeName)
+	}
+	if e.gpu, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "gpu", false); err != nil {
+		return err
+	}
+	if err = e.getGPUDevicesProps(ctx); err != nil {
+		return err
+	}
+	if e.gpu != "" && e.gpu != "none" && !e.hasGPUAllocation() {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, e.deploymentID).Registerf(
+			"GPU devices are exposed to the container of node %q but no GPU is allocated to the job, consider setting the \"gres\" job option (ex: gpu:1)", e.NodeName)
+	}
+	if e.cleanEnv, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "clean_env"); err != nil {
+		return err
+	}
+	if err = e.getIsolationProps(ctx); err != nil {
+		return err
+	}
+	if e.isolatesEnvironment() && e.jobInfo.Array != "" {
+		// The task index of a job array is set by Slurm on the compute node
+		e.passthroughEnv = append(e.passthroughEnv, "SLURM_ARRAY_TASK_ID")
+	}
+	if e.jobInfo.Blocking, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "blocking"); err != nil {
+		return err
+	}
+	// Containers run in an allocation are run as blocking jobs
+	if err = e.getAllocationProps(ctx); err != nil {
+		return err
+	}
+	if e.jobInfo.Blocking && e.jobInfo.Array != "" {
+		return errors.Errorf("node %q can't run a job array as a blocking job", e.NodeName)
+	}
+	if e.jobInfo.Blocking && e.jobInfo.SeparateErrorOutput {
+		return errors.Errorf("node %q can't write the error output of a blocking job to a dedicated file as its output is registered as a log", e.NodeName)
+	}
+	if e.nodeLocalScratch, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "node_local_scratch"); err != nil {
+		return err
+	}
+	if e.nodeLocalScratch && e.jobInfo.Nodes > 1 {
+		return errors.Errorf("node %q can't use node-local scratch as its job runs on %d nodes, only single node jobs are supported", e.NodeName, e.jobInfo.Nodes)
+	}
+	if err = e.getCheckpointProps(ctx); err != nil {
+		return err
+	}
+	if err = e.getHetComponentsProps(ctx); err != nil {
+		return err
+	}
+	if err = e.getPrivilegesProps(ctx); err != nil {
+		return err
+	}
+	return e.getWritableLayerProps(ctx)
+}
+
+// Retrieves the properties isolating the container from the host filesystems and environment
+func (e *executionSingularity) getIsolationProps(ctx context.Context) error {
+	var err error
+	if e.containAll, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "contain_all"); err != nil {
+		return err
+	}
+	if e.noHome, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "no_home"); err != nil {
+		return err
+	}
+	if e.noTmp, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "no_tmp"); err != nil {
+		return err
+	}
+	return e.checkIsolationProps()
+}
+
+// Rejects the granular isolation properties if the container is already fully isolated
+func (e *executionSingularity) checkIsolationProps() error {
+	if e.containAll && e.noHome {
+		return errors.Errorf("contain_all and no_home properties of node %q can't be used together, contain_all already isolates the home directory", e.NodeName)
+	}
+	if e.containAll && e.noTmp {
+		return errors.Errorf("contain_all and no_tmp properties of node %q can't be used together, contain_all already isolates the temporary directory", e.NodeName)
+	}
+	return nil
+}
+
+// Checks if the container doesn't inherit the host environment because of the clean_env or contain_all properties
+func (e *executionSingularity) isolatesEnvironment() bool {
+	return e.cleanEnv || e.containAll
+}
+
+// Retrieves the fakeroot and user namespace properties and checks they are allowed by the location
+func (e *executionSingularity) getPrivilegesProps(ctx context.Context) error {
+	var err error
+	if e.fakeroot, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "fakeroot"); err != nil {
+		return err
+	}
+	if e.userns, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "userns"); err != nil {
+		return err
+	}
+	return e.checkPrivilegesPolicy()
+}
+
+// Rejects the fakeroot and user namespace options if disabled on the location
+func (e *executionSingularity) checkPrivilegesPolicy() error {
+	if e.fakeroot && e.locationProps.GetBool("disable_fakeroot") {
+		return errors.Errorf("node %q requires to run the container with fakeroot, but fakeroot is disabled on this location by the \"disable_fakeroot\" property", e.NodeName)
+	}
+	if e.userns && e.locationProps.GetBool("disable_userns") {
+		return errors.Errorf("node %q requires to run the container in a user namespace, but user namespaces are disabled on this location by the \"disable_userns\" property", e.NodeName)
+	}
+	return nil
+}
+
+// Retrieves the properties defining a writable layer for the container: a temporary filesystem, an overlay or a sandbox directory
+func (e *executionSingularity) getWritableLayerProps(ctx context.Context) error {
+	var err error
+	if e.writableTmpfs, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "writable_tmpfs"); err != nil {
+		return err
+	}
+	if e.overlay, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "overlay", false); err != nil {
+		return err
+	}
+	if s, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "overlay_size"); err != nil {
+		return err
+	} else if s != nil && s.RawString() != "" {
+		size, err := humanize.ParseBytes(s.RawString())
+		if err != nil {
+			return errors.Wrapf(err, "invalid overlay size %q", s.RawString())
+		}
+		// Overlay images size is expressed in MiB
+		e.overlaySize = size / humanize.MiByte
+	}
+	if e.sandbox, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "sandbox_directory", false); err != nil {
+		return err
+	}
+	return e.checkWritableLayerProps()
+}
+
+// Rejects writable layer options that can't be used together
+func (e *executionSingularity) checkWritableLayerProps() error {
+	if e.sandbox != "" && e.overlay != "" {
+		return errors.Errorf("sandbox_directory and overlay properties of node %q can't be used together", e.NodeName)
+	}
+	if e.sandbox != "" && e.writableTmpfs {
+		return errors.Errorf("sandbox_directory and writable_tmpfs properties of node %q can't be used together, the sandbox is already writable", e.NodeName)
+	}
+	if e.overlaySize > 0 && e.overlay == "" {
+		return errors.Errorf("overlay_size property of node %q requires the overlay property to be set", e.NodeName)
+	}
+	if strings.HasSuffix(e.overlay, ":ro") && e.overlaySize > 0 {
+		return errors.Errorf("read-only overlay %q of node %q can't be created, overlay_size should not be set", e.overlay, e.NodeName)
+	}
+	return nil
+}
+
+// Checks that the overlay exists on the Slurm client node, or creates it as an image of the overlay_size size
+func (e *executionSingularity) prepareOverlay(ctx context.Context) error {
+	if e.overlay == "" {
+		return nil
+	}
+	overlayPath := strings.TrimSuffix(strings.TrimSuffix(e.overlay, ":ro"), ":rw")
+	if out, err := e.client.RunCommand(fmt.Sprintf("test -e %s", shellQuotePath(overlayPath))); err == nil {
+		return nil
+	} else if e.overlaySize == 0 {
+		return errors.Errorf("overlay %q doesn't exist on the Slurm client node, set the overlay_size property to create it: %s", overlayPath, out)
+	}
+	cmd := fmt.Sprintf("%s overlay create --size %d %s", e.containerRuntime(), e.overlaySize, shellQuotePath(overlayPath))
+	if e.jobInfo.DryRun {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+			"Dry run of node %q, the overlay image would be created with the command: %s", e.NodeName, cmd)
+		return nil
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+		"Creating overlay image %q of %d MiB for node %q", overlayPath, e.overlaySize, e.NodeName)
+	if out, err := e.client.RunCommand(cmd); err != nil {
+		return errors.Wrapf(err, "failed to create overlay image %q: %s", overlayPath, out)
+	}
+	return nil
+}
+
+// If an image cache directory is defined by the node or the location "image_cache_directory" property,
+// remote images are pulled once into this directory and the cached image is used by jobs.
+func (e *executionSingularity) resolveImageCache(ctx context.Context) error {
+	cacheDir, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "image_cache_directory", false)
+	if err != nil {
+		return err
+	}
+	if cacheDir == "" {
+		cacheDir = e.locationProps.GetString("image_cache_directory")
+	}
+	if cacheDir == "" || !strings.Contains(e.imageURI, "://") || isLocalArchiveImage(e.imageURI) {
+		return nil
+	}
+	cachedImage := path.Join(cacheDir, cachedImageName(e.imageURI))
+	if _, err := e.client.RunCommand(fmt.Sprintf("test -f %s", shellQuotePath(cachedImage))); err == nil {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+			"Image cache hit: image %q found in cache as %q", e.imageURI, cachedImage)
+	} else {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+			"Image cache miss: image %q will be pulled by the job into cache as %q", e.imageURI, cachedImage)
+		e.imageToPull = e.imageURI
+	}
+	e.imageURI = cachedImage
+	return nil
+}
+
+// Returns the command pulling the image into the cache if it was not cached yet.
+// Concurrent pulls of the same image are serialized using a lock file next to the cached image.
+func (e *executionSingularity) buildImagePullCmd(runtime string) string {
+	if e.imageToPull == "" {
+		return ""
+	}
+	image := shellQuotePath(e.imageURI)
+	return fmt.Sprintf("mkdir -p %s && ( flock -x 9 && if [ -f %s ]; then echo %s; else echo %s && %s pull %s.$$ %s && mv %s.$$ %s; fi ) 9>%s.lock || exit 1\n",
+		shellQuotePath(path.Dir(e.imageURI)), image, shellQuote("Image cache hit: "+e.imageURI), shellQuote("Image cache miss: pulling "+e.imageToPull),
+		runtime, image, shellQuote(e.imageToPull), image, image, image)
+}
+
+// Returns the name of the image file in cache, built from the image URI
+func cachedImageName(imageURI string) string {
+	return reInvalidNameChars.ReplaceAllString(strings.Replace(imageURI, "://", "_", 1), "_") + ".sif"
+}
+
+// Returns the image the container is run from: the sandbox directory if any, the resolved image URI otherwise
+func (e *executionSingularity) containerImage() string {
+	if e.sandbox != "" {
+		return shellQuotePath(e.sandbox)
+	}
+	if e.nodeLocalScratch {
+		return fmt.Sprintf(`"$%s"`, scratchImageVar)
+	}
+	return shellQuotePath(e.imageURI)
+}
+
+// Returns the command building the sandbox directory from the image if it doesn't exist yet
+func (e *executionSingularity) buildSandboxCmd(runtime string) string {
+	if e.sandbox == "" {
+		return ""
+	}
+	sandbox := shellQuotePath(e.sandbox)
+	return fmt.Sprintf("[ -d %s ] || %s build --sandbox %s %s || exit 1\n", sandbox, runtime, sandbox, shellQuotePath(e.imageURI))
+}
+
+// Returns the options passed to the container runtime "exec" or "run" command
+func (e *executionSingularity) buildContainerOptions() []string {
+	opts := append(gpuContainerOptions(e.gpu), e.buildGPUDevicesOptions()...)
+	return append(opts, e.buildCommonContainerOptions()...)
+}
+
+// Returns the container options exposing the GPUs of the given vendor
+func gpuContainerOptions(gpu string) []string {
+	switch gpu {
+	case "nvidia":
+		return []string{"--nv"}
+	case "amd":
+		return []string{"--rocm"}
+	}
+	return []string{}
+}
+
+// Returns the container options not related to GPUs, they are shared by all the components of a heterogeneous job
+func (e *executionSingularity) buildCommonContainerOptions() []string {
+	opts := make([]string, 0)
+	for _, b := range e.bindMounts {
+		opts = append(opts, "--bind", shellQuote(b))
+	}
+	if e.pwd != "" {
+		opts = append(opts, "--pwd", shellQuote(e.pwd))
+	}
+	switch {
+	case e.sandbox != "":
+		opts = append(opts, "--writable")
+	case e.overlay != "":
+		opts = append(opts, "--overlay", shellQuotePath(e.overlay))
+	}
+	if e.writableTmpfs {
+		opts = append(opts, "--writable-tmpfs")
+	}
+	if e.fakeroot {
+		opts = append(opts, "--fakeroot")
+	}
+	if e.userns {
+		opts = append(opts, "--userns")
+	}
+	// Bind mounts and the environment file are still honored by an isolated container
+	if e.containAll {
+		opts = append(opts, "--containall")
+	}
+	if e.noHome {
+		opts = append(opts, "--no-home")
+	}
+	if e.noTmp {
+		opts = append(opts, "--no-mount", "tmp")
+	}
+	if e.isolatesEnvironment() {
+		if !e.containAll {
+			opts = append(opts, "--cleanenv")
+		}
+		for _, v := range e.passthroughEnv {
+			opts = append(opts, "--env", fmt.Sprintf(`%s="${%s}"`, v, v))
+		}
+	}
+	if e.envFile != "" {
+		opts = append(opts, "--env-file", e.envFile)
+	}
+	opts = append(opts, e.commandOptions...)
+	// Extra arguments are not validated, they are quoted so that they are passed as is to the runtime
+	for _, a := range e.extraArgs {
+		opts = append(opts, shellQuote(a))
+	}
+	return opts
+}
+
+// Returns srun options used to launch the container, tasks are distributed according to the job options
+// and the MPI plugin is selected if required. All the job options are used by blocking jobs, unless they are run in an
+// existing allocation.
+func (e *executionSingularity) buildSrunOpts() string {
+	var opts string
+	switch {
+	case e.allocationJobID != "":
+		// The container is run as a step of the allocation, within its resources
+		opts = fmt.Sprintf(" --jobid=%s", e.allocationJobID) + e.buildStepOpts()
+	case e.jobInfo.Blocking:
+		// There is no batch job, srun allocates the job resources itself
+		opts = e.buildJobOpts()
+	default:
+		opts = e.buildStepOpts()
+	}
+	if e.mpi != "" {
+		opts += fmt.Sprintf(" --mpi=%s", shellQuote(e.mpi))
+	}
+	return opts + e.buildSrunExportOpt()
+}
+
+// Returns srun options distributing the tasks of a step within the resources of the job
+func (e *executionSingularity) buildStepOpts() string {
+	var opts string
+	if e.jobInfo.Nodes > 1 {
+		opts += fmt.Sprintf(" --nodes=%d", e.jobInfo.Nodes)
+	}
+	if e.jobInfo.Tasks > 1 {
+		opts += fmt.Sprintf(" --ntasks=%d", e.jobInfo.Tasks)
+	}
+	if e.jobInfo.TasksPerNode > 0 {
+		opts += fmt.Sprintf(" --ntasks-per-node=%d", e.jobInfo.TasksPerNode)
+	}
+	if (e.gpuDevices != "" || e.migProfile != "") && e.jobInfo.Gres != "" {
+		// The step uses the GPUs of the job so that the selected devices are visible
+		opts += fmt.Sprintf(" --gres=%s", shellQuote(e.jobInfo.Gres))
+	}
+	return opts
+}
+
+// Checks if the container environment is cleaned by the clean_env or contain_all properties, command options or extra arguments
+func (e *executionSingularity) hasCleanEnvironment() bool {
+	if e.isolatesEnvironment() {
+		return true
+	}
+	opts := make([]string, 0, len(e.commandOptions)+len(e.extraArgs))
+	for _, opt := range append(append(opts, e.commandOptions...), e.extraArgs...) {
+		switch opt {
+		case "-e", "--cleanenv", "-C", "--containall":
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if GPUs are requested to Slurm either using the gres job option or using extra and inline options
+func (e *executionSingularity) hasGPUAllocation() bool {
+	if strings.Contains(e.jobInfo.Gres, "gpu") {
+		return true
+	}
+	opts := append([]string{}, e.jobInfo.Opts...)
+	opts = append(opts, e.jobInfo.ExecutionOptions.InScriptOptions...)
+	for _, opt := range opts {
+		if strings.Contains(opt, "--gres") && strings.Contains(opt, "gpu") || strings.Contains(opt, "--gpus") || strings.HasPrefix(strings.TrimSpace(opt), "-G") {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry credentials are uploaded into a file readable only by the user rather than being exported
+// within the submitted command, so they never appear in logs.
+// The file is added to the job artifacts to be removed at the end of the job if not already done.
+func (e *executionSingularity) uploadRegistryCredentials(runtime string) (string, error) {
+	envPrefix := strings.ToUpper(runtime)
+	content := fmt.Sprintf("export %s_DOCKER_USERNAME=%s\nexport %s_DOCKER_PASSWORD=%s\n",
+		envPrefix, shellQuote(e.registryUser), envPrefix, shellQuote(e.registryToken))
+	filePath, err := e.uploadJobFile(registryCredentialsFile, content, true)
+	return filePath, errors.Wrap(err, "failed to upload registry credentials")
+}
+
+// Environment variables are uploaded into a file readable only by the user rather than being exported
+// within the submitted command, so they don't appear in logs and may contain any character.
+// This file is passed to the container runtime and loaded by the job for srun.
+func (e *executionSingularity) uploadEnvFile() error {
+	var b strings.Builder
+	for _, v := range e.listEnvVars() {
+		fmt.Fprintf(&b, "%s=%s\n", v[0], shellQuote(v[1]))
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	var err error
+	if e.envFile, err = e.uploadJobFile(jobEnvFile, b.String(), !e.jobInfo.KeepScript); err != nil {
+		return errors.Wrap(err, "failed to upload environment file")
+	}
+	e.envVarsInFile = true
+	return nil
+}
+
+// The container runtime binary is taken from the "container_runtime" node property, then from the location configuration.
+// If none is set, it is detected on the Slurm client node and cached for subsequent executions.
+func (e *executionSingularity) resolveContainerRuntime(ctx context.Context) error {
+	r, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "container_runtime", false)
+	if err != nil {
+		return err
+	}
+	if r == "" {
+		r = e.locationProps.GetString("container_runtime")
+	}
+	if r == "" {
+		host := e.locationProps.GetString("url") + ":" + e.locationProps.GetString("port")
+		if cached, ok := detectedContainerRuntimes.Load(host); ok {
+			r = cached.(string)
+		} else {
+			if r, err = detectContainerRuntime(e.client); err != nil {
+				return err
+			}
+			detectedContainerRuntimes.Store(host, r)
+		}
+	}
+	if r != singularityRuntime && r != apptainerRuntime {
+		return errors.Errorf("unsupported container runtime %q, supported ones are %q and %q", r, singularityRuntime, apptainerRuntime)
+	}
+	e.runtime = r
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf("Using container runtime %q for node %q", e.runtime, e.NodeName)
+	return nil
+}
+
+func detectContainerRuntime(client sshutil.Client) (string, error) {
+	out, err := client.RunCommand(containerRuntimeProbeCmd)
+	if err != nil {
+		return "", errors.Wrap(err, out)
+	}
+	r := strings.TrimSpace(out)
+	if r == "" {
+		return "", errors.Errorf("neither %q nor %q binaries were found on the Slurm client node PATH", singularityRuntime, apptainerRuntime)
+	}
+	log.Debugf("Detected container runtime %q", r)
+	return r, nil
+}
+
+// Checks the container working directory is an absolute path
+func (e *executionSingularity) checkPwd() error {
+	if e.pwd == "" {
+		return nil
+	}
+	if !path.IsAbs(e.pwd) {
+		return errors.Errorf("invalid pwd %q for node %q, expecting an absolute path in the container", e.pwd, e.NodeName)
+	}
+	e.pwd = path.Clean(e.pwd)
+	return nil
+}
+
+// Returns the host path of the container working directory if it is in a bind mount, empty otherwise.
+// When bind mounts are nested, the most specific one is used.
+func (e *executionSingularity) pwdHostPath() string {
+	var hostPath, containerPath string
+	for _, b := range e.bindMounts {
+		parts := strings.Split(b, ":")
+		c := path.Clean(parts[1])
+		if (e.pwd == c || strings.HasPrefix(e.pwd, strings.TrimSuffix(c, "/")+"/")) && len(c) > len(containerPath) {
+			hostPath, containerPath = parts[0], c
+		}
+	}
+	if containerPath == "" {
+		return ""
+	}
+	return path.Join(hostPath, strings.TrimPrefix(e.pwd, containerPath))
+}
+
+// Checks bind mount specifications of the form "host_path:container_path[:ro|rw]"
+func checkBindMounts(specs []string) error {
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return errors.Errorf("invalid bind mount %q, expecting \"host_path:container_path[:ro|rw]\"", spec)
+		}
+		if strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return errors.Errorf("invalid bind mount %q, host and container paths should not be empty", spec)
+		}
+		if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+			return errors.Errorf("invalid bind mount %q, mode should be either \"ro\" or \"rw\"", spec)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+	"github.com/ystia/yorc/v4/events"
+)
+
+// The node type holding a Slurm allocation created by salloc
+const allocationNodeType = "yorc.nodes.slurm.Compute"
+
+// Retrieves the allocation the container is run in: the allocation of a yorc.nodes.slurm.Compute node created by salloc.
+// The container runs synchronously as a step of this allocation, like a blocking job, so that several containers can be
+// run one after the other without waiting for the scheduler each time.
+func (e *executionSingularity) getAllocationProps(ctx context.Context) error {
+	allocation, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "allocation", false)
+	if err != nil || allocation == "" {
+		return err
+	}
+	if exist, err := deployments.DoesNodeExist(ctx, e.deploymentID, allocation); err != nil {
+		return err
+	} else if exist {
+		nodeType, err := deployments.GetNodeType(ctx, e.deploymentID, allocation)
+		if err != nil {
+			return err
+		}
+		if nodeType != allocationNodeType {
+			return errors.Errorf("allocation %q of node %q should be a %s node, not a %s node", allocation, e.NodeName, allocationNodeType, nodeType)
+		}
+	}
+	if e.allocationJobID, err = e.getNodeJobID(ctx, allocation); err != nil {
+		return errors.Wrapf(err, "invalid allocation %q for node %q", allocation, e.NodeName)
+	}
+	e.allocationNode = allocation
+	if e.releaseAllocation, err = deployments.GetBooleanNodeProperty(ctx, e.deploymentID, e.NodeName, "release_allocation"); err != nil {
+		return err
+	}
+	e.jobInfo.Blocking = true
+	return nil
+}
+
+// Checks that the allocation the container is run in is still running, it may have been released or reached its time limit
+func (e *executionSingularity) checkAllocation() error {
+	if e.allocationJobID == "" || e.jobInfo.DryRun {
+		return nil
+	}
+	state, _, err := getJobQueueState(e.client, e.allocationJobID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to retrieve the state of allocation %q of node %q", e.allocationNode, e.NodeName)
+	}
+	if state != "RUNNING" {
+		if state == "" {
+			state = "COMPLETED"
+		}
+		return errors.Errorf("allocation %q (job ID %s) of node %q is not running (state %s), it may have been released or reached its time limit",
+			e.allocationNode, e.allocationJobID, e.NodeName, state)
+	}
+	return nil
+}
+
+// Releases the allocation the container has been run in if release_allocation is set, whether the container succeeded
+// or not. The job ID of the allocation node is cleared so that its deletion doesn't cancel the allocation again.
+// Failures are only logged as the allocation is cancelled anyway when the allocation node is deleted.
+func (e *executionSingularity) releaseJobAllocation(ctx context.Context) {
+	if !e.releaseAllocation || e.allocationJobID == "" || e.jobInfo.DryRun {
+		return
+	}
+	if err := cancelJobID(e.allocationJobID, e.client); err != nil {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, e.deploymentID).Registerf(
+			"Failed to release allocation %q (job ID %s) after running node %q, it will be released when the allocation node is deleted: %v",
+			e.allocationNode, e.allocationJobID, e.NodeName, err)
+		return
+	}
+	// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
+	if err := deployments.SetInstanceAttribute(ctx, e.deploymentID, e.allocationNode, "0", "job_id", ""); err != nil {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, e.deploymentID).Registerf(
+			"Failed to clear the job ID of released allocation %q: %v", e.allocationNode, err)
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+		"Allocation %q (job ID %s) released after running node %q", e.allocationNode, e.allocationJobID, e.NodeName)
+	e.allocationJobID = ""
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+)
+
+// Variable holding the number of times the job has been requeued, the job command resumes from its last checkpoint if it is greater than 0
+const restartCountVar = "YORC_RESTART_COUNT"
+
+// Default time (in seconds) between the checkpoint signal and the end of the job time limit
+const defaultCheckpointSignalTime = 120
+
+// Retrieves the checkpoint properties: if a checkpoint script is defined, the job is submitted as requeueable
+// and the batch script is signaled before the end of the job time limit to run the checkpoint script.
+func (e *executionSingularity) getCheckpointProps(ctx context.Context) error {
+	var err error
+	if e.checkpointScript, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "checkpoint_script", false); err != nil {
+		return err
+	}
+	e.checkpointScript = strings.TrimSpace(e.checkpointScript)
+	if e.checkpointScript == "" {
+		return nil
+	}
+	switch {
+	case e.jobInfo.Blocking:
+		return errors.Errorf("node %q can't checkpoint a blocking job", e.NodeName)
+	case e.nodeLocalScratch:
+		return errors.Errorf("node %q can't checkpoint a job running from node-local scratch as its outputs are not copied back when the job is requeued", e.NodeName)
+	case e.jobInfo.Array != "":
+		return errors.Errorf("node %q can't checkpoint a job array", e.NodeName)
+	}
+	signalTime := defaultCheckpointSignalTime
+	if t, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "checkpoint_signal_time"); err != nil {
+		return err
+	} else if t != nil && t.RawString() != "" {
+		if signalTime, err = strconv.Atoi(t.RawString()); err != nil || signalTime <= 0 {
+			return errors.Errorf("invalid checkpoint_signal_time %q for node %q, expecting a positive number of seconds", t.RawString(), e.NodeName)
+		}
+	}
+	e.jobInfo.Requeue = true
+	e.jobInfo.Signal = fmt.Sprintf("B:USR1@%d", signalTime)
+	if e.isolatesEnvironment() {
+		e.passthroughEnv = append(e.passthroughEnv, restartCountVar)
+	}
+	return nil
+}
+
+// Returns the checkpoint script path, relative paths are relative to the job working directory
+func (e *executionSingularity) checkpointScriptPath() string {
+	if path.IsAbs(e.checkpointScript) {
+		return shellQuote(e.checkpointScript)
+	}
+	// The working directory is not quoted as it may be relative to the home directory
+	return path.Join(e.jobInfo.WorkingDir, shellQuote(e.checkpointScript))
+}
+
+// Wraps the job command so that the checkpoint script is run when the batch script receives the checkpoint signal,
+// the job is then requeued. The job command runs in background as bash only runs traps between foreground commands.
+// The restart count of the job is exported so that the job command knows when it should resume from its last checkpoint.
+func (e *executionSingularity) buildCheckpointWrapper(inner string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "export %s=${SLURM_RESTART_COUNT:-0}\n", restartCountVar)
+	fmt.Fprintf(&b, "if [ \"$%s\" -gt 0 ]; then echo \"Job $SLURM_JOB_ID restarted $%s time(s), resuming from its last checkpoint\" >&2; fi\n", restartCountVar, restartCountVar)
+	b.WriteString("yorc_checkpoint() {\n")
+	b.WriteString("echo \"Checkpointing job $SLURM_JOB_ID before its end\" >&2\n")
+	fmt.Fprintf(&b, "(cd %s && bash %s) || echo \"Checkpoint script failed with exit code $?\" >&2\n", e.jobInfo.WorkingDir, e.checkpointScriptPath())
+	b.WriteString("scontrol requeue \"$SLURM_JOB_ID\"\n")
+	b.WriteString("}\n")
+	b.WriteString("trap yorc_checkpoint USR1\n")
+	fmt.Fprintf(&b, "{\n%s\n} &\n", inner)
+	b.WriteString("YORC_STEP_PID=$!\n")
+	// wait is interrupted by the signal, the job command is waited again once the trap is run
+	b.WriteString("wait $YORC_STEP_PID; rc=$?\n")
+	b.WriteString("while [ $rc -gt 128 ] && kill -0 $YORC_STEP_PID 2>/dev/null; do wait $YORC_STEP_PID; rc=$?; done\n")
+	b.WriteString("exit $rc\n")
+	return b.String()
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+)
+
+// GPU devices are selected by index (relative to the GPUs allocated to the job) or by GPU or MIG device UUID
+var reGPUDevice = regexp.MustCompile(`^(\d+|GPU-[0-9a-fA-F-]+|MIG-[0-9a-zA-Z/-]+)$`)
+
+// MIG profiles are named after their compute and memory slices (ie 1g.5gb or 3g.20gb)
+var reMIGProfile = regexp.MustCompile(`^\d+g\.\d+gb$`)
+
+// Retrieves the GPU devices visible in the container and the MIG profile of the requested GPUs.
+// They are only supported for NVIDIA GPUs. Without gres job option, the gres is built from the MIG profile,
+// otherwise the gres and the MIG profile should be consistent.
+func (e *executionSingularity) getGPUDevicesProps(ctx context.Context) error {
+	var err error
+	if e.gpuDevices, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "gpu_devices", false); err != nil {
+		return err
+	}
+	if e.migProfile, err = deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "mig_profile", false); err != nil {
+		return err
+	}
+	e.gpuDevices, e.migProfile = strings.Replace(e.gpuDevices, " ", "", -1), strings.TrimSpace(e.migProfile)
+	return e.checkGPUDevices()
+}
+
+// Checks the consistency of the selected GPU devices, the MIG profile and the GPUs requested to Slurm
+func (e *executionSingularity) checkGPUDevices() error {
+	if e.gpuDevices == "" && e.migProfile == "" {
+		return nil
+	}
+	if e.gpu != "nvidia" {
+		return errors.Errorf("node %q selects GPU devices or a MIG profile, the gpu property should be set to \"nvidia\"", e.NodeName)
+	}
+	if e.migProfile != "" {
+		if !reMIGProfile.MatchString(e.migProfile) {
+			return errors.Errorf("invalid MIG profile %q for node %q, expecting a profile like 1g.5gb", e.migProfile, e.NodeName)
+		}
+		if e.jobInfo.Gres == "" {
+			e.jobInfo.Gres = fmt.Sprintf("gpu:%s:1", e.migProfile)
+		} else if gpuType, _ := parseGPUGres(e.jobInfo.Gres); gpuType != e.migProfile {
+			return errors.Errorf("gres %q of node %q doesn't request GPUs of MIG profile %q", e.jobInfo.Gres, e.NodeName, e.migProfile)
+		}
+	}
+	if e.gpuDevices == "" {
+		return nil
+	}
+	devices := strings.Split(e.gpuDevices, ",")
+	for _, d := range devices {
+		if !reGPUDevice.MatchString(d) {
+			return errors.Errorf("invalid GPU device %q for node %q, expecting a device index, a GPU UUID or a MIG device UUID", d, e.NodeName)
+		}
+	}
+	if _, count := parseGPUGres(e.jobInfo.Gres); count > 0 && len(devices) > count {
+		return errors.Errorf("node %q selects %d GPU devices but only %d GPUs are requested by gres %q", e.NodeName, len(devices), count, e.jobInfo.Gres)
+	}
+	return nil
+}
+
+// Returns the type and the count of the GPUs requested by the given gres (ie gpu:a100:2 or gpu:1g.5gb:1,tmp:10G).
+// The count is 0 if no GPU is requested, and 1 if not specified.
+func parseGPUGres(gres string) (string, int) {
+	for _, r := range strings.Split(gres, ",") {
+		parts := strings.Split(strings.TrimSpace(r), ":")
+		if parts[0] != "gpu" {
+			continue
+		}
+		switch len(parts) {
+		case 1:
+			return "", 1
+		case 2:
+			if count, err := strconv.Atoi(parts[1]); err == nil {
+				return "", count
+			}
+			return parts[1], 1
+		default:
+			count, _ := strconv.Atoi(parts[2])
+			return parts[1], count
+		}
+	}
+	return "", 0
+}
+
+// Returns the container options making only the selected GPU devices visible
+func (e *executionSingularity) buildGPUDevicesOptions() []string {
+	if e.gpuDevices == "" {
+		return nil
+	}
+	return []string{
+		"--env", "CUDA_VISIBLE_DEVICES=" + shellQuote(e.gpuDevices),
+		"--env", "NVIDIA_VISIBLE_DEVICES=" + shellQuote(e.gpuDevices),
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+)
+
+// Separates the options of the components of a heterogeneous job on the sbatch and srun command lines
+const hetJobSeparator = ":"
+
+// Retrieves the additional components of a heterogeneous job, the first component being described by the job options.
+// Each component has its own resources and runs its own command in the job container image, all the components
+// are launched by a single srun command so that they can be coupled (ie. using MPI).
+func (e *executionSingularity) getHetComponentsProps(ctx context.Context) error {
+	h, err := deployments.GetNodePropertyValue(ctx, e.deploymentID, e.NodeName, "het_components")
+	if err != nil {
+		return err
+	}
+	if h == nil || h.RawString() == "" {
+		return nil
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{WeaklyTypedInput: true, Result: &e.jobInfo.HetComponents})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(h.Value); err != nil {
+		return errors.Wrapf(err, "invalid het_components property for node %q", e.NodeName)
+	}
+	return e.checkHetComponents()
+}
+
+// Checks the components of a heterogeneous job, their memory is converted to the Slurm format
+func (e *executionSingularity) checkHetComponents() error {
+	if len(e.jobInfo.HetComponents) == 0 {
+		return nil
+	}
+	switch {
+	case e.jobInfo.Blocking:
+		return errors.Errorf("node %q can't run a heterogeneous job as a blocking job", e.NodeName)
+	case e.jobInfo.Array != "":
+		return errors.Errorf("node %q can't run a heterogeneous job as a job array", e.NodeName)
+	case e.nodeLocalScratch:
+		return errors.Errorf("node %q can't use node-local scratch for a heterogeneous job", e.NodeName)
+	}
+	for i := range e.jobInfo.HetComponents {
+		c := &e.jobInfo.HetComponents[i]
+		// Component 0 is described by the job options
+		index := i + 1
+		if c.Nodes == 0 {
+			c.Nodes = 1
+		}
+		if c.Nodes < 0 || c.Tasks < 0 || c.TasksPerNode < 0 || c.Cpus < 0 {
+			return errors.Errorf("invalid component %d of the heterogeneous job of node %q, nodes, tasks and cpus should be positive", index, e.NodeName)
+		}
+		if c.Partition != "" && !reSlurmName.MatchString(c.Partition) {
+			return errors.Errorf("invalid partition %q of component %d of the heterogeneous job of node %q", c.Partition, index, e.NodeName)
+		}
+		switch c.GPU {
+		case "", "none", "nvidia", "amd":
+		default:
+			return errors.Errorf("invalid gpu %q of component %d of the heterogeneous job of node %q, expecting nvidia or amd", c.GPU, index, e.NodeName)
+		}
+		if c.Mem != "" {
+			mem, err := parseMemorySize(c.Mem)
+			if err != nil {
+				return errors.Wrapf(err, "invalid memory of component %d of the heterogeneous job of node %q", index, e.NodeName)
+			}
+			c.Mem = mem
+		}
+	}
+	return nil
+}
+
+// Returns the sbatch options of a component of a heterogeneous job
+func (c hetComponent) buildJobOptsList(q func(string) string) []string {
+	opts := []string{fmt.Sprintf("--nodes=%d", c.Nodes)}
+	if c.Tasks > 1 {
+		opts = append(opts, fmt.Sprintf("--ntasks=%d", c.Tasks))
+	}
+	if c.TasksPerNode > 0 {
+		opts = append(opts, fmt.Sprintf("--ntasks-per-node=%d", c.TasksPerNode))
+	}
+	if c.Mem != "" {
+		opts = append(opts, fmt.Sprintf("--mem=%s", q(c.Mem)))
+	}
+	if c.Cpus != 0 {
+		opts = append(opts, fmt.Sprintf("--cpus-per-task=%d", c.Cpus))
+	}
+	if c.Gres != "" {
+		opts = append(opts, fmt.Sprintf("--gres=%s", q(c.Gres)))
+	}
+	if c.Partition != "" {
+		opts = append(opts, fmt.Sprintf("--partition=%s", q(c.Partition)))
+	}
+	return opts
+}
+
+// Returns the srun command launching the containers of all the components of a heterogeneous job as a single step.
+// The container command of the first component is given, the other ones run their own command in the same image.
+func (e *executionSingularity) buildHetSrunCommand(runtime, debug, containerCmd string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s --het-group=0%s %s", srunCommand, e.buildSrunOpts(), containerCmd)
+	commonOpts := e.buildCommonContainerOptions()
+	for i, c := range e.jobInfo.HetComponents {
+		opts := strings.Join(append(gpuContainerOptions(c.GPU), commonOpts...), " ")
+		var cmd string
+		if c.Command != "" {
+			cmd = fmt.Sprintf("%s %s exec %s %s %s %s", runtime, debug, opts, e.containerImage(), c.Command, quoteArgs(c.Args))
+		} else {
+			cmd = fmt.Sprintf("%s %s run %s %s %s", runtime, debug, opts, e.containerImage(), quoteArgs(c.Args))
+		}
+		fmt.Fprintf(&b, " %s --het-group=%d%s %s", hetJobSeparator, i+1, e.buildHetSrunOpts(c), e.wrapContainerEnv(runtime, strings.TrimSpace(cmd)))
+	}
+	return b.String()
+}
+
+// Returns the srun options of a component of a heterogeneous job
+func (e *executionSingularity) buildHetSrunOpts(c hetComponent) string {
+	var opts string
+	if c.Nodes > 1 {
+		opts += fmt.Sprintf(" --nodes=%d", c.Nodes)
+	}
+	if c.Tasks > 1 {
+		opts += fmt.Sprintf(" --ntasks=%d", c.Tasks)
+	}
+	if c.TasksPerNode > 0 {
+		opts += fmt.Sprintf(" --ntasks-per-node=%d", c.TasksPerNode)
+	}
+	if e.mpi != "" {
+		opts += fmt.Sprintf(" --mpi=%s", shellQuote(e.mpi))
+	}
+	return opts + e.buildSrunExportOpt()
+}
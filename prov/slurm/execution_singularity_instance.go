@@ -0,0 +1,179 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/deployments"
+	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/prov"
+	"github.com/ystia/yorc/v4/prov/scheduling"
+	"github.com/ystia/yorc/v4/tosca"
+)
+
+const (
+	serviceStartOperationName = tosca.StandardInterfaceName + ".start"
+	serviceStopOperationName  = tosca.StandardInterfaceName + ".stop"
+)
+
+// Action type used to check that a singularity instance is still running
+const instanceMonitoringActionType = "singularity-instance-monitoring"
+
+// Command running a step within an existing job allocation, used to reach the node running an instance
+const jobStepCommand = "srun --jobid=%s --overlap --nodes=1 --ntasks=1 %s"
+
+// Period in seconds used by the job to check if the instance it started is still running
+const instanceWatchPeriod = 30
+
+var reInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// Starts a named instance within a Slurm job lasting as long as the instance is running,
+// then registers an action monitoring this instance.
+func (e *executionSingularity) startInstance(ctx context.Context) error {
+	if e.hasCommand() {
+		return errors.Errorf("a command can't be executed by the singularity service %q, the image start script is used instead", e.NodeName)
+	}
+	if e.allocationJobID != "" {
+		return errors.Errorf("the singularity service %q can't be run in an allocation, it runs in its own job", e.NodeName)
+	}
+	name, err := e.resolveInstanceName(ctx)
+	if err != nil {
+		return err
+	}
+	var debug string
+	if e.debug {
+		debug = "-d -v"
+	}
+	runtime := e.containerRuntime()
+	if err = e.uploadEnvFile(); err != nil {
+		return err
+	}
+	cmdOpts := strings.Join(e.buildContainerOptions(), " ")
+	startCmd := fmt.Sprintf("%s %s instance start %s %s %s %s", runtime, debug, cmdOpts, e.containerImage(), name, quoteArgs(e.jobInfo.ExecutionOptions.Args))
+	// The job lasts as long as the instance is running
+	inner := fmt.Sprintf("%s || exit 1\nwhile %s instance list %s | grep -qwF -- %s ; do sleep %d ; done",
+		startCmd, runtime, name, name, instanceWatchPeriod)
+	if err = e.submitContainerJob(ctx, runtime, inner); err != nil {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelERROR, e.deploymentID).RegisterAsString(err.Error())
+		return errors.Wrapf(err, "failed to submit job for singularity service %q", e.NodeName)
+	}
+	if e.jobInfo.DryRun {
+		return nil
+	}
+	if err = deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "job_id", e.jobInfo.ID); err != nil {
+		return errors.Wrap(err, "failed to store job id an manual cleanup may be necessary: ")
+	}
+	if err = deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "instance_name", name); err != nil {
+		return errors.Wrap(err, "failed to store instance name an manual cleanup may be necessary: ")
+	}
+
+	cc, err := e.cfg.GetConsulClient()
+	if err != nil {
+		return err
+	}
+	action := &prov.Action{ActionType: instanceMonitoringActionType, Data: map[string]string{
+		"nodeName":     e.NodeName,
+		"jobID":        e.jobInfo.ID,
+		"instanceName": name,
+		"runtime":      runtime,
+	}}
+	id, err := scheduling.RegisterAction(cc, e.deploymentID, e.jobInfo.MonitoringTimeInterval, action)
+	if err != nil {
+		return errors.Wrapf(err, "failed to register monitoring of singularity instance %q", name)
+	}
+	if err = deployments.SetAttributeForAllInstances(ctx, e.deploymentID, e.NodeName, "instance_monitoring_id", id); err != nil {
+		return err
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+		"Singularity instance %q of node %q submitted with job ID %q", name, e.NodeName, e.jobInfo.ID)
+	return nil
+}
+
+// Stops the instance started by the start operation and the job running it
+func (e *executionSingularity) stopInstance(ctx context.Context) error {
+	// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
+	monitoringID, err := e.getInstanceAttribute(ctx, "instance_monitoring_id")
+	if err != nil {
+		return err
+	}
+	if monitoringID != "" {
+		// Monitoring is stopped first as the instance is expected to stop
+		cc, err := e.cfg.GetConsulClient()
+		if err != nil {
+			return err
+		}
+		if err = scheduling.UnregisterAction(cc, monitoringID); err != nil {
+			return err
+		}
+	}
+	jobID, err := e.getInstanceAttribute(ctx, "job_id")
+	if err != nil {
+		return err
+	}
+	name, err := e.getInstanceAttribute(ctx, "instance_name")
+	if err != nil {
+		return err
+	}
+	if jobID == "" || name == "" {
+		log.Printf("No singularity instance to stop for node %q in deployment %q", e.NodeName, e.deploymentID)
+		return nil
+	}
+	if err = e.resolveContainerRuntime(ctx); err != nil {
+		return errors.Wrap(err, "failed to resolve container runtime")
+	}
+
+	cmd := fmt.Sprintf(jobStepCommand, jobID, fmt.Sprintf("%s instance stop %s", e.runtime, name))
+	if out, err := e.client.RunCommand(cmd); err != nil {
+		// The job cancellation below stops the instance anyway
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, e.deploymentID).Registerf(
+			"Failed to stop singularity instance %q gracefully: %v: %s", name, err, out)
+	}
+	if err = cancelJobID(jobID, e.client); err != nil {
+		return err
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, e.deploymentID).Registerf(
+		"Singularity instance %q of node %q stopped", name, e.NodeName)
+	return nil
+}
+
+// Returns the instance name defined by the "instance_name" property, or a name built from the deployment and node names
+func (e *executionSingularity) resolveInstanceName(ctx context.Context) (string, error) {
+	name, err := deployments.GetStringNodeProperty(ctx, e.deploymentID, e.NodeName, "instance_name", false)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return reInvalidNameChars.ReplaceAllString(e.deploymentID+"_"+e.NodeName, "_"), nil
+	}
+	if reInvalidNameChars.MatchString(name) {
+		return "", errors.Errorf("invalid singularity instance name %q, only letters, digits, '_', '.' and '-' are allowed", name)
+	}
+	return name, nil
+}
+
+func (e *executionSingularity) getInstanceAttribute(ctx context.Context, attributeName string) (string, error) {
+	value, err := deployments.GetInstanceAttributeValue(ctx, e.deploymentID, e.NodeName, "0", attributeName)
+	if err != nil || value == nil {
+		return "", err
+	}
+	return value.RawString(), nil
+}
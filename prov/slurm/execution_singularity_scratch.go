@@ -0,0 +1,62 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Variable holding the path of the container image, it points to the staged image when the job runs from node-local scratch
+const scratchImageVar = "YORC_IMAGE"
+
+// Returns the job prologue staging the container image and the job artifacts into the node-local scratch directory
+// of the job ($SLURM_TMPDIR or $TMPDIR), the job then runs from this directory.
+// If no scratch directory is available, the job runs from its working directory.
+func (e *executionSingularity) buildScratchPrologue() string {
+	artifacts := make([]string, 0, len(e.Artifacts))
+	for name := range e.Artifacts {
+		// The working directory is not quoted as it may be relative to the home directory
+		artifacts = append(artifacts, path.Join(e.jobInfo.WorkingDir, shellQuote(name)))
+	}
+	sort.Strings(artifacts)
+	var stageArtifacts string
+	if len(artifacts) > 0 {
+		stageArtifacts = fmt.Sprintf(" && cp -r %s \"$YORC_SCRATCH_DIR\"/", strings.Join(artifacts, " "))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "export %s=%s\n", scratchImageVar, shellQuotePath(e.imageURI))
+	b.WriteString("YORC_SCRATCH_DIR=\"${SLURM_TMPDIR:-$TMPDIR}\"\n")
+	b.WriteString("if [ -n \"$SLURM_JOB_ID\" ] && [ -n \"$YORC_SCRATCH_DIR\" ] && [ -d \"$YORC_SCRATCH_DIR\" ]; then\n")
+	b.WriteString("YORC_SCRATCH_DIR=\"$YORC_SCRATCH_DIR/yorc-$SLURM_JOB_ID\"\n")
+	fmt.Fprintf(&b, "mkdir -p \"$YORC_SCRATCH_DIR\"%s || exit 1\n", stageArtifacts)
+	// The image is staged next to the scratch directory so that it is not copied back with the job outputs
+	fmt.Fprintf(&b, "if [ -f \"$%s\" ]; then cp \"$%s\" \"$YORC_SCRATCH_DIR.sif\" && %s=\"$YORC_SCRATCH_DIR.sif\" || exit 1; fi\n", scratchImageVar, scratchImageVar, scratchImageVar)
+	b.WriteString("cd \"$YORC_SCRATCH_DIR\" || exit 1\n")
+	b.WriteString("else\n")
+	b.WriteString("echo \"No node-local scratch directory available, the job runs from its working directory\" >&2\n")
+	b.WriteString("YORC_SCRATCH_DIR=\n")
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// Returns the job epilogue copying the job outputs back from the node-local scratch directory to the job working directory
+// and removing the scratch directory. The job exit status is preserved.
+func (e *executionSingularity) buildScratchEpilogue() string {
+	return fmt.Sprintf("\nrc=$?\nif [ -n \"$YORC_SCRATCH_DIR\" ]; then cp -r \"$YORC_SCRATCH_DIR\"/. %s/ || rc=1; rm -rf \"$YORC_SCRATCH_DIR\" \"$YORC_SCRATCH_DIR.sif\"; fi\nexit $rc\n",
+		e.jobInfo.WorkingDir)
+}
@@ -0,0 +1,1027 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/deployments"
+	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/helper/sshutil"
+	"github.com/ystia/yorc/v4/prov"
+	"github.com/ystia/yorc/v4/testutil"
+	"github.com/ystia/yorc/v4/tosca"
+	"github.com/ystia/yorc/v4/tosca/types"
+)
+
+func Test_detectContainerRuntime(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		cmdErr  error
+		want    string
+		wantErr bool
+	}{
+		{"SingularityFound", "singularity\n", nil, "singularity", false},
+		{"ApptainerFound", "apptainer\n", nil, "apptainer", false},
+		{"NoneFound", "", nil, "", true},
+		{"ProbeFailure", "connection lost", errors.New("exit status 255"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &sshutil.MockSSHClient{
+				MockRunCommand: func(cmd string) (string, error) {
+					assert.Equal(t, containerRuntimeProbeCmd, cmd)
+					return tt.out, tt.cmdErr
+				},
+			}
+			got, err := detectContainerRuntime(client)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("detectContainerRuntime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_executionSingularity_buildContainerOptions(t *testing.T) {
+	tests := []struct {
+		name           string
+		gpu            string
+		bindMounts     []string
+		commandOptions []string
+		extraArgs      []string
+		want           []string
+		wantCleanEnv   bool
+	}{
+		{"NoGPU", "none", nil, []string{"--cleanenv"}, nil, []string{"--cleanenv"}, true},
+		{"NvidiaGPU", "nvidia", nil, []string{"--cleanenv"}, nil, []string{"--nv", "--cleanenv"}, true},
+		{"AMDGPU", "amd", nil, nil, nil, []string{"--rocm"}, false},
+		{"BindMounts", "", []string{"/data:/data:ro", "/scratch:/scratch"}, nil, nil, []string{"--bind", "'/data:/data:ro'", "--bind", "'/scratch:/scratch'"}, false},
+		{"QuotedBindMount", "", []string{"/data/it's:/data"}, nil, nil, []string{"--bind", `'/data/it'\''s:/data'`}, false},
+		{"ExtraArgs", "nvidia", nil, []string{"--cleanenv"}, []string{"--no-home", "--hostname", "my host"}, []string{"--nv", "--cleanenv", "'--no-home'", "'--hostname'", "'my host'"}, true},
+		{"ExtraArgsCleanEnv", "", nil, nil, []string{"--containall"}, []string{"'--containall'"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{gpu: tt.gpu, bindMounts: tt.bindMounts, commandOptions: tt.commandOptions, extraArgs: tt.extraArgs}
+			assert.Equal(t, tt.want, e.buildContainerOptions())
+			assert.Equal(t, tt.wantCleanEnv, e.hasCleanEnvironment())
+		})
+	}
+}
+
+func Test_executionSingularity_pwd(t *testing.T) {
+	tests := []struct {
+		name         string
+		pwd          string
+		bindMounts   []string
+		wantPwd      string
+		wantHostPath string
+		wantErr      bool
+	}{
+		{"NoPwd", "", []string{"/data:/data"}, "", "", false},
+		{"NotBound", "/opt/app", []string{"/data:/data"}, "/opt/app", "", false},
+		{"BindMount", "/mnt/data/", []string{"/home/user/data:/mnt/data:ro"}, "/mnt/data", "/home/user/data", false},
+		{"InBindMount", "/mnt/data/run/../out", []string{"/home/user/data:/mnt/data"}, "/mnt/data/out", "/home/user/data/out", false},
+		{"NestedBindMounts", "/mnt/data/out", []string{"/home/user/data:/mnt/data", "/scratch/out:/mnt/data/out"}, "/mnt/data/out", "/scratch/out", false},
+		{"PrefixOnly", "/mnt/database", []string{"/home/user/data:/mnt/data"}, "/mnt/database", "", false},
+		{"RootBindMount", "/work", []string{"/scratch:/"}, "/work", "/scratch/work", false},
+		{"RelativePath", "work", nil, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{NodeName: "Job"}, pwd: tt.pwd, bindMounts: tt.bindMounts}
+			err := e.checkPwd()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPwd, e.pwd)
+			assert.Equal(t, tt.wantHostPath, e.pwdHostPath())
+			if tt.pwd != "" {
+				assert.Contains(t, strings.Join(e.buildContainerOptions(), " "), "--pwd '"+tt.wantPwd+"'")
+			}
+		})
+	}
+}
+
+func Test_executionSingularity_writableLayer(t *testing.T) {
+	tests := []struct {
+		name        string
+		e           *executionSingularity
+		wantErr     bool
+		wantOptions []string
+		wantImage   string
+		wantSandbox string
+	}{
+		{"ReadOnly", &executionSingularity{}, false, []string{}, "'docker://alpine'", ""},
+		{"WritableTmpfs", &executionSingularity{writableTmpfs: true}, false, []string{"--writable-tmpfs"}, "'docker://alpine'", ""},
+		{"Overlay", &executionSingularity{overlay: "~/overlay.img", overlaySize: 512}, false, []string{"--overlay", "~/'overlay.img'"}, "'docker://alpine'", ""},
+		{"ReadOnlyOverlayWithTmpfs", &executionSingularity{overlay: "~/overlay.img:ro", writableTmpfs: true}, false, []string{"--overlay", "~/'overlay.img:ro'", "--writable-tmpfs"}, "'docker://alpine'", ""},
+		{"Sandbox", &executionSingularity{sandbox: "~/alpine"}, false, []string{"--writable"}, "~/'alpine'", "[ -d ~/'alpine' ] || apptainer build --sandbox ~/'alpine' 'docker://alpine' || exit 1\n"},
+		{"QuotedSandbox", &executionSingularity{sandbox: "/scratch/my sandbox;"}, false, []string{"--writable"}, "'/scratch/my sandbox;'", "[ -d '/scratch/my sandbox;' ] || apptainer build --sandbox '/scratch/my sandbox;' 'docker://alpine' || exit 1\n"},
+		{"SandboxAndOverlay", &executionSingularity{sandbox: "~/alpine", overlay: "~/overlay.img"}, true, nil, "", ""},
+		{"SandboxAndWritableTmpfs", &executionSingularity{sandbox: "~/alpine", writableTmpfs: true}, true, nil, "", ""},
+		{"OverlaySizeWithoutOverlay", &executionSingularity{overlaySize: 512}, true, nil, "", ""},
+		{"ReadOnlyOverlayCreation", &executionSingularity{overlay: "~/overlay.img:ro", overlaySize: 512}, true, nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.e.executionCommon = &executionCommon{NodeName: "Job"}
+			tt.e.imageURI = "docker://alpine"
+			err := tt.e.checkWritableLayerProps()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executionSingularity.checkWritableLayerProps() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.wantOptions, tt.e.buildContainerOptions())
+			assert.Equal(t, tt.wantImage, tt.e.containerImage())
+			assert.Equal(t, tt.wantSandbox, tt.e.buildSandboxCmd("apptainer"))
+		})
+	}
+}
+
+func Test_executionSingularity_hasGPUAllocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobInfo *jobInfo
+		want    bool
+	}{
+		{"NoAllocation", &jobInfo{Opts: []string{"--partition=gpu_part"}}, false},
+		{"GresOption", &jobInfo{Gres: "gpu:2"}, true},
+		{"OtherGres", &jobInfo{Gres: "bandwidth:100"}, false},
+		{"ExtraOptions", &jobInfo{Opts: []string{"--gres=gpu:1"}}, true},
+		{"GpusExtraOption", &jobInfo{Opts: []string{"--gpus-per-node=4"}}, true},
+		{"InScriptOption", &jobInfo{ExecutionOptions: types.SlurmExecutionOptions{InScriptOptions: []string{"#SBATCH --gres=gpu:1"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{jobInfo: tt.jobInfo}}
+			assert.Equal(t, tt.want, e.hasGPUAllocation())
+		})
+	}
+}
+
+func Test_checkBindMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		wantErr bool
+	}{
+		{"NoBindMounts", nil, false},
+		{"ValidBindMounts", []string{"/data:/mnt/data", "/scratch:/scratch:rw", "/refs:/refs:ro"}, false},
+		{"MissingContainerPath", []string{"/data"}, true},
+		{"EmptyHostPath", []string{":/mnt/data"}, true},
+		{"EmptyContainerPath", []string{"/data: "}, true},
+		{"InvalidMode", []string{"/data:/mnt/data:rx"}, true},
+		{"TooManyParts", []string{"/data:/mnt/data:ro:rw"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkBindMounts(tt.specs); (err != nil) != tt.wantErr {
+				t.Errorf("checkBindMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testExecutionSingularityPrepareAndSubmitJob(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	tests := []struct {
+		name            string
+		runtime         string
+		registryUser    string
+		registryToken   string
+		mpi             string
+		commandOptions  []string
+		wantCredentials string
+		wantInner       string
+	}{
+		{"WithoutRegistryCredentials", "singularity", "", "", "", nil, "", "\nsrun --nodes=2 --ntasks=8 singularity  run  'docker://registry.example.com/image:latest'\n"},
+		{"WithRegistryCredentials", "singularity", "user", "s3cr3t", "", nil, "export SINGULARITY_DOCKER_USERNAME='user'\nexport SINGULARITY_DOCKER_PASSWORD='s3cr3t'\n", ""},
+		{"WithApptainerRegistryCredentials", "apptainer", "user", "s3cr3t", "", nil, "export APPTAINER_DOCKER_USERNAME='user'\nexport APPTAINER_DOCKER_PASSWORD='s3cr3t'\n", ""},
+		{"WithMPI", "singularity", "", "", "pmix", nil, "", "\nsrun --nodes=2 --ntasks=8 --mpi='pmix' singularity  run  'docker://registry.example.com/image:latest'\n"},
+		{"WithMPIAndCleanEnv", "apptainer", "", "", "pmix", []string{"--cleanenv"}, "",
+			"\nsrun --nodes=2 --ntasks=8 --mpi='pmix' bash -c 'for v in ${!PMI*} ${!PMIX*} ${!SLURM*} ${!OMPI*}; do export APPTAINERENV_$v=\"${!v}\"; done; exec apptainer  run --cleanenv '\\''docker://registry.example.com/image:latest'\\'''\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var credentials, submitted string
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					jobInfo:      &jobInfo{Name: "MyJob", Nodes: 2, Tasks: 8, WorkingDir: home},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							submitted = cmd
+							return "Submitted batch job 42", nil
+						},
+						MockCopyFile: func(source io.Reader, remotePath, permissions string) error {
+							assert.Equal(t, "0600", permissions)
+							b, err := ioutil.ReadAll(source)
+							credentials = string(b)
+							return err
+						},
+					},
+				},
+				imageURI:       "docker://registry.example.com/image:latest",
+				runtime:        tt.runtime,
+				registryUser:   tt.registryUser,
+				registryToken:  tt.registryToken,
+				mpi:            tt.mpi,
+				commandOptions: tt.commandOptions,
+			}
+			require.NoError(t, e.prepareAndSubmitSingularityJob(ctx))
+			assert.Equal(t, "42", e.jobInfo.ID)
+			assert.Equal(t, tt.wantCredentials, credentials)
+			assert.Contains(t, submitted, tt.wantInner)
+			if tt.registryToken != "" {
+				assert.NotContains(t, submitted, tt.registryToken)
+				assert.Regexp(t, `source ~/r-[-a-f0-9]+\.env; rm -f ~/r-[-a-f0-9]+\.env\n`, submitted)
+			}
+		})
+	}
+}
+
+func testExecutionSingularityPrepareAndSubmitJobWithEnvFile(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	tests := []struct {
+		name           string
+		keepScript     bool
+		cleanEnv       bool
+		containAll     bool
+		passthroughEnv []string
+		wantArtifacts  int
+		wantCommand    string
+	}{
+		{"EnvFileRemoved", false, false, false, nil, 2, `set -a; source ~/e-[-a-f0-9]+\.env; set \+a\nsrun singularity  run --env-file ~/e-[-a-f0-9]+\.env 'docker://`},
+		{"EnvFileKept", true, false, false, nil, 0, `set -a; source ~/e-[-a-f0-9]+\.env; set \+a\nsrun singularity  run --env-file ~/e-[-a-f0-9]+\.env 'docker://`},
+		{"CleanEnv", false, true, false, nil, 2, `\nsrun singularity  run --cleanenv --env-file ~/e-[-a-f0-9]+\.env 'docker://`},
+		{"CleanEnvJobArray", false, true, false, []string{"SLURM_ARRAY_TASK_ID"}, 2, `\nsrun singularity  run --cleanenv --env SLURM_ARRAY_TASK_ID="\$\{SLURM_ARRAY_TASK_ID\}" --env-file ~/e-[-a-f0-9]+\.env 'docker://`},
+		{"ContainAll", false, false, true, []string{"SLURM_ARRAY_TASK_ID"}, 2, `\nsrun singularity  run --containall --env SLURM_ARRAY_TASK_ID="\$\{SLURM_ARRAY_TASK_ID\}" --env-file ~/e-[-a-f0-9]+\.env 'docker://`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var envFile, submitted string
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					jobInfo: &jobInfo{
+						Name:             "MyJob",
+						WorkingDir:       home,
+						KeepScript:       tt.keepScript,
+						ExecutionOptions: types.SlurmExecutionOptions{EnvVars: []string{"MSG=it's a test"}},
+						Inputs:           map[string]string{"B": "$HOME", "A": "a b", "EMPTY": " "},
+					},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							submitted = cmd
+							return "Submitted batch job 42", nil
+						},
+						MockCopyFile: func(source io.Reader, remotePath, permissions string) error {
+							if !strings.HasSuffix(remotePath, ".env") {
+								return nil
+							}
+							assert.Equal(t, "0600", permissions)
+							b, err := ioutil.ReadAll(source)
+							envFile = string(b)
+							return err
+						},
+					},
+				},
+				imageURI:       "docker://registry.example.com/image:latest",
+				cleanEnv:       tt.cleanEnv,
+				containAll:     tt.containAll,
+				passthroughEnv: tt.passthroughEnv,
+			}
+			require.NoError(t, e.prepareAndSubmitSingularityJob(ctx))
+			assert.Equal(t, "MSG='it'\\''s a test'\nA='a b'\nB='$HOME'\n", envFile)
+			assert.Regexp(t, tt.wantCommand, submitted)
+			assert.NotContains(t, submitted, "export MSG")
+			if tt.cleanEnv || tt.containAll {
+				assert.NotContains(t, submitted, "set -a")
+			}
+			assert.Len(t, e.jobInfo.Artifacts, tt.wantArtifacts)
+		})
+	}
+}
+
+func testExecutionSingularityPrepareAndSubmitJobDryRun(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	tests := []struct {
+		name             string
+		scriptDirectives bool
+		containAll       bool
+		wantCommand      string
+	}{
+		{"InlineScript", false, false, "\nsrun --nodes=2 singularity  run --env-file ~/e-"},
+		{"ContainAll", false, true, "\nsrun --nodes=2 singularity  run --containall --env-file ~/e-"},
+		{"ScriptDirectives", true, false, "using the generated batch script:\n#!/bin/bash\n#SBATCH --job-name=MyJob\n#SBATCH --nodes=2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					NodeName:     tt.name,
+					jobInfo: &jobInfo{
+						Name:             "MyJob",
+						Nodes:            2,
+						WorkingDir:       home,
+						DryRun:           true,
+						ScriptDirectives: tt.scriptDirectives,
+						ExecutionOptions: types.SlurmExecutionOptions{EnvVars: []string{"MSG=test"}},
+					},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							t.Errorf("unexpected command run in dry run mode: %s", cmd)
+							return "", nil
+						},
+						MockCopyFile: func(source io.Reader, remotePath, permissions string) error {
+							t.Errorf("unexpected file %s uploaded in dry run mode", remotePath)
+							return nil
+						},
+					},
+				},
+				imageURI:      "docker://registry.example.com/image:latest",
+				registryUser:  "user",
+				registryToken: "s3cr3t",
+				containAll:    tt.containAll,
+			}
+			require.NoError(t, e.prepareAndSubmitSingularityJob(ctx))
+			assert.Equal(t, "", e.jobInfo.ID)
+
+			logs, _, err := events.LogsEvents(ctx, deploymentID, 0, 0)
+			require.NoError(t, err)
+			var found bool
+			for _, l := range logs {
+				var entry map[string]interface{}
+				require.NoError(t, json.Unmarshal(l, &entry))
+				content, _ := entry["content"].(string)
+				if !strings.Contains(content, fmt.Sprintf("Dry run of node %q", tt.name)) {
+					continue
+				}
+				found = true
+				assert.Contains(t, content, tt.wantCommand)
+				assert.Regexp(t, `source ~/r-[-a-f0-9]+\.env; rm -f ~/r-[-a-f0-9]+\.env\n`, content)
+				assert.NotContains(t, content, "s3cr3t")
+			}
+			assert.True(t, found, "dry run command not logged")
+		})
+	}
+}
+
+func testExecutionSingularityPrepareAndSubmitJobBlocking(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		runErr  error
+		wantErr bool
+	}{
+		{"Succeeded", nil, false},
+		{"Failed", errors.New("Process exited with status 1"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var submitted string
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					NodeName:     tt.name,
+					jobInfo:      &jobInfo{Name: tt.name, Nodes: 2, WorkingDir: home, Blocking: true},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							submitted = cmd
+							return fmt.Sprintf("output of %s\n", tt.name), tt.runErr
+						},
+					},
+				},
+				imageURI: "docker://registry.example.com/image:latest",
+			}
+			err := e.prepareAndSubmitSingularityJob(ctx)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, "", e.jobInfo.ID)
+			assert.Contains(t, submitted, "cd ~ && bash -c 'srun --job-name='\\''"+tt.name+"'\\'' --nodes=2 singularity  run  '\\''docker://")
+			assert.NotContains(t, submitted, "sbatch")
+
+			logs, _, err := events.LogsEvents(ctx, deploymentID, 0, 0)
+			require.NoError(t, err)
+			var found bool
+			for _, l := range logs {
+				var entry map[string]interface{}
+				require.NoError(t, json.Unmarshal(l, &entry))
+				content, _ := entry["content"].(string)
+				if content == fmt.Sprintf("Output of blocking job %q:\noutput of %s", tt.name, tt.name) {
+					found = true
+				}
+			}
+			assert.True(t, found, "job output not logged")
+		})
+	}
+}
+
+func Test_executionSingularity_privileges(t *testing.T) {
+	tests := []struct {
+		name          string
+		fakeroot      bool
+		userns        bool
+		locationProps config.DynamicMap
+		wantErr       bool
+		wantOptions   []string
+	}{
+		{"NoPrivileges", false, false, config.DynamicMap{"disable_fakeroot": true, "disable_userns": true}, false, []string{}},
+		{"Fakeroot", true, false, config.DynamicMap{}, false, []string{"--fakeroot"}},
+		{"FakerootAndUserns", true, true, config.DynamicMap{"disable_fakeroot": false}, false, []string{"--fakeroot", "--userns"}},
+		{"FakerootDisabled", true, false, config.DynamicMap{"disable_fakeroot": true}, true, nil},
+		{"UsernsDisabled", false, true, config.DynamicMap{"disable_userns": true}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{
+				executionCommon: &executionCommon{NodeName: "Job", locationProps: tt.locationProps},
+				fakeroot:        tt.fakeroot,
+				userns:          tt.userns,
+			}
+			err := e.checkPrivilegesPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executionSingularity.checkPrivilegesPolicy() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantOptions, e.buildContainerOptions())
+			}
+		})
+	}
+}
+
+func Test_executionSingularity_isolation(t *testing.T) {
+	tests := []struct {
+		name         string
+		e            *executionSingularity
+		wantErr      bool
+		wantOptions  []string
+		wantCleanEnv bool
+	}{
+		{"NoIsolation", &executionSingularity{}, false, []string{}, false},
+		{"ContainAll", &executionSingularity{containAll: true, bindMounts: []string{"/data:/data"}}, false, []string{"--bind", "'/data:/data'", "--containall"}, true},
+		{"ContainAllAndCleanEnv", &executionSingularity{containAll: true, cleanEnv: true}, false, []string{"--containall"}, true},
+		{"NoHome", &executionSingularity{noHome: true}, false, []string{"--no-home"}, false},
+		{"NoHomeAndNoTmp", &executionSingularity{noHome: true, noTmp: true, cleanEnv: true}, false, []string{"--no-home", "--no-mount", "tmp", "--cleanenv"}, true},
+		{"ContainAllAndNoHome", &executionSingularity{containAll: true, noHome: true}, true, nil, false},
+		{"ContainAllAndNoTmp", &executionSingularity{containAll: true, noTmp: true}, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.e.executionCommon = &executionCommon{NodeName: "Job"}
+			err := tt.e.checkIsolationProps()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executionSingularity.checkIsolationProps() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantOptions, tt.e.buildContainerOptions())
+				assert.Equal(t, tt.wantCleanEnv, tt.e.hasCleanEnvironment())
+			}
+		})
+	}
+}
+
+func testExecutionSingularityPrepareOverlay(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	tests := []struct {
+		name        string
+		overlay     string
+		overlaySize uint64
+		exists      bool
+		wantCreate  string
+		wantErr     bool
+	}{
+		{"NoOverlay", "", 0, false, "", false},
+		{"ExistingOverlay", "~/overlay.img:ro", 0, true, "", false},
+		{"MissingOverlay", "~/overlay.img", 0, false, "", true},
+		{"CreatedOverlay", "~/overlay.img", 1024, false, "singularity overlay create --size 1024 ~/'overlay.img'", false},
+		{"DryRunOverlay", "~/overlay.img", 1024, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created string
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					NodeName:     "Job",
+					jobInfo:      &jobInfo{DryRun: tt.name == "DryRunOverlay"},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							if strings.HasPrefix(cmd, "test -e ") {
+								assert.Equal(t, "test -e ~/'overlay.img'", cmd)
+								if !tt.exists {
+									return "", errors.New("exit status 1")
+								}
+								return "", nil
+							}
+							created = cmd
+							return "", nil
+						},
+					},
+				},
+				overlay:     tt.overlay,
+				overlaySize: tt.overlaySize,
+			}
+			err := e.prepareOverlay(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executionSingularity.prepareOverlay() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantCreate, created)
+		})
+	}
+}
+
+func testExecutionSingularityResolveImageURI(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/singularity_images.yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		nodeName     string
+		primary      string
+		wantImageURI string
+		wantUser     string
+		wantToken    string
+		wantErr      bool
+		// Command expected to check that a local OCI layout or archive exists, and whether it is missing
+		wantCheck string
+		missing   bool
+	}{
+		{"DockerHub", "DockerHubJob", "docker://ubuntu:20.04", "docker://ubuntu:20.04", "", "", false, "", false},
+		{"PrivateDockerRegistry", "PrivateDockerJob", "docker://myorg/myimage:1.0", "docker://registry.example.com/myorg/myimage:1.0", "myuser", "s3cr3t", false, "", false},
+		{"SylabsLibrary", "LibraryJob", "library://sylabs/examples/lolcow:latest", "library://sylabs/examples/lolcow:latest", "", "", false, "", false},
+		{"PrivateLibrary", "PrivateLibraryJob", "library://myorg/collection/myimage:1.0", "library://library.example.com/myorg/collection/myimage:1.0", "", "", false, "", false},
+		{"PrivateOrasRegistry", "PrivateOrasJob", "oras://myorg/myimage:1.0", "oras://registry.example.com/myorg/myimage:1.0", "myuser", "s3cr3t", false, "", false},
+		{"SIFFile", "DockerHubJob", "/home/john/images/myimage.sif", "/home/john/images/myimage.sif", "", "", false, "", false},
+		{"SIMGFile", "DockerHubJob", "/home/john/images/myimage.simg", "/home/john/images/myimage.simg", "", "", false, "", false},
+		{"IMGFile", "DockerHubJob", "myimage.img", "myimage.img", "", "", false, "", false},
+		{"OCILayout", "DockerHubJob", "oci:///home/john/images/layout", "oci:///home/john/images/layout", "", "", false, "test -d '/home/john/images/layout'", false},
+		{"OCILayoutWithRef", "DockerHubJob", "oci:/home/john/images/layout:1.0", "oci:/home/john/images/layout:1.0", "", "", false, "test -d '/home/john/images/layout'", false},
+		{"OCIArchive", "DockerHubJob", "oci-archive:/home/john/images/myimage.tar", "oci-archive:/home/john/images/myimage.tar", "", "", false, "test -f '/home/john/images/myimage.tar'", false},
+		{"DockerArchive", "DockerHubJob", "docker-archive:images/myimage.tar:latest", "docker-archive:images/myimage.tar:latest", "", "", false, "test -f 'images/myimage.tar'", false},
+		{"MissingOCILayout", "DockerHubJob", "oci:///home/john/images/missing", "", "", "", true, "test -d '/home/john/images/missing'", true},
+		{"MissingDockerArchive", "DockerHubJob", "docker-archive:/home/john/images/missing.tar", "", "", "", true, "test -f '/home/john/images/missing.tar'", true},
+		{"EmptyArchivePath", "DockerHubJob", "oci-archive:", "", "", "", true, "", false},
+		{"UnknownScheme", "DockerHubJob", "ftp://example.com/myimage.tar", "", "", "", true, "", false},
+		{"MalformedImage", "DockerHubJob", "docker://", "", "", "", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID: deploymentID,
+					NodeName:     tt.nodeName,
+					NodeType:     "yorc.nodes.slurm.SingularityJob",
+					operation:    prov.Operation{Name: tosca.RunnableSubmitOperationName, ImplementedInNodeTemplate: tt.nodeName},
+					Primary:      tt.primary,
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							assert.Equal(t, tt.wantCheck, cmd)
+							if tt.missing {
+								return "", errors.New("exit status 1")
+							}
+							return "", nil
+						},
+					},
+				},
+			}
+			err := e.resolveImageURI(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("executionSingularity.resolveImageURI() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantImageURI, e.imageURI)
+			assert.Equal(t, tt.wantUser, e.registryUser)
+			assert.Equal(t, tt.wantToken, e.registryToken)
+			if tt.wantErr {
+				return
+			}
+			imageURI, err := deployments.GetInstanceAttributeValue(ctx, deploymentID, tt.nodeName, "0", "image_uri")
+			require.NoError(t, err)
+			require.NotNil(t, imageURI)
+			assert.Equal(t, tt.wantImageURI, imageURI.RawString())
+		})
+	}
+}
+
+func testExecutionSingularityResolveImageCache(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/singularity_images.yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		cacheDir      string
+		imageURI      string
+		cached        bool
+		wantImageURI  string
+		wantPullImage string
+	}{
+		{"CacheDisabled", "", "docker://ubuntu:20.04", false, "docker://ubuntu:20.04", ""},
+		{"LocalImage", "/shared/cache", "/home/john/myimage.sif", false, "/home/john/myimage.sif", ""},
+		{"OCILayout", "/shared/cache", "oci:///home/john/images/layout", false, "oci:///home/john/images/layout", ""},
+		{"CacheHit", "/shared/cache", "docker://ubuntu:20.04", true, "/shared/cache/docker_ubuntu_20.04.sif", ""},
+		{"CacheMiss", "/shared/cache", "docker://registry.example.com/myorg/myimage:1.0", false, "/shared/cache/docker_registry.example.com_myorg_myimage_1.0.sif", "docker://registry.example.com/myorg/myimage:1.0"},
+		{"QuotedCacheDir", "/shared/my cache;", "docker://ubuntu:20.04", false, "/shared/my cache;/docker_ubuntu_20.04.sif", "docker://ubuntu:20.04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{
+				executionCommon: &executionCommon{
+					deploymentID:  deploymentID,
+					NodeName:      "DockerHubJob",
+					locationProps: config.DynamicMap{"image_cache_directory": tt.cacheDir},
+					client: &sshutil.MockSSHClient{
+						MockRunCommand: func(cmd string) (string, error) {
+							assert.Equal(t, "test -f "+shellQuote(tt.wantImageURI), cmd)
+							if !tt.cached {
+								return "", errors.New("exit status 1")
+							}
+							return "", nil
+						},
+					},
+				},
+				imageURI: tt.imageURI,
+			}
+			require.NoError(t, e.resolveImageCache(ctx))
+			assert.Equal(t, tt.wantImageURI, e.imageURI)
+			assert.Equal(t, tt.wantPullImage, e.imageToPull)
+			if tt.wantPullImage == "" {
+				assert.Equal(t, "", e.buildImagePullCmd("singularity"))
+			} else {
+				image := shellQuote(tt.wantImageURI)
+				assert.Equal(t, "mkdir -p "+shellQuote(tt.cacheDir)+" && ( flock -x 9 && if [ -f "+image+" ]; then echo 'Image cache hit: "+tt.wantImageURI+
+					"'; else echo 'Image cache miss: pulling "+tt.wantPullImage+"' && singularity pull "+image+".$$ '"+tt.wantPullImage+
+					"' && mv "+image+".$$ "+image+"; fi ) 9>"+image+".lock || exit 1\n", e.buildImagePullCmd("singularity"))
+			}
+		})
+	}
+}
+
+func Test_executionSingularity_buildSrunOpts(t *testing.T) {
+	tests := []struct {
+		name         string
+		jobInfo      *jobInfo
+		mpi          string
+		allocationID string
+		want         string
+	}{
+		{"SingleTask", &jobInfo{Nodes: 1, Tasks: 1}, "", "", ""},
+		{"MultiNodes", &jobInfo{Nodes: 4, Tasks: 16, TasksPerNode: 4}, "", "", " --nodes=4 --ntasks=16 --ntasks-per-node=4"},
+		{"MPI", &jobInfo{Nodes: 2, Tasks: 1}, "pmix", "", " --nodes=2 --mpi='pmix'"},
+		{"Blocking", &jobInfo{Name: "MyJob", Nodes: 2, Tasks: 1, Blocking: true}, "pmix", "", " --job-name='MyJob' --nodes=2 --mpi='pmix'"},
+		{"ExportEnv", &jobInfo{Nodes: 2, Tasks: 1, ExecutionOptions: types.SlurmExecutionOptions{ExportEnv: "NONE"}}, "pmix", "", " --nodes=2 --mpi='pmix' --export=NONE"},
+		{"InAllocation", &jobInfo{Name: "MyJob", Nodes: 2, Tasks: 1, Blocking: true}, "pmix", "4242", " --jobid=4242 --nodes=2 --mpi='pmix'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{jobInfo: tt.jobInfo}, mpi: tt.mpi, allocationJobID: tt.allocationID}
+			assert.Equal(t, tt.want, e.buildSrunOpts())
+		})
+	}
+}
+
+func Test_executionSingularity_nodeLocalScratch(t *testing.T) {
+	e := &executionSingularity{
+		executionCommon: &executionCommon{
+			jobInfo:   &jobInfo{WorkingDir: home},
+			Artifacts: map[string]string{"input.dat": "data/input.dat", "config.yaml": "config.yaml"},
+		},
+		imageURI:         "/scratch/images/app.sif",
+		nodeLocalScratch: true,
+	}
+	assert.Equal(t, `"$YORC_IMAGE"`, e.containerImage())
+	prologue := e.buildScratchPrologue()
+	assert.Contains(t, prologue, "export YORC_IMAGE='/scratch/images/app.sif'\n")
+	assert.Contains(t, prologue, `YORC_SCRATCH_DIR="${SLURM_TMPDIR:-$TMPDIR}"`)
+	assert.Contains(t, prologue, `mkdir -p "$YORC_SCRATCH_DIR" && cp -r ~/'config.yaml' ~/'input.dat' "$YORC_SCRATCH_DIR"/ || exit 1`)
+	assert.Contains(t, prologue, `cd "$YORC_SCRATCH_DIR" || exit 1`)
+	assert.Equal(t, "\nrc=$?\nif [ -n \"$YORC_SCRATCH_DIR\" ]; then cp -r \"$YORC_SCRATCH_DIR\"/. ~/ || rc=1; rm -rf \"$YORC_SCRATCH_DIR\" \"$YORC_SCRATCH_DIR.sif\"; fi\nexit $rc\n",
+		e.buildScratchEpilogue())
+
+	// A sandbox directory is not staged
+	e.sandbox = "/scratch/sandboxes/app"
+	assert.Equal(t, "'/scratch/sandboxes/app'", e.containerImage())
+}
+
+func Test_executionSingularity_buildCheckpointWrapper(t *testing.T) {
+	e := &executionSingularity{
+		executionCommon:  &executionCommon{jobInfo: &jobInfo{WorkingDir: "~/work"}},
+		checkpointScript: "ckpt.sh",
+	}
+	wrapper := e.buildCheckpointWrapper("srun singularity run app.sif")
+	assert.True(t, strings.HasPrefix(wrapper, "export YORC_RESTART_COUNT=${SLURM_RESTART_COUNT:-0}\n"))
+	assert.Contains(t, wrapper, "(cd ~/work && bash ~/work/'ckpt.sh') || echo")
+	assert.Contains(t, wrapper, "scontrol requeue \"$SLURM_JOB_ID\"\n")
+	assert.Contains(t, wrapper, "trap yorc_checkpoint USR1\n{\nsrun singularity run app.sif\n} &\n")
+	assert.True(t, strings.HasSuffix(wrapper, "exit $rc\n"))
+
+	// Absolute scripts are run as is
+	e.checkpointScript = "/opt/tools/ckpt.sh"
+	assert.Contains(t, e.buildCheckpointWrapper("hostname"), "(cd ~/work && bash '/opt/tools/ckpt.sh')")
+}
+
+func Test_validateImageName(t *testing.T) {
+	tests := []struct {
+		name      string
+		image     string
+		wantImage string
+		wantErr   bool
+	}{
+		{"DockerImage", "docker://ubuntu:20.04", "docker://ubuntu:20.04", false},
+		{"DockerImageWithDigest", "docker://ubuntu@sha256:abcdef", "docker://ubuntu@sha256:abcdef", false},
+		{"LibraryImage", "library://sylabs/examples/lolcow:latest", "library://sylabs/examples/lolcow:latest", false},
+		{"SIFFile", "/home/john/images/myimage.sif", "/home/john/images/myimage.sif", false},
+		{"OCIArchive", "oci-archive:/home/john/images/myimage.tar", "oci-archive:/home/john/images/myimage.tar", false},
+		{"SurroundingWhitespaces", "  docker://ubuntu:20.04\n", "docker://ubuntu:20.04", false},
+		{"Empty", "", "", true},
+		{"OnlyWhitespaces", " \t", "", true},
+		{"InnerWhitespace", "docker://ubuntu :20.04", "", true},
+		{"NoRepository", "docker://", "", true},
+		{"OnlySlash", "oras:///", "", true},
+		{"OnlyTag", "docker://:latest", "", true},
+		{"EmptyTag", "docker://ubuntu:", "", true},
+		{"EmptyDigest", "docker://ubuntu@", "", true},
+		{"SIFFileWithScheme", "docker://myorg/myimage.sif", "", true},
+		{"IMGFileWithScheme", "library://myimage.img", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateImageName(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateImageName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantImage, got)
+		})
+	}
+}
+
+func Test_executionSingularity_checkGPUDevices(t *testing.T) {
+	tests := []struct {
+		name       string
+		gpu        string
+		gpuDevices string
+		migProfile string
+		gres       string
+		wantGres   string
+		wantErr    bool
+	}{
+		{"NoSelection", "none", "", "", "", "", false},
+		{"DeviceIndexes", "nvidia", "0,1", "", "gpu:2", "gpu:2", false},
+		{"MIGDevice", "nvidia", "MIG-4f9fbd2c-0a4e-5a6c-8a61-9e0f1e4c1f8d", "", "gpu:1", "gpu:1", false},
+		{"MIGProfileWithoutGres", "nvidia", "", "1g.5gb", "", "gpu:1g.5gb:1", false},
+		{"MIGProfileWithGres", "nvidia", "0", "3g.20gb", "gpu:3g.20gb:2", "gpu:3g.20gb:2", false},
+		{"NotNvidia", "amd", "0", "", "gpu:1", "", true},
+		{"NoGPUExposed", "none", "", "1g.5gb", "", "", true},
+		{"InvalidDevice", "nvidia", "0,all", "", "gpu:2", "", true},
+		{"InvalidMIGProfile", "nvidia", "", "1g", "", "", true},
+		{"InconsistentMIGProfile", "nvidia", "", "1g.5gb", "gpu:a100:1", "", true},
+		{"TooManyDevices", "nvidia", "0,1,2", "", "gpu:a100:2", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{NodeName: "Job", jobInfo: &jobInfo{Gres: tt.gres}},
+				gpu: tt.gpu, gpuDevices: tt.gpuDevices, migProfile: tt.migProfile}
+			err := e.checkGPUDevices()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantGres, e.jobInfo.Gres)
+		})
+	}
+}
+
+func Test_executionSingularity_gpuDevicesOptions(t *testing.T) {
+	e := &executionSingularity{executionCommon: &executionCommon{jobInfo: &jobInfo{Nodes: 1, Gres: "gpu:1g.5gb:2"}},
+		gpu: "nvidia", gpuDevices: "0,1", migProfile: "1g.5gb"}
+	assert.Equal(t, []string{"--nv", "--env", "CUDA_VISIBLE_DEVICES='0,1'", "--env", "NVIDIA_VISIBLE_DEVICES='0,1'"}, e.buildContainerOptions())
+	assert.Equal(t, " --gres='gpu:1g.5gb:2'", e.buildSrunOpts())
+}
+
+func Test_executionSingularity_checkHetComponents(t *testing.T) {
+	tests := []struct {
+		name      string
+		jobInfo   *jobInfo
+		scratch   bool
+		wantNodes int
+		wantMem   string
+		wantErr   bool
+	}{
+		{"NoComponent", &jobInfo{}, false, 0, "", false},
+		{"DefaultNodes", &jobInfo{HetComponents: []hetComponent{{Mem: "4G"}}}, false, 1, "4194304K", false},
+		{"Nodes", &jobInfo{HetComponents: []hetComponent{{Nodes: 4, GPU: "nvidia", Partition: "gpu"}}}, false, 4, "", false},
+		{"Blocking", &jobInfo{Blocking: true, HetComponents: []hetComponent{{}}}, false, 0, "", true},
+		{"Array", &jobInfo{Array: "1-4", HetComponents: []hetComponent{{}}}, false, 0, "", true},
+		{"NodeLocalScratch", &jobInfo{HetComponents: []hetComponent{{}}}, true, 0, "", true},
+		{"NegativeTasks", &jobInfo{HetComponents: []hetComponent{{Tasks: -1}}}, false, 0, "", true},
+		{"InvalidPartition", &jobInfo{HetComponents: []hetComponent{{Partition: "gpu; ls"}}}, false, 0, "", true},
+		{"InvalidGPU", &jobInfo{HetComponents: []hetComponent{{GPU: "intel"}}}, false, 0, "", true},
+		{"InvalidMemory", &jobInfo{HetComponents: []hetComponent{{Mem: "4096"}}}, false, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{NodeName: "Job", jobInfo: tt.jobInfo}, nodeLocalScratch: tt.scratch}
+			err := e.checkHetComponents()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if len(tt.jobInfo.HetComponents) > 0 {
+				assert.Equal(t, tt.wantNodes, tt.jobInfo.HetComponents[0].Nodes)
+				assert.Equal(t, tt.wantMem, tt.jobInfo.HetComponents[0].Mem)
+			}
+		})
+	}
+}
+
+func Test_executionSingularity_buildHetSrunCommand(t *testing.T) {
+	e := &executionSingularity{
+		executionCommon: &executionCommon{jobInfo: &jobInfo{Nodes: 1, Tasks: 1, HetComponents: []hetComponent{
+			{Nodes: 2, Tasks: 8, GPU: "nvidia", Command: "python3", Args: []string{"train.py", "--epochs=2"}},
+			{Nodes: 1, Args: []string{"serve"}},
+		}}},
+		imageURI:   "/images/app.sif",
+		bindMounts: []string{"/data:/data"},
+		mpi:        "pmix",
+	}
+	assert.Equal(t, "srun --het-group=0 --mpi='pmix' singularity run --bind /data:/data /images/app.sif"+
+		" : --het-group=1 --nodes=2 --ntasks=8 --mpi='pmix' singularity  exec --nv --bind '/data:/data' '/images/app.sif' python3 'train.py' '--epochs=2'"+
+		" : --het-group=2 --mpi='pmix' singularity  run --bind '/data:/data' '/images/app.sif' 'serve'",
+		e.buildHetSrunCommand("singularity", "", "singularity run --bind /data:/data /images/app.sif"))
+}
+
+func testExecutionSingularityMPIProp(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/singularity_mpi.yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		nodeName string
+		wantMPI  string
+		wantErr  bool
+	}{
+		{"MPIPlugin", "MPIJob", "pmix_v3", false},
+		{"ShellUnsafeMPIPlugin", "InvalidMPIJob", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{deploymentID: deploymentID, NodeName: tt.nodeName, jobInfo: &jobInfo{}}}
+			err := e.getSingularityProps(ctx)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid mpi")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMPI, e.mpi)
+		})
+	}
+}
+
+func testExecutionSingularityAllocation(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/singularity_allocation.yaml")
+	require.NoError(t, err)
+	err = deployments.SetInstanceAttribute(ctx, deploymentID, "Allocation", "0", "job_id", "4242")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		nodeName    string
+		wantRelease bool
+		wantErr     bool
+	}{
+		{"Step", "FirstStep", false, false},
+		{"LastStep", "LastStep", true, false},
+		{"UnknownAllocation", "StepInUnknownAllocation", false, true},
+		{"NotAnAllocation", "StepInJobAllocation", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionSingularity{executionCommon: &executionCommon{deploymentID: deploymentID, NodeName: tt.nodeName, jobInfo: &jobInfo{}}}
+			err := e.getAllocationProps(ctx)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "4242", e.allocationJobID)
+			assert.Equal(t, tt.wantRelease, e.releaseAllocation)
+			assert.True(t, e.jobInfo.Blocking)
+		})
+	}
+
+	t.Run("AllocationNotRunning", func(t *testing.T) {
+		e := &executionSingularity{
+			executionCommon: &executionCommon{
+				deploymentID: deploymentID,
+				NodeName:     "FirstStep",
+				jobInfo:      &jobInfo{Name: "FirstStep", Nodes: 1, WorkingDir: home, Blocking: true},
+				client: &sshutil.MockSSHClient{
+					MockRunCommand: func(cmd string) (string, error) {
+						require.False(t, strings.HasPrefix(cmd, "cd "), "unexpected step command %s", cmd)
+						return "", nil
+					},
+				},
+			},
+			imageURI:        "docker://registry.example.com/image:latest",
+			allocationNode:  "Allocation",
+			allocationJobID: "4242",
+		}
+		err := e.prepareAndSubmitSingularityJob(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not running")
+	})
+
+	t.Run("StepsRunAndAllocationReleased", func(t *testing.T) {
+		var commands []string
+		e := &executionSingularity{
+			executionCommon: &executionCommon{
+				deploymentID: deploymentID,
+				NodeName:     "LastStep",
+				jobInfo:      &jobInfo{Name: "LastStep", Nodes: 2, WorkingDir: home, Blocking: true},
+				client: &sshutil.MockSSHClient{
+					MockRunCommand: func(cmd string) (string, error) {
+						commands = append(commands, cmd)
+						if strings.HasPrefix(cmd, "squeue") {
+							return "RUNNING None\n", nil
+						}
+						return "", nil
+					},
+				},
+			},
+			imageURI:          "docker://registry.example.com/image:latest",
+			allocationNode:    "Allocation",
+			allocationJobID:   "4242",
+			releaseAllocation: true,
+		}
+		require.NoError(t, e.prepareAndSubmitSingularityJob(ctx))
+		e.releaseJobAllocation(ctx)
+		require.Len(t, commands, 3)
+		assert.Equal(t, "squeue -j 4242 -h -o '%T %r'", commands[0])
+		assert.Contains(t, commands[1], "bash -c 'srun --jobid=4242 --nodes=2 singularity  run  '\\''docker://")
+		assert.Equal(t, "scancel 4242", commands[2])
+
+		// The allocation is not cancelled again once its node is deleted
+		jobID, err := deployments.GetInstanceAttributeValue(ctx, deploymentID, "Allocation", "0", "job_id")
+		require.NoError(t, err)
+		require.NotNil(t, jobID)
+		assert.Equal(t, "", jobID.RawString())
+		e.releaseJobAllocation(ctx)
+		assert.Len(t, commands, 3)
+	})
+}
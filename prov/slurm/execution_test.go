@@ -16,6 +16,8 @@ package slurm
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"regexp"
 	"testing"
 	"time"
@@ -27,6 +29,7 @@ import (
 	"github.com/ystia/yorc/v4/deployments"
 	"github.com/ystia/yorc/v4/helper/sshutil"
 	"github.com/ystia/yorc/v4/prov/operations"
+	"github.com/ystia/yorc/v4/tasks"
 	"github.com/ystia/yorc/v4/testutil"
 	"github.com/ystia/yorc/v4/tosca/types"
 )
@@ -35,6 +38,7 @@ func Test_executionCommon_wrapCommand(t *testing.T) {
 	type fields struct {
 		Artifacts map[string]string
 		jobInfo   *jobInfo
+		umask     string
 	}
 	type args struct {
 		innerCmd string
@@ -54,13 +58,53 @@ func Test_executionCommon_wrapCommand(t *testing.T) {
 			args{"ping -c 3 1.1.1.1"}, regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n#BB ddd\n#another one\n\nping -c 3 1.1.1.1\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
 		{"TestWithSourceEnvFile", fields{
 			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", EnvFile: "~/.bash_profile"}},
-			args{"ping -c 3 1.1.1.1"}, regexp.MustCompile(`\[ -f ~/.bash_profile \] && \{ source ~/.bash_profile ; \} ;cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nping -c 3 1.1.1.1\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+			args{"ping -c 3 1.1.1.1"}, regexp.MustCompile(`\[ -f ~/.bash_profile \] && \{ source ~/.bash_profile ; \} ;touch ~/b-[-a-f0-9]+.batch && chmod 700 ~/b-[-a-f0-9]+.batch \|\| \{ echo failed to restrict permissions of ~/b-[-a-f0-9]+.batch >&2 ; exit 1 ; \} ;cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nping -c 3 1.1.1.1\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithUmask", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~"}, umask: "0077"},
+			args{"hostname"}, regexp.MustCompile(`^umask 0077;touch ~/b-[-a-f0-9]+.batch && chmod 700 `), false},
+		{"TestWithGres", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Gres: "gpu:2"}},
+			args{"nvidia-smi"}, regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nnvidia-smi\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 --gres='gpu:2' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithArray", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Array: "0-99%10"}},
+			args{"echo $SLURM_ARRAY_TASK_ID"}, regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\necho \$SLURM_ARRAY_TASK_ID\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 --array='0-99%10' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithRequeue", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Requeue: true, Signal: "B:USR1@120"}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --requeue --signal='B:USR1@120' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithSeparateErrorOutput", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", SeparateErrorOutput: true}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --output='slurm-%j.out' --error='slurm-%j.err' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestArrayWithSeparateErrorOutput", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Array: "1-4", SeparateErrorOutput: true}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --array='1-4' --output='slurm-%A_%a.out' --error='slurm-%A_%a.err' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithOutputFileName", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", OutputFileName: "result-%j-%t.out"}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --output='result-%j-%t.out' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithOutputFileNameAndSeparateErrorOutput", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", OutputFileName: "result-%j.out", SeparateErrorOutput: true}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --output='result-%j.out' --error='result-%j.err' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithHetComponents", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", HetComponents: []hetComponent{{Nodes: 2, Tasks: 4, Mem: "4096M", Gres: "gpu:2", Partition: "gpu"}}}},
+			args{"srun hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 : --nodes=2 --ntasks=4 --mem='4096M' --gres='gpu:2' --partition='gpu' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithModules", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Modules: []string{"singularity/3.8", " "}}},
+			args{"srun singularity run img.sif"}, regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nmodule load 'singularity/3.8' \|\| \{ echo failed to load module 'singularity/3.8' >&2 ; exit 1 ; \} ;srun singularity run img.sif\nEOF\nsbatch -D ~ --job-name='MyJob' --nodes=1 ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithSchedulingOptions", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Partition: "gpu,gpu-long", QOS: "high", Account: "acc", Reservation: "resa"}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --partition='gpu,gpu-long' --qos='high' --reservation='resa' --account='acc' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithConstraint", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Constraint: "intel&ib"}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --constraint='intel&ib' ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
+		{"TestWithDependency", fields{
+			jobInfo: &jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", Dependency: "afterok:4242?afterany:5678"}},
+			args{"hostname"}, regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 --dependency='afterok:4242\?afterany:5678' --kill-on-invalid-dep=yes ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`), false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			e := &executionCommon{
 				Artifacts: tt.fields.Artifacts,
 				jobInfo:   tt.fields.jobInfo,
+				umask:     tt.fields.umask,
 			}
 			got, err := e.wrapCommand(tt.args.innerCmd)
 			if (err != nil) != tt.wantErr {
@@ -74,6 +118,64 @@ func Test_executionCommon_wrapCommand(t *testing.T) {
 	}
 }
 
+func Test_executionCommon_wrapCommandWithDirectives(t *testing.T) {
+	tests := []struct {
+		name          string
+		jobInfo       *jobInfo
+		wantScript    *regexp.Regexp
+		wantCmd       *regexp.Regexp
+		wantArtifacts int
+	}{
+		{"ScriptDirectives",
+			&jobInfo{Name: "My Job", Nodes: 2, MaxTime: "10:00", Account: "acc", Opts: []string{"--partition=debug"}, WorkingDir: "~", ScriptDirectives: true,
+				ExecutionOptions: types.SlurmExecutionOptions{InScriptOptions: []string{"#BB ddd"}}},
+			regexp.MustCompile(`^#!/bin/bash\n#SBATCH --job-name='My Job'\n#SBATCH --nodes=2\n#SBATCH --time=10:00\n#SBATCH --partition=debug\n#SBATCH --account=acc\n#BB ddd\nsrun hostname\n$`),
+			regexp.MustCompile(`^sbatch -D ~ ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch$`), 1},
+		{"KeepScript",
+			&jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", ScriptDirectives: true, KeepScript: true},
+			regexp.MustCompile(`^#!/bin/bash\n#SBATCH --job-name=MyJob\n#SBATCH --nodes=1\nsrun hostname\n$`),
+			regexp.MustCompile(`^sbatch -D ~ ~/b-[-a-f0-9]+.batch$`), 0},
+		{"HetJobDirectives",
+			&jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", ScriptDirectives: true, KeepScript: true, HetComponents: []hetComponent{{Nodes: 2, Cpus: 4}}},
+			regexp.MustCompile(`^#!/bin/bash\n#SBATCH --job-name=MyJob\n#SBATCH --nodes=1\n#SBATCH hetjob\n#SBATCH --nodes=2\n#SBATCH --cpus-per-task=4\nsrun hostname\n$`),
+			regexp.MustCompile(`^sbatch -D ~ ~/b-[-a-f0-9]+.batch$`), 0},
+		{"KeepInlineScript",
+			&jobInfo{Name: "MyJob", Nodes: 1, WorkingDir: "~", KeepScript: true},
+			nil,
+			regexp.MustCompile(`sbatch -D ~ --job-name='MyJob' --nodes=1 ~/b-[-a-f0-9]+.batch$`), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var script, chmod string
+			e := &executionCommon{
+				jobInfo: tt.jobInfo,
+				client: &sshutil.MockSSHClient{
+					MockCopyFile: func(source io.Reader, remotePath, permissions string) error {
+						assert.Equal(t, "0700", permissions)
+						b, err := ioutil.ReadAll(source)
+						script = string(b)
+						return err
+					},
+					MockRunCommand: func(cmd string) (string, error) {
+						chmod = cmd
+						return "", nil
+					},
+				},
+			}
+			got, err := e.wrapCommand("srun hostname")
+			require.NoError(t, err)
+			assert.Regexp(t, tt.wantCmd, got)
+			if tt.wantScript != nil {
+				assert.Regexp(t, tt.wantScript, script)
+				assert.Regexp(t, `^chmod 700 ~/b-[-a-f0-9]+.batch$`, chmod)
+			} else {
+				assert.Empty(t, script)
+			}
+			assert.Len(t, e.jobInfo.Artifacts, tt.wantArtifacts)
+		})
+	}
+}
+
 func testExecutionCommonBuildJobInfo(t *testing.T) {
 
 	deploymentID := testutil.BuildDeploymentID(t)
@@ -84,6 +186,9 @@ func testExecutionCommonBuildJobInfo(t *testing.T) {
 	deploymentIDOpts := deploymentID + "-with-opts"
 	err = deployments.StoreDeploymentDefinition(ctx, deploymentIDOpts, "testdata/job_with_options.yaml")
 	require.NoError(t, err)
+	// Job referenced by the dependency of another job
+	err = deployments.SetInstanceAttribute(ctx, deploymentIDOpts, "SubmittedJob", "0", "job_id", "4242")
+	require.NoError(t, err)
 
 	type fields struct {
 		locationProps config.DynamicMap
@@ -101,18 +206,44 @@ func testExecutionCommonBuildJobInfo(t *testing.T) {
 		expectedJobInfo jobInfo
 	}{
 		{"CheckDefaultValues", fields{config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "primary", false}, false,
-			jobInfo{Name: deploymentID, Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home}},
+			jobInfo{Name: deploymentID, Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10}},
 		{"ChecklocationPropertiesValues", fields{config.DynamicMap{"default_job_name": "myjobname", "job_monitoring_time_interval": "1s"}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "primary", false}, false,
-			jobInfo{Name: "myjobname", Tasks: 1, Nodes: 1, MonitoringTimeInterval: time.Second, Inputs: make(map[string]string), WorkingDir: home}},
+			jobInfo{Name: "myjobname", Tasks: 1, Nodes: 1, MonitoringTimeInterval: time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10}},
 		{"CheckErrorIfNoCommandAndPrimary", fields{config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "", false}, true, jobInfo{}},
 		{"CheckDefaultValues", fields{config.DynamicMap{}, deploymentIDOpts, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "primary", false}, false,
-			jobInfo{Name: "ClassificationJobUnit_Singularity", Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home,
+			jobInfo{Name: "ClassificationJobUnit_Singularity", Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
 				ExecutionOptions: types.SlurmExecutionOptions{
 					Args:            []string{"-c", "python3 /opt/kdetect.py ${STORAGE_PATH}"},
 					InScriptOptions: []string{"#BB volume=a4b4f33c-994f-4f3f-877e-395d21bd3fb2 user=bu key=key path=/sharing lustre_path=/fs1/myuser/bu size=1"},
 					Command:         "sh",
 					EnvVars:         []string{"STORAGE_PATH=/mnt/data-bu/models/C56A8BCD-380E-4E6C-B265-D50208641102-4203addf-aca9-3040-271d-d2bbe0719f79"},
 				}}},
+		{"CheckSchedulingOptions", fields{config.DynamicMap{"enforce_accounting": true, "enforce_qos": true}, deploymentIDOpts, "JobWithSchedulingOptions", make([]*operations.EnvInput, 0), "primary", false}, false,
+			jobInfo{Name: "JobWithSchedulingOptions", Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
+				Partition: "gpu,gpu-long", QOS: "high", Account: "project_01", Reservation: "maintenance.2024", Constraint: "[haswell|broadwell]&ib"}},
+		{"CheckErrorIfConstraintIsShellUnsafe", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithInvalidConstraint", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfQOSIsEnforced", fields{config.DynamicMap{"enforce_qos": true}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfAccountIsShellUnsafe", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithInvalidAccount", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckWalltimeAndMemory", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithWalltimeAndMemory", make([]*operations.EnvInput, 0), "primary", false}, false,
+			jobInfo{Name: "JobWithWalltimeAndMemory", Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
+				MaxTime: "26:30:15", Mem: "4194304K"}},
+		{"CheckErrorIfWalltimeIsInvalid", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithInvalidWalltime", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfMemoryIsInvalid", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithInvalidMemory", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfTimeAndWalltime", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithConflictingTime", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckSeparateErrorOutput", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithSeparateErrorOutput", make([]*operations.EnvInput, 0), "primary", false}, false,
+			jobInfo{Name: deploymentIDOpts, Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
+				SeparateErrorOutput: true}},
+		{"CheckErrorIfSeparateErrorOutputAndOutputOption", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithSeparateErrorOutputAndOutputOption", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckOutputFileName", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithOutputFileName", make([]*operations.EnvInput, 0), "primary", false}, false,
+			jobInfo{Name: deploymentIDOpts, Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
+				OutputFileName: "result-%j-%t.out"}},
+		{"CheckErrorIfOutputFileNameAndOutputOption", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithOutputFileNameAndOutputOption", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckDependency", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithDependency", make([]*operations.EnvInput, 0), "primary", false}, false,
+			jobInfo{Name: deploymentIDOpts, Tasks: 1, Nodes: 1, MonitoringTimeInterval: 5 * time.Second, Inputs: make(map[string]string), WorkingDir: home, ErrorOutputLines: 10,
+				Dependency: "afterok:4242:1234_2?afterany:5678+1,singleton"}},
+		{"CheckErrorIfDependencyOnNotSubmittedJob", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithDependencyOnNotSubmittedJob", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfInvalidDependency", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithInvalidDependency", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
+		{"CheckErrorIfDependencyAndDependencyOption", fields{config.DynamicMap{}, deploymentIDOpts, "JobWithDependencyAndDependencyOption", make([]*operations.EnvInput, 0), "primary", false}, true, jobInfo{}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -135,6 +266,42 @@ func testExecutionCommonBuildJobInfo(t *testing.T) {
 	}
 }
 
+func testExecutionCommonResumeSubmittedJob(t *testing.T) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/jobMonitoringTest.yaml")
+	require.NoError(t, err)
+	tests := []struct {
+		name        string
+		taskData    string
+		wantResumed bool
+	}{
+		{"NotSubmitted", "", false},
+		{"DryRun", `{"name":"MyJob","dry_run":true}`, false},
+		{"Submitted", `{"id":"1234","name":"MyJob"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskID := deploymentID + "-" + tt.name
+			if tt.taskData != "" {
+				require.NoError(t, tasks.SetTaskData(taskID, "Job-jobInfo", tt.taskData))
+			}
+			e := &executionCommon{deploymentID: deploymentID, taskID: taskID, NodeName: "Job"}
+			resumed, err := e.resumeSubmittedJob(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantResumed, resumed)
+			if !tt.wantResumed {
+				return
+			}
+			assert.Equal(t, "1234", e.jobInfo.ID)
+			jobID, err := deployments.GetInstanceAttributeValue(ctx, deploymentID, "Job", "0", "job_id")
+			require.NoError(t, err)
+			require.NotNil(t, jobID)
+			assert.Equal(t, "1234", jobID.RawString())
+		})
+	}
+}
+
 func testExecutionCommonPrepareAndSubmitJob(t *testing.T) {
 
 	deploymentID := testutil.BuildDeploymentID(t)
@@ -161,6 +328,11 @@ func testExecutionCommonPrepareAndSubmitJob(t *testing.T) {
 				&jobInfo{Name: "MyJob", Tasks: 2, Nodes: 4, WorkingDir: home}},
 			regexp.MustCompile("sbatch -D ~ --job-name='MyJob' --ntasks=2 --nodes=4 ~/primary.batch"),
 			false},
+		{"CheckProvidedBatchScriptWithModules",
+			fields{config.Configuration{}, config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "primary.batch",
+				&jobInfo{Name: "MyJob", Tasks: 1, Nodes: 1, WorkingDir: home, Modules: []string{"mpi/openmpi"}}},
+			regexp.MustCompile("^module load 'mpi/openmpi' \\|\\| \\{ echo failed to load module 'mpi/openmpi' >&2 ; exit 1 ; \\} ;sbatch -D ~ --job-name='MyJob' --nodes=1 ~/primary.batch$"),
+			false},
 		{"CheckWrappedCommand",
 			fields{config.Configuration{}, config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "",
 				&jobInfo{Name: "MyJob", Tasks: 2, Nodes: 4, WorkingDir: home,
@@ -170,6 +342,15 @@ func testExecutionCommonPrepareAndSubmitJob(t *testing.T) {
 					}}},
 			regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nsrun cat '/etc/os-release' \nEOF\nsbatch -D ~ --job-name='MyJob' --ntasks=2 --nodes=4 ~/b-[-a-f0-9]+.batch; rm -f ~/b-[-a-f0-9]+.batch`),
 			false},
+		{"CheckWrappedCommandLine",
+			fields{config.Configuration{}, config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "",
+				&jobInfo{Name: "MyJob", Tasks: 2, Nodes: 4, WorkingDir: home,
+					ExecutionOptions: types.SlurmExecutionOptions{
+						CommandLine: []string{"srun", "/opt/my app/run", "--input", "my data.csv"},
+						Args:        []string{"--verbose"},
+					}}},
+			regexp.MustCompile(`cat <<'EOF' > ~/b-[-a-f0-9]+.batch\n#!/bin/bash\n\nsrun '/opt/my app/run' '--input' 'my data.csv' '--verbose'\nEOF\n`),
+			false},
 		{"CheckWrappedCommandWithSrun",
 			fields{config.Configuration{}, config.DynamicMap{}, deploymentID, "ClassificationJobUnit_Singularity", make([]*operations.EnvInput, 0), "",
 				&jobInfo{Name: "MyJob", Tasks: 2, Nodes: 4, WorkingDir: home,
@@ -206,3 +387,30 @@ func testExecutionCommonPrepareAndSubmitJob(t *testing.T) {
 		})
 	}
 }
+
+func Test_executionCommon_buildSrunExportOpt(t *testing.T) {
+	inputs := map[string]string{"INPUT": "value"}
+	tests := []struct {
+		name      string
+		exportEnv string
+		envVars   []string
+		inputs    map[string]string
+		want      string
+	}{
+		{"Default", "", []string{"A=1"}, inputs, ""},
+		{"All", "ALL", []string{"A=1"}, inputs, " --export='ALL'"},
+		{"AllWithVars", "ALL,B=2", nil, inputs, " --export='ALL,B=2'"},
+		{"None", "NONE", nil, nil, " --export=NONE"},
+		{"NoneWithJobEnv", "NONE", []string{"A=1"}, inputs, " --export='A,INPUT'"},
+		{"List", "PATH, HOME", []string{"A=1", "HOME=/tmp"}, inputs, " --export='PATH,HOME,A,INPUT'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &executionCommon{jobInfo: &jobInfo{
+				ExecutionOptions: types.SlurmExecutionOptions{ExportEnv: tt.exportEnv, EnvVars: tt.envVars},
+				Inputs:           tt.inputs,
+			}}
+			assert.Equal(t, tt.want, e.buildSrunExportOpt())
+		})
+	}
+}
@@ -22,6 +22,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/dustin/go-humanize"
 	"github.com/mitchellh/mapstructure"
@@ -36,12 +38,28 @@ import (
 	"github.com/ystia/yorc/v4/tosca/types"
 )
 
-const reSbatch = `Submitted batch job (\d+)`
+const reSbatch = `Submitted batch job (\d+(?:_\d+)?)`
 
 const errMsgInvalidJob = "Invalid job id specified"
 
+// Slurm partition, QOS, account and reservation names, several names may be separated by commas
+var reSlurmName = regexp.MustCompile(`^[A-Za-z0-9_.-]+(,[A-Za-z0-9_.-]+)*$`)
+
+// Slurm node features constraint: features (optionally with a node count, ex: knl*2) combined by the &, |, comma and
+// brackets operators of the Slurm boolean syntax. Quotes, spaces and other shell-unsafe characters are not allowed.
+var reSlurmConstraint = regexp.MustCompile(`^[A-Za-z0-9_.:\-&|,*()\[\]]+$`)
+
+// Slurm MPI plugin type, ex: pmix, pmix_v3 or pmi2
+var reMPIPlugin = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
 const errMsgAccountingDisabled = "Slurm accounting storage is disabled"
 
+// Default maximum durations of the commands run on the Slurm client node to submit jobs and to monitor them
+const (
+	defaultSSHCommandTimeout           = 5 * time.Minute
+	defaultSSHMonitoringCommandTimeout = time.Minute
+)
+
 // getSSHClient returns a SSH client with slurm credentials from node or job configuration provided by the deployment,
 // or by the yorc slurm configuration
 func getSSHClient(cfg config.Configuration, credentials *types.Credential, locationProps config.DynamicMap) (*sshutil.SSHClient, error) {
@@ -89,14 +107,26 @@ func getSSHClient(cfg config.Configuration, credentials *types.Credential, locat
 	}
 
 	return &sshutil.SSHClient{
-		Config:       SSHConfig,
-		Host:         locationProps.GetString("url"),
-		Port:         port,
-		MaxRetries:   locationProps.GetUint64OrDefault("ssh_connection_max_retries", cfg.SSHConnectionMaxRetries),
-		RetryBackoff: locationProps.GetDurationOrDefault("ssh_connection_retry_backoff", cfg.SSHConnectionRetryBackoff),
+		Config:          SSHConfig,
+		Host:            locationProps.GetString("url"),
+		Port:            port,
+		MaxRetries:      locationProps.GetUint64OrDefault("ssh_connection_max_retries", cfg.SSHConnectionMaxRetries),
+		RetryBackoff:    locationProps.GetDurationOrDefault("ssh_connection_retry_backoff", cfg.SSHConnectionRetryBackoff),
+		RetryMaxBackoff: locationProps.GetDurationOrDefault("ssh_connection_max_backoff", 0),
+		CommandTimeout:  locationProps.GetDurationOrDefault("ssh_command_timeout", defaultSSHCommandTimeout),
 	}, nil
 }
 
+// Returns a client running commands without timeout, for commands lasting as long as the job they run
+func withoutCommandTimeout(client sshutil.Client) sshutil.Client {
+	if c, ok := client.(*sshutil.SSHClient); ok && c.CommandTimeout > 0 {
+		noTimeoutClient := *c
+		noTimeoutClient.CommandTimeout = 0
+		return &noTimeoutClient
+	}
+	return client
+}
+
 // getUserCredentials returns user credentials from a node property, or a capability property.
 // the property name is provided by propertyName parameter, and its type is supposed to be tosca.datatypes.Credential
 func getUserCredentials(ctx context.Context, locationProps config.DynamicMap, deploymentID, nodeName, capabilityName string) (*types.Credential, error) {
@@ -291,10 +321,19 @@ func parseSallocResponse(r io.Reader, chRes chan allocationResponse, chErr chan
 }
 
 func parseJobInfo(r io.Reader) (map[string]string, error) {
+	// Job arrays have a job record per task, records are separated by empty lines
+	records := make([]map[string]string, 0)
 	data := make(map[string]string, 0)
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(data) > 0 {
+				records = append(records, data)
+				data = make(map[string]string, 0)
+			}
+			continue
+		}
 		props := strings.Split(line, " ")
 		for _, prop := range props {
 			if strings.Contains(prop, "=") {
@@ -303,7 +342,103 @@ func parseJobInfo(r io.Reader) (map[string]string, error) {
 			}
 		}
 	}
-	return data, nil
+	if len(data) > 0 {
+		records = append(records, data)
+	}
+	switch len(records) {
+	case 0:
+		return data, nil
+	case 1:
+		return records[0], nil
+	}
+	if _, isHetJob := records[0]["HetJobId"]; isHetJob {
+		return aggregateHetJobInfo(records), nil
+	}
+	return aggregateArrayJobInfo(records), nil
+}
+
+// Aggregates the records of the components of a heterogeneous job into a single job information.
+// The information is the one of the first component, which runs the batch script, with the heterogeneous job ID
+// as job ID. The job state is aggregated as for job arrays and the state of each component is listed.
+func aggregateHetJobInfo(records []map[string]string) map[string]string {
+	leader := records[0]
+	for _, rec := range records {
+		if rec["HetJobOffset"] == "0" {
+			leader = rec
+			break
+		}
+	}
+	info := make(map[string]string, len(leader)+1)
+	for k, v := range leader {
+		info[k] = v
+	}
+	info["JobId"] = info["HetJobId"]
+	states := make([]string, len(records))
+	componentStates := make([]string, len(records))
+	for i, rec := range records {
+		states[i] = rec["JobState"]
+		componentStates[i] = fmt.Sprintf("%s:%s", rec["HetJobOffset"], rec["JobState"])
+	}
+	info["JobState"] = aggregateJobStates(states)
+	info["HetComponentStates"] = strings.Join(componentStates, ",")
+	return info
+}
+
+// Aggregates the states of the tasks of a job array or of the components of a heterogeneous job.
+// The job is active while at least one of them is active, completed if all are completed,
+// otherwise its state is the first unsuccessful state.
+func aggregateJobStates(states []string) string {
+	var activeState, failedState string
+	for _, state := range states {
+		switch {
+		case isActiveJobState(state):
+			if activeState == "" || state == "RUNNING" {
+				activeState = state
+			}
+		case state == "COMPLETED":
+		default:
+			if failedState == "" {
+				failedState = state
+			}
+		}
+	}
+	switch {
+	case activeState != "":
+		return activeState
+	case failedState != "":
+		return failedState
+	}
+	return "COMPLETED"
+}
+
+// Aggregates job array tasks records into a single job information.
+// The array is active while at least one task is active, completed if all tasks are completed,
+// otherwise its state is the state of the first unsuccessful task.
+// Tasks outputs are task specific so they are not part of the aggregated information, started tasks IDs are listed instead.
+func aggregateArrayJobInfo(records []map[string]string) map[string]string {
+	info := make(map[string]string, len(records[0]))
+	for k, v := range records[0] {
+		info[k] = v
+	}
+	if id, ok := info["ArrayJobId"]; ok {
+		info["JobId"] = id
+	}
+	delete(info, "ArrayTaskId")
+	delete(info, "StdOut")
+	delete(info, "StdErr")
+
+	states := make([]string, len(records))
+	taskIDs := make([]string, 0)
+	for i, rec := range records {
+		states[i] = rec["JobState"]
+		// Pending tasks may be grouped into a single record with a tasks range
+		if _, err := strconv.Atoi(rec["ArrayTaskId"]); err == nil && states[i] != "PENDING" {
+			taskIDs = append(taskIDs, rec["ArrayTaskId"])
+		}
+	}
+	info["JobState"] = aggregateJobStates(states)
+	info["ArrayTaskIds"] = strings.Join(taskIDs, ",")
+	return info
 }
 
 func parseJobID(str string, regexp *regexp.Regexp) (string, error) {
@@ -317,6 +452,11 @@ func parseJobID(str string, regexp *regexp.Regexp) (string, error) {
 func cancelJobID(jobID string, client sshutil.Client) error {
 	scancelCmd := fmt.Sprintf("scancel %s", jobID)
 	sCancelOutput, err := client.RunCommand(scancelCmd)
+	if err != nil && strings.Contains(sCancelOutput, errMsgInvalidJob) {
+		// The job already ended and has been purged from the controller, nothing to cancel
+		log.Printf("Slurm job %q not found, it may have already ended: %s", jobID, sCancelOutput)
+		return nil
+	}
 	if err != nil {
 		return errors.Wrapf(err, "Failed to cancel Slurm job: %s:", sCancelOutput)
 	}
@@ -345,7 +485,8 @@ func parseKeyValue(str string) (bool, string, string) {
 }
 
 func getJobStatusUsingAccounting(ctx context.Context, client sshutil.Client, deploymentID, jobID string) (string, error) {
-	cmd := fmt.Sprintf("sacct -P -n -o JobID,State -j %s | grep \"^%s|\" | awk -F '|' '{print $2;}'", jobID, jobID)
+	// Components of heterogeneous jobs are suffixed by their offset (ex: 1234+0)
+	cmd := fmt.Sprintf("sacct -P -n -o JobID,State -j %s | grep -E \"^%s(\\+[0-9]+)?\\|\" | awk -F '|' '{print $2;}'", jobID, jobID)
 	output, err := client.RunCommand(cmd)
 	out := strings.Trim(output, "\" \t\n\x00")
 	if err != nil {
@@ -360,7 +501,7 @@ func getJobStatusUsingAccounting(ctx context.Context, client sshutil.Client, dep
 	if out == "" {
 		return "", &noJobFound{msg: fmt.Sprintf("no accounting information found for job with id: %q", jobID)}
 	}
-	return out, nil
+	return aggregateJobStates(strings.Split(out, "\n")), nil
 }
 
 func getMinimalJobInfoUsingAccounting(ctx context.Context, client sshutil.Client, deploymentID, jobID string) (map[string]string, error) {
@@ -385,18 +526,115 @@ func getJobInfo(ctx context.Context, client sshutil.Client, deploymentID, jobID
 	return getMinimalJobInfoUsingAccounting(ctx, client, deploymentID, jobID)
 }
 
+var reJobState = regexp.MustCompile(`^[A-Z_]+$`)
+
+// Returns the state of a job in the Slurm queue, or an empty string if the job is no longer in the queue, and the reason
+// why it is pending if it is.
+// An array job is pending while all its tasks are pending, otherwise the state of its first not pending task is returned.
+func getJobQueueState(client sshutil.Client, jobID string) (string, string, error) {
+	output, err := client.RunCommand(fmt.Sprintf("squeue -j %s -h -o '%%T %%r'", jobID))
+	if err != nil {
+		if strings.Contains(output, errMsgInvalidJob) {
+			return "", "", nil
+		}
+		return "", "", errors.Wrap(err, output)
+	}
+	var state, reason string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !reJobState.MatchString(fields[0]) {
+			continue
+		}
+		if fields[0] != "PENDING" {
+			return fields[0], "", nil
+		}
+		if state == "" {
+			state, reason = fields[0], strings.Join(fields[1:], " ")
+		}
+	}
+	return state, reason, nil
+}
+
+// Quotes a value to be safely used in a shell, single quotes are escaped
+func shellQuote(v string) string {
+	return "'" + strings.Replace(v, "'", `'\''`, -1) + "'"
+}
+
+// Quotes a path to be safely used in a shell, a leading home directory is kept unquoted to be expanded by the shell
+func shellQuotePath(p string) string {
+	if p == home {
+		return p
+	}
+	if strings.HasPrefix(p, home+"/") {
+		return home + "/" + shellQuote(strings.TrimPrefix(p, home+"/"))
+	}
+	return shellQuote(p)
+}
+
 func quoteArgs(t []string) string {
 	var args string
 	for _, v := range t {
 		if !strings.HasPrefix(v, "'") && !strings.HasSuffix(v, "'") {
-			v = strings.Replace(v, "'", "\"", -1)
-			v = "'" + v + "'"
+			v = shellQuote(v)
 		}
 		args += v + " "
 	}
 	return args
 }
 
+// Returns the given words shell-escaped and separated by spaces
+func quoteWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = shellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Splits a string into words as a shell would do, words are separated by spaces and may be quoted
+// using single or double quotes, a backslash escapes the next character outside of single quotes.
+// Neither variables nor globs are expanded.
+func splitShellWords(s string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, errors.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
 // Convert scalar-unit size to Kib as K for Slurm
 func toSlurmMemFormat(memStr string) (string, error) {
 	mem, err := humanize.ParseBytes(memStr)
@@ -407,3 +645,207 @@ func toSlurmMemFormat(memStr string) (string, error) {
 	// Pass to KiB as K for Slurm
 	return strconv.Itoa(int(mem)/1024) + "K", nil
 }
+
+var reMemorySize = regexp.MustCompile(`^\s*[0-9.]+\s*([a-zA-Z]+)\s*$`)
+
+// Converts a memory size with a unit suffix to the Slurm format.
+// Single letter suffixes (K, M, G, T) are binary units as for Slurm.
+func parseMemorySize(memStr string) (string, error) {
+	m := reMemorySize.FindStringSubmatch(memStr)
+	if m == nil {
+		return "", errors.Errorf("invalid memory size %q, expecting a number with a unit suffix (ex: 512M, 4GB or 4GiB)", memStr)
+	}
+	if len(m[1]) == 1 && strings.ContainsAny(strings.ToUpper(m[1]), "KMGT") {
+		memStr = strings.TrimSpace(memStr) + "iB"
+	}
+	mem, err := toSlurmMemFormat(memStr)
+	if err != nil || mem == "0K" {
+		return "", errors.Errorf("invalid memory size %q, expecting a number with a unit suffix (ex: 512M, 4GB or 4GiB)", strings.TrimSuffix(memStr, "iB"))
+	}
+	return mem, nil
+}
+
+// Converts a duration to the Slurm hours:minutes:seconds time format, rounded up to the second
+func toSlurmTimeFormat(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+// Accounting information of a job, a job array task or a job step as returned by sacct
+type jobAccounting struct {
+	JobID    string
+	State    string
+	ExitCode string
+	Elapsed  string
+	MaxRSS   string
+	// Partition, QOS, Account and Constraints are only set for jobs and array tasks, not for steps
+	Partition   string
+	QOS         string
+	Account     string
+	Constraints string
+}
+
+func getJobAccounting(ctx context.Context, client sshutil.Client, deploymentID, jobID string) ([]jobAccounting, error) {
+	cmd := fmt.Sprintf("sacct -j %s --format=JobID,State,ExitCode,Elapsed,MaxRSS,Partition,QOS,Account,Constraints --parsable2 --noheader", jobID)
+	output, err := client.RunCommand(cmd)
+	if err != nil {
+		if strings.Contains(output, errMsgAccountingDisabled) {
+			return nil, &noJobFound{msg: fmt.Sprintf("accounting is disabled on Slurm cluster, can't retrieve accounting information for job %q.", jobID)}
+		}
+		return nil, errors.Wrap(err, output)
+	}
+	acct := parseJobAccounting(output)
+	if len(acct) == 0 {
+		return nil, &noJobFound{msg: fmt.Sprintf("no accounting information found for job with id: %q", jobID)}
+	}
+	return acct, nil
+}
+
+// Parses sacct parsable output, lines not matching the expected format are ignored
+func parseJobAccounting(output string) []jobAccounting {
+	acct := make([]jobAccounting, 0)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if (len(fields) != 5 && len(fields) != 8 && len(fields) != 9) || fields[0] == "" {
+			continue
+		}
+		a := jobAccounting{JobID: fields[0], State: fields[1], ExitCode: fields[2], Elapsed: fields[3], MaxRSS: fields[4]}
+		if len(fields) >= 8 {
+			a.Partition, a.QOS, a.Account = fields[5], fields[6], fields[7]
+		}
+		if len(fields) == 9 {
+			a.Constraints = fields[8]
+		}
+		acct = append(acct, a)
+	}
+	return acct
+}
+
+// Summarizes job accounting information. Jobs arrays have a row per task, and each job or task has a row per step.
+// The exit code is the first non-zero exit code of the job or its array tasks, the elapsed time is the longest one
+// and the max RSS is the highest one among all steps.
+// The partition, QOS, account and constraints of the job are added if known.
+func summarizeJobAccounting(acct []jobAccounting) map[string]string {
+	exitCode := "0:0"
+	var elapsed, maxRSS string
+	var maxElapsed time.Duration
+	var maxRSSBytes uint64
+	summary := make(map[string]string)
+	for _, a := range acct {
+		// Steps are suffixed by a dot (ex: 1234.batch or 1234_1.0)
+		if !strings.Contains(a.JobID, ".") {
+			if exitCode == "0:0" && a.ExitCode != "" {
+				exitCode = a.ExitCode
+			}
+			for k, v := range map[string]string{"Partition": a.Partition, "QOS": a.QOS, "Account": a.Account, "Constraints": a.Constraints} {
+				if _, ok := summary[k]; !ok && v != "" {
+					summary[k] = v
+				}
+			}
+			if d, err := parseSlurmElapsed(a.Elapsed); err == nil && (elapsed == "" || d > maxElapsed) {
+				elapsed, maxElapsed = a.Elapsed, d
+			}
+		}
+		if rss, err := humanize.ParseBytes(a.MaxRSS); err == nil && (maxRSS == "" || rss > maxRSSBytes) {
+			maxRSS, maxRSSBytes = a.MaxRSS, rss
+		}
+	}
+	summary["ExitCode"] = exitCode
+	summary["Elapsed"] = elapsed
+	summary["MaxRSS"] = maxRSS
+	return summary
+}
+
+// Parses a Slurm elapsed time of the form [days-]hours:minutes:seconds or minutes:seconds
+func parseSlurmElapsed(elapsed string) (time.Duration, error) {
+	var days int
+	var err error
+	if i := strings.Index(elapsed, "-"); i >= 0 {
+		if days, err = strconv.Atoi(elapsed[:i]); err != nil {
+			return 0, errors.Wrapf(err, "invalid elapsed time %q", elapsed)
+		}
+		elapsed = elapsed[i+1:]
+	}
+	parts := strings.Split(elapsed, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.Errorf("invalid elapsed time %q", elapsed)
+	}
+	d := time.Duration(days) * 24 * time.Hour
+	unit := time.Second
+	for i := len(parts) - 1; i >= 0; i-- {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid elapsed time %q", elapsed)
+		}
+		d += time.Duration(v) * unit
+		unit *= 60
+	}
+	return d, nil
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("duration %q should be positive", value)
+	}
+	return d, nil
+}
+
+// Returns true if the job is still running or if its state is about to be set definitively
+func isActiveJobState(state string) bool {
+	switch state {
+	case "RUNNING", "PENDING", "COMPLETING", "CONFIGURING", "SIGNALING", "RESIZING":
+		return true
+	}
+	return false
+}
+
+// Common Slurm submission errors with a user-facing explanation
+var slurmErrorPatterns = []struct {
+	re      *regexp.Regexp
+	message string
+}{
+	{regexp.MustCompile(`(?i)invalid partition (name )?specified`), "the requested partition doesn't exist, check the partition job option"},
+	{regexp.MustCompile(`(?i)invalid account or account/partition combination specified`), "the account is not permitted to submit jobs to the requested partition, check the account job option"},
+	{regexp.MustCompile(`(?i)invalid qos specification`), "the requested QOS doesn't exist or is not permitted for this account"},
+	{regexp.MustCompile(`(?i)job violates accounting/qos policy`), "the job exceeds the limits of its account or QOS (number of jobs, size or time limits)"},
+	{regexp.MustCompile(`(?i)requested time limit is invalid|time limit exceeds`), "the requested time limit exceeds the partition limit, reduce the time job option"},
+	{regexp.MustCompile(`(?i)requested node configuration is not available`), "no node of the partition provides the requested resources, check the nodes, cpus, memory, gres and constraint job options"},
+	{regexp.MustCompile(`(?i)memory specification can not be satisfied`), "the requested memory is larger than the memory of the partition nodes, reduce the memory job option"},
+	{regexp.MustCompile(`(?i)node count specification invalid`), "the requested number of nodes is not available in the partition, reduce the nodes job option"},
+	{regexp.MustCompile(`(?i)invalid generic resource \(gres\) specification`), "the requested generic resources are not available, check the gres job option"},
+	{regexp.MustCompile(`(?i)unable to contact slurm controller`), "the Slurm controller can't be reached from the Slurm client node"},
+}
+
+// slurmError is a Slurm command error with a user-facing explanation, the raw command output is kept
+type slurmError struct {
+	message string
+	output  string
+	cause   error
+}
+
+func (se *slurmError) Error() string {
+	return fmt.Sprintf("%s: %s", se.message, strings.TrimSpace(se.output))
+}
+
+func (se *slurmError) Cause() error {
+	return se.cause
+}
+
+func (se *slurmError) Unwrap() error {
+	return se.cause
+}
+
+// Returns an error explaining the failure of a Slurm command if its output matches a known Slurm error,
+// otherwise the command error is wrapped with the raw output
+func parseSlurmError(err error, output string) error {
+	for _, p := range slurmErrorPatterns {
+		if p.re.MatchString(output) {
+			return &slurmError{message: p.message, output: output, cause: err}
+		}
+	}
+	return errors.Wrap(err, output)
+}
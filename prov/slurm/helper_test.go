@@ -23,6 +23,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -286,6 +287,32 @@ func TestPrivateKey(t *testing.T) {
 	assert.NoError(t, err, "Unexpected error getting a ssh client using provided credentials with password")
 }
 
+func TestSSHClientCommandTimeout(t *testing.T) {
+	t.Parallel()
+	locationProps := config.DynamicMap{
+		"user_name": "jdoe",
+		"url":       "127.0.0.1",
+		"port":      22,
+		"password":  "test",
+	}
+	cfg := config.Configuration{}
+	client, err := getSSHClient(cfg, &types.Credential{User: "jdoe", Token: "test"}, locationProps)
+	require.NoError(t, err)
+	assert.Equal(t, defaultSSHCommandTimeout, client.CommandTimeout)
+
+	locationProps.Set("ssh_command_timeout", "30s")
+	client, err = getSSHClient(cfg, &types.Credential{User: "jdoe", Token: "test"}, locationProps)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, client.CommandTimeout)
+
+	// Blocking jobs commands are not limited, the client itself is unchanged
+	noTimeoutClient := withoutCommandTimeout(client)
+	assert.Equal(t, time.Duration(0), noTimeoutClient.(*sshutil.SSHClient).CommandTimeout)
+	assert.Equal(t, 30*time.Second, client.CommandTimeout)
+	mock := &sshutil.MockSSHClient{}
+	assert.Equal(t, mock, withoutCommandTimeout(mock))
+}
+
 func TestParseJobIDFromSbatchOut(t *testing.T) {
 	t.Parallel()
 	str := "Submitted batch job 4567"
@@ -459,6 +486,37 @@ func TestGetJobInfoWithError(t *testing.T) {
 	require.Equal(t, "oups, it's bad: this is an error !", err.Error(), "expected error")
 }
 
+func TestCancelJobID(t *testing.T) {
+	t.Parallel()
+	s := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			require.Equal(t, "scancel 1234", cmd)
+			return "", nil
+		},
+	}
+	require.NoError(t, cancelJobID("1234", s))
+}
+
+func TestCancelJobIDWithEndedJob(t *testing.T) {
+	t.Parallel()
+	s := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			return "scancel: error: Kill job error on job id 1234: Invalid job id specified", errors.New("exit status 1")
+		},
+	}
+	require.NoError(t, cancelJobID("1234", s), "an already ended job should not be an error")
+}
+
+func TestCancelJobIDWithError(t *testing.T) {
+	t.Parallel()
+	s := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			return "scancel: error: Access/permission denied", errors.New("exit status 1")
+		},
+	}
+	require.Error(t, cancelJobID("1234", s))
+}
+
 func TestToSlurmMemFormat(t *testing.T) {
 	t.Parallel()
 	type args struct {
@@ -491,3 +549,305 @@ func TestToSlurmMemFormat(t *testing.T) {
 	}
 
 }
+
+func TestParseMemorySize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		memStr  string
+		want    string
+		wantErr bool
+	}{
+		{"4G", "4194304K", false},
+		{"512m", "524288K", false},
+		{"4GiB", "4194304K", false},
+		{"1 GB", "976562K", false},
+		{"4096", "", true},
+		{"0G", "", true},
+		{"4 bananas", "", true},
+		{"G", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.memStr, func(t *testing.T) {
+			got, err := parseMemorySize(tt.memStr)
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "expecting a number with a unit suffix")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToSlurmTimeFormat(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, "00:01:30", toSlurmTimeFormat(90*time.Second))
+	require.Equal(t, "00:00:01", toSlurmTimeFormat(100*time.Millisecond))
+	require.Equal(t, "48:00:00", toSlurmTimeFormat(48*time.Hour))
+	require.Equal(t, "01:05:07", toSlurmTimeFormat(time.Hour+5*time.Minute+7*time.Second))
+}
+
+func TestParseJobAccounting(t *testing.T) {
+	t.Parallel()
+	out := "1234|COMPLETED|0:0|00:10:00|\n1234.batch|COMPLETED|0:0|00:10:00|12M\nnot an accounting line\n"
+	require.Equal(t, []jobAccounting{
+		{JobID: "1234", State: "COMPLETED", ExitCode: "0:0", Elapsed: "00:10:00"},
+		{JobID: "1234.batch", State: "COMPLETED", ExitCode: "0:0", Elapsed: "00:10:00", MaxRSS: "12M"},
+	}, parseJobAccounting(out))
+}
+
+func TestSummarizeJobAccounting(t *testing.T) {
+	t.Parallel()
+	content, err := ioutil.ReadFile("testdata/sacct_array_failed.txt")
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		acct []jobAccounting
+		want map[string]string
+	}{
+		{"SimpleJob", parseJobAccounting("1234|COMPLETED|0:0|1-02:00:00|\n1234.batch|COMPLETED|0:0|1-02:00:00|512K\n"),
+			map[string]string{"ExitCode": "0:0", "Elapsed": "1-02:00:00", "MaxRSS": "512K"}},
+		{"JobArray", parseJobAccounting(string(content)),
+			map[string]string{"ExitCode": "2:0", "Elapsed": "00:02:10", "MaxRSS": "300M"}},
+		{"Signaled", parseJobAccounting("1234|CANCELLED|0:15|05:00|\n"),
+			map[string]string{"ExitCode": "0:15", "Elapsed": "05:00", "MaxRSS": ""}},
+		{"WithSchedulingOptions", parseJobAccounting("1234|COMPLETED|0:0|05:00||gpu|high|project_01\n1234.batch|COMPLETED|0:0|05:00|512K|||project_01\n"),
+			map[string]string{"ExitCode": "0:0", "Elapsed": "05:00", "MaxRSS": "512K", "Partition": "gpu", "QOS": "high", "Account": "project_01"}},
+		{"WithConstraints", parseJobAccounting("1234|COMPLETED|0:0|05:00||gpu|high|project_01|intel&ib\n1234.batch|COMPLETED|0:0|05:00|512K||||\n"),
+			map[string]string{"ExitCode": "0:0", "Elapsed": "05:00", "MaxRSS": "512K", "Partition": "gpu", "QOS": "high", "Account": "project_01", "Constraints": "intel&ib"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, summarizeJobAccounting(tt.acct))
+		})
+	}
+}
+
+func TestParseSlurmElapsed(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		elapsed string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"05:30", 5*time.Minute + 30*time.Second, false},
+		{"01:05:30", time.Hour + 5*time.Minute + 30*time.Second, false},
+		{"2-01:00:00", 49 * time.Hour, false},
+		{"", 0, true},
+		{"x-01:00:00", 0, true},
+		{"01:aa:00", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.elapsed, func(t *testing.T) {
+			got, err := parseSlurmElapsed(tt.elapsed)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseJobArray(t *testing.T) {
+	t.Parallel()
+	data, err := os.Open("testdata/scontrol_show_job_array.txt")
+	require.Nil(t, err, "unexpected error while opening test file")
+	info, err := parseJobInfo(data)
+	require.Nil(t, err, "unexpected error while parsing job info")
+	require.Equal(t, "6260", info["JobId"], "unexpected value for \"JobId\" key")
+	require.Equal(t, "RUNNING", info["JobState"], "unexpected value for \"JobState\" key")
+	require.Equal(t, "0,1", info["ArrayTaskIds"], "unexpected value for \"ArrayTaskIds\" key")
+	require.NotContains(t, info, "StdOut", "tasks outputs should not be part of array job info")
+}
+
+func TestParseHetJob(t *testing.T) {
+	t.Parallel()
+	data, err := os.Open("testdata/scontrol_show_hetjob.txt")
+	require.Nil(t, err, "unexpected error while opening test file")
+	info, err := parseJobInfo(data)
+	require.Nil(t, err, "unexpected error while parsing job info")
+	require.Equal(t, "7410", info["JobId"], "unexpected value for \"JobId\" key")
+	require.Equal(t, "RUNNING", info["JobState"], "unexpected value for \"JobState\" key")
+	require.Equal(t, "0:RUNNING,1:PENDING", info["HetComponentStates"], "unexpected value for \"HetComponentStates\" key")
+	require.Equal(t, "/home_nfs/john/slurm-7410.out", info["StdOut"], "unexpected value for \"StdOut\" key")
+}
+
+func TestGetJobStatusUsingAccountingForHetJob(t *testing.T) {
+	t.Parallel()
+	s := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			require.Contains(t, cmd, `grep -E "^7410(\+[0-9]+)?\|"`)
+			return "COMPLETED\nFAILED\n", nil
+		},
+	}
+	state, err := getJobStatusUsingAccounting(context.Background(), s, "d1", "7410")
+	require.Nil(t, err)
+	require.Equal(t, "FAILED", state)
+}
+
+func TestAggregateArrayJobInfo(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		states    []string
+		wantState string
+	}{
+		{"AllCompleted", []string{"COMPLETED", "COMPLETED"}, "COMPLETED"},
+		{"Pending", []string{"COMPLETED", "PENDING"}, "PENDING"},
+		{"RunningAndPending", []string{"PENDING", "RUNNING", "FAILED"}, "RUNNING"},
+		{"Failed", []string{"COMPLETED", "FAILED", "TIMEOUT"}, "FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := make([]map[string]string, 0)
+			for i, state := range tt.states {
+				records = append(records, map[string]string{"ArrayJobId": "10", "ArrayTaskId": strconv.Itoa(i), "JobState": state})
+			}
+			require.Equal(t, tt.wantState, aggregateArrayJobInfo(records)["JobState"])
+		})
+	}
+}
+
+func TestParseArrayJobIDFromSbatchOut(t *testing.T) {
+	t.Parallel()
+	ret, err := retrieveJobID("Submitted batch job 4567_3")
+	require.Nil(t, err, "unexpected error")
+	require.Equal(t, "4567_3", ret, "unexpected JobID parsing")
+}
+
+func TestGetJobQueueState(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		output     string
+		err        error
+		wantState  string
+		wantReason string
+		wantErr    bool
+	}{
+		{"Pending", "PENDING Resources\n", nil, "PENDING", "Resources", false},
+		{"PendingOnDependency", "PENDING Dependency\n", nil, "PENDING", "Dependency", false},
+		{"Running", "RUNNING None\n", nil, "RUNNING", "", false},
+		{"ArrayPending", "PENDING Priority\nPENDING Resources\n", nil, "PENDING", "Priority", false},
+		{"ArrayPartiallyRunning", "PENDING Resources\nRUNNING None\nCOMPLETING None\n", nil, "RUNNING", "", false},
+		{"NotInQueue", "", nil, "", "", false},
+		{"InvalidJob", "slurm_load_jobs error: Invalid job id specified", errors.New("exit status 1"), "", "", false},
+		{"Error", "slurm_load_jobs error: Unable to contact slurm controller", errors.New("exit status 1"), "", "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &sshutil.MockSSHClient{
+				MockRunCommand: func(cmd string) (string, error) {
+					require.Equal(t, "squeue -j 1234 -h -o '%T %r'", cmd)
+					return tt.output, tt.err
+				},
+			}
+			state, reason, err := getJobQueueState(s, "1234")
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantState, state)
+			require.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestQuoteArgs(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, `'pass' `, quoteArgs([]string{"pass"}))
+	require.Equal(t, `'it'\''s' 'a test' 'already quoted' `, quoteArgs([]string{"it's", "a test", "'already quoted'"}))
+}
+
+func TestQuoteWords(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, ``, quoteWords(nil))
+	require.Equal(t, `'/opt/my app/run' 'it'\''s' 'a test' ''\''quoted'\'''`, quoteWords([]string{"/opt/my app/run", "it's", "a test", "'quoted'"}))
+}
+
+func TestShellQuotePath(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, `~`, shellQuotePath("~"))
+	require.Equal(t, `~/'my overlay.img'`, shellQuotePath("~/my overlay.img"))
+	require.Equal(t, `'/scratch/it'\''s; rm -rf ~'`, shellQuotePath("/scratch/it's; rm -rf ~"))
+	require.Equal(t, `'~user/overlay.img'`, shellQuotePath("~user/overlay.img"))
+}
+
+func TestSplitShellWords(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"Empty", "  ", []string{}, false},
+		{"Flags", " --no-home  --contain\t-B /data ", []string{"--no-home", "--contain", "-B", "/data"}, false},
+		{"SingleQuotes", `--hostname 'my host' --env 'A="b c"'`, []string{"--hostname", "my host", "--env", `A="b c"`}, false},
+		{"DoubleQuotes", `--env "A=it's" ""`, []string{"--env", "A=it's", ""}, false},
+		{"Escapes", `my\ host 'a\b' $HOME;ls`, []string{"my host", `a\b`, "$HOME;ls"}, false},
+		{"UnterminatedQuote", `--hostname "my host`, nil, true},
+		{"TrailingEscape", `--contain \`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitShellWords(tt.s)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParsePositiveDuration(t *testing.T) {
+	t.Parallel()
+	d, err := parsePositiveDuration("1m30s")
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, d)
+	for _, v := range []string{"0s", "-5s", "5"} {
+		_, err = parsePositiveDuration(v)
+		require.Error(t, err, "expected an error for duration %q", v)
+	}
+}
+
+func TestParseSlurmError(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		output      string
+		wantMessage string
+	}{
+		{"InvalidPartition", "sbatch: error: Batch job submission failed: Invalid partition name specified", "the requested partition doesn't exist"},
+		{"AccountNotPermitted", "sbatch: error: Batch job submission failed: Invalid account or account/partition combination specified", "the account is not permitted"},
+		{"InvalidQOS", "sbatch: error: Batch job submission failed: Invalid qos specification", "the requested QOS doesn't exist"},
+		{"QOSPolicy", "sbatch: error: Batch job submission failed: Job violates accounting/QOS policy (job submit limit, user's size and/or time limits)", "the job exceeds the limits"},
+		{"TimeLimit", "sbatch: error: Batch job submission failed: Requested time limit is invalid (missing or exceeds some limit)", "the requested time limit exceeds"},
+		{"NodeConfiguration", "sbatch: error: Batch job submission failed: Requested node configuration is not available", "no node of the partition provides"},
+		{"Memory", "sbatch: error: Memory specification can not be satisfied", "the requested memory is larger"},
+		{"NodeCount", "sbatch: error: Batch job submission failed: Node count specification invalid", "the requested number of nodes"},
+		{"Gres", "sbatch: error: Batch job submission failed: Invalid generic resource (gres) specification", "the requested generic resources"},
+		{"Controller", "sbatch: error: Batch job submission failed: Unable to contact slurm controller (connect failure)", "the Slurm controller can't be reached"},
+		{"Unknown", "sbatch: error: something unexpected", "exit status 1"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cause := errors.New("exit status 1")
+			err := parseSlurmError(cause, tt.output+"\n")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.wantMessage)
+			require.Contains(t, err.Error(), strings.TrimSpace(tt.output), "raw output should be kept")
+			require.True(t, errors.Is(err, cause), "command error should be kept")
+		})
+	}
+}
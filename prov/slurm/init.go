@@ -33,5 +33,5 @@ func init() {
 			artifactImageImplementation,
 		}, executor, registry.BuiltinOrigin)
 
-	reg.RegisterActionOperator([]string{"job-monitoring"}, &actionOperator{}, registry.BuiltinOrigin)
+	reg.RegisterActionOperator([]string{"job-monitoring", instanceMonitoringActionType}, &actionOperator{}, registry.BuiltinOrigin)
 }
@@ -0,0 +1,113 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/deployments"
+	"github.com/ystia/yorc/v4/events"
+	"github.com/ystia/yorc/v4/helper/sshutil"
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/prov"
+	"github.com/ystia/yorc/v4/prov/scheduling"
+	"github.com/ystia/yorc/v4/tosca"
+)
+
+func (o *actionOperator) monitorInstance(ctx context.Context, cfg config.Configuration, deploymentID string, action *prov.Action) (bool, error) {
+	for _, k := range []string{"nodeName", "jobID", "instanceName", "runtime"} {
+		if action.Data[k] == "" {
+			return true, errors.Errorf("Missing mandatory information %s for actionType:%q", k, action.ActionType)
+		}
+	}
+	sshClient, _, err := getMonitoringSSHClient(ctx, cfg, deploymentID, action.Data["nodeName"])
+	if err != nil {
+		return true, err
+	}
+	cc, err := cfg.GetConsulClient()
+	if err != nil {
+		return true, err
+	}
+	return o.checkInstance(ctx, cc, sshClient, deploymentID, action)
+}
+
+// Checks that the singularity instance is still running. The instance is considered as dead if the job running it
+// is finished or if it is not listed anymore by the container runtime after having been started.
+func (o *actionOperator) checkInstance(ctx context.Context, cc *api.Client, sshClient sshutil.Client, deploymentID string, action *prov.Action) (bool, error) {
+	nodeName := action.Data["nodeName"]
+	jobID := action.Data["jobID"]
+	name := action.Data["instanceName"]
+
+	info, err := getJobInfo(ctx, sshClient, deploymentID, jobID)
+	if err != nil && !isNoJobFoundError(err) {
+		// Connection issues should not stop the monitoring
+		log.Printf("failed to get info of job %q running singularity instance %q: %v", jobID, name, err)
+		return false, nil
+	}
+	if err != nil || !isActiveJobState(info["JobState"]) {
+		return true, o.instanceDied(ctx, deploymentID, nodeName, name, fmt.Sprintf("job %q is not running anymore", jobID))
+	}
+	if info["JobState"] != "RUNNING" {
+		return false, nil
+	}
+
+	out, err := sshClient.RunCommand(fmt.Sprintf(jobStepCommand, jobID, fmt.Sprintf("%s instance list %s", action.Data["runtime"], name)))
+	if err != nil {
+		log.Printf("failed to list singularity instances of job %q: %v: %s", jobID, err, out)
+		return false, nil
+	}
+	started := action.Data["instanceStarted"] == "true"
+	if isInstanceListed(out, name) {
+		if !started {
+			action.Data["instanceStarted"] = "true"
+			if err = scheduling.UpdateActionData(cc, action.ID, "instanceStarted", "true"); err != nil {
+				log.Debugf("fail to update action data due to error:%+v:", err)
+			}
+			events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).Registerf(
+				"Singularity instance %q of node %q is running", name, nodeName)
+		}
+		return false, nil
+	}
+	if started {
+		return true, o.instanceDied(ctx, deploymentID, nodeName, name, "it is not listed anymore")
+	}
+	// The instance is still starting
+	return false, nil
+}
+
+func (o *actionOperator) instanceDied(ctx context.Context, deploymentID, nodeName, name, reason string) error {
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelERROR, deploymentID).Registerf(
+		"Singularity instance %q of node %q stopped unexpectedly: %s", name, nodeName, reason)
+	// TODO(loicalbertin) for now we consider only instance 0 (https://github.com/ystia/yorc/issues/670)
+	deployments.SetInstanceStateWithContextualLogs(ctx, deploymentID, nodeName, "0", tosca.NodeStateError)
+	return errors.Errorf("singularity instance %q of node %q stopped unexpectedly: %s", name, nodeName, reason)
+}
+
+// Checks if an instance is part of the output of the "instance list" command
+func isInstanceListed(out, name string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ctu "github.com/hashicorp/consul/sdk/testutil"
+	"gotest.tools/v3/assert"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/helper/sshutil"
+	"github.com/ystia/yorc/v4/prov"
+	"github.com/ystia/yorc/v4/testutil"
+)
+
+const instanceListOutput = `INSTANCE NAME    PID      IP    IMAGE
+jupyter          12345          /home/john/jupyter.sif
+`
+
+func testActionOperatorCheckInstance(t *testing.T, srv *ctu.TestServer, cfg config.Configuration) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	cc, err := cfg.GetConsulClient()
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name            string
+		jobInfoFile     string
+		instanceStarted bool
+		listOutput      string
+		wantDeregister  bool
+		wantErr         bool
+		wantStarted     bool
+	}{
+		{"InstanceStarting", "scontrol.txt", false, "INSTANCE NAME    PID      IP    IMAGE\n", false, false, false},
+		{"InstanceStarted", "scontrol.txt", false, instanceListOutput, false, false, true},
+		{"InstanceRunning", "scontrol.txt", true, instanceListOutput, false, false, true},
+		{"InstanceDied", "scontrol.txt", true, "INSTANCE NAME    PID      IP    IMAGE\n", true, true, true},
+		{"JobFinished", "scontrol_show_job_completed.txt", true, "", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := &prov.Action{ID: "checkInstance" + tt.name, ActionType: instanceMonitoringActionType, Data: map[string]string{
+				"nodeName":     "Service",
+				"jobID":        "6260",
+				"instanceName": "jupyter",
+				"runtime":      "apptainer",
+			}}
+			if tt.instanceStarted {
+				action.Data["instanceStarted"] = "true"
+			}
+			sshClient := &sshutil.MockSSHClient{
+				MockRunCommand: func(cmd string) (string, error) {
+					if strings.HasPrefix(cmd, "srun ") {
+						assert.Equal(t, "srun --jobid=6260 --overlap --nodes=1 --ntasks=1 apptainer instance list jupyter", cmd)
+						return tt.listOutput, nil
+					}
+					content, err := ioutil.ReadFile(filepath.Join("testdata", tt.jobInfoFile))
+					assert.NilError(t, err)
+					return string(content), nil
+				},
+			}
+
+			o := &actionOperator{}
+			deregister, err := o.checkInstance(context.Background(), cc, sshClient, deploymentID, action)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("actionOperator.checkInstance() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantDeregister, deregister)
+			assert.Equal(t, tt.wantStarted, action.Data["instanceStarted"] == "true")
+		})
+	}
+}
+
+func Test_isInstanceListed(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want bool
+	}{
+		{"Listed", instanceListOutput, true},
+		{"NoInstance", "INSTANCE NAME    PID      IP    IMAGE\n", false},
+		{"OtherInstance", strings.Replace(instanceListOutput, "jupyter ", "jupyter2", 1), false},
+		{"Empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isInstanceListed(tt.out, "jupyter"))
+		})
+	}
+}
@@ -16,10 +16,14 @@ package slurm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
@@ -36,7 +40,7 @@ import (
 
 const bashLogger = `
 if [ -f %s ]; then
-    tail -n +%d %s
+    tail -c +%d %s
 fi
 
 `
@@ -44,6 +48,10 @@ fi
 type actionOperator struct {
 }
 
+// IDs of the job monitoring actions checked by this Yorc instance. The first check of an action is always done,
+// regardless of the adaptive monitoring schedule, so that jobs that ended while Yorc was stopped are reconciled immediately.
+var checkedActions sync.Map
+
 type actionData struct {
 	stepName   string
 	jobID      string
@@ -56,7 +64,11 @@ func (o *actionOperator) ExecAction(ctx context.Context, cfg config.Configuratio
 	log.Debugf("Execute Action with ID:%q, taskID:%q, deploymentID:%q", action.ID, taskID, deploymentID)
 
 	if action.ActionType == "job-monitoring" {
+		ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{events.JobID: action.Data["jobID"]})
 		deregister, err := o.monitorJob(ctx, cfg, deploymentID, action)
+		if deregister || err != nil {
+			checkedActions.Delete(action.ID)
+		}
 		if err != nil {
 			// action scheduling needs to be unregistered
 			return true, err
@@ -64,6 +76,9 @@ func (o *actionOperator) ExecAction(ctx context.Context, cfg config.Configuratio
 
 		return deregister, nil
 	}
+	if action.ActionType == instanceMonitoringActionType {
+		return o.monitorInstance(ctx, cfg, deploymentID, action)
+	}
 	return true, errors.Errorf("Unsupported actionType %q", action.ActionType)
 }
 
@@ -131,27 +146,57 @@ func getCustomLogStream(cc *api.Client, action *prov.Action, info map[string]str
 }
 
 func (o *actionOperator) logJob(ctx context.Context, cc *api.Client, sshClient sshutil.Client, deploymentID, jobID string, action *prov.Action, info map[string]string) {
-
+	jobFinished := !isActiveJobState(info["JobState"])
 	stdOut, existStdOut := getCustomLogStream(cc, action, info, "StdOut")
 	stdErr, existStdErr := getCustomLogStream(cc, action, info, "StdErr")
 	if existStdOut && existStdErr && stdOut == stdErr {
-		o.logFile(ctx, cc, action, deploymentID, stdOut, "StdOut/StdErr", sshClient)
+		o.logFile(ctx, cc, action, deploymentID, stdOut, "StdOut/StdErr", sshClient, jobFinished)
 	} else {
 		if existStdOut {
-			o.logFile(ctx, cc, action, deploymentID, stdOut, "StdOut", sshClient)
+			o.logFile(ctx, cc, action, deploymentID, stdOut, "StdOut", sshClient, jobFinished)
 		}
 		if existStdErr {
-			o.logFile(ctx, cc, action, deploymentID, stdErr, "StdErr", sshClient)
+			o.logFile(ctx, cc, action, deploymentID, stdErr, "StdErr", sshClient, jobFinished)
 		}
 	}
 
-	// See default output if nothing is specified here
+	// See default or templated output if nothing is specified here
 	if !existStdOut && !existStdErr {
-		o.logFile(ctx, cc, action, deploymentID, fmt.Sprintf("slurm-%s.out", jobID), "StdOut/Stderr", sshClient)
+		separateErrorOutput := action.Data["separateErrorOutput"] == "true"
+		outputPattern := action.Data["outputFileName"]
+		if taskIDs, isArray := info["ArrayTaskIds"]; isArray {
+			// Output of job arrays is a file per task
+			for _, taskID := range strings.Split(taskIDs, ",") {
+				if taskID == "" {
+					continue
+				}
+				if separateErrorOutput {
+					o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, taskID, outputExt), "StdOut-"+taskID, sshClient, jobFinished)
+					o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, taskID, errorOutputExt), "StdErr-"+taskID, sshClient, jobFinished)
+				} else {
+					o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, taskID, outputExt), "StdOut/Stderr-"+taskID, sshClient, jobFinished)
+				}
+			}
+			return
+		}
+		if separateErrorOutput {
+			o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, "", outputExt), "StdOut", sshClient, jobFinished)
+			o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, "", errorOutputExt), "StdErr", sshClient, jobFinished)
+			return
+		}
+		o.logFile(ctx, cc, action, deploymentID, jobOutputFile(outputPattern, jobID, "", outputExt), "StdOut/Stderr", sshClient, jobFinished)
 	}
 
 }
 
+// Returns the name of the default output file of a job, or of a job array task if the task ID is set
+func defaultJobOutputFile(jobID, taskID, ext string) string {
+	if taskID != "" {
+		return fmt.Sprintf("slurm-%s_%s%s", jobID, taskID, ext)
+	}
+	return fmt.Sprintf("slurm-%s%s", jobID, ext)
+}
+
 func (o *actionOperator) analyzeJob(ctx context.Context, cc *api.Client, sshClient sshutil.Client, deploymentID, nodeName string, action *prov.Action, keepArtifacts bool) (bool, error) {
 	var (
 		err        error
@@ -163,11 +208,26 @@ func (o *actionOperator) analyzeJob(ctx context.Context, cc *api.Client, sshClie
 		return true, err
 	}
 
-	info, err := getJobInfo(ctx, sshClient, deploymentID, actionData.jobID)
-
 	// TODO(loicalbertin): This should be improved instance name should not be hard-coded (https://github.com/ystia/yorc/issues/670)
 	instanceName := "0"
 
+	// The queue state tells if the job is still pending, in this case there is nothing to inspect yet
+	// and output files may only exist from a previous run
+	state, reason, err := getJobQueueState(sshClient, actionData.jobID)
+	if err != nil {
+		log.Printf("failed to get queue state of job %q, using job information instead: %v", actionData.jobID, err)
+	} else if state == "PENDING" {
+		return false, o.checkPendingJob(ctx, cc, deploymentID, nodeName, instanceName, action, reason, time.Now())
+	}
+
+	info, err := getJobInfo(ctx, sshClient, deploymentID, actionData.jobID)
+
+	if errors.Is(err, sshutil.ErrCommandTimeout) {
+		// The Slurm client node may be temporarily unresponsive, the job is checked again later
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, deploymentID).Registerf(
+			"Failed to get information of job %q, it will be checked again: %v", actionData.jobID, err)
+		return false, nil
+	}
 	if err != nil {
 		if isNoJobFoundError(err) {
 			// the job is not found in slurm database (should have been purged) : pass its status to "UNKNOWN"
@@ -187,10 +247,14 @@ func (o *actionOperator) analyzeJob(ctx context.Context, cc *api.Client, sshClie
 		} else {
 			mess = fmt.Sprintf("Job Name:%s, ID:%s, State:%s, Execution Time:%s", info["JobName"], info["JobId"], info["JobState"], info["RunTime"])
 		}
+		if componentStates, isHetJob := info["HetComponentStates"]; isHetJob {
+			mess += fmt.Sprintf(", Components States:%s", componentStates)
+		}
 		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).RegisterAsString(mess)
 	}
 
 	o.logJob(ctx, cc, sshClient, deploymentID, actionData.jobID, action, info)
+	o.checkJobRestarts(ctx, cc, deploymentID, nodeName, action, info)
 
 	previousJobState, err := deployments.GetInstanceStateString(ctx, deploymentID, nodeName, instanceName)
 	if err != nil {
@@ -201,12 +265,19 @@ func (o *actionOperator) analyzeJob(ctx context.Context, cc *api.Client, sshClie
 	}
 
 	// See if monitoring must be continued and set job state if terminated
-	switch info["JobState"] {
+	jobState := info["JobState"]
+	if jobState == "PREEMPTED" && action.Data["requeue"] == "true" {
+		// A requeueable job is requeued by Slurm once preempted
+		jobState = "REQUEUED"
+	}
+	switch jobState {
 	case "COMPLETED":
 		// job has been done successfully : unregister monitoring
 		deregister = true
 	case "RUNNING", "PENDING", "COMPLETING", "CONFIGURING", "SIGNALING", "RESIZING":
 		// job's still running or its state is about to be set definitively: monitoring is keeping on (deregister stays false)
+	case "REQUEUED", "REQUEUE_FED", "REQUEUE_HOLD":
+		// job has been requeued, it resumes once scheduled again: monitoring is keeping on
 	default:
 		// Other cases as FAILED, CANCELLED, STOPPED, SUSPENDED, TIMEOUT, etc : error is return with job state and job info is logged
 		deregister = true
@@ -216,15 +287,258 @@ func (o *actionOperator) analyzeJob(ctx context.Context, cc *api.Client, sshClie
 		err = errors.Errorf("job with ID:%q finished unsuccessfully with state:%q", actionData.jobID, info["JobState"])
 	}
 
-	// cleanup except if error occurred or explicitly specified in config
-	if deregister && err == nil {
-		if !keepArtifacts {
-			o.removeArtifacts(actionData, sshClient)
+	// Once the job is finished, its exit code is retrieved from accounting
+	if deregister {
+		exitCode, accErr := o.updateJobAccounting(ctx, sshClient, deploymentID, nodeName, instanceName, actionData.jobID)
+		if accErr != nil {
+			log.Printf("failed to retrieve accounting information for job %q: %v", actionData.jobID, accErr)
+		} else if err == nil && exitCode != "0:0" {
+			err = errors.Errorf("job with ID:%q finished unsuccessfully with exit code:%q", actionData.jobID, exitCode)
 		}
 	}
+
+	// Last lines of the job output help to understand the failure
+	if err != nil {
+		err = o.addJobOutputTail(err, sshClient, actionData.jobID, action, info)
+	} else if deregister {
+		err = o.updateOutputFilesAttributes(ctx, sshClient, deploymentID, nodeName, instanceName, actionData, action)
+	}
+
+	if deregister {
+		o.cleanupJob(actionData, action, info, err == nil, keepArtifacts, sshClient)
+	}
 	return deregister, err
 }
 
+// Sets the pending state of a job not started yet and raises a warning once if the job is pending
+// for more than the pending timeout. A job waiting for its dependencies may be pending for a long time, the pending
+// timeout only starts once its dependencies are satisfied.
+func (o *actionOperator) checkPendingJob(ctx context.Context, cc *api.Client, deploymentID, nodeName, instanceName string, action *prov.Action, reason string, now time.Time) error {
+	previousJobState, err := deployments.GetInstanceStateString(ctx, deploymentID, nodeName, instanceName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get instance state for job %q", action.Data["jobID"])
+	}
+	if previousJobState != "PENDING" {
+		deployments.SetInstanceStateStringWithContextualLogs(ctx, deploymentID, nodeName, instanceName, "PENDING")
+	}
+
+	timeout, err := time.ParseDuration(action.Data["pendingTimeout"])
+	if err != nil || action.Data["pendingWarned"] == "true" {
+		// No timeout or warning already raised
+		return nil
+	}
+	if reason == dependencyPendingReason {
+		if action.Data["pendingSince"] != "" {
+			o.updateActionData(cc, action, "pendingSince", "")
+		}
+		return nil
+	}
+	pendingSince, err := time.Parse(time.RFC3339Nano, action.Data["pendingSince"])
+	if err != nil {
+		o.updateActionData(cc, action, "pendingSince", now.Format(time.RFC3339Nano))
+		return nil
+	}
+	if now.Sub(pendingSince) > timeout {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelWARN, deploymentID).Registerf(
+			"Job %q of node %q is pending for more than %s, check the job requirements and the cluster load", action.Data["jobID"], nodeName, timeout)
+		o.updateActionData(cc, action, "pendingWarned", "true")
+	}
+	return nil
+}
+
+// Raises an event when a requeueable job has been restarted, the job resumes from its last checkpoint.
+// The number of restarts already notified is stored in the action data.
+func (o *actionOperator) checkJobRestarts(ctx context.Context, cc *api.Client, deploymentID, nodeName string, action *prov.Action, info map[string]string) {
+	if action.Data["requeue"] != "true" {
+		return
+	}
+	restarts, err := strconv.Atoi(info["Restarts"])
+	if err != nil {
+		return
+	}
+	notified, _ := strconv.Atoi(action.Data["restarts"])
+	if restarts <= notified {
+		return
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).Registerf(
+		"Job %q of node %q has been requeued %d time(s), it resumes from its last checkpoint", action.Data["jobID"], nodeName, restarts)
+	o.updateActionData(cc, action, "restarts", strconv.Itoa(restarts))
+}
+
+func (o *actionOperator) updateActionData(cc *api.Client, action *prov.Action, key, value string) {
+	action.Data[key] = value
+	if err := scheduling.UpdateActionData(cc, action.ID, key, value); err != nil {
+		log.Debugf("fail to update action data due to error:%+v:", err)
+	}
+}
+
+// Removes the job files according to the job cleanup policy. Without policy, artifacts are removed if the job succeeded
+// except if explicitly specified in config. Otherwise artifacts, output files and the working directory are removed.
+// Cleanup failures are only logged.
+func (o *actionOperator) cleanupJob(actionData *actionData, action *prov.Action, info map[string]string, succeeded, keepArtifacts bool, sshClient sshutil.Client) {
+	switch action.Data["cleanupPolicy"] {
+	case cleanupNever:
+		return
+	case cleanupOnSuccess:
+		if !succeeded {
+			log.Printf("Job %q failed, its working directory %s is kept for debugging purpose", actionData.jobID, actionData.workingDir)
+			return
+		}
+	case cleanupAlways:
+	default:
+		if succeeded && !keepArtifacts {
+			o.removeArtifacts(actionData, sshClient)
+		}
+		return
+	}
+
+	o.removeArtifacts(actionData, sshClient)
+	outputs := make([]string, 0)
+	for _, stream := range []string{"StdOut", "StdErr"} {
+		if f, ok := action.Data[stream]; ok {
+			outputs = append(outputs, f)
+		}
+	}
+	if len(outputs) == 0 && actionData.jobID != "" {
+		var taskID string
+		if _, isArray := info["ArrayTaskIds"]; isArray {
+			taskID = "*"
+		}
+		outputs = append(outputs, path.Join(actionData.workingDir, jobOutputFile(action.Data["outputFileName"], actionData.jobID, taskID, outputExt)))
+		if action.Data["separateErrorOutput"] == "true" {
+			outputs = append(outputs, path.Join(actionData.workingDir, jobOutputFile(action.Data["outputFileName"], actionData.jobID, taskID, errorOutputExt)))
+		}
+	}
+	// Blocking jobs have no output file
+	if len(outputs) > 0 {
+		cmd := fmt.Sprintf("rm -f %s", strings.Join(outputs, " "))
+		if out, err := sshClient.RunCommand(cmd); err != nil {
+			log.Printf("an error:%+v occurred during removing output files of job %q: %s", err, actionData.jobID, out)
+		}
+	}
+	// The home directory is never removed, other directories are only removed if empty
+	if actionData.workingDir != home && actionData.workingDir != "" {
+		if out, err := sshClient.RunCommand(fmt.Sprintf("rmdir %s", actionData.workingDir)); err != nil {
+			log.Printf("working directory %s of job %q not removed: %+v: %s", actionData.workingDir, actionData.jobID, err, out)
+		}
+	}
+}
+
+// Adds the last lines of the job output to the error if enabled by the "error_output_lines" job property.
+// The error output is used if it is written to a dedicated file.
+func (o *actionOperator) addJobOutputTail(jobErr error, sshClient sshutil.Client, jobID string, action *prov.Action, info map[string]string) error {
+	lines, _ := strconv.Atoi(action.Data["errorOutputLines"])
+	if lines <= 0 {
+		return jobErr
+	}
+	outputFile, ok := action.Data["StdErr"]
+	if !ok {
+		outputFile, ok = action.Data["StdOut"]
+	}
+	if !ok {
+		if _, isArray := info["ArrayTaskIds"]; isArray {
+			// Each task has its own output
+			return jobErr
+		}
+		outputPattern := action.Data["outputFileName"]
+		if action.Data["separateErrorOutput"] == "true" {
+			outputFile = jobOutputFile(outputPattern, jobID, "", errorOutputExt)
+		} else {
+			outputFile = jobOutputFile(outputPattern, jobID, "", outputExt)
+		}
+	}
+	out, err := sshClient.RunCommand(fmt.Sprintf("tail -n %d %s", lines, outputFile))
+	if err != nil || strings.TrimSpace(out) == "" {
+		log.Debugf("failed to get last lines of job output file %s: %v: %s", outputFile, err, out)
+		return jobErr
+	}
+	return errors.Errorf("%v, last lines of output file %s:\n%s", jobErr, outputFile, strings.TrimRight(out, "\n"))
+}
+
+// Sets the content of the job output files as instance attributes once the job is completed
+func (o *actionOperator) updateOutputFilesAttributes(ctx context.Context, sshClient sshutil.Client, deploymentID, nodeName, instanceName string, actionData *actionData, action *prov.Action) error {
+	outputs, err := readOutputFiles(sshClient, actionData.workingDir, action.Data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to retrieve output files of job %q", actionData.jobID)
+	}
+	for name, value := range outputs {
+		if err = deployments.SetInstanceAttribute(ctx, deploymentID, nodeName, instanceName, name, value); err != nil {
+			return errors.Wrapf(err, "failed to set attribute %q from output file of job %q", name, actionData.jobID)
+		}
+	}
+	return nil
+}
+
+// Returns the content of the output files defined in action data by attribute name.
+// Paths are relative to the job working directory, the content of binary files is base64 encoded if required.
+// An error lists the outputs whose file is not found.
+func readOutputFiles(sshClient sshutil.Client, workingDir string, data map[string]string) (map[string]string, error) {
+	outputsJSON, ok := data["outputFiles"]
+	if !ok {
+		return nil, nil
+	}
+	var outputFiles map[string]string
+	if err := json.Unmarshal([]byte(outputsJSON), &outputFiles); err != nil {
+		return nil, errors.Wrap(err, "invalid output files definition")
+	}
+	names := make([]string, 0, len(outputFiles))
+	for name := range outputFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	outputs := make(map[string]string, len(names))
+	missing := make([]string, 0)
+	for _, name := range names {
+		p := shellQuote(outputFiles[name])
+		if workingDir != "" && !path.IsAbs(outputFiles[name]) {
+			// The working directory may be relative to the home directory so it is not quoted
+			p = workingDir + "/" + p
+		}
+		if _, err := sshClient.RunCommand(fmt.Sprintf("test -f %s", p)); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (%s)", name, outputFiles[name]))
+			continue
+		}
+		cmd := "cat"
+		if data["outputFilesEncoding"] == outputFilesBase64 {
+			cmd = "base64 -w 0"
+		}
+		out, err := sshClient.RunCommand(fmt.Sprintf("%s %s", cmd, p))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read output file %s of output %q: %s", outputFiles[name], name, out)
+		}
+		if data["outputFilesEncoding"] == outputFilesBase64 {
+			out = strings.TrimSpace(out)
+		}
+		outputs[name] = out
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("output files not found for outputs: %s", strings.Join(missing, ", "))
+	}
+	return outputs, nil
+}
+
+// Retrieves the job exit code, elapsed time and max RSS from accounting and sets them as job attributes.
+// The exit code is returned.
+func (o *actionOperator) updateJobAccounting(ctx context.Context, sshClient sshutil.Client, deploymentID, nodeName, instanceName, jobID string) (string, error) {
+	acct, err := getJobAccounting(ctx, sshClient, deploymentID, jobID)
+	if err != nil {
+		return "", err
+	}
+	info := summarizeJobAccounting(acct)
+	mess := fmt.Sprintf("Job ID:%s, Exit Code:%s, Elapsed Time:%s, Max RSS:%s", jobID, info["ExitCode"], info["Elapsed"], info["MaxRSS"])
+	for _, k := range []string{"Partition", "QOS", "Account", "Constraints"} {
+		if v, ok := info[k]; ok {
+			mess += fmt.Sprintf(", %s:%s", k, v)
+		}
+	}
+	events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).RegisterAsString(mess)
+	if err = o.updateJobAttributes(ctx, deploymentID, nodeName, instanceName, info); err != nil {
+		return "", err
+	}
+	return info["ExitCode"], nil
+}
+
 func (o *actionOperator) monitorJob(ctx context.Context, cfg config.Configuration, deploymentID string, action *prov.Action) (bool, error) {
 	var (
 		err error
@@ -232,33 +546,138 @@ func (o *actionOperator) monitorJob(ctx context.Context, cfg config.Configuratio
 
 	nodeName := action.Data["nodeName"]
 
+	// No job was submitted in dry run mode
+	if action.Data["dryRun"] == "true" {
+		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).Registerf(
+			"Dry run of node %q, no job to monitor", nodeName)
+		return true, nil
+	}
+
+	// A blocking job is already completed when the run operation starts
+	if action.Data["blocking"] == "true" {
+		return true, o.completeBlockingJob(ctx, cfg, deploymentID, nodeName, action)
+	}
+
+	// In adaptive monitoring mode, triggers occurring before the next check is due are skipped
+	if !isJobCheckDue(action, time.Now()) {
+		return false, nil
+	}
+
+	sshClient, locationProps, err := getMonitoringSSHClient(ctx, cfg, deploymentID, nodeName)
+	if err != nil {
+		return true, err
+	}
+
+	cc, err := cfg.GetConsulClient()
+	if err != nil {
+		log.Debugf("fail to retrieve consul client due to error:%+v:", err)
+		return true, err
+	}
+
+	deregister, err := o.analyzeJob(ctx, cc, sshClient, deploymentID, nodeName, action, locationProps.GetBool("keep_job_remote_artifacts"))
+	if err == nil && !deregister {
+		o.scheduleNextMonitoringCheck(cc, action, time.Now())
+	}
+	return deregister, err
+}
+
+// Retrieves the output files of a completed blocking job and cleans up its working directory
+func (o *actionOperator) completeBlockingJob(ctx context.Context, cfg config.Configuration, deploymentID, nodeName string, action *prov.Action) error {
+	actionData, err := getMonitoringJobActionData(action)
+	if err != nil {
+		return err
+	}
+	sshClient, locationProps, err := getMonitoringSSHClient(ctx, cfg, deploymentID, nodeName)
+	if err != nil {
+		return err
+	}
+	// TODO(loicalbertin): This should be improved instance name should not be hard-coded (https://github.com/ystia/yorc/issues/670)
+	instanceName := "0"
+	deployments.SetInstanceStateStringWithContextualLogs(ctx, deploymentID, nodeName, instanceName, "COMPLETED")
+	err = o.updateOutputFilesAttributes(ctx, sshClient, deploymentID, nodeName, instanceName, actionData, action)
+	o.cleanupJob(actionData, action, nil, err == nil, locationProps.GetBool("keep_job_remote_artifacts"), sshClient)
+	return err
+}
+
+// Returns a sshClient to connect to slurm client node, and execute slurm commands such as squeue, or system commands such as cp, mv, mkdir, etc.
+// Location properties of the node are returned too.
+func getMonitoringSSHClient(ctx context.Context, cfg config.Configuration, deploymentID, nodeName string) (sshutil.Client, config.DynamicMap, error) {
 	var locationProps config.DynamicMap
 	locationMgr, err := locations.GetManager(cfg)
 	if err == nil {
 		locationProps, err = locationMgr.GetLocationPropertiesForNode(ctx, deploymentID, nodeName, infrastructureType)
 	}
 	if err != nil {
-		return true, err
+		return nil, nil, err
 	}
 
 	credentials, err := getUserCredentials(ctx, locationProps, deploymentID, nodeName, "")
 	if err != nil {
-		return true, err
+		return nil, nil, err
 	}
-	// Get a sshClient to connect to slurm client node, and execute slurm commands such as squeue, or system commands such as cp, mv, mkdir, etc.
 	sshClient, err := getSSHClient(cfg, credentials, locationProps)
 	if err != nil {
-		return true, err
+		return nil, nil, err
+	}
+	sshClient.CommandTimeout = locationProps.GetDurationOrDefault("ssh_monitoring_command_timeout", defaultSSHMonitoringCommandTimeout)
+	return sshClient, locationProps, nil
+}
+
+// Returns true if the job of a monitoring action should be checked now: on the first check of the action
+// by this Yorc instance, or when its next monitoring check is due
+func isJobCheckDue(action *prov.Action, now time.Time) bool {
+	if _, checked := checkedActions.LoadOrStore(action.ID, true); !checked {
+		return true
 	}
+	return isMonitoringCheckDue(action.Data, now)
+}
 
-	cc, err := cfg.GetConsulClient()
+// Checks if the job should be checked now, this is always the case unless adaptive monitoring is enabled
+func isMonitoringCheckDue(data map[string]string, now time.Time) bool {
+	nextCheck, ok := data["nextMonitoringCheck"]
+	if !ok {
+		return true
+	}
+	next, err := time.Parse(time.RFC3339Nano, nextCheck)
 	if err != nil {
-		log.Debugf("fail to retrieve consul client due to error:%+v:", err)
-		return true, err
+		log.Printf("invalid next monitoring check date %q: %v", nextCheck, err)
+		return true
 	}
+	return !now.Before(next)
+}
 
-	return o.analyzeJob(ctx, cc, sshClient, deploymentID, nodeName, action, locationProps.GetBool("keep_job_remote_artifacts"))
+// In adaptive monitoring mode, the monitoring interval is doubled after each check up to the max monitoring interval
+func (o *actionOperator) scheduleNextMonitoringCheck(cc *api.Client, action *prov.Action, now time.Time) {
+	maxInterval, err := time.ParseDuration(action.Data["monitoringMaxTimeInterval"])
+	if err != nil {
+		// Adaptive monitoring disabled
+		return
+	}
+	interval, err := time.ParseDuration(action.Data["monitoringCurrentTimeInterval"])
+	if err != nil {
+		interval, err = time.ParseDuration(action.Data["monitoringTimeInterval"])
+		if err != nil {
+			log.Printf("invalid monitoring time interval for action %q: %v", action.ID, err)
+			return
+		}
+	}
+	interval = nextMonitoringInterval(interval, maxInterval)
+	log.Debugf("Next monitoring check of job %q in %s", action.Data["jobID"], interval)
+	data := map[string]string{
+		"monitoringCurrentTimeInterval": interval.String(),
+		"nextMonitoringCheck":           now.Add(interval).Format(time.RFC3339Nano),
+	}
+	for k, v := range data {
+		o.updateActionData(cc, action, k, v)
+	}
+}
 
+func nextMonitoringInterval(interval, maxInterval time.Duration) time.Duration {
+	interval *= 2
+	if interval > maxInterval {
+		return maxInterval
+	}
+	return interval
 }
 
 func (o *actionOperator) removeArtifacts(actionData *actionData, sshClient sshutil.Client) {
@@ -275,36 +694,55 @@ func (o *actionOperator) removeArtifacts(actionData *actionData, sshClient sshut
 	}
 }
 
-func (o *actionOperator) logFile(ctx context.Context, cc *api.Client, action *prov.Action, deploymentID, filePath, fileType string, sshClient sshutil.Client) {
-	fileTypeKey := fmt.Sprintf("lastIndex%s", strings.Replace(fileType, "/", "", -1))
-	// Get the log last index
-	lastInd, err := o.getLogLastIndex(action, fileTypeKey)
+// Logs the content appended to the given file since the previous call as a log event.
+// The read offset is stored in bytes in the action data to avoid sending the same content twice.
+// While the job is active, only complete lines are logged, the remaining is logged once the job is finished.
+func (o *actionOperator) logFile(ctx context.Context, cc *api.Client, action *prov.Action, deploymentID, filePath, fileType string, sshClient sshutil.Client, jobFinished bool) {
+	if ctx.Err() != nil {
+		log.Debugf("skip logging file %s as context is done: %v", filePath, ctx.Err())
+		return
+	}
+	fileTypeKey := fmt.Sprintf("logOffset%s", strings.Replace(fileType, "/", "", -1))
+	// Get the log last offset
+	offset, err := o.getLogOffset(action, fileTypeKey)
 	if err != nil {
-		log.Debugf("fail to get log last index for log file (%s)due to error:%+v:", filePath, err)
+		log.Debugf("fail to get log offset for log file (%s)due to error:%+v:", filePath, err)
 		return
 	}
 
-	cmd := fmt.Sprintf(bashLogger, filePath, lastInd+1, filePath)
+	cmd := fmt.Sprintf(bashLogger, filePath, offset+1, filePath)
 	output, err := sshClient.RunCommand(cmd)
 	if err != nil {
 		log.Debugf("fail to log file (%s)due to error:%+v:", filePath, err)
 		return
 	}
+	if !jobFinished {
+		// Keep a partially written line for the next check
+		output = output[:strings.LastIndex(output, "\n")+1]
+	}
+	if output == "" {
+		return
+	}
 	if strings.TrimSpace(output) != "" {
+		level := events.LogLevelINFO
+		if strings.HasPrefix(fileType, "StdErr") {
+			level = events.LogLevelWARN
+		}
 		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelDEBUG, deploymentID).RegisterAsString(fmt.Sprintf("Run the command: %q", cmd))
-		events.WithContextOptionalFields(ctx).NewLogEntry(events.LogLevelINFO, deploymentID).RegisterAsString(fmt.Sprintf("%s %s:\n%s", fileType, filePath, output))
+		events.WithContextOptionalFields(ctx).NewLogEntry(level, deploymentID).RegisterAsString(fmt.Sprintf("%s %s:\n%s", fileType, filePath, output))
 	}
 
-	// Update the last index
-	newInd := strconv.Itoa(lastInd + strings.Count(output, "\n"))
-	err = scheduling.UpdateActionData(cc, action.ID, fileTypeKey, newInd)
+	// Update the last offset
+	newOffset := strconv.Itoa(offset + len(output))
+	action.Data[fileTypeKey] = newOffset
+	err = scheduling.UpdateActionData(cc, action.ID, fileTypeKey, newOffset)
 	if err != nil {
 		log.Debugf("fail to update action data due to error:%+v:", err)
 		return
 	}
 }
 
-func (o *actionOperator) getLogLastIndex(action *prov.Action, fileTypeKey string) (int, error) {
+func (o *actionOperator) getLogOffset(action *prov.Action, fileTypeKey string) (int, error) {
 	lastIndex, ok := action.Data[fileTypeKey]
 	if !ok {
 		return 0, nil
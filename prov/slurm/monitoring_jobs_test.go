@@ -16,12 +16,18 @@ package slurm
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	ctu "github.com/hashicorp/consul/sdk/testutil"
+	"github.com/pkg/errors"
 	"github.com/ystia/yorc/v4/config"
 	"github.com/ystia/yorc/v4/deployments"
 	"github.com/ystia/yorc/v4/helper/sshutil"
@@ -55,6 +61,8 @@ func testActionOperatorAnalyzeJob(t *testing.T, srv *ctu.TestServer, cfg config.
 		name        string
 		args        args
 		jobInfoFile string
+		sacctFile   string
+		cmdErr      error
 		want        bool
 		wantErr     bool
 	}{
@@ -64,28 +72,42 @@ func testActionOperatorAnalyzeJob(t *testing.T, srv *ctu.TestServer, cfg config.
 			"stepName":   "run",
 			"taskID":     "t1",
 			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
-		}}, keepArtifacts: false}, "scontrol.txt", false, false},
+		}}, keepArtifacts: false}, "scontrol.txt", "", nil, false, false},
 		{"MonitorCompletedJob", args{deploymentID: deploymentID, nodeName: "Job", action: &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
 			"nodeName":   "Job",
 			"jobID":      "6260",
 			"stepName":   "run",
 			"taskID":     "t1",
 			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
-		}}, keepArtifacts: false}, "scontrol_show_job_completed.txt", true, false},
+		}}, keepArtifacts: false}, "scontrol_show_job_completed.txt", "", nil, true, false},
+		{"MonitorCompletedJobWithFailedArrayTask", args{deploymentID: deploymentID, nodeName: "Job", action: &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
+			"nodeName":   "Job",
+			"jobID":      "6260",
+			"stepName":   "run",
+			"taskID":     "t1",
+			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
+		}}, keepArtifacts: false}, "scontrol_show_job_completed.txt", "sacct_array_failed.txt", nil, true, true},
 		{"MonitorFailedJob", args{deploymentID: deploymentID, nodeName: "Job", action: &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
 			"nodeName":   "Job",
 			"jobID":      "6260",
 			"stepName":   "run",
 			"taskID":     "t1",
 			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
-		}}, keepArtifacts: false}, "scontrol_show_job_failed.txt", true, true},
+		}}, keepArtifacts: false}, "scontrol_show_job_failed.txt", "", nil, true, true},
 		{"JobNotFound", args{deploymentID: deploymentID, nodeName: "Job", action: &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
 			"nodeName":   "Job",
 			"jobID":      "6260",
 			"stepName":   "run",
 			"taskID":     "t1",
 			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
-		}}, keepArtifacts: false}, "", true, true},
+		}}, keepArtifacts: false}, "", "", nil, true, true},
+		{"JobInfoCommandTimeout", args{deploymentID: deploymentID, nodeName: "Job", action: &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
+			"nodeName":   "Job",
+			"jobID":      "6260",
+			"stepName":   "run",
+			"taskID":     "t1",
+			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
+		}}, keepArtifacts: false}, "", "", errors.Wrap(sshutil.ErrCommandTimeout, "command not completed after 1m0s"), false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -93,6 +115,14 @@ func testActionOperatorAnalyzeJob(t *testing.T, srv *ctu.TestServer, cfg config.
 
 			sshClient := &sshutil.MockSSHClient{
 				MockRunCommand: func(input string) (string, error) {
+					if tt.cmdErr != nil && strings.HasPrefix(input, "scontrol show job") {
+						return "", tt.cmdErr
+					}
+					if tt.sacctFile != "" && strings.HasPrefix(input, "sacct ") {
+						content, err := ioutil.ReadFile(filepath.Join("testdata", tt.sacctFile))
+						assert.NilError(t, err)
+						return string(content), nil
+					}
 					if tt.jobInfoFile != "" {
 						testdataFile := filepath.Join("testdata", tt.jobInfoFile)
 						testdataFileContent, err := ioutil.ReadFile(testdataFile)
@@ -115,6 +145,297 @@ func testActionOperatorAnalyzeJob(t *testing.T, srv *ctu.TestServer, cfg config.
 	}
 }
 
+func testActionOperatorMonitorPendingJob(t *testing.T, srv *ctu.TestServer, cfg config.Configuration) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/jobMonitoringTest.yaml")
+	assert.NilError(t, err)
+
+	cc, err := cfg.GetConsulClient()
+	assert.NilError(t, err)
+
+	o := &actionOperator{}
+	sshClient := &sshutil.MockSSHClient{
+		MockRunCommand: func(input string) (string, error) {
+			// Job information and output files are not inspected while the job is pending
+			assert.Assert(t, strings.HasPrefix(input, "squeue "), "unexpected command %q", input)
+			return "PENDING\n", nil
+		},
+	}
+	action := &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
+		"nodeName":       "Job",
+		"jobID":          "6260",
+		"stepName":       "run",
+		"taskID":         "t1",
+		"workingDir":     filepath.Join(cfg.WorkingDirectory, t.Name()),
+		"pendingTimeout": "1h",
+	}}
+
+	deregister, err := o.analyzeJob(ctx, cc, sshClient, deploymentID, "Job", action, false)
+	assert.NilError(t, err)
+	assert.Equal(t, deregister, false)
+	state, err := deployments.GetInstanceStateString(ctx, deploymentID, "Job", "0")
+	assert.NilError(t, err)
+	assert.Equal(t, state, "PENDING")
+	pendingSince, err := time.Parse(time.RFC3339Nano, action.Data["pendingSince"])
+	assert.NilError(t, err)
+
+	// The warning is raised once the pending timeout is exceeded
+	assert.NilError(t, o.checkPendingJob(ctx, cc, deploymentID, "Job", "0", action, "Resources", pendingSince.Add(30*time.Minute)))
+	assert.Equal(t, action.Data["pendingWarned"], "")
+
+	// A job waiting for its dependencies is not considered stuck, the timeout starts once they are satisfied
+	assert.NilError(t, o.checkPendingJob(ctx, cc, deploymentID, "Job", "0", action, "Dependency", pendingSince.Add(2*time.Hour)))
+	assert.Equal(t, action.Data["pendingWarned"], "")
+	assert.Equal(t, action.Data["pendingSince"], "")
+	assert.NilError(t, o.checkPendingJob(ctx, cc, deploymentID, "Job", "0", action, "Resources", pendingSince.Add(3*time.Hour)))
+	assert.Equal(t, action.Data["pendingSince"], pendingSince.Add(3*time.Hour).Format(time.RFC3339Nano))
+	assert.NilError(t, o.checkPendingJob(ctx, cc, deploymentID, "Job", "0", action, "Resources", pendingSince.Add(4*time.Hour)))
+	assert.Equal(t, action.Data["pendingWarned"], "")
+	assert.NilError(t, o.checkPendingJob(ctx, cc, deploymentID, "Job", "0", action, "Resources", pendingSince.Add(5*time.Hour)))
+	assert.Equal(t, action.Data["pendingWarned"], "true")
+}
+
+func testActionOperatorMonitorRequeuedJob(t *testing.T, srv *ctu.TestServer, cfg config.Configuration) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	ctx := context.Background()
+	err := deployments.StoreDeploymentDefinition(ctx, deploymentID, "testdata/jobMonitoringTest.yaml")
+	assert.NilError(t, err)
+
+	cc, err := cfg.GetConsulClient()
+	assert.NilError(t, err)
+
+	o := &actionOperator{}
+	sshClient := &sshutil.MockSSHClient{
+		MockRunCommand: func(input string) (string, error) {
+			if strings.HasPrefix(input, "squeue ") {
+				return "PREEMPTED\n", nil
+			}
+			content, err := ioutil.ReadFile(filepath.Join("testdata", "scontrol_show_job_preempted.txt"))
+			assert.NilError(t, err)
+			return string(content), nil
+		},
+	}
+	newAction := func(data map[string]string) *prov.Action {
+		action := &prov.Action{ActionType: "job-monitoring", Data: map[string]string{
+			"nodeName":   "Job",
+			"jobID":      "6260",
+			"stepName":   "run",
+			"taskID":     "t1",
+			"workingDir": filepath.Join(cfg.WorkingDirectory, t.Name()),
+		}}
+		for k, v := range data {
+			action.Data[k] = v
+		}
+		return action
+	}
+
+	// A preempted requeueable job resumes once scheduled again
+	action := newAction(map[string]string{"requeue": "true"})
+	deregister, err := o.analyzeJob(ctx, cc, sshClient, deploymentID, "Job", action, false)
+	assert.NilError(t, err)
+	assert.Equal(t, deregister, false)
+	assert.Equal(t, action.Data["restarts"], "1")
+
+	// Otherwise the job is over
+	deregister, err = o.analyzeJob(ctx, cc, sshClient, deploymentID, "Job", newAction(nil), false)
+	assert.ErrorContains(t, err, "PREEMPTED")
+	assert.Equal(t, deregister, true)
+}
+
+func testActionOperatorLogFile(t *testing.T, srv *ctu.TestServer, cfg config.Configuration) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	cc, err := cfg.GetConsulClient()
+	assert.NilError(t, err)
+
+	content := "line 1\nline 2\npartial"
+	reTail := regexp.MustCompile(`tail -c \+(\d+) `)
+	sshClient := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			m := reTail.FindStringSubmatch(cmd)
+			assert.Assert(t, m != nil, "unexpected command %q", cmd)
+			start, err := strconv.Atoi(m[1])
+			assert.NilError(t, err)
+			return content[start-1:], nil
+		},
+	}
+
+	o := &actionOperator{}
+	action := &prov.Action{ID: "logFileAction", ActionType: "job-monitoring", Data: map[string]string{}}
+	// Partial lines are not logged while the job is running
+	o.logFile(context.Background(), cc, action, deploymentID, "slurm-1.out", "StdOut/Stderr", sshClient, false)
+	assert.Equal(t, "14", action.Data["logOffsetStdOutStderr"])
+	// Nothing new
+	o.logFile(context.Background(), cc, action, deploymentID, "slurm-1.out", "StdOut/Stderr", sshClient, false)
+	assert.Equal(t, "14", action.Data["logOffsetStdOutStderr"])
+	// Remaining content is logged once the job is finished
+	content += " line\n"
+	o.logFile(context.Background(), cc, action, deploymentID, "slurm-1.out", "StdOut/Stderr", sshClient, true)
+	assert.Equal(t, strconv.Itoa(len(content)), action.Data["logOffsetStdOutStderr"])
+
+	// Nothing is read once the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	content += "after cancel\n"
+	o.logFile(ctx, cc, action, deploymentID, "slurm-1.out", "StdOut/Stderr", sshClient, true)
+	assert.Equal(t, strconv.Itoa(len(content)-len("after cancel\n")), action.Data["logOffsetStdOutStderr"])
+}
+
+func testActionOperatorLogJobSeparateErrorOutput(t *testing.T, srv *ctu.TestServer, cfg config.Configuration) {
+	deploymentID := testutil.BuildDeploymentID(t)
+	cc, err := cfg.GetConsulClient()
+	assert.NilError(t, err)
+
+	reTail := regexp.MustCompile(`tail -c \+1 (\S+)`)
+	var files []string
+	sshClient := &sshutil.MockSSHClient{
+		MockRunCommand: func(cmd string) (string, error) {
+			m := reTail.FindStringSubmatch(cmd)
+			assert.Assert(t, m != nil, "unexpected command %q", cmd)
+			files = append(files, m[1])
+			return "line\n", nil
+		},
+	}
+
+	o := &actionOperator{}
+	action := &prov.Action{ID: "logJobAction", ActionType: "job-monitoring", Data: map[string]string{"separateErrorOutput": "true"}}
+	o.logJob(context.Background(), cc, sshClient, deploymentID, "6260", action, map[string]string{"JobState": "RUNNING", "ArrayTaskIds": "0,1"})
+	assert.DeepEqual(t, []string{"slurm-6260_0.out", "slurm-6260_0.err", "slurm-6260_1.out", "slurm-6260_1.err"}, files)
+	for _, key := range []string{"logOffsetStdOut-0", "logOffsetStdErr-0", "logOffsetStdOut-1", "logOffsetStdErr-1"} {
+		assert.Equal(t, "5", action.Data[key], "unexpected offset %s", key)
+	}
+}
+
+func Test_actionOperator_addJobOutputTail(t *testing.T) {
+	jobErr := errors.New("job with ID:\"6260\" finished unsuccessfully with state:\"FAILED\"")
+	tests := []struct {
+		name    string
+		data    map[string]string
+		info    map[string]string
+		wantCmd string
+		want    string
+	}{
+		{"Disabled", map[string]string{}, map[string]string{}, "", jobErr.Error()},
+		{"DefaultOutput", map[string]string{"errorOutputLines": "2"}, map[string]string{}, "tail -n 2 slurm-6260.out",
+			jobErr.Error() + ", last lines of output file slurm-6260.out:\nline 9\nline 10"},
+		{"ErrorOutput", map[string]string{"errorOutputLines": "2", "StdOut": "/home/john/out.txt", "StdErr": "/home/john/err.txt"}, map[string]string{}, "tail -n 2 /home/john/err.txt",
+			jobErr.Error() + ", last lines of output file /home/john/err.txt:\nline 9\nline 10"},
+		{"SeparateErrorOutput", map[string]string{"errorOutputLines": "2", "separateErrorOutput": "true"}, map[string]string{}, "tail -n 2 slurm-6260.err",
+			jobErr.Error() + ", last lines of output file slurm-6260.err:\nline 9\nline 10"},
+		{"JobArray", map[string]string{"errorOutputLines": "2"}, map[string]string{"ArrayTaskIds": "1,2"}, "", jobErr.Error()},
+		{"TemplatedOutput", map[string]string{"errorOutputLines": "2", "outputFileName": "result-%j-%t.out"}, map[string]string{}, "tail -n 2 result-6260-0.out",
+			jobErr.Error() + ", last lines of output file result-6260-0.out:\nline 9\nline 10"},
+		{"TemplatedSeparateErrorOutput", map[string]string{"errorOutputLines": "2", "outputFileName": "result-%j.out", "separateErrorOutput": "true"}, map[string]string{}, "tail -n 2 result-6260.err",
+			jobErr.Error() + ", last lines of output file result-6260.err:\nline 9\nline 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmd string
+			sshClient := &sshutil.MockSSHClient{
+				MockRunCommand: func(input string) (string, error) {
+					cmd = input
+					return "line 9\nline 10\n", nil
+				},
+			}
+			o := &actionOperator{}
+			err := o.addJobOutputTail(jobErr, sshClient, "6260", &prov.Action{Data: tt.data}, tt.info)
+			assert.Equal(t, tt.wantCmd, cmd)
+			assert.Error(t, err, tt.want)
+		})
+	}
+}
+
+func Test_readOutputFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     map[string]string
+		wantCmds []string
+		want     map[string]string
+		wantErr  string
+	}{
+		{"NoOutputFiles", map[string]string{}, nil, nil, ""},
+		{"TextOutputs", map[string]string{"outputFiles": `{"result":"out/result.txt","log":"/tmp/job.log"}`, "outputFilesEncoding": "text"},
+			[]string{"test -f '/tmp/job.log'", "cat '/tmp/job.log'", "test -f ~/work/'out/result.txt'", "cat ~/work/'out/result.txt'"},
+			map[string]string{"result": "content of ~/work/'out/result.txt'\n", "log": "content of '/tmp/job.log'\n"}, ""},
+		{"BinaryOutput", map[string]string{"outputFiles": `{"image":"plot.png"}`, "outputFilesEncoding": "base64"},
+			[]string{"test -f ~/work/'plot.png'", "base64 -w 0 ~/work/'plot.png'"},
+			map[string]string{"image": "content of ~/work/'plot.png'"}, ""},
+		{"MissingOutputs", map[string]string{"outputFiles": `{"result":"result.txt","missing1":"missing1.txt","missing2":"missing2.txt"}`},
+			[]string{"test -f ~/work/'missing1.txt'", "test -f ~/work/'missing2.txt'", "test -f ~/work/'result.txt'", "cat ~/work/'result.txt'"},
+			nil, "output files not found for outputs: missing1 (missing1.txt), missing2 (missing2.txt)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmds []string
+			sshClient := &sshutil.MockSSHClient{
+				MockRunCommand: func(input string) (string, error) {
+					cmds = append(cmds, input)
+					if strings.HasPrefix(input, "test -f ~/work/'missing") {
+						return "", errors.New("exit status 1")
+					}
+					return fmt.Sprintf("content of %s\n", input[strings.LastIndex(input, " ")+1:]), nil
+				},
+			}
+			got, err := readOutputFiles(sshClient, "~/work", tt.data)
+			assert.DeepEqual(t, tt.wantCmds, cmds)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.want, got)
+		})
+	}
+}
+
+func Test_actionOperator_cleanupJob(t *testing.T) {
+	actionData := &actionData{jobID: "6260", workingDir: "~/work", artifacts: []string{"b-1.batch"}}
+	tests := []struct {
+		name          string
+		data          map[string]string
+		info          map[string]string
+		succeeded     bool
+		keepArtifacts bool
+		wantCmds      []string
+	}{
+		{"NoPolicySuccess", map[string]string{}, map[string]string{}, true, false, []string{"rm -rf ~/work/b-1.batch"}},
+		{"NoPolicyFailure", map[string]string{}, map[string]string{}, false, false, nil},
+		{"NoPolicyKeepArtifacts", map[string]string{}, map[string]string{}, true, true, nil},
+		{"NeverSuccess", map[string]string{"cleanupPolicy": "never"}, map[string]string{}, true, false, nil},
+		{"OnSuccessFailure", map[string]string{"cleanupPolicy": "on-success"}, map[string]string{}, false, false, nil},
+		{"OnSuccessSuccess", map[string]string{"cleanupPolicy": "on-success"}, map[string]string{}, true, true,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f ~/work/slurm-6260.out", "rmdir ~/work"}},
+		{"AlwaysFailure", map[string]string{"cleanupPolicy": "always", "StdOut": "/tmp/out.txt", "StdErr": "/tmp/err.txt"}, map[string]string{}, false, false,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f /tmp/out.txt /tmp/err.txt", "rmdir ~/work"}},
+		{"AlwaysJobArray", map[string]string{"cleanupPolicy": "always"}, map[string]string{"ArrayTaskIds": "1,2"}, true, false,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f ~/work/slurm-6260_*.out", "rmdir ~/work"}},
+		{"AlwaysSeparateErrorOutput", map[string]string{"cleanupPolicy": "always", "separateErrorOutput": "true"}, map[string]string{}, true, false,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f ~/work/slurm-6260.out ~/work/slurm-6260.err", "rmdir ~/work"}},
+		{"AlwaysJobArraySeparateErrorOutput", map[string]string{"cleanupPolicy": "always", "separateErrorOutput": "true"}, map[string]string{"ArrayTaskIds": "1,2"}, true, false,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f ~/work/slurm-6260_*.out ~/work/slurm-6260_*.err", "rmdir ~/work"}},
+		{"AlwaysTemplatedJobArray", map[string]string{"cleanupPolicy": "always", "outputFileName": "result-%A-%a.log"}, map[string]string{"ArrayTaskIds": "1,2"}, true, false,
+			[]string{"rm -rf ~/work/b-1.batch", "rm -f ~/work/result-6260-*.log", "rmdir ~/work"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmds []string
+			sshClient := &sshutil.MockSSHClient{
+				MockRunCommand: func(input string) (string, error) {
+					cmds = append(cmds, input)
+					if strings.HasPrefix(input, "rmdir") {
+						// Cleanup failures are ignored
+						return "rmdir: failed to remove '~/work': Directory not empty", errors.New("exit status 1")
+					}
+					return "", nil
+				},
+			}
+			o := &actionOperator{}
+			o.cleanupJob(actionData, &prov.Action{Data: tt.data}, tt.info, tt.succeeded, tt.keepArtifacts, sshClient)
+			assert.DeepEqual(t, tt.wantCmds, cmds)
+		})
+	}
+}
+
 func Test_getMonitoringJobActionData(t *testing.T) {
 	type args struct {
 		action *prov.Action
@@ -172,3 +493,40 @@ func Test_getMonitoringJobActionData(t *testing.T) {
 		})
 	}
 }
+
+func Test_isMonitoringCheckDue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		data map[string]string
+		want bool
+	}{
+		{"NotAdaptive", map[string]string{}, true},
+		{"NotDue", map[string]string{"nextMonitoringCheck": now.Add(time.Minute).Format(time.RFC3339Nano)}, false},
+		{"Due", map[string]string{"nextMonitoringCheck": now.Add(-time.Second).Format(time.RFC3339Nano)}, true},
+		{"InvalidDate", map[string]string{"nextMonitoringCheck": "not a date"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isMonitoringCheckDue(tt.data, now))
+		})
+	}
+}
+
+func Test_isJobCheckDue(t *testing.T) {
+	now := time.Now()
+	action := &prov.Action{ID: "resumed-action", Data: map[string]string{"nextMonitoringCheck": now.Add(time.Minute).Format(time.RFC3339Nano)}}
+	// The first check after a restart is done even if the next check is not due yet
+	assert.Equal(t, true, isJobCheckDue(action, now))
+	assert.Equal(t, false, isJobCheckDue(action, now))
+	assert.Equal(t, true, isJobCheckDue(action, now.Add(2*time.Minute)))
+	checkedActions.Delete(action.ID)
+	assert.Equal(t, true, isJobCheckDue(action, now))
+	checkedActions.Delete(action.ID)
+}
+
+func Test_nextMonitoringInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, nextMonitoringInterval(5*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextMonitoringInterval(40*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextMonitoringInterval(time.Minute, time.Minute))
+}
@@ -37,21 +37,58 @@ type nodeAllocation struct {
 	reservation  string
 }
 
+// An additional component of a heterogeneous job, with its own resources and command
+type hetComponent struct {
+	Nodes        int      `json:"nodes,omitempty" mapstructure:"nodes"`
+	Tasks        int      `json:"tasks,omitempty" mapstructure:"tasks"`
+	TasksPerNode int      `json:"tasks_per_node,omitempty" mapstructure:"ntasks_per_node"`
+	Cpus         int      `json:"cpus,omitempty" mapstructure:"cpus_per_task"`
+	Mem          string   `json:"mem,omitempty" mapstructure:"memory"`
+	Gres         string   `json:"gres,omitempty" mapstructure:"gres"`
+	Partition    string   `json:"partition,omitempty" mapstructure:"partition"`
+	GPU          string   `json:"gpu,omitempty" mapstructure:"gpu"`
+	Command      string   `json:"command,omitempty" mapstructure:"command"`
+	Args         []string `json:"args,omitempty" mapstructure:"args"`
+}
+
 type jobInfo struct {
-	ID                     string                      `json:"id,omitempty"`
-	Name                   string                      `json:"name,omitempty"`
-	Tasks                  int                         `json:"tasks,omitempty"`
-	Cpus                   int                         `json:"cpus,omitempty"`
-	Nodes                  int                         `json:"nodes,omitempty"`
-	Mem                    string                      `json:"mem,omitempty"`
-	MaxTime                string                      `json:"max_time,omitempty"`
-	Opts                   []string                    `json:"opts,omitempty"`
-	ExecutionOptions       types.SlurmExecutionOptions `json:"execution_options,omitempty"`
-	Inputs                 map[string]string           `json:"inputs,omitempty"`
-	MonitoringTimeInterval time.Duration               `json:"monitoring_time_interval,omitempty"`
-	Account                string                      `json:"account,omitempty"`
-	Reservation            string                      `json:"reservation,omitempty"`
-	WorkingDir             string                      `json:"working_directory,omitempty"`
-	Artifacts              []string                    `json:"artifacts,omitempty"`
-	EnvFile                string                      `json:"env_file,omitempty"`
+	ID                        string                      `json:"id,omitempty"`
+	Name                      string                      `json:"name,omitempty"`
+	Tasks                     int                         `json:"tasks,omitempty"`
+	TasksPerNode              int                         `json:"tasks_per_node,omitempty"`
+	Cpus                      int                         `json:"cpus,omitempty"`
+	Nodes                     int                         `json:"nodes,omitempty"`
+	Mem                       string                      `json:"mem,omitempty"`
+	MaxTime                   string                      `json:"max_time,omitempty"`
+	Opts                      []string                    `json:"opts,omitempty"`
+	ExecutionOptions          types.SlurmExecutionOptions `json:"execution_options,omitempty"`
+	Inputs                    map[string]string           `json:"inputs,omitempty"`
+	MonitoringTimeInterval    time.Duration               `json:"monitoring_time_interval,omitempty"`
+	MonitoringMaxTimeInterval time.Duration               `json:"monitoring_max_time_interval,omitempty"`
+	PendingTimeout            time.Duration               `json:"pending_timeout,omitempty"`
+	Partition                 string                      `json:"partition,omitempty"`
+	QOS                       string                      `json:"qos,omitempty"`
+	Account                   string                      `json:"account,omitempty"`
+	Reservation               string                      `json:"reservation,omitempty"`
+	Constraint                string                      `json:"constraint,omitempty"`
+	Dependency                string                      `json:"dependency,omitempty"`
+	Gres                      string                      `json:"gres,omitempty"`
+	Array                     string                      `json:"array,omitempty"`
+	WorkingDir                string                      `json:"working_directory,omitempty"`
+	Artifacts                 []string                    `json:"artifacts,omitempty"`
+	EnvFile                   string                      `json:"env_file,omitempty"`
+	ScriptDirectives          bool                        `json:"script_directives,omitempty"`
+	KeepScript                bool                        `json:"keep_script,omitempty"`
+	Modules                   []string                    `json:"modules,omitempty"`
+	ErrorOutputLines          int                         `json:"error_output_lines,omitempty"`
+	CleanupPolicy             string                      `json:"cleanup_policy,omitempty"`
+	DryRun                    bool                        `json:"dry_run,omitempty"`
+	Blocking                  bool                        `json:"blocking,omitempty"`
+	Requeue                   bool                        `json:"requeue,omitempty"`
+	Signal                    string                      `json:"signal,omitempty"`
+	SeparateErrorOutput       bool                        `json:"separate_error_output,omitempty"`
+	OutputFileName            string                      `json:"output_file_name,omitempty"`
+	HetComponents             []hetComponent              `json:"het_components,omitempty"`
+	OutputFiles               map[string]string           `json:"output_files,omitempty"`
+	OutputFilesEncoding       string                      `json:"output_files_encoding,omitempty"`
 }
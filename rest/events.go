@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -109,9 +110,19 @@ func (s *Server) pollLogs(w http.ResponseWriter, r *http.Request) {
 
 	var logs []json.RawMessage
 	var lastIdx uint64
+	var idx uint64
 
 	// If id parameter not set (id == ""), LogsEvents returns logs for all the deployments
-	logs, idx, err := events.LogsEvents(ctx, id, waitIndex, timeout)
+	if lvl := values.Get("level"); lvl != "" {
+		var minLevel events.LogLevel
+		if minLevel, err = events.ParseLogLevel(strings.ToUpper(lvl)); err != nil {
+			writeError(w, r, newBadRequestParameter("level", err))
+			return
+		}
+		logs, idx, err = events.LogsEventsFromLevel(ctx, id, minLevel, waitIndex, timeout)
+	} else {
+		logs, idx, err = events.LogsEvents(ctx, id, waitIndex, timeout)
+	}
 	if err != nil {
 		log.Panicf("Can't retrieve logs: %v", err)
 	}
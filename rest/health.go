@@ -14,9 +14,18 @@
 
 package rest
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/ystia/yorc/v4/storage"
+)
 
 func (s *Server) getHealthHandler(w http.ResponseWriter, r *http.Request) {
-	health := Health{"passing"}
+	health := Health{Value: "passing", Stores: storage.CheckStoresHealth(r.Context())}
+	for _, storeHealth := range health.Stores {
+		if !storeHealth.Reachable || storeHealth.Status == "red" {
+			health.Value = "warning"
+		}
+	}
 	encodeJSONResponse(w, r, health)
 }
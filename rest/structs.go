@@ -24,6 +24,7 @@ import (
 	"github.com/ystia/yorc/v4/deployments/store"
 	"github.com/ystia/yorc/v4/prov/hostspool"
 	"github.com/ystia/yorc/v4/registry"
+	storagestore "github.com/ystia/yorc/v4/storage/store"
 	"github.com/ystia/yorc/v4/tosca"
 )
 
@@ -79,6 +80,8 @@ func newAtomLink(rel, href string) AtomLink {
 // Health of a Yorc instance
 type Health struct {
 	Value string `json:"value"`
+	// Stores holds the health of the stores relying on an external service (Elasticsearch for instance) indexed by store type
+	Stores map[string]storagestore.HealthStatus `json:"stores,omitempty"`
 }
 
 // LocationRequest represents a request for creating or updating a location
@@ -122,6 +122,8 @@ func RunServer(configuration config.Configuration, shutdownCh chan struct{}) err
 	if err != nil {
 		return err
 	}
+	// Stores may buffer data, so they are closed once everything else is stopped
+	defer storage.CloseStores()
 
 	err = initLocationManager(configuration)
 	if err != nil {
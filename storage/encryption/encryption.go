@@ -68,6 +68,9 @@ func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
 // We need to retrieve the nonce defined as the encrypted data prefix
 func (e *Encryptor) Decrypt(data []byte) ([]byte, error) {
 	nonceSize := e.gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.Errorf("failed to decrypt data from cipher GCM: data is too short")
+	}
 	nonce, encrypted := data[:nonceSize], data[nonceSize:]
 	decrypted, err := e.gcm.Open(nil, nonce, encrypted, nil)
 	if err != nil {
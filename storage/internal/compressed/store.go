@@ -0,0 +1,159 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compressed provides a store decorator compressing values before storing them into another store.
+package compressed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/storage/internal/transformed"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// Name of the field holding the compressed value in stored documents
+const compressedField = "_compressed"
+
+// Header bytes prefixing compressed data to identify the codec used to compress it
+const (
+	gzipHeader byte = 1
+	zstdHeader byte = 2
+)
+
+// Codec is the name of a compression codec
+type Codec string
+
+const (
+	// Gzip compression, widely supported
+	Gzip Codec = "gzip"
+	// Zstd compression, faster with a better compression ratio than gzip
+	Zstd Codec = "zstd"
+)
+
+// StoreOptions defines how values are compressed
+type StoreOptions struct {
+	// Codec used to compress new values
+	Codec Codec
+	// MinSize is the size in bytes under which values are stored uncompressed
+	MinSize int
+	// ClearFields are the top-level fields of values that are stored uncompressed along with the compressed value,
+	// so that they can still be queried (ie. deploymentId for logs and events)
+	ClearFields []string
+}
+
+type compressedStore struct {
+	*transformed.Store
+	minSize int
+	header  byte
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewStore returns a store compressing values before storing them into the given store.
+// Values are decompressed on read whatever the codec used to compress them, values that were stored uncompressed remain readable.
+func NewStore(underlying store.Store, options StoreOptions) (store.Store, error) {
+	s := &compressedStore{minSize: options.MinSize}
+	switch options.Codec {
+	case Gzip:
+		s.header = gzipHeader
+	case Zstd:
+		s.header = zstdHeader
+	default:
+		return nil, errors.Errorf("unsupported compression codec %q, expecting %q or %q", options.Codec, Gzip, Zstd)
+	}
+	var err error
+	if options.Codec == Zstd {
+		s.encoder, err = zstd.NewWriter(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd encoder")
+		}
+	}
+	// Values compressed with any codec should be readable
+	s.decoder, err = zstd.NewReader(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd decoder")
+	}
+	s.Store = transformed.NewStore(underlying, transformed.Transform{
+		Name:        "compressed",
+		Field:       compressedField,
+		Encode:      s.compress,
+		Decode:      s.decompress,
+		ClearFields: options.ClearFields,
+	})
+	return s, nil
+}
+
+// compress returns the compressed value of the given data, or nil if it is too small to be compressed
+func (s *compressedStore) compress(data []byte) (interface{}, error) {
+	if len(data) < s.minSize {
+		return nil, nil
+	}
+	return s.compressBytes(data)
+}
+
+// compressBytes compresses data with the configured codec, the result is prefixed by the codec header
+func (s *compressedStore) compressBytes(data []byte) ([]byte, error) {
+	switch s.header {
+	case zstdHeader:
+		return s.encoder.EncodeAll(data, []byte{zstdHeader}), nil
+	default:
+		var b bytes.Buffer
+		b.WriteByte(gzipHeader)
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to gzip value")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to gzip value")
+		}
+		return b.Bytes(), nil
+	}
+}
+
+// decompress returns the decompressed value of the compressed field of a stored document
+func (s *compressedStore) decompress(field json.RawMessage) ([]byte, error) {
+	var data []byte
+	if err := json.Unmarshal(field, &data); err != nil {
+		return nil, errors.Wrap(err, "invalid compressed value")
+	}
+	return s.decompressBytes(data)
+}
+
+// decompressBytes decompresses data prefixed by the header of the codec used to compress it
+func (s *compressedStore) decompressBytes(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty compressed value")
+	}
+	switch data[0] {
+	case gzipHeader:
+		r, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to gunzip value")
+		}
+		defer r.Close()
+		data, err = ioutil.ReadAll(r)
+		return data, errors.Wrap(err, "failed to gunzip value")
+	case zstdHeader:
+		data, err := s.decoder.DecodeAll(data[1:], nil)
+		return data, errors.Wrap(err, "failed to decompress zstd value")
+	default:
+		return nil, errors.Errorf("unknown compression codec header %d", data[0])
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compressed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ystia/yorc/v4/storage/internal/transformed"
+	"github.com/ystia/yorc/v4/storage/store"
+	"github.com/ystia/yorc/v4/tosca"
+)
+
+func TestCompressedStore(t *testing.T) {
+	for _, codec := range []Codec{Gzip, Zstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			transformed.CommonTransformedStoreTest(t, compressedField, func(underlying store.Store) (store.Store, error) {
+				return NewStore(underlying, StoreOptions{Codec: codec})
+			})
+		})
+	}
+}
+
+func TestNewStoreUnsupportedCodec(t *testing.T) {
+	_, err := NewStore(nil, StoreOptions{Codec: "lz4"})
+	assert.Error(t, err)
+}
+
+func TestCompressedStoreMixedValues(t *testing.T) {
+	cfg := store.SetupTestConfig(t)
+	defer os.RemoveAll(cfg.WorkingDirectory)
+	ctx := context.Background()
+	underlying := transformed.NewTestFileStore(t, cfg)
+
+	content := strings.Repeat("some log content ", 100)
+	require.NoError(t, underlying.Set(ctx, "values/legacy", map[string]interface{}{"content": content}))
+	gzipStore, err := NewStore(underlying, StoreOptions{Codec: Gzip})
+	require.NoError(t, err)
+	require.NoError(t, gzipStore.Set(ctx, "values/gzip", map[string]interface{}{"content": content}))
+	zstdStore, err := NewStore(underlying, StoreOptions{Codec: Zstd, MinSize: 1024})
+	require.NoError(t, err)
+	require.NoError(t, zstdStore.Set(ctx, "values/zstd", map[string]interface{}{"content": content}))
+	require.NoError(t, zstdStore.Set(ctx, "values/small", map[string]interface{}{"content": "small"}))
+
+	// Values smaller than the min size are stored uncompressed
+	var small map[string]interface{}
+	found, err := underlying.Get("values/small", &small)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, map[string]interface{}{"content": "small"}, small)
+
+	// Whatever the configured codec, all values are readable
+	for _, s := range []store.Store{gzipStore, zstdStore} {
+		for _, k := range []string{"values/legacy", "values/gzip", "values/zstd"} {
+			var value map[string]interface{}
+			found, err = s.Get(k, &value)
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, content, value["content"])
+		}
+		kvs, _, err := s.List(ctx, "values", 0, 0)
+		require.NoError(t, err)
+		require.Len(t, kvs, 4)
+		for _, kv := range kvs {
+			assert.Contains(t, kv.Value, "content")
+		}
+	}
+}
+
+// Returns representative payloads: a TOSCA topology and a bulk of logs
+func benchmarkPayloads(b *testing.B) map[string]interface{} {
+	data, err := ioutil.ReadFile("../../../data/tosca/normative-types.yml")
+	require.NoError(b, err)
+	var topology tosca.Topology
+	require.NoError(b, yaml.Unmarshal(data, &topology))
+
+	logs := make([]map[string]interface{}, 100)
+	start := time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC)
+	for i := range logs {
+		logs[i] = map[string]interface{}{
+			"deploymentId": "MyApp",
+			"level":        "INFO",
+			"nodeId":       "Compute",
+			"instanceId":   "0",
+			"timestamp":    start.Add(time.Duration(i) * time.Millisecond).Format(time.RFC3339Nano),
+			"content":      fmt.Sprintf("Ansible playbook execution step %d: ok=%d changed=%d unreachable=0 failed=0", i, i%7, i%3),
+		}
+	}
+	return map[string]interface{}{"topology": topology, "logs": logs}
+}
+
+func BenchmarkCompression(b *testing.B) {
+	for name, payload := range benchmarkPayloads(b) {
+		raw, err := json.Marshal(payload)
+		require.NoError(b, err)
+		for _, codec := range []Codec{Gzip, Zstd} {
+			s, err := NewStore(nil, StoreOptions{Codec: codec})
+			require.NoError(b, err)
+			cs := s.(*compressedStore)
+			b.Run(name+"/"+string(codec)+"/compress", func(b *testing.B) {
+				var compressed []byte
+				b.SetBytes(int64(len(raw)))
+				for i := 0; i < b.N; i++ {
+					compressed, err = cs.compressBytes(raw)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(raw)), "raw-bytes")
+				b.ReportMetric(float64(len(compressed)), "compressed-bytes")
+			})
+			compressed, err := cs.compressBytes(raw)
+			require.NoError(b, err)
+			b.Run(name+"/"+string(codec)+"/decompress", func(b *testing.B) {
+				b.SetBytes(int64(len(raw)))
+				for i := 0; i < b.N; i++ {
+					if _, err := cs.decompressBytes(compressed); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -17,7 +17,10 @@ package consul
 import (
 	"github.com/hashicorp/consul/sdk/testutil"
 	"os"
+	"path"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/ystia/yorc/v4/storage/encoding"
 	"github.com/ystia/yorc/v4/storage/store"
@@ -39,6 +42,12 @@ func TestRunConsulStoragePackageTests(t *testing.T) {
 		t.Run("testConsulStore", func(t *testing.T) {
 			testStore(t, srv)
 		})
+		t.Run("testConsulStoreExistAndGetBatch", func(t *testing.T) {
+			testExistAndGetBatch(t, srv)
+		})
+		t.Run("testConsulStoreLastIndex", func(t *testing.T) {
+			testLastIndex(t, srv)
+		})
 	})
 }
 
@@ -47,6 +56,19 @@ func testStore(t *testing.T, srv1 *testutil.TestServer) {
 	store.CommonStoreTest(t, csStore)
 }
 
+func testExistAndGetBatch(t *testing.T, srv1 *testutil.TestServer) {
+	csStore := &consulStore{encoding.JSON}
+	store.CommonStoreTestExistAndGetBatch(t, csStore)
+}
+
+func testLastIndex(t *testing.T, srv1 *testutil.TestServer) {
+	csStore := &consulStore{encoding.JSON}
+	rootKey := "lastIndex/" + t.Name()
+	store.CommonStoreTestLastIndex(t, csStore, rootKey, func() string {
+		return path.Join(rootKey, strconv.FormatInt(time.Now().UnixNano(), 10))
+	})
+}
+
 func testTypes(t *testing.T, srv1 *testutil.TestServer) {
 	csStore := &consulStore{encoding.JSON}
 	store.CommonStoreTestAllTypes(t, csStore)
@@ -80,7 +80,7 @@ func (c *consulStore) Get(k string, v interface{}) (bool, error) {
 	return true, errors.Wrapf(c.codec.Unmarshal(value, v), "failed to unmarshal data:%q", string(value))
 }
 
-func (c *consulStore) Exist(k string) (bool, error) {
+func (c *consulStore) Exist(ctx context.Context, k string) (bool, error) {
 	if err := utils.CheckKey(k); err != nil {
 		return false, err
 	}
@@ -92,6 +92,33 @@ func (c *consulStore) Exist(k string) (bool, error) {
 	return found, nil
 }
 
+func (c *consulStore) GetBatch(ctx context.Context, keys []string) (map[string]store.KeyValueOut, error) {
+	values := make(map[string]store.KeyValueOut, len(keys))
+	for _, k := range keys {
+		if err := utils.CheckKey(k); err != nil {
+			return nil, err
+		}
+		kvp, _, err := consulutil.GetKV().Get(k, (&api.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get value for key:%q", k)
+		}
+		if kvp == nil {
+			continue
+		}
+		var value map[string]interface{}
+		if err := c.codec.Unmarshal(kvp.Value, &value); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal stored value: %q", string(kvp.Value))
+		}
+		values[k] = store.KeyValueOut{
+			Key:             k,
+			LastModifyIndex: kvp.ModifyIndex,
+			Value:           value,
+			RawValue:        kvp.Value,
+		}
+	}
+	return values, nil
+}
+
 func (c *consulStore) Keys(k string) ([]string, error) {
 	return consulutil.GetKeys(k)
 }
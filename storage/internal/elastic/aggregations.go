@@ -0,0 +1,140 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// The fields logs and events can be aggregated by, only indexed fields can be aggregated
+var aggregationFields = map[string]bool{
+	"level":        true,
+	"deploymentId": true,
+	"nodeId":       true,
+}
+
+// The number of field values returned by default
+const defaultAggregationSize = 10
+
+// aggregationResponse decodes the buckets computed by an aggregation query (see esQuery.aggregate)
+type aggregationResponse struct {
+	Aggregations struct {
+		ByField *termsAggregation     `json:"by_field"`
+		ByTime  *histogramAggregation `json:"by_time"`
+	} `json:"aggregations"`
+}
+
+type termsAggregation struct {
+	Buckets []termsBucket `json:"buckets"`
+}
+
+type termsBucket struct {
+	Key      string                `json:"key"`
+	DocCount int                   `json:"doc_count"`
+	ByTime   *histogramAggregation `json:"by_time"`
+}
+
+type histogramAggregation struct {
+	Buckets []histogramBucket `json:"buckets"`
+}
+
+type histogramBucket struct {
+	// ES returns histogram keys as float, we have a precision loss of few ns
+	Key      float64 `json:"key"`
+	DocCount int     `json:"doc_count"`
+}
+
+// Returns the buckets of the response, the start of time buckets is rounded to the given interval to fix ES precision loss.
+func (r aggregationResponse) buckets(interval time.Duration) []store.AggregationBucket {
+	buckets := make([]store.AggregationBucket, 0)
+	appendTimeBuckets := func(key string, h *histogramAggregation) {
+		for _, b := range h.Buckets {
+			start := int64(math.Round(b.Key/float64(interval))) * int64(interval)
+			buckets = append(buckets, store.AggregationBucket{Key: key, Time: time.Unix(0, start).UTC(), Count: b.DocCount})
+		}
+	}
+	if r.Aggregations.ByField == nil {
+		if r.Aggregations.ByTime != nil {
+			appendTimeBuckets("", r.Aggregations.ByTime)
+		}
+		return buckets
+	}
+	for _, b := range r.Aggregations.ByField.Buckets {
+		if b.ByTime != nil {
+			appendTimeBuckets(b.Key, b.ByTime)
+		} else {
+			buckets = append(buckets, store.AggregationBucket{Key: b.Key, Count: b.DocCount})
+		}
+	}
+	return buckets
+}
+
+// Aggregate counts the logs or events stored under the given key (ie. "_yorc/events" or "_yorc/logs/MyApp")
+// grouped by level, deploymentId or nodeId and/or by time buckets, using ES terms and histogram aggregations.
+// The documents are not returned.
+func (s *elasticStore) Aggregate(ctx context.Context, k string, request store.AggregationRequest) ([]store.AggregationBucket, error) {
+	if err := s.checkInitialized(); err != nil {
+		return nil, err
+	}
+	if request.Field == "" && request.Interval <= 0 {
+		return nil, errors.New("Invalid aggregation request, a field or an interval should be set")
+	}
+	if request.Field != "" && !aggregationFields[request.Field] {
+		return nil, errors.Errorf("Invalid aggregation field %q, expecting level, deploymentId or nodeId", request.Field)
+	}
+	size := request.Size
+	if size <= 0 {
+		size = defaultAggregationSize
+	}
+	size, err := checkQuerySizeAndOrder(s.cfg, size, "asc")
+	if err != nil {
+		return nil, err
+	}
+
+	storeType, deploymentID := extractStoreTypeAndDeploymentID(k)
+	indexName := getReadIndexName(s.cfg, storeType)
+	query := newESQuery().deployment(deploymentID).timeRange(request.From, request.To).aggregate(request.Field, size, request.Interval).String()
+	log.Debugf("Aggregation query on index %s is: %s", indexName, query)
+
+	req := esapi.SearchRequest{
+		Index: []string{indexName},
+		Body:  strings.NewReader(query),
+	}
+	if routing := getDeploymentRouting(s.cfg, deploymentID); routing != "" {
+		req.Routing = []string{routing}
+	}
+	ctx, cancel := withRequestTimeout(ctx, s.cfg)
+	defer cancel()
+	res, err := req.Do(ctx, s.esClient)
+	defer closeResponseBody("AggregationQuery for "+k, res)
+	if err = handleESResponseError(res, "AggregationQuery for "+k, query, err); err != nil {
+		return nil, err
+	}
+	var r aggregationResponse
+	if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, errors.Wrapf(err, "Not able to parse response body after AggregationQuery was sent for key %s, query was: %s", k, query)
+	}
+	return r.buckets(request.Interval), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func TestElasticStoreAggregate(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		request     store.AggregationRequest
+		response    string
+		wantQuery   string
+		wantBuckets []store.AggregationBucket
+		wantErr     bool
+	}{
+		{"ByLevel", store.AggregationRequest{Field: "level"},
+			`{"aggregations":{"by_field":{"buckets":[{"key":"INFO","doc_count":12},{"key":"ERROR","doc_count":2}]}}}`,
+			`{"aggs":{"by_field":{"terms":{"field":"level","size":10}}},"query":{"term":{"deploymentId":"MyApp"}},"size":0}`,
+			[]store.AggregationBucket{{Key: "INFO", Count: 12}, {Key: "ERROR", Count: 2}}, false},
+		// ES returns histogram keys as float, the precision loss is fixed
+		{"ByTime", store.AggregationRequest{Interval: time.Minute},
+			`{"aggregations":{"by_time":{"buckets":[{"key":1.7041032e+18,"doc_count":3},{"key":1.70410326e+18,"doc_count":1}]}}}`,
+			`{"aggs":{"by_time":{"histogram":{"field":"iid","interval":60000000000,"min_doc_count":1}}},"query":{"term":{"deploymentId":"MyApp"}},"size":0}`,
+			[]store.AggregationBucket{{Time: minute, Count: 3}, {Time: minute.Add(time.Minute), Count: 1}}, false},
+		{"ByNodeAndTime", store.AggregationRequest{Field: "nodeId", Size: 2, Interval: time.Minute, From: minute},
+			`{"aggregations":{"by_field":{"buckets":[{"key":"Compute","doc_count":3,"by_time":{"buckets":[{"key":1.7041032e+18,"doc_count":3}]}}]}}}`,
+			`{"aggs":{"by_field":{"aggs":{"by_time":{"histogram":{"field":"iid","interval":60000000000,"min_doc_count":1}}},"terms":{"field":"nodeId","size":2}}},` +
+				`"query":{"bool":{"must":[{"term":{"deploymentId":"MyApp"}},{"range":{"iid":{"gte":"1704103200000000000"}}}]}},"size":0}`,
+			[]store.AggregationBucket{{Key: "Compute", Time: minute, Count: 3}}, false},
+		{"NoBuckets", store.AggregationRequest{Field: "level"}, `{"aggregations":{"by_field":{"buckets":[]}}}`,
+			`{"aggs":{"by_field":{"terms":{"field":"level","size":10}}},"query":{"term":{"deploymentId":"MyApp"}},"size":0}`,
+			[]store.AggregationBucket{}, false},
+		{"NotIndexedField", store.AggregationRequest{Field: "content"}, ``, ``, nil, true},
+		{"NothingToAggregate", store.AggregationRequest{}, ``, ``, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				query = string(body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7},
+				cfg: elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", MaxQuerySize: 1000, RequestTimeout: time.Second}}
+
+			buckets, err := s.Aggregate(context.Background(), "_yorc/logs/MyApp", tt.request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, query)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQuery, query)
+			assert.Equal(t, tt.wantBuckets, buckets)
+		})
+	}
+}
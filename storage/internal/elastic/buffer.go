@@ -0,0 +1,231 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// bulkBuffer accumulates documents and sends them using bulk requests when either the max number of documents,
+// the max size or the max linger duration is reached.
+type bulkBuffer struct {
+	c   *esClient
+	cfg elasticStoreConf
+	// Keeps the documents that ES fails to index, may be nil
+	deadLetter *deadLetterSink
+	// Protects the fields below
+	mu     sync.Mutex
+	body   []byte
+	count  int
+	timer  *time.Timer
+	closed bool
+	// The size of the buffered documents and of the documents being sent
+	pending int
+	// Set when pending exceeds the high watermark, until it goes below the low watermark
+	throttled bool
+	// Closed when writes are not throttled anymore
+	relieved chan struct{}
+	// Ensures bulk requests are sent in order
+	flushMu sync.Mutex
+}
+
+func newBulkBuffer(c *esClient, cfg elasticStoreConf, deadLetter *deadLetterSink) *bulkBuffer {
+	log.Printf("\t- Logs and events will be buffered and sent using bulk requests of at most %d documents and %d kB, buffered documents will be sent after %v",
+		cfg.BufferMaxDocuments, cfg.BufferMaxSize, cfg.BufferMaxLinger)
+	if cfg.BufferHighWatermark > 0 {
+		log.Printf("\t- Writes will be throttled while more than %d kB of documents are waiting to be sent, until less than %d kB are waiting",
+			cfg.BufferHighWatermark, cfg.BufferLowWatermark)
+	}
+	// The max bulk size is used by eventuallyAppendValueToBulkRequest
+	cfg.maxBulkSize = cfg.BufferMaxSize
+	return &bulkBuffer{c: c, cfg: cfg, deadLetter: deadLetter}
+}
+
+// add appends a document to the buffer, the buffer is flushed if it is full.
+// While writes are throttled, add waits for the buffer to drain and fails with store.ErrBusy after the backpressure timeout.
+func (b *bulkBuffer) add(ctx context.Context, kv store.KeyValueIn) error {
+	if err := b.waitForRelief(ctx, kv.Key); err != nil {
+		return err
+	}
+	maxSizeInBytes := b.cfg.BufferMaxSize * 1024
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errors.Errorf("Not able to store %s, the elastic store is closed", kv.Key)
+	}
+	added, err := b.append(kv, maxSizeInBytes)
+	if err != nil {
+		b.mu.Unlock()
+		if errors.Is(err, errDocumentDropped) {
+			return nil
+		}
+		return err
+	}
+	if !added {
+		// The buffer is full, send it and add the document to the new one
+		body, count := b.swap()
+		b.mu.Unlock()
+		b.send(ctx, body, count)
+		b.mu.Lock()
+		if _, err = b.append(kv, maxSizeInBytes); err != nil {
+			b.mu.Unlock()
+			return err
+		}
+	}
+	b.count++
+	if b.count == 1 {
+		b.timer = time.AfterFunc(b.cfg.BufferMaxLinger, func() { b.flush(context.Background()) })
+	}
+	emitBufferDepthMetric(b.count)
+	if b.count < b.cfg.BufferMaxDocuments {
+		b.mu.Unlock()
+		return nil
+	}
+	body, count := b.swap()
+	b.mu.Unlock()
+	b.send(ctx, body, count)
+	return nil
+}
+
+// append adds a document to the buffered bulk request and accounts for its size, b.mu should be held.
+func (b *bulkBuffer) append(kv store.KeyValueIn, maxSizeInBytes int) (bool, error) {
+	before := len(b.body)
+	added, err := eventuallyAppendValueToBulkRequest(b.cfg, b.c, b.deadLetter, &b.body, kv, maxSizeInBytes)
+	b.updatePending(len(b.body) - before)
+	return added, err
+}
+
+// updatePending accounts for documents added to or sent from the buffer and updates the throttling state, b.mu should be held.
+func (b *bulkBuffer) updatePending(delta int) {
+	if delta == 0 {
+		return
+	}
+	b.pending += delta
+	if b.cfg.BufferHighWatermark > 0 {
+		switch {
+		case !b.throttled && b.pending >= b.cfg.BufferHighWatermark*1024:
+			log.Printf("[WARN] %d kB of logs and events are waiting to be sent to ES, writes are throttled", b.pending/1024)
+			b.throttled = true
+			b.relieved = make(chan struct{})
+		case b.throttled && b.pending <= b.cfg.BufferLowWatermark*1024:
+			log.Printf("Logs and events buffer has drained, writes are not throttled anymore")
+			b.throttled = false
+			close(b.relieved)
+		}
+	}
+	emitBufferPressureMetrics(b.pending, b.throttled)
+}
+
+// waitForRelief waits until writes are not throttled, store.ErrBusy is returned if it lasts more than the backpressure timeout.
+func (b *bulkBuffer) waitForRelief(ctx context.Context, k string) error {
+	var timer *time.Timer
+	for {
+		b.mu.Lock()
+		throttled, relieved := b.throttled, b.relieved
+		b.mu.Unlock()
+		if !throttled {
+			return nil
+		}
+		if timer == nil {
+			if b.cfg.BufferBackpressureTimeout <= 0 {
+				return errors.Wrapf(store.ErrBusy, "Not able to store %s, too many logs and events are waiting to be sent to ES", k)
+			}
+			timer = time.NewTimer(b.cfg.BufferBackpressureTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-relieved:
+		case <-timer.C:
+			return errors.Wrapf(store.ErrBusy, "Not able to store %s, too many logs and events are waiting to be sent to ES for %v", k, b.cfg.BufferBackpressureTimeout)
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "Not able to store %s, too many logs and events are waiting to be sent to ES", k)
+		}
+	}
+}
+
+// flush sends the buffered documents.
+func (b *bulkBuffer) flush(ctx context.Context) {
+	b.mu.Lock()
+	body, count := b.swap()
+	b.mu.Unlock()
+	b.send(ctx, body, count)
+}
+
+// close flushes the buffered documents, documents can't be added anymore.
+func (b *bulkBuffer) close(ctx context.Context) {
+	b.mu.Lock()
+	b.closed = true
+	body, count := b.swap()
+	b.mu.Unlock()
+	b.send(ctx, body, count)
+}
+
+// swap returns the buffered documents and resets the buffer, b.mu should be held.
+func (b *bulkBuffer) swap() ([]byte, int) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	body, count := b.body, b.count
+	b.body, b.count = nil, 0
+	emitBufferDepthMetric(0)
+	return body, count
+}
+
+func (b *bulkBuffer) send(ctx context.Context, body []byte, count int) {
+	if count == 0 {
+		return
+	}
+	// Sent documents, whether they are indexed or not, are not pending anymore
+	defer func(size int) {
+		b.mu.Lock()
+		b.updatePending(-size)
+		b.mu.Unlock()
+	}(len(body))
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	failures, err := sendBulkRequest(ctx, b.c, b.cfg, count, &body)
+	if err != nil {
+		log.Printf("[ERROR] Failed to send %d buffered documents to ES: %+v", count, err)
+		b.deadLetter.writeBody(body)
+		return
+	}
+	for _, f := range failures {
+		log.Printf("[WARN] Buffered document not indexed, status was %d (%s: %s), bulk operation was: %s", f.status, f.errType, f.errReason, string(f.operation))
+	}
+	b.deadLetter.writeFailures(failures)
+}
+
+func emitBufferDepthMetric(depth int) {
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "buffer", "depth"}), float32(depth))
+}
+
+func emitBufferPressureMetrics(pending int, throttled bool) {
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "buffer", "pending"}), float32(pending))
+	var t float32
+	if throttled {
+		t = 1
+	}
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "buffer", "throttled"}), t)
+}
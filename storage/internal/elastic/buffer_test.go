@@ -0,0 +1,164 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func newTestBulkBuffer(t *testing.T, maxDocuments int, linger time.Duration) (*bulkBuffer, func() ([]string, int), func()) {
+	var mu sync.Mutex
+	var received, encodings []string
+	srv := newFakeBulkServer(t, &mu, &received, &encodings)
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	cfg := elasticStoreConf{
+		indicePrefix:            "yorc_",
+		clusterID:               "c",
+		BufferMaxDocuments:      maxDocuments,
+		BufferMaxSize:           1000,
+		BufferMaxLinger:         linger,
+		BulkRetryBaseDelay:      time.Millisecond,
+		BulkMaxRetries:          1,
+		BulkRetryMaxElapsedTime: time.Second,
+	}
+	b := newBulkBuffer(&esClient{Transport: t6, majorVersion: 7}, cfg, nil)
+	state := func() ([]string, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		// One bulk request per received encoding
+		return append([]string(nil), received...), len(encodings)
+	}
+	return b, state, srv.Close
+}
+
+func testLogKeyValue(i int) store.KeyValueIn {
+	return store.KeyValueIn{
+		Key:   fmt.Sprintf("_yorc/logs/dep/2024-01-01T10:00:00.%09dZ", i+1),
+		Value: json.RawMessage(fmt.Sprintf(`{"content":"log %d"}`, i)),
+	}
+}
+
+func TestBulkBufferFlushOnMaxDocuments(t *testing.T) {
+	b, state, closeSrv := newTestBulkBuffer(t, 3, time.Hour)
+	defer closeSrv()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, b.add(context.Background(), testLogKeyValue(i)))
+	}
+	received, requests := state()
+	assert.Equal(t, 2, requests)
+	// 2 lines per document
+	assert.Len(t, received, 12)
+	assert.Equal(t, 1, b.count)
+
+	b.close(context.Background())
+	received, requests = state()
+	assert.Equal(t, 3, requests)
+	assert.Len(t, received, 14)
+	assert.Equal(t, 0, b.count)
+
+	// The buffer is closed
+	assert.Error(t, b.add(context.Background(), testLogKeyValue(8)))
+}
+
+func TestBulkBufferFlushOnLinger(t *testing.T) {
+	b, state, closeSrv := newTestBulkBuffer(t, 100, 50*time.Millisecond)
+	defer closeSrv()
+	require.NoError(t, b.add(context.Background(), testLogKeyValue(0)))
+	require.NoError(t, b.add(context.Background(), testLogKeyValue(1)))
+	_, requests := state()
+	assert.Equal(t, 0, requests)
+
+	assert.Eventually(t, func() bool {
+		_, requests := state()
+		return requests == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	received, _ := state()
+	assert.Len(t, received, 4)
+
+	// Nothing to send on close
+	b.close(context.Background())
+	_, requests = state()
+	assert.Equal(t, 1, requests)
+}
+
+func TestBulkBufferFlushOnMaxSize(t *testing.T) {
+	b, state, closeSrv := newTestBulkBuffer(t, 100, time.Hour)
+	defer closeSrv()
+	// 1 kB
+	b.cfg.BufferMaxSize = 1
+	b.cfg.maxBulkSize = 1
+	for i := 0; i < 20; i++ {
+		require.NoError(t, b.add(context.Background(), testLogKeyValue(i)))
+	}
+	_, requests := state()
+	assert.True(t, requests > 0, "buffer should have been flushed when its max size was reached")
+	b.close(context.Background())
+	received, _ := state()
+	assert.Len(t, received, 40)
+}
+
+func TestBulkBufferBackpressure(t *testing.T) {
+	b, _, closeSrv := newTestBulkBuffer(t, 1000, time.Hour)
+	defer closeSrv()
+	b.cfg.BufferHighWatermark = 1
+	b.cfg.BufferLowWatermark = 0
+	b.cfg.BufferBackpressureTimeout = 0
+
+	// Writes are rejected once the high watermark is reached
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = b.add(context.Background(), testLogKeyValue(i))
+	}
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, store.ErrBusy))
+	assert.True(t, b.throttled)
+	assert.True(t, b.pending >= 1024)
+
+	// And accepted again once the buffer is drained
+	b.flush(context.Background())
+	assert.False(t, b.throttled)
+	assert.Equal(t, 0, b.pending)
+	require.NoError(t, b.add(context.Background(), testLogKeyValue(0)))
+
+	// Throttled writes wait for the buffer to drain
+	for !b.throttled {
+		require.NoError(t, b.add(context.Background(), testLogKeyValue(1)))
+	}
+	b.cfg.BufferBackpressureTimeout = 5 * time.Second
+	time.AfterFunc(50*time.Millisecond, func() { b.flush(context.Background()) })
+	require.NoError(t, b.add(context.Background(), testLogKeyValue(2)))
+
+	// Until the backpressure timeout
+	for !b.throttled {
+		require.NoError(t, b.add(context.Background(), testLogKeyValue(3)))
+	}
+	b.cfg.BufferBackpressureTimeout = 20 * time.Millisecond
+	err = b.add(context.Background(), testLogKeyValue(4))
+	assert.True(t, errors.Is(err, store.ErrBusy))
+	b.close(context.Background())
+}
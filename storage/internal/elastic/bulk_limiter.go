@@ -0,0 +1,101 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// bulkLimiter limits the number of bulk requests sent concurrently to ES, excess requests wait for a slot.
+// A nil bulkLimiter doesn't limit bulk requests.
+type bulkLimiter struct {
+	slots chan struct{}
+	// Protects the counters below, they are only used for metrics
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+}
+
+// Returns a limiter allowing at most max concurrent bulk requests, nil if max is not positive.
+func newBulkLimiter(max int) *bulkLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &bulkLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a slot to send a bulk request, store.ErrBusy is returned if it lasts more than timeout.
+// A zero timeout means fail immediately when no slot is available. The returned function releases the slot.
+func (l *bulkLimiter) acquire(ctx context.Context, timeout time.Duration) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.update(1, 0)
+		return l.release, nil
+	default:
+	}
+	if timeout <= 0 {
+		return nil, errors.Wrapf(store.ErrBusy, "Not able to send bulk request, %d bulk requests are already in flight", cap(l.slots))
+	}
+	l.update(0, 1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.update(1, -1)
+		return l.release, nil
+	case <-timer.C:
+		l.update(0, -1)
+		return nil, errors.Wrapf(store.ErrBusy, "Not able to send bulk request, %d bulk requests are in flight for %v", cap(l.slots), timeout)
+	case <-ctx.Done():
+		l.update(0, -1)
+		return nil, errors.Wrap(ctx.Err(), "Not able to send bulk request while waiting for in flight bulk requests")
+	}
+}
+
+func (l *bulkLimiter) release() {
+	<-l.slots
+	l.update(-1, 0)
+}
+
+func (l *bulkLimiter) update(inFlight, queued int) {
+	l.mu.Lock()
+	l.inFlight += inFlight
+	l.queued += queued
+	emitBulkConcurrencyMetrics(l.inFlight, l.queued)
+	l.mu.Unlock()
+}
+
+// Returns the number of in flight bulk requests and the number of bulk requests waiting for a slot
+func (l *bulkLimiter) counts() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, l.queued
+}
+
+func emitBulkConcurrencyMetrics(inFlight, queued int) {
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "in_flight"}), float32(inFlight))
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "queued"}), float32(queued))
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func TestBulkLimiterUnlimited(t *testing.T) {
+	l := newBulkLimiter(0)
+	assert.Nil(t, l)
+	for i := 0; i < 10; i++ {
+		release, err := l.acquire(context.Background(), 0)
+		require.NoError(t, err)
+		defer release()
+	}
+}
+
+func TestBulkLimiterWaitsForSlot(t *testing.T) {
+	l := newBulkLimiter(2)
+	release1, err := l.acquire(context.Background(), time.Second)
+	require.NoError(t, err)
+	release2, err := l.acquire(context.Background(), time.Second)
+	require.NoError(t, err)
+	inFlight, queued := l.counts()
+	assert.Equal(t, 2, inFlight)
+	assert.Equal(t, 0, queued)
+
+	acquired := make(chan error)
+	go func() {
+		release3, err := l.acquire(context.Background(), 5*time.Second)
+		if err == nil {
+			release3()
+		}
+		acquired <- err
+	}()
+	require.Eventually(t, func() bool {
+		_, queued := l.counts()
+		return queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	release1()
+	require.NoError(t, <-acquired)
+	release2()
+	inFlight, queued = l.counts()
+	assert.Equal(t, 0, inFlight)
+	assert.Equal(t, 0, queued)
+}
+
+func TestBulkLimiterBusy(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		wantErr error
+	}{
+		{"FailImmediately", 0, false, store.ErrBusy},
+		{"Timeout", 20 * time.Millisecond, false, store.ErrBusy},
+		{"Cancelled", 5 * time.Second, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newBulkLimiter(1)
+			release, err := l.acquire(context.Background(), 0)
+			require.NoError(t, err)
+			defer release()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			} else {
+				defer cancel()
+			}
+			_, err = l.acquire(ctx, tt.timeout)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.wantErr), "unexpected error %v", err)
+			inFlight, queued := l.counts()
+			assert.Equal(t, 1, inFlight)
+			assert.Equal(t, 0, queued)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// A fake ES bulk endpoint that decodes the bulk body and acknowledges each operation.
+func newFakeBulkServer(t *testing.T, mu *sync.Mutex, received *[]string, encodings *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		var reader io.Reader = r.Body
+		*encodings = append(*encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reader = gz
+		}
+		body, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+		*received = append(*received, lines...)
+		items := make([]string, len(lines)/2)
+		for i := range items {
+			items[i] = `{"index":{"status":201}}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	}))
+}
+
+func TestSendBulkRequestCompression(t *testing.T) {
+	body := []byte(`{"index":{"_index":"yorc_logs"}}
+{"deploymentId":"d1","iid":1,"iidStr":"1"}
+{"index":{"_index":"yorc_logs"}}
+{"deploymentId":"d1","iid":2,"iidStr":"2"}
+`)
+	tests := []struct {
+		name         string
+		compression  bool
+		level        int
+		wantEncoding string
+	}{
+		{"NoCompression", false, -1, ""},
+		{"DefaultLevel", true, -1, "gzip"},
+		{"BestSpeed", true, 1, "gzip"},
+		{"BestCompression", true, 9, "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var received, encodings []string
+			srv := newFakeBulkServer(t, &mu, &received, &encodings)
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}})
+			require.NoError(t, err)
+			c := &esClient{Transport: t6, majorVersion: 7}
+			conf := elasticStoreConf{
+				BulkCompression:         tt.compression,
+				BulkCompressionLevel:    tt.level,
+				BulkRetryBaseDelay:      time.Millisecond,
+				BulkMaxRetries:          1,
+				BulkRetryMaxElapsedTime: time.Second,
+			}
+			b := append([]byte(nil), body...)
+			failures, err := sendBulkRequest(context.Background(), c, conf, 2, &b)
+			require.NoError(t, err)
+			assert.Len(t, failures, 0)
+			assert.Equal(t, []string{tt.wantEncoding}, encodings)
+			assert.Equal(t, strings.Split(strings.TrimRight(string(body), "\n"), "\n"), received)
+		})
+	}
+}
+
+func TestGzipBytes(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"deploymentId":"d1","content":"some log content"}`+"\n"), 100)
+	compressed, err := gzipBytes(data, gzip.BestCompression)
+	require.NoError(t, err)
+	assert.True(t, len(compressed) < len(data))
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	uncompressed, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, uncompressed)
+
+	_, err = gzipBytes(data, 42)
+	assert.Error(t, err)
+}
+
+func TestBulkIndex(t *testing.T) {
+	logs := []byte(`{"index":{"_index":"yorc_logs","_type":"_doc"}}` + "\n" + `{"iid":"1"}` + "\n")
+	logs2 := []byte(`{"index":{"_index":"yorc_logs"}}` + "\n" + `{"iid":"2"}` + "\n")
+	events := []byte(`{"index":{"_index":"yorc_events-2024.01"}}` + "\n" + `{"iid":"3"}` + "\n")
+	assert.Equal(t, "yorc_logs", bulkIndex([][]byte{logs, logs2}))
+	assert.Equal(t, "yorc_events-2024.01", bulkIndex([][]byte{events}))
+	assert.Equal(t, "mixed", bulkIndex([][]byte{logs, events}))
+	assert.Equal(t, "", bulkIndex(nil))
+	assert.Equal(t, "", bulkOperationIndex([]byte(`{"delete":{}}`)))
+}
+
+func TestSendBulkRequestCancelled(t *testing.T) {
+	blocked := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer srv.Close()
+	defer close(blocked)
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	c := &esClient{Transport: t6, majorVersion: 7}
+	conf := elasticStoreConf{
+		BulkRetryBaseDelay:      time.Millisecond,
+		BulkMaxRetries:          3,
+		BulkRetryMaxElapsedTime: time.Minute,
+		RequestTimeout:          time.Minute,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b := []byte(`{"index":{"_index":"yorc_logs"}}` + "\n" + `{"iid":"1"}` + "\n")
+	start := time.Now()
+	_, err = sendBulkRequest(ctx, c, conf, 1, &b)
+	require.Error(t, err)
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	assert.True(t, time.Since(start) < 5*time.Second, "cancelled bulk request should return promptly")
+}
+
+// A fake ES bulk endpoint creating documents by ID, as ES does for create operations: a conflict is returned for existing IDs.
+// Index operations with an external version are handled the same way, a conflict being returned if the version is not greater
+// than the version of the existing document.
+func newFakeCreateBulkServer(t *testing.T, mu *sync.Mutex, documents map[string]string) *httptest.Server {
+	versions := make(map[string]int)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/_bulk", r.URL.Path)
+		mu.Lock()
+		defer mu.Unlock()
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+		items := make([]string, 0, len(lines)/2)
+		conflicts := false
+		for i := 0; i+1 < len(lines); i += 2 {
+			var action map[string]struct {
+				ID          string `json:"_id"`
+				Version     int    `json:"version"`
+				VersionType string `json:"version_type"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(lines[i]), &action))
+			name, op := "create", action["create"]
+			if versioned, ok := action["index"]; ok {
+				require.Equal(t, "external", versioned.VersionType, "expecting an externally versioned index operation: %s", lines[i])
+				name, op = "index", versioned
+			}
+			id := op.ID
+			require.NotEmpty(t, id, "expecting a create operation with an ID: %s", lines[i])
+			if _, ok := documents[id]; ok && (name == "create" || op.Version <= versions[id]) {
+				conflicts = true
+				items = append(items, `{"`+name+`":{"_id":"`+id+`","status":409,"error":{"type":"version_conflict_engine_exception","reason":"document already exists"}}}`)
+				continue
+			}
+			documents[id] = lines[i+1]
+			versions[id] = op.Version
+			items = append(items, `{"`+name+`":{"_id":"`+id+`","status":201}}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, conflicts, strings.Join(items, ","))
+	}))
+}
+
+func TestSetCollectionReplayedWithDeterministicDocumentIDs(t *testing.T) {
+	for _, strategy := range []string{deterministicDocumentIDs, versionedDocumentIDs} {
+		t.Run(strategy, func(t *testing.T) {
+			testSetCollectionReplayed(t, strategy)
+		})
+	}
+}
+
+func testSetCollectionReplayed(t *testing.T, documentIDStrategy string) {
+	var mu sync.Mutex
+	documents := make(map[string]string)
+	srv := newFakeCreateBulkServer(t, &mu, documents)
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{
+		indicePrefix:            "yorc_",
+		clusterID:               "c",
+		maxBulkSize:             1000,
+		maxBulkCount:            2,
+		BulkRetryBaseDelay:      time.Millisecond,
+		BulkMaxRetries:          1,
+		BulkRetryMaxElapsedTime: time.Second,
+		DocumentIDStrategy:      documentIDStrategy,
+	}}
+	keyValues := make([]store.KeyValueIn, 5)
+	for i := range keyValues {
+		keyValues[i] = testLogKeyValue(i)
+	}
+
+	// Replaying the same bulk, as done when retrying a request which actually succeeded, creates no duplicates
+	require.NoError(t, s.SetCollection(context.Background(), keyValues))
+	require.NoError(t, s.SetCollection(context.Background(), keyValues))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, documents, len(keyValues))
+}
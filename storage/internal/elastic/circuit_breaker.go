@@ -0,0 +1,158 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// The states of the circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops sending requests to ES once it failed consecutively a given number of times, requests fail
+// immediately with store.ErrUnavailable during a cool-down period. A single request is then let through to probe
+// whether ES recovered: the breaker is closed if it succeeds, opened again otherwise.
+// A nil circuitBreaker lets all the requests through.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	// Returns the current time, replaced by tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// True while the probe request of the half-open state is in flight
+	probing bool
+}
+
+// Returns a circuit breaker opening after threshold consecutive failures, nil if threshold is not positive.
+func newCircuitBreaker(threshold int, coolDown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	emitCircuitBreakerStateMetric(breakerClosed)
+	return &circuitBreaker{threshold: threshold, coolDown: coolDown, now: time.Now, state: breakerClosed}
+}
+
+// allow returns an error wrapping store.ErrUnavailable if the request should not be sent to ES.
+// Once allowed, the request outcome has to be recorded.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.coolDown {
+			break
+		}
+		log.Printf("ES circuit breaker is half-open after %v, the next request probes whether ES recovered", b.coolDown)
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			break
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+	metrics.IncrCounter(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "breaker", "rejected"}), 1)
+	return errors.Wrapf(store.ErrUnavailable, "ES circuit breaker is %s after %d consecutive failures, request not sent", b.state, b.threshold)
+}
+
+// record updates the breaker state according to the outcome of an allowed request.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbing := b.probing
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		// A cancelled request doesn't tell anything about ES availability
+		return
+	}
+	if !isUnavailabilityError(err) {
+		if b.state != breakerClosed {
+			log.Printf("ES recovered, closing the circuit breaker")
+			b.setState(breakerClosed)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if (b.state == breakerHalfOpen && wasProbing) || (b.state == breakerClosed && b.failures >= b.threshold) {
+		log.Printf("[WARN] ES failed %d consecutive times, opening the circuit breaker for %v, last error was: %v", b.failures, b.coolDown, err)
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// Returns the breaker state, an empty string for a nil breaker.
+func (b *circuitBreaker) currentState() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	emitCircuitBreakerStateMetric(state)
+}
+
+// Returns true if the error means ES is not able to handle requests: connection errors, timeouts, throttling or server
+// errors. ES answered client errors (ie. a mapping error) so it is available.
+func isUnavailabilityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	statusCode := esErrorStatusCode(err)
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// The state gauge is 0 when closed, 1 when half-open and 2 when open
+func emitCircuitBreakerStateMetric(state string) {
+	var value float32
+	switch state {
+	case breakerHalfOpen:
+		value = 1
+	case breakerOpen:
+		value = 2
+	}
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "breaker", "state"}), value)
+}
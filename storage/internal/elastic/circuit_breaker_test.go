@@ -0,0 +1,127 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(0, time.Minute)
+	assert.Nil(t, b)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, b.allow())
+		b.record(errors.New("connection refused"))
+	}
+	assert.Equal(t, "", b.currentState())
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+	unavailable := &ESError{StatusCode: http.StatusServiceUnavailable}
+
+	// Client errors and successes reset the consecutive failures
+	for _, err := range []error{unavailable, unavailable, &ESError{StatusCode: http.StatusBadRequest}, unavailable, nil, unavailable, unavailable} {
+		require.NoError(t, b.allow())
+		b.record(err)
+	}
+	assert.Equal(t, breakerClosed, b.currentState())
+	require.NoError(t, b.allow())
+	b.record(errors.New("connection refused"))
+	assert.Equal(t, breakerOpen, b.currentState())
+
+	// Requests are short-circuited during the cool-down
+	err := b.allow()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, store.ErrUnavailable), "unexpected error %v", err)
+	now = now.Add(59 * time.Second)
+	assert.Error(t, b.allow())
+
+	// A single probe is let through once half-open, it opens the breaker again if it fails
+	now = now.Add(time.Second)
+	require.NoError(t, b.allow())
+	assert.Equal(t, breakerHalfOpen, b.currentState())
+	assert.Error(t, b.allow())
+	b.record(unavailable)
+	assert.Equal(t, breakerOpen, b.currentState())
+	assert.Error(t, b.allow())
+
+	// A cancelled probe doesn't change the state, a successful one closes the breaker
+	now = now.Add(time.Minute)
+	require.NoError(t, b.allow())
+	b.record(errors.Wrap(context.Canceled, "cancelled"))
+	assert.Equal(t, breakerHalfOpen, b.currentState())
+	require.NoError(t, b.allow())
+	b.record(nil)
+	assert.Equal(t, breakerClosed, b.currentState())
+	require.NoError(t, b.allow())
+}
+
+func TestCircuitBreakerShortCircuitsBulkRequests(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"cluster_name":"yorc","version":{"number":"7.17.1"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	cfg := elasticStoreConf{
+		BulkRetryBaseDelay:      time.Millisecond,
+		BulkMaxRetries:          5,
+		BulkRetryMaxElapsedTime: time.Second,
+		HealthCheckTimeout:      time.Second,
+	}
+	c := &esClient{Transport: t6, majorVersion: 7, breaker: newCircuitBreaker(2, time.Minute)}
+	body := []byte(`{"index":{"_index":"yorc_logs"}}` + "\n" + `{"content":"log"}` + "\n")
+
+	// The retries stop as soon as the breaker opens
+	_, err = sendBulkRequest(context.Background(), c, cfg, 1, &body)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, store.ErrUnavailable), "unexpected error %v", err)
+	assert.Equal(t, 2, requests)
+
+	_, err = sendBulkRequest(context.Background(), c, cfg, 1, &body)
+	assert.True(t, errors.Is(err, store.ErrUnavailable), "unexpected error %v", err)
+	_, _, _, err = doQueryEs(context.Background(), c, cfg, "yorc_logs", "", `{"query":{"match_all":{}}}`, 0, 10, "asc")
+	assert.True(t, errors.Is(err, store.ErrUnavailable), "unexpected error %v", err)
+	assert.Equal(t, 2, requests)
+
+	s := &elasticStore{esClient: c, cfg: cfg}
+	health := s.Check(context.Background())
+	assert.Equal(t, breakerOpen, health.CircuitBreaker)
+	assert.NotEmpty(t, health.Error)
+}
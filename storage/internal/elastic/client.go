@@ -0,0 +1,271 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	elasticsearch7 "github.com/elastic/go-elasticsearch/v7"
+	elasticsearch8 "github.com/elastic/go-elasticsearch/v8"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+)
+
+// esClient wraps the ES client matching the major version of the ES cluster.
+// All the clients share the same transport contract, so requests are built using esapi and performed
+// using this client whatever the cluster version is.
+type esClient struct {
+	esapi.Transport
+	// The major version of the ES cluster, detected at init time
+	majorVersion int
+	// The ingest pipeline documents are sent through, empty if not configured or not found at init time
+	ingestPipeline string
+	// Limits the number of concurrent bulk requests, nil if not limited
+	bulkLimiter *bulkLimiter
+	// Short-circuits bulk requests and queries while ES is failing, nil if disabled
+	breaker *circuitBreaker
+	// Short-circuits writes while ES indexes are read-only as the disk is full, nil if disabled
+	writeBlock *writeBlock
+	// Checks the health of the configured ES nodes once the store is initialized, nil if disabled
+	healthProbe *nodesHealthProbe
+}
+
+// The response of the ES info API ('/' endpoint), only the fields we need.
+type infoResponse struct {
+	ClusterName string `json:"cluster_name"`
+	Version     struct {
+		Number        string `json:"number"`
+		LuceneVersion string `json:"lucene_version"`
+		// Only set by ES forks (ie. opensearch)
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// The response of the ES cluster health API, only the fields we need.
+type clusterHealthResponse struct {
+	ClusterName   string `json:"cluster_name"`
+	Status        string `json:"status"`
+	NumberOfNodes int    `json:"number_of_nodes"`
+}
+
+// Returns true if the ES cluster uses typed mappings (ES 6.x).
+// Starting with ES 7 mapping types are deprecated and they are removed from ES 8.
+func (c *esClient) hasMappingTypes() bool {
+	return c.majorVersion < 7
+}
+
+// Query the ES info API using the given transport and return the decoded response.
+func getClusterInfo(ctx context.Context, t esapi.Transport) (*infoResponse, error) {
+	req := esapi.InfoRequest{}
+	res, err := req.Do(ctx, t)
+	defer closeResponseBody("InfoRequest", res)
+	if err = handleESResponseError(res, "InfoRequest", "", err); err != nil {
+		return nil, err
+	}
+	info := new(infoResponse)
+	if err = json.NewDecoder(res.Body).Decode(info); err != nil {
+		return nil, errors.Wrapf(err, "Not able to decode ES info response, status was %s", res.Status())
+	}
+	return info, nil
+}
+
+// Query the ES cluster health API using the given transport and return the decoded response.
+func getClusterHealth(ctx context.Context, t esapi.Transport) (*clusterHealthResponse, error) {
+	req := esapi.ClusterHealthRequest{}
+	res, err := req.Do(ctx, t)
+	defer closeResponseBody("ClusterHealthRequest", res)
+	if err = handleESResponseError(res, "ClusterHealthRequest", "", err); err != nil {
+		return nil, err
+	}
+	health := new(clusterHealthResponse)
+	if err = json.NewDecoder(res.Body).Decode(health); err != nil {
+		return nil, errors.Wrapf(err, "Not able to decode ES cluster health response, status was %s", res.Status())
+	}
+	return health, nil
+}
+
+// Parse the major version from an ES version number (ie 7.10.2 => 7).
+func parseMajorVersion(version string) (int, error) {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return 0, errors.Wrapf(err, "Not able to parse ES version %q", version)
+	}
+	return major, nil
+}
+
+// Check that the cluster described by the given info response is supported and return its major version.
+func checkClusterVersion(info *infoResponse) (int, error) {
+	if info.Version.Distribution != "" && info.Version.Distribution != "elasticsearch" {
+		return 0, errors.Errorf("ES cluster %q is a %s %s cluster, only Elasticsearch 6.x, 7.x and 8.x clusters are supported",
+			info.ClusterName, info.Version.Distribution, info.Version.Number)
+	}
+	majorVersion, err := parseMajorVersion(info.Version.Number)
+	if err != nil {
+		return 0, err
+	}
+	if majorVersion < 6 || majorVersion > 8 {
+		return 0, errors.Errorf("ES cluster %q version %s is not supported, supported versions are 6.x, 7.x and 8.x", info.ClusterName, info.Version.Number)
+	}
+	return majorVersion, nil
+}
+
+// Sends a first request using the client built for the cluster version, so that a client not able to talk to the cluster
+// (ie. the ES 8.x client refuses to talk to a server not identified as Elasticsearch) fails at init rather than on the first bulk.
+func warmUpClient(ctx context.Context, c *esClient, conf elasticStoreConf) error {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	if _, err := getClusterInfo(ctx, c); err != nil {
+		return errors.Wrapf(err, "The ES cluster info request using the ES %d.x client failed", c.majorVersion)
+	}
+	return nil
+}
+
+// Build the ES client matching the given cluster major version using the given configuration.
+func newVersionedClient(esConfig elasticsearch6.Config, majorVersion int) (*esClient, error) {
+	var t esapi.Transport
+	var err error
+	switch majorVersion {
+	case 6:
+		t, err = elasticsearch6.NewClient(esConfig)
+	case 7:
+		t, err = elasticsearch7.NewClient(elasticsearch7.Config{
+			Addresses:             esConfig.Addresses,
+			Username:              esConfig.Username,
+			Password:              esConfig.Password,
+			APIKey:                esConfig.APIKey,
+			CACert:                esConfig.CACert,
+			Transport:             esConfig.Transport,
+			Logger:                esConfig.Logger,
+			DiscoverNodesOnStart:  esConfig.DiscoverNodesOnStart,
+			DiscoverNodesInterval: esConfig.DiscoverNodesInterval,
+		})
+	case 8:
+		t, err = elasticsearch8.NewClient(elasticsearch8.Config{
+			Addresses:             esConfig.Addresses,
+			Username:              esConfig.Username,
+			Password:              esConfig.Password,
+			APIKey:                esConfig.APIKey,
+			CACert:                esConfig.CACert,
+			Transport:             esConfig.Transport,
+			Logger:                esConfig.Logger,
+			DiscoverNodesOnStart:  esConfig.DiscoverNodesOnStart,
+			DiscoverNodesInterval: esConfig.DiscoverNodesInterval,
+		})
+	default:
+		return nil, errors.Errorf("ES version %d is not supported, supported versions are 6.x, 7.x and 8.x", majorVersion)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Not able build ES %d.x client", majorVersion)
+	}
+	return &esClient{Transport: t, majorVersion: majorVersion}, nil
+}
+
+// ES 7 changed hits.total from a number to an object like {"value": N, "relation": "eq"}.
+// This function handles both formats.
+func getTotalHits(r map[string]interface{}) int {
+	switch total := r["hits"].(map[string]interface{})["total"].(type) {
+	case float64:
+		return int(total)
+	case map[string]interface{}:
+		if v, ok := total["value"].(float64); ok {
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// nodesHealthProbe periodically checks the health of each configured ES node and logs when the set of reachable
+// nodes changes. Each node is checked using its own client, so that the check doesn't fail over to another node.
+type nodesHealthProbe struct {
+	clients  map[string]esapi.Transport
+	interval time.Duration
+}
+
+// Builds the clients used to check the health of each configured ES node, checks are started by start.
+func newNodesHealthProbe(esConfig elasticsearch6.Config, interval time.Duration) (*nodesHealthProbe, error) {
+	clients := make(map[string]esapi.Transport, len(esConfig.Addresses))
+	for _, address := range esConfig.Addresses {
+		nodeConfig := esConfig
+		nodeConfig.Addresses = []string{address}
+		nodeConfig.DiscoverNodesOnStart = false
+		nodeConfig.DiscoverNodesInterval = 0
+		nodeConfig.DisableRetry = true
+		nodeConfig.Logger = nil
+		c, err := elasticsearch6.NewClient(nodeConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Not able build ES client for health checks of node %s", address)
+		}
+		clients[address] = c
+	}
+	return &nodesHealthProbe{clients: clients, interval: interval}, nil
+}
+
+// Starts the periodic checks in background, they stop once the done channel is closed. A nil probe does nothing.
+func (p *nodesHealthProbe) start(done <-chan struct{}) {
+	if p == nil {
+		return
+	}
+	go func() {
+		reachable := make(map[string]bool, len(p.clients))
+		for address := range p.clients {
+			// Nodes are considered reachable at startup as the cluster info request succeeded
+			reachable[address] = true
+		}
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkNodesHealth(p.clients, reachable, p.interval)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func checkNodesHealth(clients map[string]esapi.Transport, reachable map[string]bool, timeout time.Duration) {
+	changed := false
+	for address, c := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := getClusterInfo(ctx, c)
+		cancel()
+		if err != nil && reachable[address] {
+			log.Printf("[WARN] ES node %s is not reachable anymore: %v", address, err)
+		} else if err == nil && !reachable[address] {
+			log.Printf("ES node %s is reachable again", address)
+		}
+		changed = changed || reachable[address] != (err == nil)
+		reachable[address] = err == nil
+	}
+	if changed {
+		var nodes []string
+		for address, ok := range reachable {
+			if ok {
+				nodes = append(nodes, address)
+			}
+		}
+		sort.Strings(nodes)
+		log.Printf("Reachable ES nodes are now %d/%d: %v", len(nodes), len(reachable), nodes)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckClusterVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      string
+		wantMajor int
+		wantErr   string
+	}{
+		{"ES6", `{"cluster_name":"yorc","version":{"number":"6.8.23","lucene_version":"7.7.3"}}`, 6, ""},
+		{"ES7", `{"cluster_name":"yorc","version":{"number":"7.17.9","lucene_version":"8.11.1"}}`, 7, ""},
+		{"ES8", `{"cluster_name":"yorc","version":{"number":"8.6.2","lucene_version":"9.4.2"}}`, 8, ""},
+		{"ES5", `{"cluster_name":"yorc","version":{"number":"5.6.16"}}`, 0, `ES cluster "yorc" version 5.6.16 is not supported`},
+		{"ES9", `{"cluster_name":"yorc","version":{"number":"9.0.0"}}`, 0, `ES cluster "yorc" version 9.0.0 is not supported`},
+		{"OpenSearch", `{"cluster_name":"yorc","version":{"distribution":"opensearch","number":"2.5.0"}}`, 0, `ES cluster "yorc" is a opensearch 2.5.0 cluster`},
+		{"InvalidVersion", `{"cluster_name":"yorc","version":{"number":"latest"}}`, 0, `Not able to parse ES version "latest"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := new(infoResponse)
+			require.NoError(t, json.Unmarshal([]byte(tt.info), info))
+			major, err := checkClusterVersion(info)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMajor, major)
+		})
+	}
+}
+
+func TestWarmUpClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		majorVersion int
+		product      string
+		wantErr      bool
+	}{
+		{"ES6", 6, "", false},
+		{"ES7", 7, "Elasticsearch", false},
+		{"ES8", 8, "Elasticsearch", false},
+		// The ES 8.x client refuses to talk to a server not identified as Elasticsearch
+		{"UnknownProduct", 8, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if tt.product != "" {
+					w.Header().Set("X-Elastic-Product", tt.product)
+				}
+				fmt.Fprintf(w, `{"cluster_name":"yorc","version":{"number":"%d.17.0","build_flavor":"default"}}`, tt.majorVersion)
+			}))
+			defer srv.Close()
+			c, err := newVersionedClient(elasticsearch6.Config{Addresses: []string{srv.URL}}, tt.majorVersion)
+			require.NoError(t, err)
+
+			err = warmUpClient(context.Background(), c, elasticStoreConf{RequestTimeout: time.Second})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNodesHealthProbeStops(t *testing.T) {
+	var checks int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checks, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cluster_name":"yorc","version":{"number":"7.17.0"}}`))
+	}))
+	defer srv.Close()
+	done := make(chan struct{})
+	p, err := newNodesHealthProbe(elasticsearch6.Config{Addresses: []string{srv.URL}}, 10*time.Millisecond)
+	require.NoError(t, err)
+	p.start(done)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&checks) > 0 }, time.Second, 10*time.Millisecond)
+
+	// No more checks are run once done is closed
+	close(done)
+	time.Sleep(30 * time.Millisecond)
+	stopped := atomic.LoadInt32(&checks)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&checks))
+}
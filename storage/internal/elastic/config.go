@@ -15,7 +15,12 @@
 package elastic
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -26,6 +31,13 @@ import (
 
 var elasticStoreConfType = reflect.TypeOf(elasticStoreConf{})
 
+// The document_id_strategy values
+const (
+	autoDocumentIDs          = "auto"
+	deterministicDocumentIDs = "deterministic"
+	versionedDocumentIDs     = "versioned"
+)
+
 // elasticStoreConf represents the elastic store configuration that can be set in store.properties configuration.
 type elasticStoreConf struct {
 	// The ES cluster urls (array or CSV)
@@ -53,14 +65,149 @@ type elasticStoreConf struct {
 	clusterID string `json:"cluster_id"`
 	// Set to true if you want to print ES requests (for debug only)
 	traceRequests bool `json:"trace_requests" default:"false"`
+	// When false, only the metadata of traced requests (status, took, hits) are logged instead of the whole bodies
+	TraceRequestsBodies bool `json:"trace_requests_bodies" default:"true"`
+	// Values of the JSON fields whose name matches one of these case insensitive regular expressions are redacted from traced bodies
+	TraceRedactedFields []string `json:"trace_redacted_fields" default:"password,passwd,secret,token,api_?key,authorization,credentials?"`
+	// The regexp built from TraceRedactedFields
+	traceRedactedFieldsRegexp *regexp.Regexp
 	// Set to true if you want to trace events & logs when sent (for debug only)
 	traceEvents bool `json:"trace_events" default:"false"`
 	// Inital shards at index creation
 	InitialShards int `json:"initial_shards" default:"-1"`
 	// Initial replicas at index creation
 	InitialReplicas int `json:"initial_replicas" default:"-1"`
+	// The refresh interval of indexes
+	RefreshInterval string `json:"refresh_interval" default:"1s"`
+	// Additional index settings (ex: codec), merged into the settings of created indexes
+	IndexSettings map[string]interface{} `json:"index_settings"`
+	// The name of the logs index, prefixed by the index prefix and cluster ID
+	LogsIndexName string `json:"logs_index_name" default:"logs"`
+	// The name of the events index, prefixed by the index prefix and cluster ID
+	EventsIndexName string `json:"events_index_name" default:"events"`
+	// Additional settings of the logs index, merged into the index settings
+	LogsIndexSettings map[string]interface{} `json:"logs_index_settings"`
+	// Additional settings of the events index, merged into the index settings
+	EventsIndexSettings map[string]interface{} `json:"events_index_settings"`
+	// Additional field mappings of the logs index (ex: a keyword subfield of the content)
+	LogsMappingProperties map[string]interface{} `json:"logs_mapping_properties"`
+	// Additional field mappings of the events index
+	EventsMappingProperties map[string]interface{} `json:"events_mapping_properties"`
+	// The username for HTTP basic authentication
+	Username string `json:"username"`
+	// The password for HTTP basic authentication
+	Password string `json:"password"`
+	// Base64 encoded API key, if set it takes precedence over basic authentication
+	APIKey string `json:"api_key"`
+	// The PEM encoded CA certificate or the path to the CA certificate file (alternative to ca_cert_path)
+	CACert string `json:"ca_cert"`
+	// The PEM encoded client certificate or the path to the certificate file (alternative to cert_path)
+	ClientCert string `json:"client_cert"`
+	// The PEM encoded client private key or the path to the private key file (alternative to key_path)
+	ClientKey string `json:"client_key"`
+	// Set to true to skip the verification of the ES server certificate (not recommended for production)
+	InsecureSkipVerify bool `json:"insecure_skip_verify" default:"false"`
+	// The initial delay before retrying a bulk request rejected by ES (429 or 503), doubled at each retry
+	BulkRetryBaseDelay time.Duration `json:"bulk_retry_base_delay" default:"500ms"`
+	// The maximum number of retries for a bulk request rejected by ES (429 or 503)
+	BulkMaxRetries int `json:"bulk_max_retries" default:"5"`
+	// The maximum time spent retrying a bulk request rejected by ES (429 or 503)
+	BulkRetryMaxElapsedTime time.Duration `json:"bulk_retry_max_elapsed_time" default:"1m"`
+	// The maximum number of documents returned by a single query, bigger sizes are clamped to this value
+	MaxQuerySize int `json:"max_query_size" default:"1000"`
+	// Set to true to discover the ES cluster nodes when initializing the client
+	DiscoverNodesOnStart bool `json:"discover_nodes_on_start" default:"false"`
+	// When set, the ES cluster nodes are discovered periodically using this interval
+	DiscoverNodesInterval time.Duration `json:"discover_nodes_interval" default:"0s"`
+	// The interval between two health checks of the configured ES nodes, 0 disables the health checks
+	HealthCheckInterval time.Duration `json:"health_check_interval" default:"30s"`
+	// When greater than 0, logs and events are buffered and sent using bulk requests containing at most this number of documents
+	BufferMaxDocuments int `json:"buffer_max_documents" default:"0"`
+	// The maximum size (in kB) of the buffered documents
+	BufferMaxSize int `json:"buffer_max_size" default:"1000"`
+	// The maximum duration a document can stay in the buffer
+	BufferMaxLinger time.Duration `json:"buffer_max_linger" default:"1s"`
+	// The size (in kB) of the buffered and not yet sent documents above which writes are throttled, 0 disables throttling
+	BufferHighWatermark int `json:"buffer_high_watermark" default:"0"`
+	// The size (in kB) of the buffered and not yet sent documents below which writes are accepted again once throttled
+	BufferLowWatermark int `json:"buffer_low_watermark" default:"0"`
+	// How long a throttled write waits for the buffer to drain before failing with a busy error, 0 means fail immediately
+	BufferBackpressureTimeout time.Duration `json:"buffer_backpressure_timeout" default:"5s"`
+	// The maximum number of bulk requests sent concurrently, other ones wait up to the backpressure timeout. 0 means no limit
+	MaxInFlightBulkRequests int `json:"max_in_flight_bulk_requests" default:"0"`
+	// The number of consecutive ES failures opening the circuit breaker, requests then fail immediately. 0 disables the circuit breaker
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold" default:"0"`
+	// How long the circuit breaker stays open before letting a request probe whether ES recovered
+	CircuitBreakerCoolDown time.Duration `json:"circuit_breaker_cool_down" default:"30s"`
+	// While ES indexes are read-only as the disk is full, writes are sent to the dead letter sink and a single write probes
+	// whether the block is released at this interval. 0 disables the detection, writes are then always sent
+	WriteBlockProbeInterval time.Duration `json:"write_block_probe_interval" default:"1m"`
+	// The timeout of a single request sent to ES (a bulk request attempt, a search...), 0 means no timeout
+	RequestTimeout time.Duration `json:"request_timeout" default:"30s"`
+	// The timeout of the health check exposed by the store
+	HealthCheckTimeout time.Duration `json:"health_check_timeout" default:"5s"`
+	// Set to true to gzip compress bulk request bodies
+	BulkCompression bool `json:"bulk_compression" default:"false"`
+	// The gzip compression level for bulk request bodies, from 1 (best speed) to 9 (best compression), -1 for gzip default level
+	BulkCompressionLevel int `json:"bulk_compression_level" default:"-1"`
+	// The refresh policy of bulk requests: false (rely on the index refresh interval), wait_for or true
+	BulkRefresh string `json:"bulk_refresh" default:"false"`
+	// When set, documents are written into time based indexes, one per period: daily, weekly or monthly
+	IndexRolloverPeriod string `json:"index_rollover_period"`
+	// Logs and events older than this period are purged, 0 means never purge
+	RetentionPeriod time.Duration `json:"retention_period" default:"0s"`
+	// The interval between two purges of expired logs and events
+	RetentionPurgeInterval time.Duration `json:"retention_purge_interval" default:"1h"`
+	// When set, the indexes are snapshotted into this repository before each purge, the purge is aborted if the snapshot fails
+	RetentionSnapshotRepository string `json:"retention_snapshot_repository"`
+	// The number of slices read in parallel when exporting an index, bounded by the number of shards of the index
+	ExportSlices int `json:"export_slices" default:"4"`
+	// How the IDs of logs and events documents are defined: auto (generated by ES), deterministic (derived from the document)
+	// or versioned (derived from the document key, the document being indexed with an external version derived from its iid)
+	DocumentIDStrategy string `json:"document_id_strategy" default:"auto"`
+	// When set, logs and events that ES fails to index are appended to this file using the bulk API format
+	DeadLetterPath string `json:"dead_letter_path"`
+	// The size (in kB) at which the dead letter file is rotated
+	DeadLetterMaxSize int `json:"dead_letter_max_size" default:"10240"`
+	// The number of rotated dead letter files to keep
+	DeadLetterMaxBackups int `json:"dead_letter_max_backups" default:"5"`
+	// When ES is not reachable at startup, the store starts in degraded mode and retries its initialization in background
+	DegradedStartup bool `json:"degraded_startup" default:"true"`
+	// The maximum delay between two initialization attempts in degraded mode
+	InitRetryMaxDelay time.Duration `json:"init_retry_max_delay" default:"1m"`
+	// The maximum number of logs and events kept in memory in degraded mode, next ones are dropped
+	DegradedMaxDocuments int `json:"degraded_max_documents" default:"10000"`
+	// The name of the ingest pipeline logs and events are sent through
+	IngestPipeline string `json:"ingest_pipeline"`
+	// When true, Yorc installs a default ingest pipeline adding an @timestamp field derived from the document iid
+	InstallIngestPipeline bool `json:"install_ingest_pipeline" default:"false"`
+	// When true, logs and events are routed by deployment ID so that the documents of a deployment are stored in a single shard
+	RoutingByDeployment bool `json:"routing_by_deployment" default:"false"`
+	// The maximum size (in kB) of a single log or event, 0 means documents are only limited by the bulk size
+	MaxDocumentSize int `json:"max_document_size" default:"0"`
+	// What to do with documents bigger than the max document size: truncate them or drop them (they are written to the dead letter file)
+	OversizedDocumentPolicy string `json:"oversized_document_policy" default:"truncate"`
 }
 
+// Just an alias without String() method to print the config
+type printableElasticStoreConf elasticStoreConf
+
+// String returns the configuration without credentials, so that it can be safely logged.
+func (c elasticStoreConf) String() string {
+	if c.Password != "" {
+		c.Password = redacted
+	}
+	if c.APIKey != "" {
+		c.APIKey = redacted
+	}
+	if c.ClientKey != "" {
+		c.ClientKey = redacted
+	}
+	return fmt.Sprintf("%+v", printableElasticStoreConf(c))
+}
+
+const redacted = "<redacted>"
+
 // Get the tag for this field (for internal usage only: fatal if not found !).
 func getElasticStorageConfigPropertyTag(fn string, tn string) (tagValue string, e error) {
 	f, found := elasticStoreConfType.FieldByName(fn)
@@ -182,6 +329,19 @@ func getElasticStoreConfig(yorcConfig config.Configuration, storeConfig config.S
 	if e != nil {
 		return
 	}
+	cfg.TraceRequestsBodies, e = getBoolFromSettingsOrDefaults("TraceRequestsBodies", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.TraceRedactedFields, e = getStringSliceFromSettingsOrDefaults("TraceRedactedFields", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.traceRedactedFieldsRegexp, e = buildRedactedFieldsRegexp(cfg.TraceRedactedFields)
+	if e != nil {
+		e = errors.Wrapf(e, "Invalid trace_redacted_fields %v for elastic store", cfg.TraceRedactedFields)
+		return
+	}
 
 	cfg.InitialShards, e = getIntFromSettingsOrDefaults("InitialShards", storeProperties)
 	if e != nil {
@@ -193,6 +353,399 @@ func getElasticStoreConfig(yorcConfig config.Configuration, storeConfig config.S
 		return
 	}
 
+	cfg.RefreshInterval, e = getStringFromSettingsOrDefaults("RefreshInterval", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.IndexSettings, e = getIndexSettingsFromSettings("IndexSettings", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.LogsIndexSettings, e = getIndexSettingsFromSettings("LogsIndexSettings", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.EventsIndexSettings, e = getIndexSettingsFromSettings("EventsIndexSettings", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.LogsIndexName, e = getStringFromSettingsOrDefaults("LogsIndexName", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.EventsIndexName, e = getStringFromSettingsOrDefaults("EventsIndexName", storeProperties)
+	if e != nil {
+		return
+	}
+	for _, name := range []string{cfg.LogsIndexName, cfg.EventsIndexName} {
+		if !reIndexName.MatchString(name) {
+			e = errors.Errorf("Invalid index name %q for elastic store, it should be lowercase and only contain letters, digits, '_', '-' or '.'", name)
+			return
+		}
+	}
+	if cfg.LogsIndexName == cfg.EventsIndexName {
+		e = errors.Errorf("Invalid index names for elastic store, logs and events should be stored in distinct indexes")
+		return
+	}
+	cfg.LogsMappingProperties, e = getMappingPropertiesFromSettings("LogsMappingProperties", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.EventsMappingProperties, e = getMappingPropertiesFromSettings("EventsMappingProperties", storeProperties)
+	if e != nil {
+		return
+	}
+
+	cfg.Username, e = getOptionalStringFromSettings("Username", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.Password, e = getOptionalStringFromSettings("Password", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.APIKey, e = getOptionalStringFromSettings("APIKey", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.CACert, e = getOptionalStringFromSettings("CACert", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.ClientCert, e = getOptionalStringFromSettings("ClientCert", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.ClientKey, e = getOptionalStringFromSettings("ClientKey", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.InsecureSkipVerify, e = getBoolFromSettingsOrDefaults("InsecureSkipVerify", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BulkRetryBaseDelay, e = getDurationFromSettingsOrDefaults("BulkRetryBaseDelay", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BulkMaxRetries, e = getIntFromSettingsOrDefaults("BulkMaxRetries", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BulkRetryMaxElapsedTime, e = getDurationFromSettingsOrDefaults("BulkRetryMaxElapsedTime", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.BulkRetryBaseDelay <= 0 || cfg.BulkMaxRetries < 0 {
+		e = errors.Errorf("Invalid bulk retry configuration for elastic store, bulk_retry_base_delay should be positive and bulk_max_retries should not be negative")
+		return
+	}
+	cfg.MaxQuerySize, e = getIntFromSettingsOrDefaults("MaxQuerySize", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.DiscoverNodesOnStart, e = getBoolFromSettingsOrDefaults("DiscoverNodesOnStart", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.DiscoverNodesInterval, e = getDurationFromSettingsOrDefaults("DiscoverNodesInterval", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.HealthCheckInterval, e = getDurationFromSettingsOrDefaults("HealthCheckInterval", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BufferMaxDocuments, e = getIntFromSettingsOrDefaults("BufferMaxDocuments", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BufferMaxSize, e = getIntFromSettingsOrDefaults("BufferMaxSize", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BufferMaxLinger, e = getDurationFromSettingsOrDefaults("BufferMaxLinger", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.BufferMaxDocuments > 0 && (cfg.BufferMaxSize <= 0 || cfg.BufferMaxLinger <= 0) {
+		e = errors.Errorf("Invalid buffer configuration for elastic store, buffer_max_size and buffer_max_linger should be positive")
+		return
+	}
+	cfg.BufferHighWatermark, e = getIntFromSettingsOrDefaults("BufferHighWatermark", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BufferLowWatermark, e = getIntFromSettingsOrDefaults("BufferLowWatermark", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BufferBackpressureTimeout, e = getDurationFromSettingsOrDefaults("BufferBackpressureTimeout", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.BufferHighWatermark > 0 && cfg.BufferLowWatermark == 0 {
+		cfg.BufferLowWatermark = cfg.BufferHighWatermark / 2
+	}
+	if cfg.BufferHighWatermark < 0 || cfg.BufferLowWatermark < 0 || cfg.BufferLowWatermark > cfg.BufferHighWatermark || cfg.BufferBackpressureTimeout < 0 {
+		e = errors.Errorf("Invalid buffer backpressure configuration for elastic store, buffer_low_watermark should not be greater than buffer_high_watermark and values should not be negative")
+		return
+	}
+	cfg.MaxInFlightBulkRequests, e = getIntFromSettingsOrDefaults("MaxInFlightBulkRequests", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.MaxInFlightBulkRequests < 0 {
+		e = errors.Errorf("Invalid max_in_flight_bulk_requests %d for elastic store, it should not be negative", cfg.MaxInFlightBulkRequests)
+		return
+	}
+	cfg.CircuitBreakerThreshold, e = getIntFromSettingsOrDefaults("CircuitBreakerThreshold", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.CircuitBreakerCoolDown, e = getDurationFromSettingsOrDefaults("CircuitBreakerCoolDown", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.CircuitBreakerThreshold < 0 || cfg.CircuitBreakerCoolDown <= 0 {
+		e = errors.Errorf("Invalid circuit breaker configuration for elastic store, circuit_breaker_threshold should not be negative and circuit_breaker_cool_down should be positive")
+		return
+	}
+	cfg.WriteBlockProbeInterval, e = getDurationFromSettingsOrDefaults("WriteBlockProbeInterval", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.WriteBlockProbeInterval < 0 {
+		e = errors.Errorf("Invalid write_block_probe_interval %v for elastic store, it should not be negative", cfg.WriteBlockProbeInterval)
+		return
+	}
+	cfg.RequestTimeout, e = getDurationFromSettingsOrDefaults("RequestTimeout", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.HealthCheckTimeout, e = getDurationFromSettingsOrDefaults("HealthCheckTimeout", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BulkCompression, e = getBoolFromSettingsOrDefaults("BulkCompression", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.BulkCompressionLevel, e = getIntFromSettingsOrDefaults("BulkCompressionLevel", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.BulkCompressionLevel < gzip.DefaultCompression || cfg.BulkCompressionLevel > gzip.BestCompression {
+		e = errors.Errorf("Invalid bulk_compression_level %d for elastic store, it should be -1 or between 0 and 9", cfg.BulkCompressionLevel)
+		return
+	}
+	cfg.BulkRefresh, e = getStringFromSettingsOrDefaults("BulkRefresh", storeProperties)
+	if e != nil {
+		return
+	}
+	switch cfg.BulkRefresh {
+	case "false", "wait_for", "true":
+	default:
+		e = errors.Errorf("Invalid bulk_refresh %q for elastic store, expecting false, wait_for or true", cfg.BulkRefresh)
+		return
+	}
+	cfg.IndexRolloverPeriod, e = getOptionalStringFromSettings("IndexRolloverPeriod", storeProperties)
+	if e != nil {
+		return
+	}
+	switch cfg.IndexRolloverPeriod {
+	case "", "daily", "weekly", "monthly":
+	default:
+		e = errors.Errorf("Invalid index_rollover_period %q for elastic store, expecting daily, weekly or monthly", cfg.IndexRolloverPeriod)
+		return
+	}
+	cfg.RetentionPeriod, e = getDurationFromSettingsOrDefaults("RetentionPeriod", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.RetentionPurgeInterval, e = getDurationFromSettingsOrDefaults("RetentionPurgeInterval", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.RetentionPeriod < 0 || (cfg.RetentionPeriod > 0 && cfg.RetentionPurgeInterval <= 0) {
+		e = errors.Errorf("Invalid retention configuration for elastic store, retention_period should not be negative and retention_purge_interval should be positive")
+		return
+	}
+	cfg.RetentionSnapshotRepository, e = getOptionalStringFromSettings("RetentionSnapshotRepository", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.ExportSlices, e = getIntFromSettingsOrDefaults("ExportSlices", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.ExportSlices <= 0 {
+		e = errors.Errorf("Invalid export_slices %d for elastic store, it should be positive", cfg.ExportSlices)
+		return
+	}
+	cfg.DocumentIDStrategy, e = getStringFromSettingsOrDefaults("DocumentIDStrategy", storeProperties)
+	if e != nil {
+		return
+	}
+	switch cfg.DocumentIDStrategy {
+	case autoDocumentIDs, deterministicDocumentIDs, versionedDocumentIDs:
+	default:
+		e = errors.Errorf("Invalid document_id_strategy %q for elastic store, expecting %s, %s or %s", cfg.DocumentIDStrategy, autoDocumentIDs, deterministicDocumentIDs, versionedDocumentIDs)
+		return
+	}
+	cfg.DeadLetterPath, e = getOptionalStringFromSettings("DeadLetterPath", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.DeadLetterMaxSize, e = getIntFromSettingsOrDefaults("DeadLetterMaxSize", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.DeadLetterMaxBackups, e = getIntFromSettingsOrDefaults("DeadLetterMaxBackups", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.DeadLetterPath != "" && (cfg.DeadLetterMaxSize <= 0 || cfg.DeadLetterMaxBackups < 0) {
+		e = errors.Errorf("Invalid dead letter configuration for elastic store, dead_letter_max_size should be positive and dead_letter_max_backups should not be negative")
+		return
+	}
+	cfg.DegradedStartup, e = getBoolFromSettingsOrDefaults("DegradedStartup", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.InitRetryMaxDelay, e = getDurationFromSettingsOrDefaults("InitRetryMaxDelay", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.DegradedMaxDocuments, e = getIntFromSettingsOrDefaults("DegradedMaxDocuments", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.DegradedStartup && (cfg.InitRetryMaxDelay <= 0 || cfg.DegradedMaxDocuments < 0) {
+		e = errors.Errorf("Invalid degraded startup configuration for elastic store, init_retry_max_delay should be positive and degraded_max_documents should not be negative")
+		return
+	}
+	cfg.IngestPipeline, e = getOptionalStringFromSettings("IngestPipeline", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.InstallIngestPipeline, e = getBoolFromSettingsOrDefaults("InstallIngestPipeline", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.InstallIngestPipeline && cfg.IngestPipeline == "" {
+		cfg.IngestPipeline = cfg.indicePrefix + defaultIngestPipelineSuffix
+	}
+	cfg.RoutingByDeployment, e = getBoolFromSettingsOrDefaults("RoutingByDeployment", storeProperties)
+	if e != nil {
+		return
+	}
+	cfg.MaxDocumentSize, e = getIntFromSettingsOrDefaults("MaxDocumentSize", storeProperties)
+	if e != nil {
+		return
+	}
+	if cfg.MaxDocumentSize < 0 {
+		e = errors.Errorf("Invalid max_document_size %d for elastic store, it should not be negative", cfg.MaxDocumentSize)
+		return
+	}
+	cfg.OversizedDocumentPolicy, e = getStringFromSettingsOrDefaults("OversizedDocumentPolicy", storeProperties)
+	if e != nil {
+		return
+	}
+	switch cfg.OversizedDocumentPolicy {
+	case truncateOversizedDocuments, dropOversizedDocuments:
+	default:
+		e = errors.Errorf("Invalid oversized_document_policy %q for elastic store, expecting %s or %s", cfg.OversizedDocumentPolicy, truncateOversizedDocuments, dropOversizedDocuments)
+		return
+	}
+	if cfg.MaxQuerySize <= 0 {
+		e = errors.Errorf("Invalid max_query_size %d for elastic store, it should be positive", cfg.MaxQuerySize)
+		return
+	}
+	if cfg.APIKey != "" && (cfg.Username != "" || cfg.Password != "") {
+		log.Printf("[WARN] Both api_key and username/password are set for elastic store, api_key will be used")
+	}
+
+	return
+}
+
+// Get the index settings from store config properties, settings are flattened and validated. Returns nil if not set.
+func getIndexSettingsFromSettings(fn string, dm config.DynamicMap) (v map[string]interface{}, e error) {
+	t, e := getElasticStorageConfigPropertyTag(fn, "json")
+	if e != nil || !dm.IsSet(t) {
+		return
+	}
+	v, e = flattenIndexSettings(dm.Get(t))
+	if e != nil {
+		e = errors.Wrapf(e, "Invalid %s for elastic store", t)
+		return
+	}
+	if _, e = json.Marshal(v); e != nil {
+		e = errors.Wrapf(e, "Invalid %s for elastic store", t)
+		return
+	}
+	for _, setting := range []string{"number_of_shards", "number_of_replicas", "refresh_interval"} {
+		if _, ok := v[setting]; ok {
+			e = errors.Errorf("Invalid %s for elastic store, %s should be set using the dedicated store property", t, setting)
+			return
+		}
+	}
+	return
+}
+
+// Get the mapping properties from store config properties. Returns nil if not set.
+func getMappingPropertiesFromSettings(fn string, dm config.DynamicMap) (v map[string]interface{}, e error) {
+	t, e := getElasticStorageConfigPropertyTag(fn, "json")
+	if e != nil || !dm.IsSet(t) {
+		return
+	}
+	v, e = parseMappingProperties(dm.Get(t))
+	if e != nil {
+		e = errors.Wrapf(e, "Invalid %s for elastic store", t)
+	}
+	return
+}
+
+// Get the string from store config properties, returns an empty string if not set.
+func getOptionalStringFromSettings(fn string, dm config.DynamicMap) (v string, e error) {
+	t, e := getElasticStorageConfigPropertyTag(fn, "json")
+	if e != nil {
+		return
+	}
+	if dm.IsSet(t) {
+		v = dm.GetString(t)
+	}
+	return
+}
+
+// Get the string from store config properties, fallback to required default value defined in struc.
+func getStringFromSettingsOrDefaults(fn string, dm config.DynamicMap) (v string, e error) {
+	t, e := getElasticStorageConfigPropertyTag(fn, "json")
+	if e != nil {
+		return
+	}
+	if dm.IsSet(t) {
+		v = dm.GetString(t)
+		return
+	}
+	return getElasticStorageConfigPropertyTag(fn, "default")
+}
+
+// Get the string slice from store config properties, fallback to required default value defined in struc (a comma separated list).
+func getStringSliceFromSettingsOrDefaults(fn string, dm config.DynamicMap) (v []string, e error) {
+	t, e := getElasticStorageConfigPropertyTag(fn, "json")
+	if e != nil {
+		return
+	}
+	if dm.IsSet(t) {
+		v = dm.GetStringSlice(t)
+		return
+	}
+	t, e = getElasticStorageConfigPropertyTag(fn, "default")
+	if e != nil {
+		return
+	}
+	v = strings.Split(t, ",")
 	return
 }
 
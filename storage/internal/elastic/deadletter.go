@@ -0,0 +1,159 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/armon/go-metrics"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/log"
+)
+
+// deadLetterSink appends the bulk operations that ES failed to handle to a local file, so that they can be re-ingested later
+// using the bulk API. The file is rotated when it reaches its max size, at most maxBackups rotated files are kept.
+type deadLetterSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	// Protects the fields below
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Returns the dead letter sink defined by the store configuration, or nil if dead_letter_path is not set.
+func newDeadLetterSink(conf elasticStoreConf) (*deadLetterSink, error) {
+	if conf.DeadLetterPath == "" {
+		return nil, nil
+	}
+	log.Printf("\t- Logs and events that ES fails to index will be written to %s", conf.DeadLetterPath)
+	d := &deadLetterSink{path: conf.DeadLetterPath, maxSize: int64(conf.DeadLetterMaxSize) * 1024, maxBackups: conf.DeadLetterMaxBackups}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create the directory of dead letter file %q", d.path)
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *deadLetterSink) open() error {
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open dead letter file %q", d.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "failed to stat dead letter file %q", d.path)
+	}
+	d.file, d.size = f, info.Size()
+	return nil
+}
+
+// Renames the current file to path.1 (shifting existing backups) and opens a new one, d.mu should be held.
+func (d *deadLetterSink) rotate() error {
+	if err := d.file.Close(); err != nil {
+		log.Printf("[WARN] Failed to close dead letter file %q: %v", d.path, err)
+	}
+	d.file = nil
+	if d.maxBackups > 0 {
+		for i := d.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", d.path, i), fmt.Sprintf("%s.%d", d.path, i+1))
+		}
+		if err := os.Rename(d.path, d.path+".1"); err != nil {
+			return errors.Wrapf(err, "failed to rotate dead letter file %q", d.path)
+		}
+	} else if err := os.Remove(d.path); err != nil {
+		return errors.Wrapf(err, "failed to rotate dead letter file %q", d.path)
+	}
+	return d.open()
+}
+
+// write appends the given bulk operations (action and document lines) to the dead letter file.
+// Nothing is done if the sink is nil.
+func (d *deadLetterSink) write(operations [][]byte) error {
+	if d == nil || len(operations) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		// A previous rotation failed, let's try to reopen the file
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+	for _, operation := range operations {
+		if d.size > 0 && d.size+int64(len(operation)) > d.maxSize {
+			if err := d.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := d.file.Write(operation)
+		d.size += int64(n)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write to dead letter file %q", d.path)
+		}
+	}
+	metrics.IncrCounter(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "deadletter", "documents"}), float32(len(operations)))
+	log.Printf("[WARN] %d logs and events not indexed by ES have been written to dead letter file %s", len(operations), d.path)
+	return nil
+}
+
+// writeFailures appends the given failed bulk operations to the dead letter file, errors are logged.
+func (d *deadLetterSink) writeFailures(failures []bulkOperationFailure) {
+	if d == nil || len(failures) == 0 {
+		return
+	}
+	operations := make([][]byte, len(failures))
+	for i, f := range failures {
+		operations[i] = f.operation
+	}
+	if err := d.write(operations); err != nil {
+		log.Printf("[ERROR] %d logs and events not indexed by ES are lost: %+v", len(operations), err)
+	}
+}
+
+// writeBody appends all the operations of a failed bulk request to the dead letter file, errors are logged.
+func (d *deadLetterSink) writeBody(body []byte) {
+	if d == nil {
+		return
+	}
+	operations := splitBulkOperations(body)
+	if err := d.write(operations); err != nil {
+		log.Printf("[ERROR] %d logs and events not indexed by ES are lost: %+v", len(operations), err)
+	}
+}
+
+func (d *deadLetterSink) close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBulkOperation(i int) []byte {
+	return []byte(fmt.Sprintf(`{"index":{"_index":"yorc_logs"}}`+"\n"+`{"iid":"%010d"}`+"\n", i))
+}
+
+// Returns the operations written to the dead letter file and its backups
+func readDeadLetterFiles(t *testing.T, path string, maxBackups int) [][]byte {
+	var operations [][]byte
+	for i := maxBackups; i >= 0; i-- {
+		p := path
+		if i > 0 {
+			p = fmt.Sprintf("%s.%d", path, i)
+		}
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+		operations = append(operations, splitBulkOperations(data)...)
+	}
+	return operations
+}
+
+func TestDeadLetterSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yorc-deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "elastic", "deadletter.ndjson")
+	d, err := newDeadLetterSink(elasticStoreConf{DeadLetterPath: path, DeadLetterMaxSize: 1, DeadLetterMaxBackups: 2})
+	require.NoError(t, err)
+	defer d.close()
+	// Size is given in kB, so operations are scaled down to get a few of them per file
+	d.maxSize = int64(3 * len(testBulkOperation(0)))
+
+	var operations [][]byte
+	for i := 0; i < 9; i++ {
+		operations = append(operations, testBulkOperation(i))
+	}
+	require.NoError(t, d.write(operations))
+
+	// Only the last rotated files are kept
+	assert.Equal(t, operations, readDeadLetterFiles(t, path, 2))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, d.write([][]byte{testBulkOperation(9)}))
+	assert.Equal(t, append(operations[3:], testBulkOperation(9)), readDeadLetterFiles(t, path, 2))
+}
+
+func TestDeadLetterSinkConcurrentWriters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yorc-deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletter.ndjson")
+	d, err := newDeadLetterSink(elasticStoreConf{DeadLetterPath: path, DeadLetterMaxSize: 1024, DeadLetterMaxBackups: 1})
+	require.NoError(t, err)
+	defer d.close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 10; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, d.write([][]byte{testBulkOperation(w*10 + i)}))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Each operation is written entirely
+	operations := readDeadLetterFiles(t, path, 1)
+	require.Len(t, operations, 100)
+	seen := make(map[string]bool)
+	for _, ope := range operations {
+		assert.True(t, bytes.HasPrefix(ope, []byte(`{"index":{"_index":"yorc_logs"}}`+"\n")), string(ope))
+		seen[string(ope)] = true
+	}
+	assert.Len(t, seen, 100)
+
+	var nilSink *deadLetterSink
+	assert.NoError(t, nilSink.write(operations))
+}
+
+func TestBulkBufferDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yorc-deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletter.ndjson")
+	d, err := newDeadLetterSink(elasticStoreConf{DeadLetterPath: path, DeadLetterMaxSize: 1024})
+	require.NoError(t, err)
+	defer d.close()
+
+	// The first document is rejected, the whole second bulk request fails
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}},{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	cfg := elasticStoreConf{
+		indicePrefix:            "yorc_",
+		clusterID:               "c",
+		BufferMaxDocuments:      2,
+		BufferMaxSize:           1000,
+		BufferMaxLinger:         time.Minute,
+		BulkRetryBaseDelay:      time.Millisecond,
+		BulkMaxRetries:          1,
+		BulkRetryMaxElapsedTime: time.Second,
+	}
+	b := newBulkBuffer(&esClient{Transport: t6, majorVersion: 7}, cfg, d)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, b.add(context.Background(), testLogKeyValue(i)))
+	}
+
+	operations := readDeadLetterFiles(t, path, 0)
+	require.Len(t, operations, 3)
+	for i, ope := range operations {
+		assert.Contains(t, string(ope), fmt.Sprintf(`"content":"log %d"`, []int{0, 2, 3}[i]))
+		assert.True(t, strings.HasSuffix(string(ope), "\n"))
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-retry"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// The health status reported while the store is not initialized
+const degradedStatus = "degraded"
+
+// The delay before the first initialization retry in degraded mode, doubled at each attempt
+const initRetryBaseDelay = time.Second
+
+// Connects to the ES cluster, then installs the index templates and creates the indexes if needed.
+func initStore(ctx context.Context, conf elasticStoreConf) (*esClient, error) {
+	c, err := prepareEsClient(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, storeType := range []string{"logs", "events"} {
+		err = installIndexTemplate(ctx, c, conf, storeType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Not able to install index template for eventType <%s>", storeType)
+		}
+	}
+	for _, storeType := range []string{"logs", "events"} {
+		err = initStorageIndex(ctx, c, conf, storeType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Not able to init index for eventType <%s>", storeType)
+		}
+	}
+	if c.ingestPipeline, err = initIngestPipeline(ctx, c, conf); err != nil {
+		return nil, errors.Wrapf(err, "Not able to init ingest pipeline <%s>", conf.IngestPipeline)
+	}
+	c.bulkLimiter = newBulkLimiter(conf.MaxInFlightBulkRequests)
+	c.breaker = newCircuitBreaker(conf.CircuitBreakerThreshold, conf.CircuitBreakerCoolDown)
+	c.writeBlock = newWriteBlock(conf.WriteBlockProbeInterval)
+	return c, nil
+}
+
+// Makes the store usable once initialized: starts the background tasks and sends the documents kept in degraded mode.
+func (s *elasticStore) setInitialized(c *esClient) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.esClient = c
+	if s.cfg.BufferMaxDocuments > 0 {
+		s.buffer = newBulkBuffer(c, s.cfg, s.deadLetter)
+	}
+	s.degraded = false
+	s.initErr = nil
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	emitDegradedBufferDepthMetric(0)
+
+	// Started only once the initialization fully succeeded, as failed attempts are retried in degraded mode
+	c.healthProbe.start(s.closeCh)
+	if s.cfg.RetentionPeriod > 0 {
+		startRetentionPurge(c, s.cfg, s.closeCh)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	log.Printf("Sending the %d logs and events kept while the elastic store was not initialized", len(pending))
+	if err := s.SetCollection(context.Background(), pending); err != nil {
+		log.Printf("[ERROR] Failed to send the logs and events kept while the elastic store was not initialized: %+v", err)
+	}
+}
+
+// Retries the store initialization in background, the delay between two attempts grows up to init_retry_max_delay.
+func (s *elasticStore) startBackgroundInit(initErr error) {
+	s.mu.Lock()
+	s.degraded = true
+	s.initErr = initErr
+	s.mu.Unlock()
+	// The base delay is a positive constant, so no error can occur here
+	b, _ := retry.NewExponential(initRetryBaseDelay)
+	b = retry.WithCappedDuration(s.cfg.InitRetryMaxDelay, b)
+	go func() {
+		for {
+			delay, _ := b.Next()
+			select {
+			case <-time.After(delay):
+			case <-s.closeCh:
+				return
+			}
+			log.Printf("Retrying to initialize the elastic store")
+			c, err := initStore(context.Background(), s.cfg)
+			if err == nil {
+				log.Printf("Elastic store is now initialized, leaving degraded mode")
+				s.setInitialized(c)
+				return
+			}
+			log.Printf("[WARN] Elastic store is still not able to initialize, next attempt in at most %v: %v", s.cfg.InitRetryMaxDelay, err)
+			s.mu.Lock()
+			s.initErr = err
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Keeps the given document in memory if the store is not initialized yet, documents exceeding
+// degraded_max_documents are dropped. Returns false if the store is initialized.
+func (s *elasticStore) keepIfNotInitialized(kv store.KeyValueIn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.degraded {
+		return false
+	}
+	if len(s.pending) >= s.cfg.DegradedMaxDocuments {
+		if s.dropped == 0 {
+			log.Printf("[WARN] Elastic store is not initialized and %d logs and events are already kept in memory, next ones are dropped", s.cfg.DegradedMaxDocuments)
+		}
+		s.dropped++
+		metrics.IncrCounter(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "degraded", "dropped"}), 1)
+		return true
+	}
+	s.pending = append(s.pending, kv)
+	emitDegradedBufferDepthMetric(len(s.pending))
+	return true
+}
+
+// Returns an error if the store is not initialized yet.
+func (s *elasticStore) checkInitialized() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.degraded {
+		return errors.Wrap(s.initErr, "Elastic store is running in degraded mode, ES is not reachable")
+	}
+	return nil
+}
+
+// Returns the health reported while the store is not initialized.
+func (s *elasticStore) degradedHealth() (store.HealthStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.degraded {
+		return store.HealthStatus{}, false
+	}
+	return store.HealthStatus{
+		Status: degradedStatus,
+		Error: fmt.Sprintf("Elastic store is not initialized, %d logs and events are kept in memory and %d have been dropped, last error was: %v",
+			len(s.pending), s.dropped, s.initErr),
+	}, true
+}
+
+func emitDegradedBufferDepthMetric(depth int) {
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "degraded", "depth"}), float32(depth))
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// A fake ES cluster answering the requests sent at store initialization, unavailable until available is set.
+type fakeStartupServer struct {
+	mu        sync.Mutex
+	available bool
+	bulkLines []string
+}
+
+func (f *fakeStartupServer) setAvailable() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.available = true
+}
+
+func (f *fakeStartupServer) bulkDocuments() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.bulkLines) / 2
+}
+
+func (f *fakeStartupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.available {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/":
+		w.Write([]byte(`{"cluster_name":"yorc","version":{"number":"7.17.1"}}`))
+	case r.URL.Path == "/_cluster/health":
+		w.Write([]byte(`{"cluster_name":"yorc","status":"green","number_of_nodes":1}`))
+	case r.URL.Path == "/_bulk":
+		body, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimRight(string(body), "\n"), "\n")
+		f.bulkLines = append(f.bulkLines, lines...)
+		items := make([]string, len(lines)/2)
+		for i := range items {
+			items[i] = `{"index":{"status":201}}`
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		// Neither templates nor indexes exist
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.Write([]byte(`{"acknowledged":true}`))
+	}
+}
+
+func TestNewStoreDegradedStartup(t *testing.T) {
+	fake := &fakeStartupServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	storeConfig := config.Store{Types: []string{"Log"}, Properties: config.DynamicMap{
+		"es_urls":                []string{srv.URL},
+		"health_check_interval":  "0s",
+		"init_retry_max_delay":   "10ms",
+		"degraded_max_documents": 2,
+	}}
+
+	st, err := NewStore(cfg, storeConfig)
+	require.NoError(t, err)
+	s := st.(*elasticStore)
+	defer s.Close()
+	ctx := context.Background()
+	health := s.Check(ctx)
+	assert.False(t, health.Reachable)
+	assert.Equal(t, degradedStatus, health.Status)
+
+	// Documents are kept in memory up to degraded_max_documents, next ones are dropped
+	for i := 0; i < 3; i++ {
+		kv := testLogKeyValue(i)
+		require.NoError(t, s.Set(ctx, kv.Key, kv.Value))
+	}
+	assert.Contains(t, s.Check(ctx).Error, "2 logs and events are kept in memory and 1 have been dropped")
+	// Reads and collections fail until the store is initialized
+	_, _, err = s.List(ctx, "_yorc/logs/dep", 0, 0)
+	assert.Error(t, err)
+	assert.Error(t, s.SetCollection(ctx, []store.KeyValueIn{testLogKeyValue(3)}))
+
+	// Once ES is reachable, the store is initialized and kept documents are sent
+	fake.setAvailable()
+	assert.Eventually(t, func() bool {
+		return s.Check(ctx).Reachable
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, store.HealthStatus{Reachable: true, Status: "green", Version: "7.17.1", Nodes: 1}, s.Check(ctx))
+	assert.Eventually(t, func() bool {
+		return fake.bulkDocuments() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewStoreWithoutDegradedStartup(t *testing.T) {
+	srv := httptest.NewServer(&fakeStartupServer{})
+	defer srv.Close()
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	storeConfig := config.Store{Types: []string{"Log"}, Properties: config.DynamicMap{
+		"es_urls":               []string{srv.URL},
+		"health_check_interval": "0s",
+		"degraded_startup":      false,
+	}}
+
+	_, err := NewStore(cfg, storeConfig)
+	assert.Error(t, err)
+}
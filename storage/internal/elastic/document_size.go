@@ -0,0 +1,127 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+)
+
+// The policies applied to documents bigger than the max document size
+const (
+	truncateOversizedDocuments = "truncate"
+	dropOversizedDocuments     = "drop"
+)
+
+// The marker appended to the truncated values of a document
+const truncatedMarker = "...[truncated]"
+
+// The maximum number of string values truncated in a single document before giving up and dropping it
+const maxTruncatedValues = 10
+
+// errDocumentDropped is returned when a document is too big to be sent to ES and has been dropped.
+var errDocumentDropped = errors.New("oversized document dropped")
+
+// limitDocumentSize ensures that the given document is not bigger than maxSize bytes, the document is returned unchanged if it fits.
+// Depending on oversized_document_policy, an oversized document is either truncated by shortening its longest string values,
+// or dropped in which case errDocumentDropped is returned. A document which can't be truncated enough is dropped.
+func limitDocumentSize(c elasticStoreConf, k string, document []byte, maxSize int) ([]byte, error) {
+	if maxSize <= 0 || len(document) <= maxSize {
+		return document, nil
+	}
+	if c.OversizedDocumentPolicy == truncateOversizedDocuments {
+		truncated, err := truncateDocument(document, maxSize)
+		if err == nil {
+			log.Printf("[WARN] Document %s of %d bytes exceeds the max document size of %d bytes, it has been truncated to %d bytes", k, len(document), maxSize, len(truncated))
+			return truncated, nil
+		}
+		log.Printf("[WARN] Failed to truncate document %s: %v", k, err)
+	}
+	log.Printf("[WARN] Document %s of %d bytes exceeds the max document size of %d bytes, it has been dropped", k, len(document), maxSize)
+	return nil, errDocumentDropped
+}
+
+// Returns true if the given top level field of a document can be truncated. Fields added by the store and fields used to
+// query, sort, paginate or route documents are never truncated, only content fields are.
+func isTruncatableField(name string) bool {
+	if _, ok := commonMappingProperties[name]; ok {
+		return false
+	}
+	if _, ok := correlationMappingProperties[name]; ok {
+		return false
+	}
+	return name != "timestamp"
+}
+
+// truncateDocument shortens the longest top level string values of the content fields of the given JSON document until
+// it is not bigger than maxSize bytes. An error is returned if the content fields can't be shortened enough.
+func truncateDocument(document []byte, maxSize int) ([]byte, error) {
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(document))
+	// Keep numbers as is
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return nil, errors.Wrap(err, "document is not a JSON object")
+	}
+	truncated := document
+	for i := 0; i < maxTruncatedValues && len(truncated) > maxSize; i++ {
+		var longest string
+		// Values shorter than the marker can't be shortened
+		longestLen := len(truncatedMarker)
+		for name, value := range fields {
+			if s, ok := value.(string); ok && len(s) > longestLen && isTruncatableField(name) {
+				longest, longestLen = name, len(s)
+			}
+		}
+		if longest == "" {
+			break
+		}
+		value := fields[longest].(string)
+		// Escaped characters make the marshaled value longer than the raw one, the loop takes care of it
+		keep := len(value) - (len(truncated) - maxSize) - len(truncatedMarker)
+		if keep < 0 {
+			keep = 0
+		}
+		// Do not cut a multi-bytes character
+		for keep > 0 && !utf8.RuneStart(value[keep]) {
+			keep--
+		}
+		fields[longest] = value[:keep] + truncatedMarker
+		var err error
+		if truncated, err = marshalDocument(fields); err != nil {
+			return nil, err
+		}
+	}
+	if len(truncated) > maxSize {
+		return nil, errors.Errorf("document is still %d bytes after truncation", len(truncated))
+	}
+	return truncated, nil
+}
+
+func marshalDocument(fields map[string]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	// Do not make values longer by escaping HTML characters
+	e.SetEscapeHTML(false)
+	if err := e.Encode(fields); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal truncated document")
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func TestLimitDocumentSize(t *testing.T) {
+	bigContent := strings.Repeat("é", 600)
+	tests := []struct {
+		name        string
+		policy      string
+		document    string
+		maxSize     int
+		wantDropped bool
+		wantContent string
+	}{
+		{"FittingDocument", truncateOversizedDocuments, `{"content":"log"}`, 100, false, "log"},
+		{"NoLimit", truncateOversizedDocuments, `{"content":"` + bigContent + `"}`, 0, false, bigContent},
+		{"Truncate", truncateOversizedDocuments, `{"content":"` + bigContent + `","level":"INFO","iid":"1"}`, 500, false, ""},
+		{"Drop", dropOversizedDocuments, `{"content":"` + bigContent + `"}`, 500, true, ""},
+		{"NotTruncatable", truncateOversizedDocuments, `{"values":[` + strings.Repeat("1,", 300) + `1]}`, 500, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := limitDocumentSize(elasticStoreConf{OversizedDocumentPolicy: tt.policy}, "_yorc/logs/dep/2024-01-01T10:00:00Z", []byte(tt.document), tt.maxSize)
+			if tt.wantDropped {
+				assert.True(t, errors.Is(err, errDocumentDropped))
+				return
+			}
+			require.NoError(t, err)
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(document, &fields), "invalid document %s", string(document))
+			if tt.wantContent != "" {
+				assert.Equal(t, tt.wantContent, fields["content"])
+				return
+			}
+			assert.True(t, len(document) <= tt.maxSize, "document of %d bytes", len(document))
+			content := fields["content"].(string)
+			assert.True(t, strings.HasSuffix(content, truncatedMarker))
+			assert.True(t, strings.HasPrefix(bigContent, strings.TrimSuffix(content, truncatedMarker)))
+			assert.Equal(t, "INFO", fields["level"])
+			assert.Equal(t, "1", fields["iid"])
+		})
+	}
+}
+
+func TestTruncateDocumentManyShortFields(t *testing.T) {
+	storeFields := map[string]interface{}{
+		"deploymentId": "a-deployment-with-a-quite-long-identifier",
+		"iid":          "1704103200000000000",
+		"iidStr":       "1704103200000000000",
+		"timestamp":    "2024-01-01T10:00:00.000000000Z",
+		"level":        "INFO",
+	}
+	document := func(nbFields int) []byte {
+		fields := make(map[string]interface{}, len(storeFields)+nbFields)
+		for name, value := range storeFields {
+			fields[name] = value
+		}
+		for i := 0; i < nbFields; i++ {
+			fields[fmt.Sprintf("field%02d", i)] = strings.Repeat("x", 30)
+		}
+		b, err := json.Marshal(fields)
+		require.NoError(t, err)
+		return b
+	}
+
+	// Content fields are truncated, the fields used by the store are kept as is
+	d := document(4)
+	truncated, err := truncateDocument(d, len(d)-40)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(truncated, &fields))
+	for name, value := range storeFields {
+		assert.Equal(t, value, fields[name], "field %s should not be truncated", name)
+	}
+
+	// Content fields too short to be truncated enough make the document dropped rather than cutting store fields
+	d = document(30)
+	_, err = truncateDocument(d, len(d)/2)
+	assert.Error(t, err)
+	_, err = limitDocumentSize(elasticStoreConf{OversizedDocumentPolicy: truncateOversizedDocuments}, "_yorc/logs/dep/2024-01-01T10:00:00Z", d, len(d)/2)
+	assert.True(t, errors.Is(err, errDocumentDropped))
+}
+
+func TestBulkRequestOversizedDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yorc-deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletter.ndjson")
+	conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", maxBulkSize: 1, MaxDocumentSize: 1, OversizedDocumentPolicy: dropOversizedDocuments,
+		DeadLetterPath: path, DeadLetterMaxSize: 1024}
+	d, err := newDeadLetterSink(conf)
+	require.NoError(t, err)
+	defer d.close()
+
+	kv := store.KeyValueIn{Key: "_yorc/logs/dep/2024-01-01T10:00:00Z", Value: json.RawMessage(`{"content":"` + strings.Repeat("a", 2048) + `"}`)}
+	body := make([]byte, 0)
+	added, err := eventuallyAppendValueToBulkRequest(conf, &esClient{majorVersion: 7}, d, &body, kv, 1024)
+	assert.True(t, errors.Is(err, errDocumentDropped))
+	assert.False(t, added)
+	assert.Empty(t, body)
+	// The dropped document is written to the dead letter file
+	operations := readDeadLetterFiles(t, path, 0)
+	require.Len(t, operations, 1)
+	assert.Contains(t, string(operations[0]), strings.Repeat("a", 2048))
+
+	// Truncated documents fit in the bulk request, even if no max document size is set
+	conf.MaxDocumentSize, conf.OversizedDocumentPolicy = 0, truncateOversizedDocuments
+	added, err = eventuallyAppendValueToBulkRequest(conf, &esClient{majorVersion: 7}, d, &body, kv, 1024)
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.True(t, len(body) < 1024)
+	assert.Contains(t, string(body), truncatedMarker)
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+)
+
+// ESError is returned when ES answers a request with an error status code.
+// It carries the status code as well as the type and the reason of the error returned by ES if any.
+type ESError struct {
+	StatusCode int
+	// The ES error type (ie. index_not_found_exception)
+	Type   string
+	Reason string
+	msg    string
+}
+
+func (e *ESError) Error() string {
+	return e.msg
+}
+
+// Is allows to use errors.Is with an ESError target: errors match if they have the same status code
+// and, if the target has a type, the same ES error type (ie. errors.Is(err, &ESError{StatusCode: 404, Type: "index_not_found_exception"})).
+func (e *ESError) Is(target error) bool {
+	t, ok := target.(*ESError)
+	if !ok {
+		return false
+	}
+	return t.StatusCode == e.StatusCode && (t.Type == "" || t.Type == e.Type)
+}
+
+// Builds the ESError of an error response, the human readable message contains the whole response.
+func newESError(res *esapi.Response, requestDescription string, query string) *ESError {
+	// The response body is restored once read by String()
+	e := &ESError{
+		StatusCode: res.StatusCode,
+		msg: fmt.Sprintf(
+			"An error was returned by ES while sending %s, status was %s, query was: %s, response: %+v",
+			requestDescription, res.Status(), query, res.String()),
+	}
+	var rsp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if res.Body == nil || json.NewDecoder(res.Body).Decode(&rsp) != nil {
+		return e
+	}
+	// The error is an object, or a string for some old ES versions
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(rsp.Error, &cause) == nil {
+		e.Type, e.Reason = cause.Type, cause.Reason
+	} else {
+		json.Unmarshal(rsp.Error, &e.Reason)
+	}
+	return e
+}
+
+// Returns the status code of the ES error wrapped by err, or 0 if err is not an ES error.
+func esErrorStatusCode(err error) int {
+	var esErr *ESError
+	if errors.As(err, &esErr) {
+		return esErr.StatusCode
+	}
+	return 0
+}
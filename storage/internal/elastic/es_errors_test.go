@@ -0,0 +1,85 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleESResponseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		wantType   string
+		wantReason string
+	}{
+		{"IndexNotFound", http.StatusNotFound, `{"error":{"root_cause":[],"type":"index_not_found_exception","reason":"no such index [yorc_logs]"},"status":404}`,
+			"index_not_found_exception", "no such index [yorc_logs]"},
+		{"Throttled", http.StatusTooManyRequests, `{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`,
+			"es_rejected_execution_exception", "rejected execution"},
+		{"StringError", http.StatusBadRequest, `{"error":"MapperParsingException[failed to parse]","status":400}`, "", "MapperParsingException[failed to parse]"},
+		{"NoBody", http.StatusServiceUnavailable, ``, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &esapi.Response{StatusCode: tt.statusCode, Body: ioutil.NopCloser(strings.NewReader(tt.body))}
+			err := errors.Wrap(handleESResponseError(res, "Search:yorc_logs", "{}", nil), "failed to search")
+
+			var esErr *ESError
+			require.True(t, errors.As(err, &esErr))
+			assert.Equal(t, tt.statusCode, esErr.StatusCode)
+			assert.Equal(t, tt.wantType, esErr.Type)
+			assert.Equal(t, tt.wantReason, esErr.Reason)
+			assert.Equal(t, tt.statusCode, esErrorStatusCode(err))
+			// The message is unchanged
+			assert.Equal(t, "An error was returned by ES while sending Search:yorc_logs, status was "+res.Status()+", query was: {}, response: ["+res.Status()+"] "+tt.body, esErr.Error())
+
+			assert.True(t, errors.Is(err, &ESError{StatusCode: tt.statusCode}))
+			assert.True(t, errors.Is(err, &ESError{StatusCode: tt.statusCode, Type: tt.wantType}))
+			assert.False(t, errors.Is(err, &ESError{StatusCode: tt.statusCode, Type: "resource_already_exists_exception"}))
+			assert.False(t, errors.Is(err, &ESError{StatusCode: http.StatusConflict}))
+		})
+	}
+	assert.Equal(t, 0, esErrorStatusCode(errors.New("connection refused")))
+	assert.NoError(t, handleESResponseError(&esapi.Response{StatusCode: http.StatusOK}, "Search:yorc_logs", "{}", nil))
+}
+
+func TestElasticStoreCheckUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"type":"security_exception","reason":"missing authentication credentials"},"status":401}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{HealthCheckTimeout: time.Second}}
+
+	health := s.Check(context.Background())
+	assert.True(t, health.Reachable)
+	assert.Contains(t, health.Error, "missing authentication credentials")
+}
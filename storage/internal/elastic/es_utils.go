@@ -16,61 +16,62 @@ package elastic
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	stderrors "errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
 	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
 	"github.com/elastic/go-elasticsearch/v6/esapi"
 	"github.com/pkg/errors"
+	"github.com/sethvargo/go-retry"
 	"github.com/ystia/yorc/v4/log"
 	"github.com/ystia/yorc/v4/storage/store"
 )
 
 var pfalse = false
+var ptrue = true
 
-func prepareEsClient(elasticStoreConfig elasticStoreConf) (*elasticsearch6.Client, error) {
+// Build the ES client: the cluster version is detected using the info API and the client matching this version is returned.
+func prepareEsClient(ctx context.Context, elasticStoreConfig elasticStoreConf) (*esClient, error) {
 	log.Printf("Elastic storage will run using this configuration: %+v", elasticStoreConfig)
 
-	esConfig := elasticsearch6.Config{Addresses: elasticStoreConfig.esUrls}
-
-	if len(elasticStoreConfig.caCertPath) > 0 {
-		log.Printf("Reading CACert file from %s", elasticStoreConfig.caCertPath)
-		caCert, err := ioutil.ReadFile(elasticStoreConfig.caCertPath)
-		if err != nil {
-			return nil, errors.Wrapf(err, "Not able to read Cert file from <%s>", elasticStoreConfig.caCertPath)
-		}
-		esConfig.CACert = caCert
-
-		if len(elasticStoreConfig.certPath) > 0 && len(elasticStoreConfig.keyPath) > 0 {
-			cert, err := tls.LoadX509KeyPair(elasticStoreConfig.certPath, elasticStoreConfig.keyPath)
-			if err != nil {
-				return nil, errors.Wrapf(err, "Not able to read cert and/or key file from <%s> and <%s>", elasticStoreConfig.certPath, elasticStoreConfig.keyPath)
-			}
-			caCertPool := x509.NewCertPool()
-			caCertPool.AppendCertsFromPEM(caCert)
-
-			// Setup HTTPS client
-			tlsConfig := &tls.Config{
-				Certificates: []tls.Certificate{cert},
-				RootCAs:      caCertPool,
-			}
-			tlsConfig.BuildNameToCertificate()
-			transport := &http.Transport{TLSClientConfig: tlsConfig}
+	esConfig := elasticsearch6.Config{
+		Addresses:             elasticStoreConfig.esUrls,
+		DiscoverNodesOnStart:  elasticStoreConfig.DiscoverNodesOnStart,
+		DiscoverNodesInterval: elasticStoreConfig.DiscoverNodesInterval,
+	}
+	if len(elasticStoreConfig.APIKey) > 0 {
+		// API key takes precedence over basic authentication
+		esConfig.APIKey = elasticStoreConfig.APIKey
+	} else {
+		esConfig.Username = elasticStoreConfig.Username
+		esConfig.Password = elasticStoreConfig.Password
+	}
 
-			esConfig.Transport = transport
-		}
+	tlsConfig, err := buildTLSConfig(elasticStoreConfig)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		esConfig.Transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
 	if log.IsDebug() || elasticStoreConfig.traceRequests {
 		// In debug mode or when traceRequests option is activated, we add a custom logger that print requests & responses
 		log.Printf("\t- Tracing ES requests & response can be expensive and verbose !")
-		esConfig.Logger = &debugLogger{}
+		esConfig.Logger = &debugLogger{
+			bodies:         elasticStoreConfig.TraceRequestsBodies,
+			redactedFields: elasticStoreConfig.traceRedactedFieldsRegexp,
+		}
 	} else {
 		// otherwise log only failure are logger
 		esConfig.Logger = &defaultLogger{}
@@ -84,28 +85,244 @@ func prepareEsClient(elasticStoreConfig elasticStoreConf) (*elasticsearch6.Clien
 		willRefresh = "not "
 	}
 	log.Printf("\t- Will %srefresh index before waiting for indexation", willRefresh)
+	switch elasticStoreConfig.BulkRefresh {
+	case "wait_for":
+		log.Printf("\t- Bulk requests will wait for the next index refresh, this may reduce indexing throughput")
+	case "true":
+		log.Printf("\t- Bulk requests will force an index refresh, this significantly reduces indexing throughput and should not be used on busy clusters")
+	}
 	log.Printf("\t- While migrating data, the max bulk request size will be %d documents and will never exceed %d kB",
 		elasticStoreConfig.maxBulkCount, elasticStoreConfig.maxBulkSize)
 	if log.IsDebug() {
-		log.Printf("\t- Will use this ES client configuration: %+v", esConfig)
+		log.Printf("\t- Will use this ES client configuration: %+v", redactClientConfig(esConfig))
 	}
 
-	esClient, e := elasticsearch6.NewClient(esConfig)
+	// The info request is understood by all ES versions, so we use a 6.x client to detect the cluster version
+	probeClient, e := elasticsearch6.NewClient(esConfig)
 	if e != nil {
 		return nil, errors.Wrapf(e, "Not able build ES client")
 	}
-	infoResponse, e := esClient.Info()
+	infoCtx, cancel := withRequestTimeout(ctx, elasticStoreConfig)
+	info, e := getClusterInfo(infoCtx, probeClient)
+	cancel()
 	if e != nil {
+		if isCertificateError(e) {
+			return nil, errors.Wrapf(e, "The ES cluster info request failed due to a TLS certificate issue, please check ca_cert_path, cert_path, key_path or insecure_skip_verify configuration")
+		}
 		return nil, errors.Wrapf(e, "The ES cluster info request failed")
 	}
-	log.Printf("Here is the ES cluster info: %+v", infoResponse)
-	return esClient, e
+	log.Printf("Reached ES cluster %q, version %s (lucene %s)", info.ClusterName, info.Version.Number, info.Version.LuceneVersion)
+	majorVersion, e := checkClusterVersion(info)
+	if e != nil {
+		return nil, e
+	}
+	c, e := newVersionedClient(esConfig, majorVersion)
+	if e != nil {
+		return nil, e
+	}
+	if e = warmUpClient(ctx, c, elasticStoreConfig); e != nil {
+		return nil, e
+	}
+	log.Printf("ES cluster version is %s, will use ES %d.x client", info.Version.Number, majorVersion)
+	if elasticStoreConfig.HealthCheckInterval > 0 {
+		log.Printf("\t- Will check the health of the %d configured ES nodes every %v", len(esConfig.Addresses), elasticStoreConfig.HealthCheckInterval)
+		if c.healthProbe, e = newNodesHealthProbe(esConfig, elasticStoreConfig.HealthCheckInterval); e != nil {
+			return nil, e
+		}
+	}
+	return c, nil
+}
+
+// Build the TLS configuration used to connect to ES, nil is returned if TLS is not configured.
+// CA certificate, client certificate and key can be provided as file paths or PEM encoded content.
+func buildTLSConfig(c elasticStoreConf) (*tls.Config, error) {
+	caCert := c.caCertPath
+	if caCert == "" {
+		caCert = c.CACert
+	}
+	cert := c.certPath
+	if cert == "" {
+		cert = c.ClientCert
+	}
+	key := c.keyPath
+	if key == "" {
+		key = c.ClientKey
+	}
+	if caCert == "" && cert == "" && key == "" && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if caCert != "" {
+		caCertPEM, err := readPEM(caCert, "CA cert")
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCertPEM) {
+			return nil, errors.Errorf("No valid PEM encoded certificate found in CA cert <%s>", caCert)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, errors.New("Both client certificate and key should be provided to use TLS client authentication with ES")
+		}
+		certPEM, err := readPEM(cert, "cert")
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := readPEM(key, "key")
+		if err != nil {
+			return nil, err
+		}
+		keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "Not able to load client cert and/or key")
+		}
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+	}
+	if c.InsecureSkipVerify {
+		log.Printf("[WARN] insecure_skip_verify is set for elastic store, usage of this option is not recommended for production and may expose to MITM attack")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// Return the given value if it's a PEM encoded content, otherwise consider it as a path and read the file.
+func readPEM(valueOrPath string, description string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(valueOrPath), "-----BEGIN") {
+		return []byte(valueOrPath), nil
+	}
+	log.Printf("Reading %s file from %s", description, valueOrPath)
+	content, err := ioutil.ReadFile(valueOrPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Not able to read %s file from <%s>", description, valueOrPath)
+	}
+	return content, nil
+}
+
+// Returns true if the error is due to a TLS certificate verification failure.
+func isCertificateError(err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	return stderrors.As(err, &unknownAuthorityErr) || stderrors.As(err, &certInvalidErr) || stderrors.As(err, &hostnameErr)
+}
+
+// Returns a copy of the client configuration without credentials, so that it can be safely logged.
+func redactClientConfig(esConfig elasticsearch6.Config) elasticsearch6.Config {
+	if esConfig.Password != "" {
+		esConfig.Password = redacted
+	}
+	if esConfig.APIKey != "" {
+		esConfig.APIKey = redacted
+	}
+	return esConfig
 }
 
 // Init ES index for logs or events storage: create it if not found.
-func initStorageIndex(c *elasticsearch6.Client, elasticStoreConfig elasticStoreConf, storeType string) error {
+func initStorageIndex(ctx context.Context, c *esClient, elasticStoreConfig elasticStoreConf, storeType string) error {
+	err := createIndexIfNotExists(ctx, c, elasticStoreConfig, storeType, getIndexName(elasticStoreConfig, storeType))
+	if err != nil || elasticStoreConfig.IndexRolloverPeriod == "" {
+		return err
+	}
+	// Create the index of the current period, next ones will be created on the fly using the index template
+	return createIndexIfNotExists(ctx, c, elasticStoreConfig, storeType, getWriteIndexName(elasticStoreConfig, storeType, time.Now()))
+}
+
+// Indexes created by older versions or by hand may use dynamic mapping. Since event and log payloads
+// contain arbitrary keys, such indexes may hit the total fields limit and reject documents.
+// Disable dynamic mapping on existing indexes, the documents source is kept as is so nothing is lost.
+// Fields added to the mapping of the store type since the index creation are also added, they are only indexed for new documents.
+func ensureStaticMapping(ctx context.Context, c *esClient, conf elasticStoreConf, storeType, indexName string) error {
+	req := esapi.IndicesGetMappingRequest{
+		Index: []string{indexName},
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IndicesGetMappingRequest:"+indexName, res)
+	if err = handleESResponseError(res, "IndicesGetMappingRequest:"+indexName, "", err); err != nil {
+		return err
+	}
+	var rsp map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return errors.Wrapf(err, "failed to decode mapping of index %q", indexName)
+	}
+	mappingTypes := c.hasMappingTypes()
+	dynamicDisabled, upToDate := true, true
+	properties := mappingPropertiesNames(conf, storeType)
+	for _, index := range rsp {
+		if !isDynamicMappingDisabled(index.Mappings, mappingTypes) {
+			dynamicDisabled = false
+		}
+		for _, property := range properties {
+			if !hasMappingProperty(index.Mappings, mappingTypes, property) {
+				upToDate = false
+			}
+		}
+	}
+	if dynamicDisabled && upToDate {
+		return nil
+	}
 
-	indexName := getIndexName(elasticStoreConfig, storeType)
+	if dynamicDisabled {
+		log.Printf("Mapping of index %s is outdated, let's update it", indexName)
+	} else {
+		log.Printf("Dynamic mapping is enabled on index %s, let's disable it", indexName)
+	}
+	requestBodyData := buildStaticMappingQuery(conf, storeType)
+	putReq := esapi.IndicesPutMappingRequest{
+		Index: []string{indexName},
+		Body:  strings.NewReader(requestBodyData),
+	}
+	if mappingTypes {
+		putReq.DocumentType = "_doc"
+	}
+	putRes, err := putReq.Do(ctx, c)
+	defer closeResponseBody("IndicesPutMappingRequest:"+indexName, putRes)
+	return handleESResponseError(putRes, "IndicesPutMappingRequest:"+indexName, requestBodyData, err)
+}
+
+// Returns the mappings of documents, they are nested into the '_doc' mapping type if mappingTypes is true (ES 6.x).
+func getDocumentMappings(mappings map[string]interface{}, mappingTypes bool) map[string]interface{} {
+	if mappingTypes {
+		docMapping, _ := mappings["_doc"].(map[string]interface{})
+		return docMapping
+	}
+	return mappings
+}
+
+// Checks whether the given index mappings define the given property.
+func hasMappingProperty(mappings map[string]interface{}, mappingTypes bool, property string) bool {
+	properties, ok := getDocumentMappings(mappings, mappingTypes)["properties"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = properties[property]
+	return ok
+}
+
+// Checks whether the given index mappings disable dynamic mapping (either "false" or "strict").
+// Mappings are nested into the '_doc' mapping type if mappingTypes is true (ES 6.x).
+func isDynamicMappingDisabled(mappings map[string]interface{}, mappingTypes bool) bool {
+	mappings = getDocumentMappings(mappings, mappingTypes)
+	if mappings == nil {
+		return false
+	}
+	switch dynamic := mappings["dynamic"].(type) {
+	case bool:
+		return !dynamic
+	case string:
+		return dynamic == "false" || dynamic == "strict"
+	}
+	return false
+}
+
+func createIndexIfNotExists(ctx context.Context, c *esClient, elasticStoreConfig elasticStoreConf, storeType, indexName string) error {
+	ctx, cancel := withRequestTimeout(ctx, elasticStoreConfig)
+	defer cancel()
 	log.Printf("Checking if index <%s> already exists", indexName)
 
 	// check if the sequences index exists
@@ -114,7 +331,7 @@ func initStorageIndex(c *elasticsearch6.Client, elasticStoreConfig elasticStoreC
 		ExpandWildcards: "none",
 		AllowNoIndices:  &pfalse,
 	}
-	res, err := req.Do(context.Background(), c)
+	res, err := req.Do(ctx, c)
 	defer closeResponseBody("IndicesExistsRequest:"+indexName, res)
 
 	if err != nil {
@@ -122,21 +339,29 @@ func initStorageIndex(c *elasticsearch6.Client, elasticStoreConfig elasticStoreC
 	}
 
 	if res.StatusCode == 200 {
-		log.Printf("Indice %s was found, nothing to do !", indexName)
-		return nil
+		log.Printf("Indice %s was found, checking its mapping and settings", indexName)
+		if err = ensureStaticMapping(ctx, c, elasticStoreConfig, storeType, indexName); err != nil {
+			return err
+		}
+		return ensureIndexSettings(ctx, c, elasticStoreConfig, storeType, indexName)
 	} else if res.StatusCode == 404 {
 		log.Printf("Indice %s was not found, let's create it !", indexName)
 
-		requestBodyData := buildInitStorageIndexQuery(elasticStoreConfig)
+		requestBodyData := initStorageIndexQueryBuilders[storeType](elasticStoreConfig, c.hasMappingTypes())
 
 		// indice doest not exist, let's create it
 		req := esapi.IndicesCreateRequest{
 			Index: indexName,
 			Body:  strings.NewReader(requestBodyData),
 		}
-		res, err := req.Do(context.Background(), c)
+		res, err := req.Do(ctx, c)
 		defer closeResponseBody("IndicesCreateRequest:"+indexName, res)
 		if err = handleESResponseError(res, "IndicesCreateRequest:"+indexName, requestBodyData, err); err != nil {
+			// Another Yorc server sharing the ES cluster may have created the index meanwhile
+			if errors.Is(err, &ESError{StatusCode: http.StatusBadRequest, Type: "resource_already_exists_exception"}) {
+				log.Printf("Indice %s has been created concurrently, using it", indexName)
+				return nil
+			}
 			return err
 		}
 	} else {
@@ -145,14 +370,71 @@ func initStorageIndex(c *elasticsearch6.Client, elasticStoreConfig elasticStoreC
 	return nil
 }
 
+// The version of the index templates installed by Yorc, should be incremented each time index settings or mappings change.
+const indexTemplateVersion = 6
+
+// Install or update the index template used for the given store type, so that any index matching the store index name
+// (including rollover indexes) inherits the store settings and mappings.
+// An existing template is updated only if its version is older than indexTemplateVersion.
+func installIndexTemplate(ctx context.Context, c *esClient, elasticStoreConfig elasticStoreConf, storeType string) error {
+	ctx, cancel := withRequestTimeout(ctx, elasticStoreConfig)
+	defer cancel()
+	templateName := getIndexName(elasticStoreConfig, storeType)
+	log.Printf("Checking if index template <%s> is up to date", templateName)
+
+	req := esapi.IndicesGetTemplateRequest{Name: []string{templateName}}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IndicesGetTemplateRequest:"+templateName, res)
+	if err != nil {
+		return err
+	}
+	switch res.StatusCode {
+	case 200:
+		var templates map[string]struct {
+			Version int `json:"version"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&templates); err != nil {
+			return errors.Wrapf(err, "Not able to decode index template %s", templateName)
+		}
+		if current := templates[templateName].Version; current >= indexTemplateVersion {
+			log.Printf("Index template %s version %d is up to date, nothing to do !", templateName, current)
+			return nil
+		}
+		log.Printf("Index template %s is outdated, let's update it to version %d !", templateName, indexTemplateVersion)
+	case 404:
+		log.Printf("Index template %s was not found, let's create it !", templateName)
+	default:
+		return handleESResponseError(res, "IndicesGetTemplateRequest:"+templateName, "", err)
+	}
+
+	requestBodyData := buildIndexTemplateQuery(elasticStoreConfig, c.hasMappingTypes(), storeType, templateName+"*", indexTemplateVersion)
+	putReq := esapi.IndicesPutTemplateRequest{
+		Name: templateName,
+		Body: strings.NewReader(requestBodyData),
+	}
+	putRes, err := putReq.Do(ctx, c)
+	defer closeResponseBody("IndicesPutTemplateRequest:"+templateName, putRes)
+	return handleESResponseError(putRes, "IndicesPutTemplateRequest:"+templateName, requestBodyData, err)
+}
+
+// Returns a context derived from ctx and limited by the configured request timeout.
+func withRequestTimeout(ctx context.Context, conf elasticStoreConf) (context.Context, context.CancelFunc) {
+	if conf.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, conf.RequestTimeout)
+}
+
 // Perform a refresh query on ES cluster for this particular index.
-func refreshIndex(c *elasticsearch6.Client, indexName string) {
+func refreshIndex(ctx context.Context, c *esClient, conf elasticStoreConf, indexName string) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
 	req := esapi.IndicesRefreshRequest{
 		Index:           []string{indexName},
-		ExpandWildcards: "none",
-		AllowNoIndices:  &pfalse,
+		ExpandWildcards: "open",
+		AllowNoIndices:  &ptrue,
 	}
-	res, err := req.Do(context.Background(), c)
+	res, err := req.Do(ctx, c)
 	err = handleESResponseError(res, "IndicesRefreshRequest:"+indexName, "", err)
 	if err != nil {
 		log.Println("An error occurred while refreshing index, due to : %+v", err)
@@ -161,32 +443,76 @@ func refreshIndex(c *elasticsearch6.Client, indexName string) {
 }
 
 // Query ES for events or logs specifying the expected results 'size' and the sort 'order'.
-func doQueryEs(ctx context.Context, c *elasticsearch6.Client, conf elasticStoreConf,
+// When routing is set, only the shard holding the documents having this routing is searched.
+func doQueryEs(ctx context.Context, c *esClient, conf elasticStoreConf,
+	index string,
+	routing string,
+	query string,
+	waitIndex uint64,
+	size int,
+	order string,
+) (hits int, values []store.KeyValueOut, lastIndex uint64, err error) {
+	return doQueryEsSorted(ctx, c, conf, index, routing, query, waitIndex, size, defaultSortField, order)
+}
+
+// doQueryEsSorted is like doQueryEs but results are sorted by the given field (iid if empty), iid being used as tiebreaker.
+// When not sorted by iid, the returned lastIndex is the greatest iid of the results.
+func doQueryEsSorted(ctx context.Context, c *esClient, conf elasticStoreConf,
 	index string,
+	routing string,
 	query string,
 	waitIndex uint64,
 	size int,
+	sortField string,
 	order string,
 ) (hits int, values []store.KeyValueOut, lastIndex uint64, err error) {
 
 	log.Debugf("Search ES %s using query: %s", index, query)
 	lastIndex = waitIndex
+	size, err = checkQuerySizeAndOrder(conf, size, order)
+	if err != nil {
+		return
+	}
+	if sortField, err = checkSortField(sortField); err != nil {
+		return
+	}
+	if query, err = addSort(query, sortField, order); err != nil {
+		return
+	}
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			emitQueryFailureMetrics(index)
+		}
+	}()
 
-	res, e := c.Search(
-		c.Search.WithContext(ctx),
-		c.Search.WithIndex(index),
-		c.Search.WithSize(size),
-		c.Search.WithBody(strings.NewReader(query)),
+	req := esapi.SearchRequest{
+		Index: []string{index},
+		Size:  &size,
+		Body:  strings.NewReader(query),
+	}
+	if sortField == defaultSortField {
 		// important sort on iid
-		c.Search.WithSort("iid:"+order),
-	)
+		req.Sort = []string{"iid:" + order}
+	}
+	if routing != "" {
+		req.Routing = []string{routing}
+	}
+	if err = c.breaker.allow(); err != nil {
+		return
+	}
+	res, e := req.Do(ctx, c)
 	if e != nil {
 		err = errors.Wrapf(e, "Failed to perform ES search on index %s, query was: <%s>, error was: %+v", index, query, e)
+		c.breaker.record(err)
 		return
 	}
 	defer closeResponseBody("Search:"+index, res)
 
 	err = handleESResponseError(res, "Search:"+index, query, e)
+	c.breaker.record(err)
 	if err != nil {
 		return
 	}
@@ -202,91 +528,539 @@ func doQueryEs(ctx context.Context, c *elasticsearch6.Client, conf elasticStoreC
 
 	logShardsInfos(r)
 
-	hits = int(r["hits"].(map[string]interface{})["total"].(float64))
+	hits = getTotalHits(r)
 	duration := int(r["took"].(float64))
 	log.Debugf("Search ES request on index %s took %dms, hits=%d, response code was %d (%s)", index, duration, hits, res.StatusCode, res.Status())
+	emitQueryMetrics(index, hits, duration, start)
 
 	lastIndex = decodeEsQueryResponse(conf, index, waitIndex, size, r, &values)
+	if sortField != defaultSortField {
+		lastIndex = waitIndex
+		for _, v := range values {
+			if v.LastModifyIndex > lastIndex {
+				lastIndex = v.LastModifyIndex
+			}
+		}
+	}
 
 	log.Debugf("doQueryEs called result waitIndex: %d, LastIndex: %d, len(values): %d", waitIndex, lastIndex, len(values))
 	return hits, values, lastIndex, nil
 }
 
+// Ensure the query order is valid and return the query size clamped to the configured max query size.
+func checkQuerySizeAndOrder(conf elasticStoreConf, size int, order string) (int, error) {
+	if order != "asc" && order != "desc" {
+		return size, errors.Errorf("Invalid sort order %q for ES query, expecting asc or desc", order)
+	}
+	if size < 0 {
+		return size, errors.Errorf("Invalid size %d for ES query, it should not be negative", size)
+	}
+	if size > conf.MaxQuerySize {
+		log.Printf("Requested ES query size %d exceeds max_query_size, it is clamped to %d", size, conf.MaxQuerySize)
+		return conf.MaxQuerySize, nil
+	}
+	return size, nil
+}
+
 // Decode the response and define the last index
 func decodeEsQueryResponse(conf elasticStoreConf, index string, waitIndex uint64, size int, r map[string]interface{}, values *[]store.KeyValueOut) (lastIndex uint64) {
 	lastIndex = waitIndex
 	// Print the ID and document source for each hit.
 	i := 0
 	for _, hit := range r["hits"].(map[string]interface{})["hits"].([]interface{}) {
-		id := hit.(map[string]interface{})["_id"].(string)
-		source := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		iid := source["iidStr"]
-		iidUInt64, err := parseInt64StringToUint64(iid.(string))
-		if err != nil {
-			log.Printf("Not able to parse iid_str property %s as uint64, document id: %s, source: %+v, ignoring this document !", iid, id, source)
-		} else {
-			jsonString, err := json.Marshal(source)
-			if err != nil {
-				log.Printf("Not able to marshall document source, document id: %s, source: %+v, ignoring this document !", id, source)
-			} else {
-				// since the result is sorted on iid, we can use the last hit to define lastIndex
-				lastIndex = iidUInt64
-				if conf.traceEvents {
-					i++
-					waitTimestamp := _getTimestampFromUint64(waitIndex)
-					iidInt64 := _parseInt64StringToInt64(iid.(string))
-					iidTimestamp := time.Unix(0, iidInt64)
-					log.Printf("ESList-%s;%d,%v,%d,%d,%s,%v,%d,%d",
-						index, waitIndex, waitTimestamp, size, i, iid, iidTimestamp, iidInt64, lastIndex)
-				}
-				// append value to result
-				*values = append(*values, store.KeyValueOut{
-					Key:             id,
-					LastModifyIndex: iidUInt64,
-					Value:           source,
-					RawValue:        jsonString,
-				})
-			}
+		kv, ok := decodeEsHit(hit)
+		if !ok {
+			continue
 		}
+		// since the result is sorted on iid, we can use the last hit to define lastIndex
+		lastIndex = kv.LastModifyIndex
+		if conf.traceEvents {
+			i++
+			traceEsHit(index, waitIndex, size, i, kv)
+		}
+		// append value to result
+		*values = append(*values, kv)
 	}
 	return
 }
 
+// Decode a single search hit, returns false if the document should be ignored.
+// The iid is read from the iidStr property, or from the iid property if missing, it may be a string or a number.
+func decodeEsHit(rawHit interface{}) (store.KeyValueOut, bool) {
+	hit, _ := rawHit.(map[string]interface{})
+	id, _ := hit["_id"].(string)
+	source, ok := hit["_source"].(map[string]interface{})
+	if !ok {
+		log.Printf("Not able to read the source of document id: %s, ignoring this document !", id)
+		return store.KeyValueOut{}, false
+	}
+	iid, ok := source["iidStr"]
+	if !ok {
+		iid = source["iid"]
+	}
+	iidUInt64, err := parseIID(iid)
+	if err != nil {
+		log.Printf("Not able to parse iid_str property %v as uint64, document id: %s, source: %+v, ignoring this document ! %v", iid, id, source, err)
+		return store.KeyValueOut{}, false
+	}
+	jsonString, err := json.Marshal(source)
+	if err != nil {
+		log.Printf("Not able to marshall document source, document id: %s, source: %+v, ignoring this document !", id, source)
+		return store.KeyValueOut{}, false
+	}
+	return store.KeyValueOut{
+		Key:             id,
+		LastModifyIndex: iidUInt64,
+		Value:           source,
+		RawValue:        jsonString,
+	}, true
+}
+
+func traceEsHit(index string, waitIndex uint64, size int, i int, kv store.KeyValueOut) {
+	waitTimestamp := _getTimestampFromUint64(waitIndex)
+	iidInt64 := int64(kv.LastModifyIndex)
+	iidTimestamp := time.Unix(0, iidInt64)
+	log.Printf("ESList-%s;%d,%v,%d,%d,%d,%v,%d,%d",
+		index, waitIndex, waitTimestamp, size, i, iidInt64, iidTimestamp, iidInt64, kv.LastModifyIndex)
+}
+
+// How long ES should keep the search context alive between two pages of a streaming query
+const esScrollKeepAlive = time.Minute
+
+// esQueryStream holds the results of a streaming query.
+//
+// Values are emitted on the channel returned by Values(), which is closed when all the results have been read,
+// when an error occurs or when the query context is cancelled.
+// Hits, LastIndex and Err should be called only once the values channel is closed.
+type esQueryStream struct {
+	values    chan store.KeyValueOut
+	hits      int
+	lastIndex uint64
+	err       error
+}
+
+// Values returns the channel on which results are emitted.
+func (s *esQueryStream) Values() <-chan store.KeyValueOut {
+	return s.values
+}
+
+// Hits returns the total number of documents matching the query.
+func (s *esQueryStream) Hits() int {
+	return s.hits
+}
+
+// LastIndex returns the index of the last emitted value, or the waitIndex if no value was emitted.
+func (s *esQueryStream) LastIndex() uint64 {
+	return s.lastIndex
+}
+
+// Err returns the error that stopped the stream if any.
+func (s *esQueryStream) Err() error {
+	return s.err
+}
+
+// doQueryEsStream performs the same search as doQueryEs but instead of loading all the hits in memory, it uses the ES scroll API
+// to retrieve results by pages of pageSize documents and emits them on a buffered channel as soon as they are received.
+// The scroll context is cleared once the stream ends, including when the query context is cancelled.
+func doQueryEsStream(ctx context.Context, c *esClient, conf elasticStoreConf,
+	index string,
+	routing string,
+	query string,
+	waitIndex uint64,
+	pageSize int,
+	order string,
+) *esQueryStream {
+	s := &esQueryStream{lastIndex: waitIndex}
+	pageSize, s.err = checkQuerySizeAndOrder(conf, pageSize, order)
+	if s.err != nil {
+		s.values = make(chan store.KeyValueOut)
+		close(s.values)
+		return s
+	}
+	s.values = make(chan store.KeyValueOut, pageSize)
+	go s.run(ctx, c, conf, index, routing, query, waitIndex, pageSize, order)
+	return s
+}
+
+func (s *esQueryStream) run(ctx context.Context, c *esClient, conf elasticStoreConf, index, routing, query string, waitIndex uint64, pageSize int, order string) {
+	defer close(s.values)
+
+	log.Debugf("Stream search ES %s using query: %s", index, query)
+	req := esapi.SearchRequest{
+		Index: []string{index},
+		Size:  &pageSize,
+		Body:  strings.NewReader(query),
+		// important sort on iid
+		Sort:   []string{"iid:" + order},
+		Scroll: esScrollKeepAlive,
+	}
+	if routing != "" {
+		req.Routing = []string{routing}
+	}
+	if s.err = c.breaker.allow(); s.err != nil {
+		return
+	}
+	res, err := req.Do(ctx, c)
+	requestName := "Search:" + index
+	firstPage := true
+	i := 0
+	var scrollID string
+	defer func() {
+		clearScroll(c, conf, index, scrollID)
+	}()
+	for {
+		var r map[string]interface{}
+		r, s.err = decodeEsScrollResponse(res, err, requestName, query)
+		if firstPage {
+			c.breaker.record(s.err)
+		}
+		if s.err != nil {
+			return
+		}
+		if id, ok := r["_scroll_id"].(string); ok && id != "" {
+			scrollID = id
+		}
+		if firstPage {
+			logShardsInfos(r)
+			s.hits = getTotalHits(r)
+			firstPage = false
+		}
+		pageHits := r["hits"].(map[string]interface{})["hits"].([]interface{})
+		if len(pageHits) == 0 {
+			log.Debugf("Stream search ES %s done, hits=%d, lastIndex=%d", index, s.hits, s.lastIndex)
+			return
+		}
+		for _, hit := range pageHits {
+			kv, ok := decodeEsHit(hit)
+			if !ok {
+				continue
+			}
+			if conf.traceEvents {
+				i++
+				traceEsHit(index, waitIndex, pageSize, i, kv)
+			}
+			select {
+			case s.values <- kv:
+				// since the result is sorted on iid, we can use the last emitted hit to define lastIndex
+				s.lastIndex = kv.LastModifyIndex
+			case <-ctx.Done():
+				s.err = errors.Wrapf(ctx.Err(), "Stream search ES %s interrupted", index)
+				return
+			}
+		}
+
+		requestName = "Scroll:" + index
+		body := fmt.Sprintf(`{"scroll": %q, "scroll_id": %q}`, esScrollKeepAlive.String(), scrollID)
+		res, err = esapi.ScrollRequest{Body: strings.NewReader(body)}.Do(ctx, c)
+	}
+}
+
+// Releases the given scroll context on ES rather than letting it expire, nothing is done if the scroll ID is empty.
+// The query context may be cancelled, so a new one is used. Failures are only logged as the context will expire anyway.
+func clearScroll(c *esClient, conf elasticStoreConf, index, scrollID string) {
+	if scrollID == "" {
+		return
+	}
+	ctx, cancel := withRequestTimeout(context.Background(), conf)
+	defer cancel()
+	body := fmt.Sprintf(`{"scroll_id": [%q]}`, scrollID)
+	res, err := esapi.ClearScrollRequest{Body: strings.NewReader(body)}.Do(ctx, c)
+	defer closeResponseBody("ClearScroll:"+index, res)
+	// A 404 means the scroll context has already expired
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		return
+	}
+	if err = handleESResponseError(res, "ClearScroll:"+index, body, err); err != nil {
+		log.Debugf("Failed to clear scroll context of index %s: %v", index, err)
+	}
+}
+
+// Check and decode a search or scroll response, the response body is closed.
+func decodeEsScrollResponse(res *esapi.Response, err error, requestName, query string) (map[string]interface{}, error) {
+	defer closeResponseBody(requestName, res)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to perform ES %s, query was: <%s>", requestName, query)
+	}
+	if err = handleESResponseError(res, requestName, query, err); err != nil {
+		return nil, err
+	}
+	var r map[string]interface{}
+	if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, errors.Wrapf(err,
+			"Not able to decode ES response while performing ES %s, query was: <%s>, response code was %d (%s)",
+			requestName, query, res.StatusCode, res.Status(),
+		)
+	}
+	return r, nil
+}
+
+// Status codes returned by ES for which a bulk request or a bulk operation can be retried
+var bulkRetryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusServiceUnavailable: true,
+}
+
+// A bulk operation that ES was not able to handle
+type bulkOperationFailure struct {
+	// The bulk operation (action and document lines)
+	operation []byte
+	status    int
+	errType   string
+	errReason string
+}
+
+// The response of a bulk request, only the fields we need.
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// The response of the last index aggregation query (see esQuery.lastIndex).
+type lastIndexResponse struct {
+	Hits         hits `json:"hits"`
+	Aggregations struct {
+		LogsOrEvents logOrEventAggregation `json:"max_iid"`
+	} `json:"aggregations"`
+}
+
+type hits struct {
+	Total totalHits `json:"total"`
+}
+
+// totalHits decodes hits.total as a number (ES 6.x) or as an object like {"value": N, "relation": "eq"} (ES 7+).
+type totalHits int
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *totalHits) UnmarshalJSON(data []byte) error {
+	var total struct {
+		Value int `json:"value"`
+	}
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &total); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, &total.Value); err != nil {
+		return err
+	}
+	*t = totalHits(total.Value)
+	return nil
+}
+
+type logOrEventAggregation struct {
+	DocCount  int         `json:"doc_count"`
+	LastIndex stringValue `json:"last_index"`
+}
+
+type stringValue struct {
+	// ES returns aggregations as float, we have a precision loss of few ns
+	Value         float64 `json:"value"`
+	ValueAsString string  `json:"value_as_string"`
+}
+
 // Send the bulk request to ES and ensure no error is returned.
-func sendBulkRequest(c *elasticsearch6.Client, opeCount int, body *[]byte) error {
-	log.Printf("About to bulk request containing %d operations (%d bytes)", opeCount, len(*body))
+// When ES rejects the request with a retryable status code (429 or 503), the request is retried using an exponential backoff.
+// Other errors (mapping or validation errors for instance) are not retried.
+// When the request succeeds but some operations failed, only the failed operations having a retryable status are resent
+// using the same backoff. The operations that still fail are returned, so the caller can decide what to do with them.
+// When max_in_flight_bulk_requests is reached, the request waits for another one to complete, up to the backpressure timeout.
+// While the circuit breaker is open or ES indexes are read-only, the request is not sent and the remaining attempts are skipped.
+func sendBulkRequest(ctx context.Context, c *esClient, conf elasticStoreConf, opeCount int, body *[]byte) ([]bulkOperationFailure, error) {
+	release, err := c.bulkLimiter.acquire(ctx, conf.BufferBackpressureTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if conf.BulkCompression {
+		log.Printf("About to bulk request containing %d operations (%d bytes uncompressed, will be gzip compressed)", opeCount, len(*body))
+	} else {
+		log.Printf("About to bulk request containing %d operations (%d bytes)", opeCount, len(*body))
+	}
 	if log.IsDebug() {
 		log.Debugf("About to send bulk request query to ES: %s", string(*body))
 	}
 
-	// Prepare ES bulk request
+	start := time.Now()
+	operations := splitBulkOperations(*body)
+	index := bulkIndex(operations)
+	var failures, pending []bulkOperationFailure
+	var attempt int
+	var lastErr error
+	err = retry.Do(ctx, newBulkRetryBackoff(conf), func(ctx context.Context) error {
+		attempt++
+		if err := c.writeBlock.allow(); err != nil {
+			lastErr = err
+			return err
+		}
+		if err := c.breaker.allow(); err != nil {
+			lastErr = err
+			return err
+		}
+		attemptCtx, cancel := withRequestTimeout(ctx, conf)
+		defer cancel()
+		opeFailures, err := doSendBulkRequest(attemptCtx, c, conf, operations)
+		c.breaker.record(err)
+		if c.writeBlock.record(err, opeFailures) {
+			logNodesDiskUsage(ctx, c, conf)
+		}
+		lastErr = err
+		if err != nil {
+			if statusCode := esErrorStatusCode(err); bulkRetryableStatusCodes[statusCode] {
+				log.Printf("[WARN] Bulk request attempt %d failed with status code %d", attempt, statusCode)
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		// Resend only the operations that failed with a retryable status
+		pending = pending[:0]
+		operations = operations[:0]
+		for _, f := range opeFailures {
+			if bulkRetryableStatusCodes[f.status] {
+				pending = append(pending, f)
+				operations = append(operations, f.operation)
+			} else {
+				failures = append(failures, f)
+			}
+		}
+		if len(pending) > 0 {
+			log.Printf("[WARN] Bulk request attempt %d: %d operations have been rejected, they will be resent", attempt, len(pending))
+			return retry.RetryableError(errors.Errorf("%d bulk operations have been rejected", len(pending)))
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		emitBulkMetrics(index, opeCount, len(*body), attempt, opeCount, start)
+		return nil, errors.Wrapf(ctx.Err(), "Bulk request containing %d operations cancelled after %d attempt(s)", opeCount, attempt)
+	}
+	if err != nil && lastErr != nil {
+		emitBulkMetrics(index, opeCount, len(*body), attempt, opeCount, start)
+		return nil, errors.Wrapf(lastErr, "Bulk request containing %d operations failed after %d attempt(s)", opeCount, attempt)
+	}
+	failures = append(failures, pending...)
+	emitBulkMetrics(index, opeCount, len(*body), attempt, len(failures), start)
+	if len(failures) > 0 {
+		log.Printf("[WARN] Bulk request containing %d operations (%d bytes) has been accepted but %d operations failed permanently", opeCount, len(*body), len(failures))
+		return failures, nil
+	}
+	log.Printf("Bulk request containing %d operations (%d bytes) has been accepted successfully", opeCount, len(*body))
+	return nil, nil
+}
+
+// Build the backoff used to retry bulk requests: exponential delays limited by a number of retries and a max elapsed time.
+func newBulkRetryBackoff(conf elasticStoreConf) retry.Backoff {
+	// The base delay is validated when reading the configuration, so no error can occur here
+	b, _ := retry.NewExponential(conf.BulkRetryBaseDelay)
+	b = retry.WithMaxDuration(conf.BulkRetryMaxElapsedTime, retry.WithMaxRetries(uint64(conf.BulkMaxRetries), b))
+	return retry.BackoffFunc(func() (time.Duration, bool) {
+		delay, stop := b.Next()
+		if !stop {
+			log.Printf("Bulk request will be retried in %v", delay)
+		}
+		return delay, stop
+	})
+}
+
+// Compress the given bytes using gzip and the given compression level.
+func gzipBytes(b []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Split a bulk request body into operations: each operation is made of an action line and a document line.
+func splitBulkOperations(body []byte) [][]byte {
+	lines := bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+	operations := make([][]byte, 0, len(lines)/2)
+	for i := 0; i+1 < len(lines); i += 2 {
+		operation := make([]byte, 0, len(lines[i])+len(lines[i+1])+2)
+		operation = append(operation, lines[i]...)
+		operation = append(operation, '\n')
+		operation = append(operation, lines[i+1]...)
+		operation = append(operation, '\n')
+		operations = append(operations, operation)
+	}
+	return operations
+}
+
+// Send a single bulk request made of the given operations, the operations that failed are returned.
+// If ES rejects the whole request, an ESError is returned.
+func doSendBulkRequest(ctx context.Context, c *esClient, conf elasticStoreConf, operations [][]byte) ([]bulkOperationFailure, error) {
+	// The bulk request must be terminated by a newline
+	body := append(bytes.Join(operations, nil), '\n')
 	req := esapi.BulkRequest{
-		Body: bytes.NewReader(*body),
+		Body:     bytes.NewReader(body),
+		Refresh:  conf.BulkRefresh,
+		Pipeline: c.ingestPipeline,
 	}
-	res, err := req.Do(context.Background(), c)
+	if conf.BulkCompression {
+		compressed, err := gzipBytes(body, conf.BulkCompressionLevel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Not able to compress bulk request body")
+		}
+		log.Printf("Bulk request body compressed from %d bytes to %d bytes", len(body), len(compressed))
+		req.Body = bytes.NewReader(compressed)
+		req.Header = http.Header{"Content-Encoding": []string{"gzip"}}
+	}
+	res, err := req.Do(ctx, c)
 	defer closeResponseBody("BulkRequest", res)
 
 	if err != nil {
-		return err
+		return nil, err
 	} else if res.IsError() {
-		return handleESResponseError(res, "BulkRequest", string(*body), err)
-	} else {
-		var rsp map[string]interface{}
-		err = json.NewDecoder(res.Body).Decode(&rsp)
-		if err != nil {
-			// Don't know if the bulk request response contains error so fail by default
-			return errors.Errorf(
-				"The bulk request succeeded (%s), but not able to decode the response, so not able to determine if bulk operations are correctly handled",
-				res.Status(),
-			)
-		}
-		if rsp["errors"].(bool) {
-			// The bulk request contains errors
-			return errors.Errorf("The bulk request succeeded, but the response contains errors : %+v", rsp)
+		return nil, handleESResponseError(res, "BulkRequest", string(body), err)
+	}
+	var rsp bulkResponse
+	err = json.NewDecoder(res.Body).Decode(&rsp)
+	if err != nil {
+		// Don't know if the bulk request response contains error so fail by default
+		return nil, errors.Errorf(
+			"The bulk request succeeded (%s), but not able to decode the response, so not able to determine if bulk operations are correctly handled",
+			res.Status(),
+		)
+	}
+	if !rsp.Errors {
+		return nil, nil
+	}
+	if len(rsp.Items) != len(operations) {
+		return nil, errors.Errorf("The bulk request succeeded, but the response contains errors and %d items for %d operations", len(rsp.Items), len(operations))
+	}
+	// Items are returned in the same order as the operations
+	var failures []bulkOperationFailure
+	for i, item := range rsp.Items {
+		for action, result := range item {
+			if result.Error == nil {
+				continue
+			}
+			if (action == "create" || conf.DocumentIDStrategy == versionedDocumentIDs) && result.Status == http.StatusConflict {
+				// The document has already been indexed, by a previous attempt of this request for instance
+				log.Debugf("Document already indexed, bulk operation was: %s", string(operations[i]))
+				continue
+			}
+			failures = append(failures, bulkOperationFailure{
+				operation: operations[i],
+				status:    result.Status,
+				errType:   result.Error.Type,
+				errReason: result.Error.Reason,
+			})
 		}
 	}
-	log.Printf("Bulk request containing %d operations (%d bytes) has been accepted successfully", opeCount, len(*body))
-	return nil
+	return failures, nil
 }
 
 // Consider the ES Response and wrap errors when needed
@@ -295,9 +1069,11 @@ func handleESResponseError(res *esapi.Response, requestDescription string, query
 		return errors.Wrapf(requestError, "Error while sending %s, query was: %s", requestDescription, query)
 	}
 	if res.IsError() {
-		return errors.Errorf(
-			"An error was returned by ES while sending %s, status was %s, query was: %s, response: %+v",
-			requestDescription, res.Status(), query, res.String())
+		esErr := newESError(res, requestDescription, query)
+		if isDiskWatermarkBlock(esErr.Type, esErr.Reason) {
+			return errors.Wrap(esErr, diskWatermarkBlockMsg)
+		}
+		return esErr
 	}
 	return nil
 }
@@ -326,10 +1102,15 @@ func logShardsInfos(r map[string]interface{}) {
 	}
 }
 
-type debugLogger struct{}
+type debugLogger struct {
+	// When false, only the requests metadata are logged
+	bodies bool
+	// The values of the JSON fields matching this regexp are redacted from the logged bodies
+	redactedFields *regexp.Regexp
+}
 
 // RequestBodyEnabled makes the client pass request body to logger
-func (l *debugLogger) RequestBodyEnabled() bool { return true }
+func (l *debugLogger) RequestBodyEnabled() bool { return l.bodies }
 
 // ResponseBodyEnabled makes the client pass response body to logger
 func (l *debugLogger) ResponseBodyEnabled() bool { return true }
@@ -357,19 +1138,28 @@ func (l *debugLogger) LogRoundTrip(
 		level = "Unknown"
 	}
 
+	var statusCode int
 	var reqBuffer, resBuffer bytes.Buffer
-	if req != nil && req.Body != nil && req.Body != http.NoBody {
+	if req != nil && req.Body != nil && req.Body != http.NoBody && l.bodies {
 		// We explicitly ignore errors here since it's a debug feature
 		_, _ = io.Copy(&reqBuffer, req.Body)
 	}
-	reqStr := reqBuffer.String()
-	if res != nil && res.Body != nil && res.Body != http.NoBody {
-		// We explicitly ignore errors here since it's a debug feature
-		_, _ = io.Copy(&resBuffer, res.Body)
+	if res != nil {
+		statusCode = res.StatusCode
+		if res.Body != nil && res.Body != http.NoBody {
+			// We explicitly ignore errors here since it's a debug feature
+			_, _ = io.Copy(&resBuffer, res.Body)
+		}
+	}
+	if !l.bodies {
+		log.Printf("ES Request [%s][%v][%s][%s][%d][%v] [%s]",
+			level, start, req.Method, req.URL.String(), statusCode, dur, responseMetadata(resBuffer.Bytes()))
+		return nil
 	}
-	resStr := resBuffer.String()
+	reqStr := redactBody(reqBuffer.Bytes(), l.redactedFields)
+	resStr := redactBody(resBuffer.Bytes(), l.redactedFields)
 	log.Printf("ES Request [%s][%v][%s][%s][%d][%v] [%+v] : [%+v]",
-		level, start, req.Method, req.URL.String(), res.StatusCode, dur, reqStr, resStr)
+		level, start, req.Method, req.URL.String(), statusCode, dur, reqStr, resStr)
 
 	return nil
 }
@@ -0,0 +1,268 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastIndexResponseDecoding(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      string
+		wantTotal     totalHits
+		wantDocCount  int
+		wantLastIndex uint64
+	}{
+		{"ES6Response", `{
+  "took": 3,
+  "timed_out": false,
+  "_shards": {"total": 5, "successful": 5, "skipped": 0, "failed": 0},
+  "hits": {"total": 42, "max_score": 0.0, "hits": []},
+  "aggregations": {
+    "max_iid": {
+      "doc_count": 12,
+      "last_index": {"value": 1.5846567385913344E18, "value_as_string": "1584656738591334400"}
+    }
+  }
+}`, 42, 12, 1584656738591334400},
+		{"ES7Response", `{
+  "took": 1,
+  "timed_out": false,
+  "_shards": {"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+  "hits": {"total": {"value": 10000, "relation": "gte"}, "max_score": null, "hits": []},
+  "aggregations": {
+    "max_iid": {
+      "doc_count": 3,
+      "last_index": {"value": 1.5846567385913344E18}
+    }
+  }
+}`, 10000, 3, 1584656738591334400},
+		{"EmptyIndex", `{
+  "hits": {"total": {"value": 0, "relation": "eq"}, "hits": []},
+  "aggregations": {"max_iid": {"doc_count": 0, "last_index": {"value": null}}}
+}`, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r lastIndexResponse
+			err := json.Unmarshal([]byte(tt.response), &r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTotal, r.Hits.Total)
+			assert.Equal(t, tt.wantDocCount, r.Aggregations.LogsOrEvents.DocCount)
+			assert.Equal(t, tt.wantLastIndex, uint64(r.Aggregations.LogsOrEvents.LastIndex.Value))
+		})
+	}
+}
+
+func TestTotalHitsDecodingError(t *testing.T) {
+	var h hits
+	err := json.Unmarshal([]byte(`{"total": "not a number"}`), &h)
+	assert.Error(t, err)
+}
+
+func TestCheckQuerySizeAndOrder(t *testing.T) {
+	conf := elasticStoreConf{MaxQuerySize: 1000}
+	tests := []struct {
+		name     string
+		size     int
+		order    string
+		wantSize int
+		wantErr  bool
+	}{
+		{"SizeUnderMax", 10, "asc", 10, false},
+		{"SizeEqualsMax", 1000, "desc", 1000, false},
+		{"SizeClamped", 1000000, "asc", 1000, false},
+		{"ZeroSize", 0, "desc", 0, false},
+		{"NegativeSize", -1, "asc", 0, true},
+		{"EmptyOrder", 10, "", 0, true},
+		{"UpperCaseOrder", 10, "ASC", 0, true},
+		{"InvalidOrder", 10, "asc,iid:desc", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := checkQuerySizeAndOrder(conf, tt.size, tt.order)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSize, size)
+		})
+	}
+}
+
+func TestIsDynamicMappingDisabled(t *testing.T) {
+	tests := []struct {
+		name         string
+		mappings     string
+		mappingTypes bool
+		want         bool
+	}{
+		{"StaticMapping", `{"dynamic":"false","properties":{}}`, false, true},
+		{"StrictMapping", `{"dynamic":"strict"}`, false, true},
+		{"BooleanFalse", `{"dynamic":false}`, false, true},
+		{"DynamicMapping", `{"dynamic":"true"}`, false, false},
+		{"DefaultMapping", `{"properties":{}}`, false, false},
+		{"StaticMappingType", `{"_doc":{"dynamic":"false"}}`, true, true},
+		{"DynamicMappingType", `{"_doc":{"properties":{}}}`, true, false},
+		{"MissingMappingType", `{}`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mappings map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.mappings), &mappings))
+			assert.Equal(t, tt.want, isDynamicMappingDisabled(mappings, tt.mappingTypes))
+		})
+	}
+}
+
+func TestHasMappingProperty(t *testing.T) {
+	tests := []struct {
+		name         string
+		mappings     string
+		mappingTypes bool
+		want         bool
+	}{
+		{"Property", `{"dynamic":"false","properties":{"nodeId":{"type":"keyword"}}}`, false, true},
+		{"MissingProperty", `{"dynamic":"false","properties":{"level":{"type":"keyword"}}}`, false, false},
+		{"NoProperties", `{"dynamic":"false"}`, false, false},
+		{"PropertyMappingType", `{"_doc":{"properties":{"nodeId":{"type":"keyword"}}}}`, true, true},
+		{"MissingMappingType", `{}`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mappings map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.mappings), &mappings))
+			assert.Equal(t, tt.want, hasMappingProperty(mappings, tt.mappingTypes, "nodeId"))
+		})
+	}
+}
+
+func TestQueryEsStreamClearScroll(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+	}{
+		{"Completed", false},
+		{"Cancelled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var cleared []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/yorc_c_logs/_search":
+					w.Write([]byte(`{"_scroll_id":"scroll-1","took":1,"_shards":{"total":1,"successful":1},"hits":{"total":{"value":3},"hits":[` +
+						`{"_id":"1","_source":{"iidStr":"1"}},{"_id":"2","_source":{"iidStr":"2"}}]}}`))
+				case r.URL.Path == "/_search/scroll" && r.Method == http.MethodDelete:
+					body, _ := ioutil.ReadAll(r.Body)
+					mu.Lock()
+					cleared = append(cleared, string(body))
+					mu.Unlock()
+					w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+				case r.URL.Path == "/_search/scroll":
+					w.Write([]byte(`{"_scroll_id":"scroll-2","took":1,"_shards":{"total":1,"successful":1},"hits":{"total":{"value":3},"hits":[]}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			c := &esClient{Transport: t6, majorVersion: 7}
+			conf := elasticStoreConf{MaxQuerySize: 1000, RequestTimeout: time.Second}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// A page size of 1 lets the stream block on the second value until it is read
+			stream := doQueryEsStream(ctx, c, conf, "yorc_c_logs", "", `{"query":{"match_all":{}}}`, 0, 1, "asc")
+			wantScrollID := "scroll-2"
+			if tt.cancel {
+				<-stream.Values()
+				cancel()
+				wantScrollID = "scroll-1"
+			}
+			for range stream.Values() {
+			}
+			if tt.cancel {
+				assert.Error(t, stream.Err())
+			} else {
+				assert.NoError(t, stream.Err())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, []string{`{"scroll_id": ["` + wantScrollID + `"]}`}, cleared)
+		})
+	}
+}
+
+func TestCreateIndexIfNotExistsConcurrently(t *testing.T) {
+	tests := []struct {
+		name         string
+		createStatus int
+		createBody   string
+		wantErr      bool
+	}{
+		{"Created", http.StatusOK, `{"acknowledged":true,"shards_acknowledged":true,"index":"yorc_c_logs"}`, false},
+		{"CreatedByAnotherServer", http.StatusBadRequest,
+			`{"error":{"root_cause":[],"type":"resource_already_exists_exception","reason":"index [yorc_c_logs/abc] already exists","index":"yorc_c_logs"},"status":400}`, false},
+		{"InvalidSettings", http.StatusBadRequest, `{"error":{"type":"illegal_argument_exception","reason":"unknown setting"},"status":400}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/yorc_c_logs", r.URL.Path)
+				switch r.Method {
+				case http.MethodHead:
+					// The index doesn't exist when checked
+					w.WriteHeader(http.StatusNotFound)
+				case http.MethodPut:
+					created = true
+					w.WriteHeader(tt.createStatus)
+					w.Write([]byte(tt.createBody))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", RequestTimeout: time.Second}
+
+			err = createIndexIfNotExists(context.Background(), &esClient{Transport: t6, majorVersion: 7}, conf, "logs", "yorc_c_logs")
+			assert.True(t, created)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// Export writes the logs or events stored under the given key (ie. "_yorc/events" or "_yorc/logs/MyApp") to w as NDJSON,
+// one document per line, and returns the number of exported documents.
+// The index is read using a sliced scroll: export_slices slices, bounded by the number of shards, are read in parallel.
+// Documents of a given slice are written in iid order, documents of different slices are interleaved.
+func (s *elasticStore) Export(ctx context.Context, k string, w io.Writer) (int, error) {
+	if err := s.checkInitialized(); err != nil {
+		return 0, err
+	}
+	storeType, deploymentID := extractStoreTypeAndDeploymentID(k)
+	indexName := getReadIndexName(s.cfg, storeType)
+
+	ctx, cancel := context.WithCancel(ctx)
+	// Stops the slices streams if writing fails
+	defer cancel()
+	streams, err := exportIndex(ctx, s.esClient, s.cfg, indexName, deploymentID)
+	if err != nil {
+		return 0, err
+	}
+	return writeNDJSON(ctx, streams, w)
+}
+
+// Starts the sliced scroll of the given index, one stream is returned per slice.
+func exportIndex(ctx context.Context, c *esClient, conf elasticStoreConf, indexName, deploymentID string) ([]*esQueryStream, error) {
+	shards, err := getShardsCount(ctx, c, conf, indexName)
+	if err != nil {
+		return nil, err
+	}
+	slices := conf.ExportSlices
+	if slices > shards {
+		slices = shards
+	}
+	if slices < 1 {
+		slices = 1
+	}
+	log.Printf("Exporting index %s (%d shards) using %d slices", indexName, shards, slices)
+	streams := make([]*esQueryStream, slices)
+	for i := range streams {
+		streams[i] = doQueryEsStream(ctx, c, conf, indexName, getDeploymentRouting(conf, deploymentID), newESQuery().deployment(deploymentID).slice(i, slices).String(), 0, conf.MaxQuerySize, "asc")
+	}
+	return streams, nil
+}
+
+// Returns the total number of primary shards of the indexes matching the given name.
+func getShardsCount(ctx context.Context, c *esClient, conf elasticStoreConf, indexName string) (int, error) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	req := esapi.IndicesGetSettingsRequest{
+		Index:        []string{indexName},
+		Name:         []string{"index.number_of_shards"},
+		FlatSettings: &ptrue,
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IndicesGetSettingsRequest:"+indexName, res)
+	if err = handleESResponseError(res, "IndicesGetSettingsRequest:"+indexName, "", err); err != nil {
+		return 0, err
+	}
+	var rsp map[string]struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return 0, errors.Wrapf(err, "failed to decode settings of index %q", indexName)
+	}
+	shards := 0
+	for name, index := range rsp {
+		n, err := strconv.Atoi(index.Settings["index.number_of_shards"])
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to parse the number of shards of index %q", name)
+		}
+		shards += n
+	}
+	return shards, nil
+}
+
+// Writes the values emitted by the given streams as NDJSON, the values of each stream are written in the order they are emitted.
+func writeNDJSON(ctx context.Context, streams []*esQueryStream, w io.Writer) (int, error) {
+	merged := make(chan store.KeyValueOut)
+	var wg sync.WaitGroup
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream *esQueryStream) {
+			defer wg.Done()
+			for kv := range stream.Values() {
+				select {
+				case merged <- kv:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(stream)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	bw := bufio.NewWriter(w)
+	count := 0
+	for kv := range merged {
+		if _, err := bw.Write(kv.RawValue); err != nil {
+			return count, errors.Wrap(err, "failed to write exported document")
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return count, errors.Wrap(err, "failed to write exported document")
+		}
+		count++
+	}
+	if err := ctx.Err(); err != nil {
+		return count, errors.Wrap(err, "export interrupted")
+	}
+	for i, stream := range streams {
+		if err := stream.Err(); err != nil {
+			return count, errors.Wrapf(err, "failed to export slice %d", i)
+		}
+	}
+	return count, errors.Wrap(bw.Flush(), "failed to write exported documents")
+}
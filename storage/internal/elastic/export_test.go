@@ -0,0 +1,127 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake ES cluster serving an events index of 2 shards, each slice of a sliced scroll returns 3 documents.
+func newFakeExportServer(t *testing.T, mu *sync.Mutex, slices *[]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/yorc_c_events/_settings/index.number_of_shards":
+			w.Write([]byte(`{"yorc_c_events": {"settings": {"index.number_of_shards": "2"}}}`))
+		case "/yorc_c_events/_search":
+			var query struct {
+				Slice struct {
+					ID  int `json:"id"`
+					Max int `json:"max"`
+				} `json:"slice"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			mu.Lock()
+			*slices = append(*slices, query.Slice.Max)
+			mu.Unlock()
+			hits := make([]string, 3)
+			for i := range hits {
+				iid := (query.Slice.ID+1)*100 + i
+				hits[i] = fmt.Sprintf(`{"_id":"%d","_source":{"deploymentId":"MyApp","iid":"%d","iidStr":"%d","slice":%d}}`, iid, iid, iid, query.Slice.ID)
+			}
+			fmt.Fprintf(w, `{"_scroll_id":"slice-%d","took":1,"_shards":{"total":1,"successful":1},"hits":{"total":{"value":3},"hits":[%s]}}`, query.Slice.ID, strings.Join(hits, ","))
+		case "/_search/scroll":
+			w.Write([]byte(`{"_scroll_id":"done","took":1,"_shards":{"total":1,"successful":1},"hits":{"total":{"value":3},"hits":[]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestElasticStoreExport(t *testing.T) {
+	var mu sync.Mutex
+	var slices []int
+	srv := newFakeExportServer(t, &mu, &slices)
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{
+		indicePrefix:   "yorc_",
+		clusterID:      "c",
+		MaxQuerySize:   1000,
+		ExportSlices:   4,
+		RequestTimeout: time.Second,
+	}}
+
+	var out bytes.Buffer
+	count, err := s.Export(context.Background(), "_yorc/events", &out)
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+	// The number of slices is bounded by the number of shards
+	assert.Equal(t, []int{2, 2}, slices)
+
+	// Documents of each slice are written in order
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	require.Len(t, lines, 6)
+	lastIID := make(map[float64]float64)
+	for _, line := range lines {
+		var document map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &document))
+		iid, err := parseInt64StringToUint64(document["iid"].(string))
+		require.NoError(t, err)
+		slice := document["slice"].(float64)
+		assert.True(t, float64(iid) > lastIID[slice], "documents of slice %v are not ordered", slice)
+		lastIID[slice] = float64(iid)
+	}
+	assert.Len(t, lastIID, 2)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+func TestElasticStoreExportWriteError(t *testing.T) {
+	var mu sync.Mutex
+	var slices []int
+	srv := newFakeExportServer(t, &mu, &slices)
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{
+		indicePrefix: "yorc_",
+		clusterID:    "c",
+		MaxQuerySize: 1,
+		ExportSlices: 1,
+	}}
+
+	_, err = s.Export(context.Background(), "_yorc/events", failingWriter{})
+	assert.Error(t, err)
+	assert.Equal(t, []int{0}, slices)
+}
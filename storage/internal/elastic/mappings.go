@@ -0,0 +1,145 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+)
+
+// The store types, each one is stored in its own index
+const (
+	logsStoreType   = "logs"
+	eventsStoreType = "events"
+)
+
+// Index names should be lowercase and can't contain special characters
+var reIndexName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Mapping properties shared by logs and events, they are used to query, sort and paginate documents so they can't be overridden.
+// The @timestamp field is set by the default ingest pipeline.
+var commonMappingProperties = map[string]interface{}{
+	"deploymentId": map[string]interface{}{"type": "keyword", "index": true},
+	"iid":          map[string]interface{}{"type": "long", "index": true},
+	"iidStr":       map[string]interface{}{"type": "keyword", "index": false},
+	"level":        map[string]interface{}{"type": "keyword", "index": true},
+	"nodeId":       map[string]interface{}{"type": "keyword", "index": true},
+	"@timestamp":   map[string]interface{}{"type": "date"},
+}
+
+// Default mapping properties of the fields correlating logs and events to a workflow step execution, shared by logs and
+// events so that they can be filtered by step. They can be overridden.
+var correlationMappingProperties = map[string]interface{}{
+	"workflowId": map[string]interface{}{"type": "keyword", "index": true},
+	"stepId":     map[string]interface{}{"type": "keyword", "index": true},
+	"jobId":      map[string]interface{}{"type": "keyword", "index": true},
+}
+
+// Default mapping properties specific to each store type
+var storeTypeMappingProperties = map[string]map[string]interface{}{
+	logsStoreType: {
+		"content": map[string]interface{}{"type": "text"},
+	},
+	eventsStoreType: {
+		"type":   map[string]interface{}{"type": "keyword", "index": true},
+		"status": map[string]interface{}{"type": "keyword", "index": true},
+	},
+}
+
+// The index configuration of a store type
+type storeIndexConf struct {
+	// The index name without the prefix and cluster ID
+	Name string
+	// Additional index settings, merged into the store index settings
+	Settings map[string]interface{}
+	// Additional mapping properties, merged into the default mapping properties of the store type
+	MappingProperties map[string]interface{}
+}
+
+// Returns the index configuration of the given store type
+func (c elasticStoreConf) indexConf(storeType string) storeIndexConf {
+	var ic storeIndexConf
+	switch storeType {
+	case logsStoreType:
+		ic = storeIndexConf{Name: c.LogsIndexName, Settings: c.LogsIndexSettings, MappingProperties: c.LogsMappingProperties}
+	case eventsStoreType:
+		ic = storeIndexConf{Name: c.EventsIndexName, Settings: c.EventsIndexSettings, MappingProperties: c.EventsMappingProperties}
+	}
+	if ic.Name == "" {
+		ic.Name = storeType
+	}
+	return ic
+}
+
+// Returns the mapping properties of the index of the given store type: the common properties, the correlation ones,
+// the store type ones and the configured ones.
+func buildMappingProperties(conf elasticStoreConf, storeType string) map[string]interface{} {
+	extra := conf.indexConf(storeType).MappingProperties
+	properties := make(map[string]interface{}, len(commonMappingProperties)+len(correlationMappingProperties)+len(storeTypeMappingProperties[storeType])+len(extra))
+	for k, v := range correlationMappingProperties {
+		properties[k] = v
+	}
+	for k, v := range storeTypeMappingProperties[storeType] {
+		properties[k] = v
+	}
+	for k, v := range extra {
+		properties[k] = v
+	}
+	for k, v := range commonMappingProperties {
+		properties[k] = v
+	}
+	return properties
+}
+
+// Returns the names of the mapping properties of the index of the given store type, sorted
+func mappingPropertiesNames(conf elasticStoreConf, storeType string) []string {
+	properties := buildMappingProperties(conf, storeType)
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Checks the mapping properties defined for a store type, each one should be a field mapping with a type.
+// Common properties can't be overridden.
+func parseMappingProperties(value interface{}) (map[string]interface{}, error) {
+	properties, err := cast.ToStringMapE(value)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range properties {
+		if _, ok := commonMappingProperties[name]; ok {
+			return nil, errors.Errorf("mapping of field %q can't be overridden", name)
+		}
+		field, err := cast.ToStringMapE(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid mapping of field %q", name)
+		}
+		if _, ok := field["type"]; !ok {
+			return nil, errors.Errorf("invalid mapping of field %q, type is missing", name)
+		}
+		properties[name] = field
+	}
+	if _, err = json.Marshal(properties); err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
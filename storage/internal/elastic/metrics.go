@@ -0,0 +1,82 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/armon/go-metrics"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+)
+
+const elasticMetricsPrefix = "elastic"
+
+func indexLabels(index string) []metrics.Label {
+	return []metrics.Label{metrics.Label{Name: "Index", Value: index}}
+}
+
+// Returns the index targeted by the operations of a bulk request, or "mixed" if they target several indexes.
+func bulkIndex(operations [][]byte) string {
+	var index string
+	for _, ope := range operations {
+		opeIndex := bulkOperationIndex(ope)
+		if index != "" && opeIndex != index {
+			return "mixed"
+		}
+		index = opeIndex
+	}
+	return index
+}
+
+// Extract the index name from the action line of a bulk operation (ie {"index":{"_index":"yorc_logs"}}).
+func bulkOperationIndex(operation []byte) string {
+	const indexAttr = `"_index":"`
+	start := bytes.Index(operation, []byte(indexAttr))
+	if start < 0 {
+		return ""
+	}
+	start += len(indexAttr)
+	end := bytes.IndexByte(operation[start:], '"')
+	if end < 0 {
+		return ""
+	}
+	return string(operation[start : start+end])
+}
+
+func emitBulkMetrics(index string, opeCount, size, attempts, failures int, start time.Time) {
+	labels := indexLabels(index)
+	metrics.MeasureSinceWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "duration"}), start, labels)
+	metrics.AddSampleWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "operations"}), float32(opeCount), labels)
+	metrics.AddSampleWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "bytes"}), float32(size), labels)
+	if attempts > 1 {
+		metrics.IncrCounterWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "retries"}), float32(attempts-1), labels)
+	}
+	if failures > 0 {
+		metrics.IncrCounterWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "bulk", "failures"}), float32(failures), labels)
+	}
+}
+
+func emitQueryMetrics(index string, hits, took int, start time.Time) {
+	labels := indexLabels(index)
+	metrics.MeasureSinceWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "query", "duration"}), start, labels)
+	metrics.AddSampleWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "query", "hits"}), float32(hits), labels)
+	metrics.AddSampleWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "query", "took"}), float32(took), labels)
+}
+
+func emitQueryFailureMetrics(index string) {
+	metrics.IncrCounterWithLabels(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "query", "failures"}), 1, indexLabels(index))
+}
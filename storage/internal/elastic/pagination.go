@@ -0,0 +1,171 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// pageToken is a cursor on a result set sorted by ascending iid, or by ascending values of another field and then by iid.
+// As several documents may share the same iid, the token holds the last returned iid and the number of documents
+// already returned having this iid, so that a page can end in the middle of documents sharing the same iid.
+// When sorted by another field, the token also holds the JSON value of this field for the last returned document.
+type pageToken struct {
+	iid       uint64
+	skip      int
+	sortValue string
+}
+
+// String returns the token in a format that can be echoed back by clients (ie "1584656738591334400.2").
+// The sort value, if any, is base64 encoded (ie "1584656738591334400.2.IklORk8i").
+func (p pageToken) String() string {
+	if p.iid == 0 {
+		return ""
+	}
+	token := strconv.FormatUint(p.iid, 10) + "." + strconv.Itoa(p.skip)
+	if p.sortValue != "" {
+		token += "." + base64.RawURLEncoding.EncodeToString([]byte(p.sortValue))
+	}
+	return token
+}
+
+// parsePageToken parses a token returned by pageToken.String, an empty token means the first page.
+func parsePageToken(token string) (pageToken, error) {
+	if token == "" {
+		return pageToken{}, nil
+	}
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) < 2 {
+		return pageToken{}, errors.Errorf("Invalid page token %q", token)
+	}
+	iid, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return pageToken{}, errors.Wrapf(err, "Invalid page token %q", token)
+	}
+	skip, err := strconv.Atoi(parts[1])
+	if err != nil || skip < 0 {
+		return pageToken{}, errors.Errorf("Invalid page token %q", token)
+	}
+	pt := pageToken{iid: iid, skip: skip}
+	if len(parts) == 3 {
+		sortValue, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || !json.Valid(sortValue) {
+			return pageToken{}, errors.Errorf("Invalid page token %q", token)
+		}
+		pt.sortValue = string(sortValue)
+	}
+	return pt, nil
+}
+
+// doQueryEsPage returns a page of documents matching the query, sorted by ascending values of the given field (iid if empty)
+// and then by ascending iid, starting after the given page token.
+// It uses the ES search_after feature, so that results can be paginated beyond the index max_result_window.
+// The token of the next page is returned as well as a boolean telling if more documents may be available.
+func doQueryEsPage(ctx context.Context, c *esClient, conf elasticStoreConf, index, routing, query, token, sortField string, pageSize int) ([]store.KeyValueOut, string, bool, error) {
+	sortField, err := checkSortField(sortField)
+	if err != nil {
+		return nil, token, false, err
+	}
+	pt, err := parsePageToken(token)
+	if err != nil {
+		return nil, token, false, err
+	}
+	if pt.iid != 0 && (pt.sortValue == "") != (sortField == defaultSortField) {
+		return nil, token, false, errors.Errorf("Page token %q was not returned by a query sorted by %s", token, sortField)
+	}
+	if pageSize > conf.MaxQuerySize-pt.skip {
+		pageSize = conf.MaxQuerySize - pt.skip
+	}
+	if pageSize <= 0 {
+		return nil, token, false, errors.Errorf("Not able to paginate, more than %d documents share the iid %d", conf.MaxQuerySize, pt.iid)
+	}
+	body, err := addSearchAfter(query, pt)
+	if err != nil {
+		return nil, token, false, err
+	}
+	// Documents already returned having the token iid are requested again and then skipped
+	size := pageSize + pt.skip
+	_, hits, _, err := doQueryEsSorted(ctx, c, conf, index, routing, body, pt.iid, size, sortField, "asc")
+	if err != nil {
+		return nil, token, false, err
+	}
+	page, next := pageFromHits(pt, hits, pageSize, sortField)
+	return page, next.String(), len(hits) == size, nil
+}
+
+// Add the search_after clause to the query so that the results start at the token sort value and iid (included).
+func addSearchAfter(query string, pt pageToken) (string, error) {
+	if pt.iid == 0 {
+		return query, nil
+	}
+	q, err := decodeQuery(query)
+	if err != nil {
+		return "", errors.Wrapf(err, "Not able to add search_after to query %s", query)
+	}
+	// iids are integers, so iid - 1 is the greatest value strictly lower than the token iid
+	if pt.sortValue != "" {
+		q["search_after"] = []interface{}{json.RawMessage(pt.sortValue), pt.iid - 1}
+	} else {
+		q["search_after"] = []uint64{pt.iid - 1}
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", errors.Wrapf(err, "Not able to add search_after to query %s", query)
+	}
+	return string(b), nil
+}
+
+// Skip the documents already returned and compute the token of the next page.
+func pageFromHits(pt pageToken, hits []store.KeyValueOut, pageSize int, sortField string) ([]store.KeyValueOut, pageToken) {
+	// Documents are identified by their sort value (if not sorted by iid) and their iid
+	sortValueOf := func(kv store.KeyValueOut) string {
+		if sortField == defaultSortField {
+			return ""
+		}
+		return sortValue(kv, sortField)
+	}
+	samePosition := func(kv store.KeyValueOut, p pageToken) bool {
+		return kv.LastModifyIndex == p.iid && sortValueOf(kv) == p.sortValue
+	}
+	skipped := 0
+	for skipped < pt.skip && skipped < len(hits) && samePosition(hits[skipped], pt) {
+		skipped++
+	}
+	page := hits[skipped:]
+	if len(page) > pageSize {
+		page = page[:pageSize]
+	}
+	if len(page) == 0 {
+		return page, pt
+	}
+	last := page[len(page)-1]
+	next := pageToken{iid: last.LastModifyIndex, sortValue: sortValueOf(last)}
+	for i := len(page) - 1; i >= 0 && samePosition(page[i], next); i-- {
+		next.skip++
+	}
+	if next.iid == pt.iid && next.sortValue == pt.sortValue {
+		// The whole page shares the previous token iid
+		next.skip += pt.skip
+	}
+	return page, next
+}
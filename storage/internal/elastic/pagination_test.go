@@ -0,0 +1,186 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func hitsWithIids(iids ...uint64) []store.KeyValueOut {
+	hits := make([]store.KeyValueOut, len(iids))
+	for i, iid := range iids {
+		hits[i] = store.KeyValueOut{LastModifyIndex: iid}
+	}
+	return hits
+}
+
+func iidsOf(values []store.KeyValueOut) []uint64 {
+	iids := make([]uint64, len(values))
+	for i, v := range values {
+		iids[i] = v.LastModifyIndex
+	}
+	return iids
+}
+
+func TestPageFromHits(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     pageToken
+		hits      []store.KeyValueOut
+		pageSize  int
+		wantIids  []uint64
+		wantToken pageToken
+	}{
+		{"FirstPage", pageToken{}, hitsWithIids(1, 2, 3, 4), 3, []uint64{1, 2, 3}, pageToken{3, 1, ""}},
+		{"LastPartialPage", pageToken{3, 1, ""}, hitsWithIids(3, 4), 3, []uint64{4}, pageToken{4, 1, ""}},
+		{"EmptyPage", pageToken{4, 1, ""}, hitsWithIids(4), 3, []uint64{}, pageToken{4, 1, ""}},
+		{"PageEndsOnDuplicateIid", pageToken{}, hitsWithIids(1, 2, 2, 2, 3), 3, []uint64{1, 2, 2}, pageToken{2, 2, ""}},
+		{"PageAfterDuplicateIid", pageToken{2, 2, ""}, hitsWithIids(2, 2, 2, 3, 4), 3, []uint64{2, 3, 4}, pageToken{4, 1, ""}},
+		{"PageEndsExactlyAfterDuplicates", pageToken{}, hitsWithIids(1, 2, 2, 3), 3, []uint64{1, 2, 2}, pageToken{2, 2, ""}},
+		{"WholePageSharesTokenIid", pageToken{5, 1, ""}, hitsWithIids(5, 5, 5, 5, 6), 2, []uint64{5, 5}, pageToken{5, 3, ""}},
+		{"NextPageSharesTokenIid", pageToken{5, 3, ""}, hitsWithIids(5, 5, 5, 5, 6), 2, []uint64{5, 6}, pageToken{6, 1, ""}},
+		{"DocumentsRemovedSinceLastPage", pageToken{5, 3, ""}, hitsWithIids(5, 6), 2, []uint64{6}, pageToken{6, 1, ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, next := pageFromHits(tt.token, tt.hits, tt.pageSize, defaultSortField)
+			assert.Equal(t, tt.wantIids, iidsOf(page))
+			assert.Equal(t, tt.wantToken, next)
+		})
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	pt, err := parsePageToken("")
+	require.NoError(t, err)
+	assert.Equal(t, pageToken{}, pt)
+	assert.Equal(t, "", pt.String())
+
+	pt, err = parsePageToken(pageToken{1584656738591334400, 2, ""}.String())
+	require.NoError(t, err)
+	assert.Equal(t, pageToken{1584656738591334400, 2, ""}, pt)
+
+	pt, err = parsePageToken(pageToken{1584656738591334400, 1, `"INFO"`}.String())
+	require.NoError(t, err)
+	assert.Equal(t, pageToken{1584656738591334400, 1, `"INFO"`}, pt)
+
+	for _, invalid := range []string{"12", "a.1", "12.a", "12.-1", "12.1.!", "12.1.bm90IGpzb24"} {
+		_, err = parsePageToken(invalid)
+		assert.Error(t, err, "token %q should be invalid", invalid)
+	}
+}
+
+func TestAddSearchAfter(t *testing.T) {
+	query := `{"query":{"range":{"iid":{"gt":"0"}}},"size":10}`
+	q, err := addSearchAfter(query, pageToken{})
+	require.NoError(t, err)
+	assert.Equal(t, query, q)
+
+	q, err = addSearchAfter(query, pageToken{1584656738591334400, 1, ""})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"query":{"range":{"iid":{"gt":"0"}}},"size":10,"search_after":[1584656738591334399]}`, q)
+
+	q, err = addSearchAfter(query, pageToken{1584656738591334400, 1, `"INFO"`})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"query":{"range":{"iid":{"gt":"0"}}},"size":10,"search_after":["INFO",1584656738591334399]}`, q)
+
+	_, err = addSearchAfter("not json", pageToken{1, 1, ""})
+	assert.Error(t, err)
+}
+
+func hitsWithLevels(levelsAndIids ...interface{}) []store.KeyValueOut {
+	hits := make([]store.KeyValueOut, 0, len(levelsAndIids)/2)
+	for i := 0; i < len(levelsAndIids); i += 2 {
+		value := map[string]interface{}{}
+		if level := levelsAndIids[i].(string); level != "" {
+			value["level"] = level
+		}
+		hits = append(hits, store.KeyValueOut{LastModifyIndex: uint64(levelsAndIids[i+1].(int)), Value: value})
+	}
+	return hits
+}
+
+func TestPageFromHitsSortedByField(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     pageToken
+		hits      []store.KeyValueOut
+		pageSize  int
+		wantIids  []uint64
+		wantToken pageToken
+	}{
+		{"FirstPage", pageToken{}, hitsWithLevels("", 4, "ERROR", 3, "INFO", 1), 2, []uint64{4, 3}, pageToken{3, 1, `"ERROR"`}},
+		{"SameIidDifferentLevels", pageToken{3, 1, `"ERROR"`}, hitsWithLevels("ERROR", 3, "INFO", 3, "INFO", 5), 2, []uint64{3, 5}, pageToken{5, 1, `"INFO"`}},
+		{"PageEndsOnDuplicates", pageToken{}, hitsWithLevels("INFO", 2, "INFO", 2, "INFO", 2), 2, []uint64{2, 2}, pageToken{2, 2, `"INFO"`}},
+		{"NextPageAfterDuplicates", pageToken{2, 2, `"INFO"`}, hitsWithLevels("INFO", 2, "INFO", 2, "INFO", 2, "WARN", 1), 2, []uint64{2, 1}, pageToken{1, 1, `"WARN"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, next := pageFromHits(tt.token, tt.hits, tt.pageSize, "level")
+			assert.Equal(t, tt.wantIids, iidsOf(page))
+			assert.Equal(t, tt.wantToken, next)
+		})
+	}
+}
+
+func TestDoQueryEsPageSortedByField(t *testing.T) {
+	var path, sortParam, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, sortParam = r.URL.Path, r.URL.Query().Get("sort")
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte(`{"took":1,"_shards":{"total":1,"successful":1},"hits":{"total":{"value":2},"hits":[
+{"_id":"a","_source":{"iid":"10","level":"INFO"}},
+{"_id":"b","_source":{"iid":"7","level":"WARN"}}]}}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	c := &esClient{Transport: t6, majorVersion: 7}
+	conf := elasticStoreConf{MaxQuerySize: 1000, RequestTimeout: time.Second}
+
+	page, token, more, err := doQueryEsPage(context.Background(), c, conf, "yorc_logs", "", `{"query":{"match_all":{}}}`, pageToken{5, 1, `"INFO"`}.String(), "level", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "/yorc_logs/_search", path)
+	assert.Equal(t, "", sortParam)
+	assert.JSONEq(t, `{"query":{"match_all":{}},"search_after":["INFO",4],
+"sort":[{"level":{"order":"asc","missing":""}},{"iid":{"order":"asc"}}]}`, body)
+	assert.Equal(t, []uint64{10, 7}, iidsOf(page))
+	assert.Equal(t, pageToken{7, 1, `"WARN"`}.String(), token)
+	assert.False(t, more)
+
+	// The default sort is on iid
+	_, _, _, err = doQueryEsPage(context.Background(), c, conf, "yorc_logs", "", `{"query":{"match_all":{}}}`, "", "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "iid:asc", sortParam)
+	assert.JSONEq(t, `{"query":{"match_all":{}}}`, body)
+
+	// Tokens can't be used with another sort, and fields should be allowed
+	_, _, _, err = doQueryEsPage(context.Background(), c, conf, "yorc_logs", "", `{}`, token, "iid", 2)
+	assert.Error(t, err)
+	_, _, _, err = doQueryEsPage(context.Background(), c, conf, "yorc_logs", "", `{}`, "", "content", 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+
+	"github.com/ystia/yorc/v4/log"
+)
+
+// The name of the default ingest pipeline installed by Yorc is the index prefix followed by this suffix
+const defaultIngestPipelineSuffix = "timestamp"
+
+// The default ingest pipeline adds an @timestamp field derived from the iid (a timestamp in nanoseconds).
+// A document having an unexpected iid is indexed without @timestamp.
+const defaultIngestPipeline = `
+{
+  "description": "Adds an @timestamp field derived from the iid of Yorc logs and events",
+  "processors": [
+    {
+      "script": {
+        "lang": "painless",
+        "source": "long iid = Long.parseLong(ctx.iid); ctx['@timestamp'] = Instant.ofEpochSecond(iid / 1000000000L, iid % 1000000000L).toString();",
+        "ignore_failure": true
+      }
+    }
+  ]
+}`
+
+// Installs the default ingest pipeline if required, otherwise checks that the configured pipeline exists.
+// The name of the pipeline documents should be sent through is returned, it is empty if the pipeline doesn't exist
+// so that documents are still indexed.
+func initIngestPipeline(ctx context.Context, c *esClient, conf elasticStoreConf) (string, error) {
+	if conf.IngestPipeline == "" {
+		return "", nil
+	}
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	if conf.InstallIngestPipeline {
+		log.Printf("Installing ingest pipeline <%s>", conf.IngestPipeline)
+		req := esapi.IngestPutPipelineRequest{
+			PipelineID: conf.IngestPipeline,
+			Body:       strings.NewReader(defaultIngestPipeline),
+		}
+		res, err := req.Do(ctx, c)
+		defer closeResponseBody("IngestPutPipelineRequest:"+conf.IngestPipeline, res)
+		if err = handleESResponseError(res, "IngestPutPipelineRequest:"+conf.IngestPipeline, defaultIngestPipeline, err); err != nil {
+			return "", err
+		}
+		return conf.IngestPipeline, nil
+	}
+
+	req := esapi.IngestGetPipelineRequest{PipelineID: conf.IngestPipeline}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IngestGetPipelineRequest:"+conf.IngestPipeline, res)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode == 404 {
+		log.Printf("[WARN] Ingest pipeline <%s> doesn't exist, logs and events will be indexed without it. "+
+			"Create this pipeline and restart Yorc, or set install_ingest_pipeline to install the default one.", conf.IngestPipeline)
+		return "", nil
+	}
+	if err = handleESResponseError(res, "IngestGetPipelineRequest:"+conf.IngestPipeline, "", err); err != nil {
+		return "", err
+	}
+	log.Printf("Logs and events will be sent through ingest pipeline <%s>", conf.IngestPipeline)
+	return conf.IngestPipeline, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+)
+
+func TestInitIngestPipeline(t *testing.T) {
+	tests := []struct {
+		name         string
+		conf         elasticStoreConf
+		status       int
+		wantRequest  string
+		wantPipeline string
+		wantErr      bool
+	}{
+		{"NoPipeline", elasticStoreConf{}, http.StatusOK, "", "", false},
+		{"ExistingPipeline", elasticStoreConf{IngestPipeline: "my_pipeline"}, http.StatusOK, "GET /_ingest/pipeline/my_pipeline", "my_pipeline", false},
+		{"MissingPipeline", elasticStoreConf{IngestPipeline: "my_pipeline"}, http.StatusNotFound, "GET /_ingest/pipeline/my_pipeline", "", false},
+		{"CheckFailure", elasticStoreConf{IngestPipeline: "my_pipeline"}, http.StatusForbidden, "GET /_ingest/pipeline/my_pipeline", "", true},
+		{"InstallPipeline", elasticStoreConf{IngestPipeline: "yorc_timestamp", InstallIngestPipeline: true}, http.StatusOK, "PUT /_ingest/pipeline/yorc_timestamp", "yorc_timestamp", false},
+		{"InstallFailure", elasticStoreConf{IngestPipeline: "yorc_timestamp", InstallIngestPipeline: true}, http.StatusBadRequest, "PUT /_ingest/pipeline/yorc_timestamp", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request = r.Method + " " + r.URL.Path
+				if r.Method == http.MethodPut {
+					var pipeline map[string]interface{}
+					body, _ := ioutil.ReadAll(r.Body)
+					assert.NoError(t, json.Unmarshal(body, &pipeline), "invalid pipeline %s", string(body))
+					assert.Len(t, pipeline["processors"], 1)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{}`))
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			tt.conf.RequestTimeout = time.Second
+
+			pipeline, err := initIngestPipeline(context.Background(), &esClient{Transport: t6, majorVersion: 7}, tt.conf)
+			assert.Equal(t, tt.wantRequest, request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPipeline, pipeline)
+		})
+	}
+}
+
+func TestBulkRequestIngestPipeline(t *testing.T) {
+	var pipeline string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pipeline = r.URL.Query().Get("pipeline")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	c := &esClient{Transport: t6, majorVersion: 7, ingestPipeline: "yorc_timestamp"}
+
+	_, err = doSendBulkRequest(context.Background(), c, elasticStoreConf{}, [][]byte{testBulkOperation(1)})
+	require.NoError(t, err)
+	assert.Equal(t, "yorc_timestamp", pipeline)
+}
+
+func TestGetElasticStoreConfigIngestPipeline(t *testing.T) {
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	storeConfig := config.Store{Properties: config.DynamicMap{
+		"es_urls":                 []string{"http://localhost:9200"},
+		"install_ingest_pipeline": true,
+	}}
+	conf, err := getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "yorc_timestamp", conf.IngestPipeline)
+
+	storeConfig.Properties["ingest_pipeline"] = "my_pipeline"
+	conf, err = getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "my_pipeline", conf.IngestPipeline)
+}
@@ -16,115 +16,146 @@ package elastic
 
 import (
 	"bytes"
-	"strconv"
+	"encoding/json"
 	"text/template"
+
+	"github.com/ystia/yorc/v4/log"
 )
 
 // Index creation request
 const initStorageTemplateText = `
 {
-     "settings": {
-        {{ if ne .InitialReplicas -1}}"number_of_replicas": {{ .InitialReplicas}},{{end}}            
-        {{ if ne .InitialShards -1 }}"number_of_shards": {{ .InitialShards}},{{end}}
-        "refresh_interval": "1s"
-     },
+{{template "indexSettingsAndMappings" .}}
+}`
+
+// Index template request, applied to any index matching the pattern (rollover indexes for instance)
+const indexTemplateTemplateText = `
+{
+     "index_patterns": ["{{ .IndexPattern }}"],
+     "version": {{ .Version }},
+{{template "indexSettingsAndMappings" .}}
+}`
+
+// Index settings and mappings, shared by index creation and index template requests
+const indexSettingsAndMappingsTemplateText = `     "settings": {{ .Settings }},
      "mappings": {
+{{ if .MappingTypes }}
          "_doc": {
              "_all": {"enabled": false},
-             "dynamic": "false",
-             "properties": {
-                 "deploymentId": { "type": "keyword", "index": true },
-                 "iid": { "type": "long", "index": true },
-                 "iidStr": { "type": "keyword","index": false }
-             }
+             {{template "mappingProperties" .}}
          }
-     }
-}`
-
-// Get last Modified index
-const lastModifiedIndexTemplateText = `
-{
-    "aggs" : {
-        "max_iid" : {
-            "filter" : {
-{{if .}}
-                "bool": {
-                    "must": [
-                        { "term": { "deploymentId": "{{ . }}" } }
-                     ]
-                }
 {{else}}
-                "match_all": {}
+         {{template "mappingProperties" .}}
 {{end}}
-            },
-            "aggs" : {
-                "last_index" : { "max" : { "field" : "iid" } }
-            }
-        }
-    }
-}`
+     }`
 
-// Range Query
-const rangeQueryTemplateText = `{ "range":{ "iid":{ "gt": "{{ conv .WaitIndex }}"{{if gt .MaxIndex 0}},"lte": "{{ conv .MaxIndex }}"{{end}}}}}`
+// Index mapping properties, since ES 7.x they are not nested into a mapping type.
+// Properties depend on the store type, see buildMappingProperties.
+const mappingPropertiesTemplateText = `"dynamic": "false",
+             "properties": {{ .Properties }}`
 
-const listQueryTemplateText = `
+// Mapping update request, used to disable dynamic mapping on existing indexes
+const staticMappingTemplateText = `
 {
-  "query":{{if .DeploymentID}}{
-    "bool":{
-        "must": [
-          {
-            "term":{
-               "deploymentId": "{{ .DeploymentID }}"
-            }
-          },
-          {{template "rangeQuery" .}}
-        ]
-    }
-  }{{else}}{{template "rangeQuery" .}}{{end}}
-}
-`
+             {{template "mappingProperties" .}}
+}`
 
 var templates *template.Template
 
 func init() {
-	funcMap := template.FuncMap{"conv": func(value uint64) string { return strconv.FormatUint(value, 10) }}
+	templates = template.Must(template.New("mappingProperties").Parse(mappingPropertiesTemplateText))
+	templates = template.Must(templates.New("indexSettingsAndMappings").Parse(indexSettingsAndMappingsTemplateText))
+	templates = template.Must(templates.New("initStorage").Parse(initStorageTemplateText))
+	templates = template.Must(templates.New("indexTemplate").Parse(indexTemplateTemplateText))
+	templates = template.Must(templates.New("staticMapping").Parse(staticMappingTemplateText))
+}
 
-	templates = template.Must(template.New("initStorage").Parse(initStorageTemplateText))
-	templates = template.Must(templates.New("lastModifiedIndex").Parse(lastModifiedIndexTemplateText))
+// The builders of the index creation queries of each store type
+var initStorageIndexQueryBuilders = map[string]func(elasticStoreConf, bool) string{
+	logsStoreType:   buildInitLogsIndexQuery,
+	eventsStoreType: buildInitEventsIndexQuery,
+}
 
-	templates = template.Must(templates.New("rangeQuery").Funcs(funcMap).Parse(rangeQueryTemplateText))
-	templates = template.Must(templates.New("listQuery").Parse(listQueryTemplateText))
+// Return the query that is used to create indexes for log storage.
+// We only index the needed fields to optimize ES indexing performance (no dynamic mapping).
+// The mapping is nested into the '_doc' mapping type only if mappingTypes is true (ES 6.x).
+func buildInitLogsIndexQuery(elasticStoreConfig elasticStoreConf, mappingTypes bool) string {
+	return buildInitStorageIndexQuery(elasticStoreConfig, mappingTypes, logsStoreType)
 }
 
-// Return the query that is used to create indexes for event and log storage.
+// Return the query that is used to create indexes for event storage.
 // We only index the needed fields to optimize ES indexing performance (no dynamic mapping).
-func buildInitStorageIndexQuery(elasticStoreConfig elasticStoreConf) string {
+// The mapping is nested into the '_doc' mapping type only if mappingTypes is true (ES 6.x).
+func buildInitEventsIndexQuery(elasticStoreConfig elasticStoreConf, mappingTypes bool) string {
+	return buildInitStorageIndexQuery(elasticStoreConfig, mappingTypes, eventsStoreType)
+}
+
+// Return the index creation query of the given store type, with its settings and mapping properties
+func buildInitStorageIndexQuery(elasticStoreConfig elasticStoreConf, mappingTypes bool, storeType string) string {
 	var buffer bytes.Buffer
-	templates.ExecuteTemplate(&buffer, "initStorage", elasticStoreConfig)
+	data := struct {
+		Settings     string
+		Properties   string
+		MappingTypes bool
+	}{
+		Settings:     marshalIndexSettings(elasticStoreConfig, storeType),
+		Properties:   marshalMappingProperties(elasticStoreConfig, storeType),
+		MappingTypes: mappingTypes,
+	}
+	templates.ExecuteTemplate(&buffer, "initStorage", data)
 	return buffer.String()
 }
 
-// This ES aggregation query is built using clusterId and eventually deploymentId.
-func buildLastModifiedIndexQuery(deploymentID string) (query string) {
+// Returns the JSON index settings, settings are validated when reading the configuration so they can always be marshaled
+func marshalIndexSettings(elasticStoreConfig elasticStoreConf, storeType string) string {
+	settings, err := json.Marshal(buildIndexSettings(elasticStoreConfig, storeType))
+	if err != nil {
+		log.Printf("[WARN] failed to marshal index settings, using default settings: %v", err)
+		return "{}"
+	}
+	return string(settings)
+}
+
+// Returns the JSON mapping properties, they are validated when reading the configuration so they can always be marshaled
+func marshalMappingProperties(elasticStoreConfig elasticStoreConf, storeType string) string {
+	properties, err := json.Marshal(buildMappingProperties(elasticStoreConfig, storeType))
+	if err != nil {
+		log.Printf("[WARN] failed to marshal %s mapping properties, using default mapping: %v", storeType, err)
+		properties, _ = json.Marshal(commonMappingProperties)
+	}
+	return string(properties)
+}
+
+// Return the index template request used to apply event and log storage settings and mappings to any index matching indexPattern.
+// The template version should be incremented each time the settings or mappings change, so that templates are updated on startup.
+func buildIndexTemplateQuery(elasticStoreConfig elasticStoreConf, mappingTypes bool, storeType, indexPattern string, version int) string {
 	var buffer bytes.Buffer
-	templates.ExecuteTemplate(&buffer, "lastModifiedIndex", deploymentID)
+	data := struct {
+		Settings     string
+		Properties   string
+		MappingTypes bool
+		IndexPattern string
+		Version      int
+	}{
+		Settings:     marshalIndexSettings(elasticStoreConfig, storeType),
+		Properties:   marshalMappingProperties(elasticStoreConfig, storeType),
+		MappingTypes: mappingTypes,
+		IndexPattern: indexPattern,
+		Version:      version,
+	}
+	templates.ExecuteTemplate(&buffer, "indexTemplate", data)
 	return buffer.String()
 }
 
-// This ES range query is built using 'waitIndex' and eventually 'maxIndex' and filtered using 'clusterId' and eventually 'deploymentId'.
-func getListQuery(deploymentID string, waitIndex uint64, maxIndex uint64) (query string) {
+// Return the mapping update request used to disable dynamic mapping on an existing index of the given store type.
+// Event and log payloads contain arbitrary keys, dynamically mapping them could exceed the index fields limit.
+func buildStaticMappingQuery(elasticStoreConfig elasticStoreConf, storeType string) string {
 	var buffer bytes.Buffer
-
 	data := struct {
-		WaitIndex    uint64
-		MaxIndex     uint64
-		DeploymentID string
+		Properties string
 	}{
-		WaitIndex:    waitIndex,
-		MaxIndex:     maxIndex,
-		DeploymentID: deploymentID,
+		Properties: marshalMappingProperties(elasticStoreConfig, storeType),
 	}
-
-	templates.ExecuteTemplate(&buffer, "listQuery", data)
+	templates.ExecuteTemplate(&buffer, "staticMapping", data)
 	return buffer.String()
 }
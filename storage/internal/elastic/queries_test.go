@@ -0,0 +1,124 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIndexTemplateQuery(t *testing.T) {
+	tests := []struct {
+		name         string
+		mappingTypes bool
+		shards       int
+		replicas     int
+	}{
+		{"ES6DefaultSettings", true, -1, -1},
+		{"ES7DefaultSettings", false, -1, -1},
+		{"ES7CustomSettings", false, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := elasticStoreConf{InitialShards: tt.shards, InitialReplicas: tt.replicas}
+			query := buildIndexTemplateQuery(conf, tt.mappingTypes, "logs", "yorc_logs*", 2)
+			var r map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(query), &r), "invalid JSON: %s", query)
+			assert.Equal(t, []interface{}{"yorc_logs*"}, r["index_patterns"])
+			assert.Equal(t, float64(2), r["version"])
+			mappings := r["mappings"].(map[string]interface{})
+			if tt.mappingTypes {
+				mappings = mappings["_doc"].(map[string]interface{})
+			}
+			assert.Contains(t, mappings["properties"], "iid")
+			settings := r["settings"].(map[string]interface{})
+			if tt.shards != -1 {
+				assert.Equal(t, float64(tt.shards), settings["number_of_shards"])
+				assert.Equal(t, float64(tt.replicas), settings["number_of_replicas"])
+			} else {
+				assert.NotContains(t, settings, "number_of_shards")
+			}
+
+			// Index creation and index template requests should share settings and mappings
+			var index map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(buildInitLogsIndexQuery(conf, tt.mappingTypes)), &index))
+			assert.Equal(t, index["settings"], r["settings"])
+			assert.Equal(t, index["mappings"], r["mappings"])
+		})
+	}
+}
+
+func TestBuildStaticMappingQuery(t *testing.T) {
+	var mappings map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(buildStaticMappingQuery(elasticStoreConf{}, "events")), &mappings))
+	assert.True(t, isDynamicMappingDisabled(mappings, false))
+	assert.True(t, hasMappingProperty(mappings, false, "type"))
+}
+
+func TestBuildInitIndexQueryPerStoreType(t *testing.T) {
+	conf := elasticStoreConf{
+		InitialShards:         -1,
+		InitialReplicas:       -1,
+		LogsMappingProperties: map[string]interface{}{"interfaceName": map[string]interface{}{"type": "keyword"}},
+	}
+	tests := []struct {
+		storeType   string
+		wantProps   []string
+		unwantProps []string
+	}{
+		{"logs", []string{"iid", "level", "content", "interfaceName", "workflowId", "stepId", "jobId"}, []string{"type", "status"}},
+		{"events", []string{"iid", "level", "type", "status", "workflowId", "stepId", "jobId"}, []string{"content", "interfaceName"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.storeType, func(t *testing.T) {
+			var index map[string]interface{}
+			query := initStorageIndexQueryBuilders[tt.storeType](conf, false)
+			require.NoError(t, json.Unmarshal([]byte(query), &index), "invalid JSON: %s", query)
+			mappings := index["mappings"].(map[string]interface{})
+			for _, p := range tt.wantProps {
+				assert.True(t, hasMappingProperty(mappings, false, p), "missing property %s", p)
+			}
+			for _, p := range tt.unwantProps {
+				assert.False(t, hasMappingProperty(mappings, false, p), "unexpected property %s", p)
+			}
+		})
+	}
+}
+
+func TestParseMappingProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr bool
+	}{
+		{"Keyword", map[string]interface{}{"workflowId": map[interface{}]interface{}{"type": "keyword"}}, false},
+		{"MissingType", map[string]interface{}{"workflowId": map[string]interface{}{"index": false}}, true},
+		{"NotAMapping", map[string]interface{}{"workflowId": "keyword"}, true},
+		{"CommonProperty", map[string]interface{}{"iid": map[string]interface{}{"type": "keyword"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMappingProperties(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// esQuery builds the body of the search and delete by query requests sent on logs and events indexes.
+// The body is marshaled from the query parameters so that values are always properly escaped.
+// Filters are combined, documents matching all of them are returned.
+type esQuery struct {
+	deploymentID string
+	// Documents having gtIID < iid <= lteIID, lteIID is not set if 0
+	hasIIDRange bool
+	gtIID       uint64
+	lteIID      uint64
+	logLevels   []string
+	// Documents having from <= timestamp < to, zero times are not set
+	from time.Time
+	to   time.Time
+	// Documents matching one of these references
+	refs []documentRef
+	// The slice of a sliced scroll, if maxSlices > 1
+	sliceID   int
+	maxSlices int
+	// If true, the query computes the max iid of the matching documents (see lastIndexResponse) instead of returning them
+	lastIndexAggregation bool
+	// If aggField or aggInterval is set, the query counts the matching documents grouped by field value
+	// and/or by iid histogram (see aggregationResponse) instead of returning them
+	aggField    string
+	aggSize     int
+	aggInterval time.Duration
+}
+
+func newESQuery() *esQuery {
+	return &esQuery{}
+}
+
+// deployment matches the documents of the given deployment, documents of all deployments are matched if it is empty.
+func (q *esQuery) deployment(deploymentID string) *esQuery {
+	q.deploymentID = deploymentID
+	return q
+}
+
+// iidRange matches the documents having an iid greater than gt and lower or equal to lte, lte is ignored if 0.
+func (q *esQuery) iidRange(gt, lte uint64) *esQuery {
+	q.hasIIDRange, q.gtIID, q.lteIID = true, gt, lte
+	return q
+}
+
+// levels matches the log entries having one of the given levels.
+func (q *esQuery) levels(levels ...string) *esQuery {
+	q.logLevels = levels
+	return q
+}
+
+// timeRange matches the documents created from the given time (inclusive) until the given time (exclusive).
+// A zero time is not used.
+func (q *esQuery) timeRange(from, to time.Time) *esQuery {
+	q.from, q.to = from, to
+	return q
+}
+
+// documents matches the documents referenced by the given deploymentId and iid couples.
+func (q *esQuery) documents(refs []documentRef) *esQuery {
+	q.refs = refs
+	return q
+}
+
+// slice restricts the query to the given slice of a sliced scroll, it is ignored if there is a single slice.
+func (q *esQuery) slice(sliceID, maxSlices int) *esQuery {
+	q.sliceID, q.maxSlices = sliceID, maxSlices
+	return q
+}
+
+// lastIndex makes the query compute the max iid of the matching documents using an aggregation.
+func (q *esQuery) lastIndex() *esQuery {
+	q.lastIndexAggregation = true
+	return q
+}
+
+// aggregate makes the query count the matching documents grouped by the values of the given field (the size most frequent ones),
+// and/or by time buckets of the given interval. The field is not used if empty and the interval is not used if 0.
+func (q *esQuery) aggregate(field string, size int, interval time.Duration) *esQuery {
+	q.aggField, q.aggSize, q.aggInterval = field, size, interval
+	return q
+}
+
+type jsonObject map[string]interface{}
+
+// iids are sent as strings to avoid any precision loss
+func iidString(iid uint64) string {
+	return strconv.FormatUint(iid, 10)
+}
+
+func (q *esQuery) clauses() []interface{} {
+	clauses := make([]interface{}, 0)
+	if q.deploymentID != "" {
+		clauses = append(clauses, jsonObject{"term": jsonObject{"deploymentId": q.deploymentID}})
+	}
+	if q.hasIIDRange {
+		r := jsonObject{"gt": iidString(q.gtIID)}
+		if q.lteIID > 0 {
+			r["lte"] = iidString(q.lteIID)
+		}
+		clauses = append(clauses, jsonObject{"range": jsonObject{"iid": r}})
+	}
+	if len(q.logLevels) > 0 {
+		clauses = append(clauses, jsonObject{"terms": jsonObject{"level": q.logLevels}})
+	}
+	if !q.from.IsZero() || !q.to.IsZero() {
+		// iid is the document timestamp in nanoseconds
+		r := jsonObject{}
+		if !q.from.IsZero() {
+			r["gte"] = strconv.FormatInt(q.from.UnixNano(), 10)
+		}
+		if !q.to.IsZero() {
+			r["lt"] = strconv.FormatInt(q.to.UnixNano(), 10)
+		}
+		clauses = append(clauses, jsonObject{"range": jsonObject{"iid": r}})
+	}
+	if len(q.refs) > 0 {
+		should := make([]interface{}, len(q.refs))
+		for i, ref := range q.refs {
+			should[i] = jsonObject{"bool": jsonObject{"must": []interface{}{
+				jsonObject{"term": jsonObject{"deploymentId": ref.DeploymentID}},
+				jsonObject{"term": jsonObject{"iid": iidString(ref.IID)}},
+			}}}
+		}
+		clauses = append(clauses, jsonObject{"bool": jsonObject{"should": should}})
+	}
+	return clauses
+}
+
+// Returns the query matching all the filters
+func (q *esQuery) filter() interface{} {
+	clauses := q.clauses()
+	switch len(clauses) {
+	case 0:
+		return jsonObject{"match_all": jsonObject{}}
+	case 1:
+		return clauses[0]
+	default:
+		return jsonObject{"bool": jsonObject{"must": clauses}}
+	}
+}
+
+// Returns the aggregations counting the documents by field value and/or by time bucket
+func (q *esQuery) aggregations() jsonObject {
+	var aggs jsonObject
+	if q.aggInterval > 0 {
+		// iid is the document timestamp in nanoseconds
+		aggs = jsonObject{"by_time": jsonObject{"histogram": jsonObject{"field": "iid", "interval": q.aggInterval.Nanoseconds(), "min_doc_count": 1}}}
+	}
+	if q.aggField == "" {
+		return aggs
+	}
+	terms := jsonObject{"terms": jsonObject{"field": q.aggField, "size": q.aggSize}}
+	if aggs != nil {
+		terms["aggs"] = aggs
+	}
+	return jsonObject{"by_field": terms}
+}
+
+// String returns the JSON body of the request.
+func (q *esQuery) String() string {
+	body := jsonObject{}
+	if q.aggField != "" || q.aggInterval > 0 {
+		body["query"] = q.filter()
+		// Only the aggregations are returned
+		body["size"] = 0
+		body["aggs"] = q.aggregations()
+	} else if q.lastIndexAggregation {
+		body["aggs"] = jsonObject{
+			"max_iid": jsonObject{
+				"filter": q.filter(),
+				"aggs":   jsonObject{"last_index": jsonObject{"max": jsonObject{"field": "iid"}}},
+			},
+		}
+	} else {
+		body["query"] = q.filter()
+	}
+	if q.maxSlices > 1 {
+		body["slice"] = jsonObject{"id": q.sliceID, "max": q.maxSlices}
+	}
+	// Only strings, numbers, maps and slices are marshaled so this can't fail
+	b, _ := json.Marshal(body)
+	return string(b)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestESQuery(t *testing.T) {
+	before := time.Unix(1591563797, 812178429)
+	tests := []struct {
+		name  string
+		query *esQuery
+		want  string
+	}{
+		{"MatchAll", newESQuery(), `{"query":{"match_all":{}}}`},
+		{"Deployment", newESQuery().deployment("MyApp"), `{"query":{"term":{"deploymentId":"MyApp"}}}`},
+		{"EscapedDeployment", newESQuery().deployment(`My"App\`), `{"query":{"term":{"deploymentId":"My\"App\\"}}}`},
+		{"List", newESQuery().deployment("MyApp").iidRange(1591563797812178429, 0),
+			`{"query":{"bool":{"must":[{"term":{"deploymentId":"MyApp"}},{"range":{"iid":{"gt":"1591563797812178429"}}}]}}}`},
+		{"ListUntil", newESQuery().deployment("MyApp").iidRange(0, 42),
+			`{"query":{"bool":{"must":[{"term":{"deploymentId":"MyApp"}},{"range":{"iid":{"gt":"0","lte":"42"}}}]}}}`},
+		{"LastIndex", newESQuery().deployment("MyApp").lastIndex(),
+			`{"aggs":{"max_iid":{"aggs":{"last_index":{"max":{"field":"iid"}}},"filter":{"term":{"deploymentId":"MyApp"}}}}}`},
+		{"Documents", newESQuery().documents([]documentRef{{"MyApp", 1591563797812178429}, {"OtherApp", 42}}),
+			`{"query":{"bool":{"should":[{"bool":{"must":[{"term":{"deploymentId":"MyApp"}},{"term":{"iid":"1591563797812178429"}}]}},` +
+				`{"bool":{"must":[{"term":{"deploymentId":"OtherApp"}},{"term":{"iid":"42"}}]}}]}}}`},
+		{"SingleSlice", newESQuery().slice(0, 1), `{"query":{"match_all":{}}}`},
+		{"Slice", newESQuery().deployment("MyApp").slice(2, 4), `{"query":{"term":{"deploymentId":"MyApp"}},"slice":{"id":2,"max":4}}`},
+		{"Levels", newESQuery().deployment("MyApp").levels("ERROR", "WARN"),
+			`{"query":{"bool":{"must":[{"term":{"deploymentId":"MyApp"}},{"terms":{"level":["ERROR","WARN"]}}]}}}`},
+		{"Before", newESQuery().timeRange(time.Time{}, before), `{"query":{"range":{"iid":{"lt":"1591563797812178429"}}}}`},
+		{"TimeRange", newESQuery().timeRange(time.Unix(0, 42), before), `{"query":{"range":{"iid":{"gte":"42","lt":"1591563797812178429"}}}}`},
+		{"AggregateField", newESQuery().deployment("MyApp").aggregate("level", 5, 0),
+			`{"aggs":{"by_field":{"terms":{"field":"level","size":5}}},"query":{"term":{"deploymentId":"MyApp"}},"size":0}`},
+		{"AggregateTime", newESQuery().aggregate("", 0, time.Minute),
+			`{"aggs":{"by_time":{"histogram":{"field":"iid","interval":60000000000,"min_doc_count":1}}},"query":{"match_all":{}},"size":0}`},
+		{"AggregateFieldAndTime", newESQuery().aggregate("nodeId", 10, time.Second),
+			`{"aggs":{"by_field":{"aggs":{"by_time":{"histogram":{"field":"iid","interval":1000000000,"min_doc_count":1}}},"terms":{"field":"nodeId","size":10}}},"query":{"match_all":{}},"size":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query.String()
+			var r map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(query), &r), "invalid JSON: %s", query)
+			assert.Equal(t, tt.want, query)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Builds the case insensitive regexp matching the names of the fields to redact, nil if there is no field to redact.
+func buildRedactedFieldsRegexp(patterns []string) (*regexp.Regexp, error) {
+	alternatives := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", p)
+		}
+		alternatives = append(alternatives, "(?:"+p+")")
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+}
+
+// Returns the given request or response body where the values of the JSON fields whose name matches re are redacted.
+// Bodies are either JSON or NDJSON (bulk requests), lines which are not valid JSON are left unchanged.
+func redactBody(body []byte, re *regexp.Regexp) string {
+	if re == nil {
+		return string(body)
+	}
+	lines := bytes.Split(body, []byte("\n"))
+	for i, line := range lines {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(line))
+		// Keep numbers as is
+		d.UseNumber()
+		if d.Decode(&v) != nil || !redactValue(v, re) {
+			continue
+		}
+		var buf bytes.Buffer
+		e := json.NewEncoder(&buf)
+		// Logged as is, no need to escape HTML characters (like the ones of the redacted placeholder)
+		e.SetEscapeHTML(false)
+		if e.Encode(v) == nil {
+			lines[i] = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		}
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
+
+// Redacts in place the values of the fields whose name matches re, returns true if a value was redacted.
+func redactValue(v interface{}, re *regexp.Regexp) bool {
+	found := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range t {
+			if re.MatchString(k) {
+				t[k] = redacted
+				found = true
+				continue
+			}
+			found = redactValue(fv, re) || found
+		}
+	case []interface{}:
+		for _, e := range t {
+			found = redactValue(e, re) || found
+		}
+	}
+	return found
+}
+
+// Returns the metadata of a response (took, hits and bulk errors) logged instead of its whole body.
+func responseMetadata(body []byte) string {
+	var r struct {
+		Took   *int  `json:"took"`
+		Hits   *hits `json:"hits"`
+		Errors *bool `json:"errors"`
+	}
+	if json.Unmarshal(body, &r) != nil {
+		return ""
+	}
+	metadata := make([]string, 0, 3)
+	if r.Took != nil {
+		metadata = append(metadata, fmt.Sprintf("took=%dms", *r.Took))
+	}
+	if r.Hits != nil {
+		metadata = append(metadata, fmt.Sprintf("hits=%d", r.Hits.Total))
+	}
+	if r.Errors != nil {
+		metadata = append(metadata, fmt.Sprintf("errors=%t", *r.Errors))
+	}
+	return strings.Join(metadata, " ")
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+)
+
+func TestRedactBody(t *testing.T) {
+	re, err := buildRedactedFieldsRegexp([]string{"password", "api_?key", " "})
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		re   bool
+		body string
+		want string
+	}{
+		{"NoRegexp", false, `{"password":"s3cr3t"}`, `{"password":"s3cr3t"}`},
+		{"NothingToRedact", true, `{"query": {"term": {"deploymentId": "MyApp"}}}`, `{"query": {"term": {"deploymentId": "MyApp"}}}`},
+		{"CaseInsensitive", true, `{"Password":"s3cr3t","user":"me"}`, `{"Password":"<redacted>","user":"me"}`},
+		{"Nested", true, `{"hits":{"hits":[{"_source":{"db_password":"s3cr3t","iid":1591563797812178429}}]}}`,
+			`{"hits":{"hits":[{"_source":{"db_password":"<redacted>","iid":1591563797812178429}}]}}`},
+		{"Bulk", true, "{\"index\":{\"_index\":\"yorc_logs\"}}\n{\"apikey\":\"abc\",\"content\":\"log\"}\n",
+			"{\"index\":{\"_index\":\"yorc_logs\"}}\n{\"apikey\":\"<redacted>\",\"content\":\"log\"}\n"},
+		{"NotJSON", true, `password=s3cr3t`, `password=s3cr3t`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.re {
+				assert.Equal(t, tt.want, redactBody([]byte(tt.body), re))
+			} else {
+				assert.Equal(t, tt.want, redactBody([]byte(tt.body), nil))
+			}
+		})
+	}
+
+	re, err = buildRedactedFieldsRegexp(nil)
+	require.NoError(t, err)
+	assert.Nil(t, re)
+	_, err = buildRedactedFieldsRegexp([]string{"pass(word"})
+	assert.Error(t, err)
+}
+
+func TestResponseMetadata(t *testing.T) {
+	assert.Equal(t, "took=3ms hits=12", responseMetadata([]byte(`{"took":3,"hits":{"total":{"value":12},"hits":[{"_source":{"password":"s3cr3t"}}]}}`)))
+	assert.Equal(t, "took=5ms errors=true", responseMetadata([]byte(`{"took":5,"errors":true,"items":[]}`)))
+	assert.Equal(t, "", responseMetadata([]byte(`not json`)))
+}
+
+func TestGetElasticStoreConfigTraceRedactedFields(t *testing.T) {
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	storeConfig := config.Store{Properties: config.DynamicMap{"es_urls": []string{"http://localhost:9200"}}}
+	conf, err := getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.True(t, conf.TraceRequestsBodies)
+	assert.True(t, conf.traceRedactedFieldsRegexp.MatchString("api_key"))
+	assert.True(t, conf.traceRedactedFieldsRegexp.MatchString("Authorization"))
+
+	storeConfig.Properties["trace_redacted_fields"] = []string{"private_.*"}
+	conf, err = getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.True(t, conf.traceRedactedFieldsRegexp.MatchString("private_data"))
+	assert.False(t, conf.traceRedactedFieldsRegexp.MatchString("password"))
+
+	storeConfig.Properties["trace_redacted_fields"] = []string{"private_(.*"}
+	_, err = getElasticStoreConfig(cfg, storeConfig)
+	assert.Error(t, err)
+}
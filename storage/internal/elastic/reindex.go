@@ -0,0 +1,317 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// The delay between two checks of the progress of a reindex task, replaced by tests
+var reindexProgressInterval = 10 * time.Second
+
+// A store index as known by the store and the concrete index holding its documents. They differ once the index has been
+// reindexed: the store index is then an alias of the concrete index.
+type storeIndex struct {
+	name     string
+	concrete string
+}
+
+// Reindex copies the logs or events stored under the given key (ie. "_yorc/logs") into new indexes created with the
+// current mapping and settings, then replaces the former indexes by aliases of the new ones.
+// Each index is migrated in turn: documents are copied using the ES reindex API, writes to the former index are then
+// blocked while the documents written meanwhile are copied, and the alias is swapped atomically with the former index
+// deletion. As documents are copied only if they don't exist yet in the new index, the operation can be resumed if it is
+// interrupted. Indexes already using the current mapping are skipped.
+func (s *elasticStore) Reindex(ctx context.Context, k string, dryRun bool) ([]store.ReindexResult, error) {
+	if err := s.checkInitialized(); err != nil {
+		return nil, err
+	}
+	storeType, _ := extractStoreTypeAndDeploymentID(k)
+	if storeType != logsStoreType && storeType != eventsStoreType {
+		return nil, errors.Errorf("unable to reindex values stored under %q, only logs and events can be reindexed", k)
+	}
+	indexes, err := listStoreIndexes(ctx, s.esClient, s.cfg, storeType)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]store.ReindexResult, 0, len(indexes))
+	for _, index := range indexes {
+		result, err := reindexStoreIndex(ctx, s.esClient, s.cfg, storeType, index, dryRun)
+		results = append(results, result)
+		if err != nil {
+			return results, errors.Wrapf(err, "failed to reindex index %s, run the reindexing again to resume it", index.name)
+		}
+	}
+	return results, nil
+}
+
+// Returns the indexes of the given store type sorted by name: the initial index and, when index rollover is enabled,
+// the rollover indexes.
+func listStoreIndexes(ctx context.Context, c *esClient, conf elasticStoreConf, storeType string) ([]storeIndex, error) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	indexName := getIndexName(conf, storeType)
+	req := esapi.IndicesGetAliasRequest{
+		Index: strings.Split(getReadIndexName(conf, storeType), ","),
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IndicesGetAliasRequest:"+indexName, res)
+	if err = handleESResponseError(res, "IndicesGetAliasRequest:"+indexName, "", err); err != nil {
+		return nil, err
+	}
+	var rsp map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode aliases of index %q", indexName)
+	}
+	indexes := make([]storeIndex, 0, len(rsp))
+	for concrete, index := range rsp {
+		name := concrete
+		for alias := range index.Aliases {
+			if alias == indexName || strings.HasPrefix(alias, indexName+"-") {
+				name = alias
+				break
+			}
+		}
+		indexes = append(indexes, storeIndex{name: name, concrete: concrete})
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].name < indexes[j].name })
+	return indexes, nil
+}
+
+// Returns the name of the index created with the given template version to reindex a store index.
+// The version is inserted after the name of the initial index (ie. yorc_logs_v6 or yorc_logs_v6-2024.01) so that the
+// new index matches the index template but not the index expression used to read the store.
+func getReindexTargetName(conf elasticStoreConf, storeType, name string, version int) string {
+	indexName := getIndexName(conf, storeType)
+	return fmt.Sprintf("%s_v%d%s", indexName, version, strings.TrimPrefix(name, indexName))
+}
+
+// Migrates a store index into a new index using the current mapping, only reports the documents count in dry run mode.
+func reindexStoreIndex(ctx context.Context, c *esClient, conf elasticStoreConf, storeType string, index storeIndex, dryRun bool) (store.ReindexResult, error) {
+	result := store.ReindexResult{
+		Index:       index.name,
+		SourceIndex: index.concrete,
+		TargetIndex: getReindexTargetName(conf, storeType, index.name, indexTemplateVersion),
+	}
+	var err error
+	if result.UpToDate = index.concrete == result.TargetIndex; result.UpToDate {
+		log.Printf("Index %s already uses the mapping version %d, skipping it", index.name, indexTemplateVersion)
+		return result, nil
+	}
+	if result.Documents, err = countDocuments(ctx, c, conf, index.concrete); err != nil {
+		return result, err
+	}
+	// Documents copied by a previous interrupted run
+	if result.Reindexed, err = countDocuments(ctx, c, conf, result.TargetIndex); err != nil {
+		return result, err
+	}
+	if dryRun {
+		log.Printf("Dry run: %d documents of index %s would be reindexed into %s, %d are already reindexed",
+			result.Documents, index.name, result.TargetIndex, result.Reindexed)
+		return result, nil
+	}
+
+	log.Printf("Reindexing the %d documents of index %s into %s", result.Documents, index.name, result.TargetIndex)
+	start := time.Now()
+	if err = createIndexIfNotExists(ctx, c, conf, storeType, result.TargetIndex); err != nil {
+		return result, err
+	}
+	if _, err = runReindexTask(ctx, c, conf, index.concrete, result.TargetIndex); err != nil {
+		return result, err
+	}
+	// Documents written during the copy are copied once writes are blocked, they are rejected until the alias is swapped
+	log.Printf("Blocking writes to index %s to reindex the documents written meanwhile", index.concrete)
+	if err = blockIndexWrites(ctx, c, conf, index.concrete); err != nil {
+		return result, err
+	}
+	if _, err = runReindexTask(ctx, c, conf, index.concrete, result.TargetIndex); err != nil {
+		return result, err
+	}
+	if result.Reindexed, err = countDocuments(ctx, c, conf, result.TargetIndex); err != nil {
+		return result, err
+	}
+	if err = swapIndexAlias(ctx, c, conf, index.name, index.concrete, result.TargetIndex); err != nil {
+		return result, err
+	}
+	log.Printf("Index %s has been reindexed into %s, %d documents in %v", index.name, result.TargetIndex, result.Reindexed, time.Since(start))
+	return result, nil
+}
+
+// Returns the number of documents of the given index, 0 if it doesn't exist.
+func countDocuments(ctx context.Context, c *esClient, conf elasticStoreConf, indexName string) (int, error) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	req := esapi.CountRequest{
+		Index:             []string{indexName},
+		IgnoreUnavailable: &ptrue,
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("CountRequest:"+indexName, res)
+	if err = handleESResponseError(res, "CountRequest:"+indexName, "", err); err != nil {
+		return 0, err
+	}
+	var rsp struct {
+		Count int `json:"count"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return 0, errors.Wrapf(err, "failed to decode documents count of index %q", indexName)
+	}
+	return rsp.Count, nil
+}
+
+// The status of a reindex task
+type reindexStatus struct {
+	Total            int `json:"total"`
+	Created          int `json:"created"`
+	VersionConflicts int `json:"version_conflicts"`
+}
+
+// Copies the documents of the source index missing from the target index, documents keep their ID and routing.
+// The reindex runs as an ES task whose progress is logged until its completion, the task is cancelled if ctx is.
+// Returns the number of copied documents.
+func runReindexTask(ctx context.Context, c *esClient, conf elasticStoreConf, source, target string) (int, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": source},
+		"dest":      map[string]interface{}{"index": target, "op_type": "create"},
+	})
+	requestName := "ReindexRequest:" + source
+	reqCtx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	req := esapi.ReindexRequest{
+		Body:              bytes.NewReader(body),
+		WaitForCompletion: &pfalse,
+	}
+	res, err := req.Do(reqCtx, c)
+	defer closeResponseBody(requestName, res)
+	if err = handleESResponseError(res, requestName, string(body), err); err != nil {
+		return 0, err
+	}
+	var rsp struct {
+		Task string `json:"task"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil || rsp.Task == "" {
+		return 0, errors.Errorf("failed to decode the task of the reindexing of index %q: %v", source, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			cancelReindexTask(c, conf, rsp.Task)
+			return 0, ctx.Err()
+		case <-time.After(reindexProgressInterval):
+		}
+		status, completed, err := getReindexTaskStatus(ctx, c, conf, rsp.Task)
+		if err != nil {
+			return status.Created, errors.Wrapf(err, "reindexing of index %s into %s failed", source, target)
+		}
+		if completed {
+			log.Printf("Reindexing of index %s into %s completed: %d documents copied, %d already copied", source, target, status.Created, status.VersionConflicts)
+			return status.Created, nil
+		}
+		log.Printf("Reindexing index %s into %s: %d/%d documents processed", source, target, status.Created+status.VersionConflicts, status.Total)
+	}
+}
+
+// Returns the status of a reindex task and whether it is completed, an error is returned if the task failed.
+func getReindexTaskStatus(ctx context.Context, c *esClient, conf elasticStoreConf, taskID string) (reindexStatus, bool, error) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	requestName := "TasksGetRequest:" + taskID
+	req := esapi.TasksGetRequest{TaskID: taskID}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody(requestName, res)
+	if err = handleESResponseError(res, requestName, "", err); err != nil {
+		return reindexStatus{}, false, err
+	}
+	var rsp struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Status reindexStatus `json:"status"`
+		} `json:"task"`
+		Response struct {
+			Failures []json.RawMessage `json:"failures"`
+		} `json:"response"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return reindexStatus{}, false, errors.Wrapf(err, "failed to decode the status of task %q", taskID)
+	}
+	if len(rsp.Error) > 0 {
+		return rsp.Task.Status, rsp.Completed, errors.Errorf("task %s failed: %s", taskID, rsp.Error)
+	}
+	if len(rsp.Response.Failures) > 0 {
+		return rsp.Task.Status, rsp.Completed, errors.Errorf("task %s failed to copy some documents, first failure was: %s", taskID, rsp.Response.Failures[0])
+	}
+	return rsp.Task.Status, rsp.Completed, nil
+}
+
+// Cancels a reindex task, errors are only logged as a new reindexing skips the documents already copied
+func cancelReindexTask(c *esClient, conf elasticStoreConf, taskID string) {
+	ctx, cancel := withRequestTimeout(context.Background(), conf)
+	defer cancel()
+	requestName := "TasksCancelRequest:" + taskID
+	req := esapi.TasksCancelRequest{TaskID: taskID}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody(requestName, res)
+	if err = handleESResponseError(res, requestName, "", err); err != nil {
+		log.Printf("[WARN] Failed to cancel the reindexing task %s: %v", taskID, err)
+	}
+}
+
+// Rejects the writes to the given index, documents can still be read
+func blockIndexWrites(ctx context.Context, c *esClient, conf elasticStoreConf, indexName string) error {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	body := `{"index.blocks.write":true}`
+	requestName := "IndicesPutSettingsRequest:" + indexName
+	req := esapi.IndicesPutSettingsRequest{
+		Index: []string{indexName},
+		Body:  strings.NewReader(body),
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody(requestName, res)
+	return handleESResponseError(res, requestName, body, err)
+}
+
+// Makes the store index name an alias of the target index and deletes the source index, atomically.
+func swapIndexAlias(ctx context.Context, c *esClient, conf elasticStoreConf, name, source, target string) error {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	body, _ := json.Marshal(map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"remove_index": map[string]interface{}{"index": source}},
+			map[string]interface{}{"add": map[string]interface{}{"index": target, "alias": name}},
+		},
+	})
+	requestName := "IndicesUpdateAliasesRequest:" + name
+	req := esapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody(requestName, res)
+	return handleESResponseError(res, requestName, string(body), err)
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// A fake ES cluster serving a logs index of 3 documents not reindexed yet, or already reindexed if aliased is true.
+// The requests changing the cluster are recorded.
+type fakeReindexCluster struct {
+	mu         sync.Mutex
+	aliased    bool
+	taskError  string
+	targetDocs int
+	polls      int
+	requests   []string
+}
+
+func (f *fakeReindexCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.Method + " " + r.URL.Path {
+	case "GET /yorc_c_logs/_alias":
+		if f.aliased {
+			w.Write([]byte(`{"yorc_c_logs_v6":{"aliases":{"yorc_c_logs":{}}}}`))
+			return
+		}
+		w.Write([]byte(`{"yorc_c_logs":{"aliases":{}}}`))
+	case "POST /yorc_c_logs/_count":
+		w.Write([]byte(`{"count":3}`))
+	case "POST /yorc_c_logs_v6/_count":
+		fmt.Fprintf(w, `{"count":%d}`, f.targetDocs)
+	case "HEAD /yorc_c_logs_v6":
+		w.WriteHeader(http.StatusNotFound)
+	case "GET /_tasks/node:1":
+		// The task is completed at the second poll
+		f.polls++
+		if f.taskError != "" {
+			fmt.Fprintf(w, `{"completed":true,"task":{"status":{"total":3,"created":1}},"error":%s}`, f.taskError)
+			return
+		}
+		if f.polls%2 == 1 {
+			w.Write([]byte(`{"completed":false,"task":{"status":{"total":3,"created":1}}}`))
+			return
+		}
+		f.targetDocs = 3
+		w.Write([]byte(`{"completed":true,"task":{"status":{"total":3,"created":3}},"response":{"failures":[]}}`))
+	case "PUT /yorc_c_logs_v6", "POST /_reindex", "PUT /yorc_c_logs/_settings", "POST /_aliases":
+		f.requests = append(f.requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), body))
+		if r.URL.Path == "/_reindex" {
+			w.Write([]byte(`{"task":"node:1"}`))
+			return
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newReindexTestStore(t *testing.T, f *fakeReindexCluster) (*elasticStore, func()) {
+	srv := httptest.NewServer(f)
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	interval := reindexProgressInterval
+	reindexProgressInterval = time.Millisecond
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{
+		indicePrefix:   "yorc_",
+		clusterID:      "c",
+		RequestTimeout: time.Second,
+	}}
+	return s, func() {
+		reindexProgressInterval = interval
+		srv.Close()
+	}
+}
+
+func TestElasticStoreReindex(t *testing.T) {
+	f := &fakeReindexCluster{}
+	s, closeFn := newReindexTestStore(t, f)
+	defer closeFn()
+
+	results, err := s.Reindex(context.Background(), "_yorc/logs", false)
+	require.NoError(t, err)
+	assert.Equal(t, []store.ReindexResult{{Index: "yorc_c_logs", SourceIndex: "yorc_c_logs", TargetIndex: "yorc_c_logs_v6", Documents: 3, Reindexed: 3}}, results)
+	require.Len(t, f.requests, 5)
+	assert.Regexp(t, `(?s)^PUT /yorc_c_logs_v6 .*"mappings"`, f.requests[0])
+	reindex := `POST /_reindex?wait_for_completion=false {"conflicts":"proceed","dest":{"index":"yorc_c_logs_v6","op_type":"create"},"source":{"index":"yorc_c_logs"}}`
+	assert.Equal(t, reindex, f.requests[1])
+	// Documents written during the first copy are copied once writes are blocked
+	assert.Equal(t, `PUT /yorc_c_logs/_settings {"index.blocks.write":true}`, f.requests[2])
+	assert.Equal(t, reindex, f.requests[3])
+	assert.Equal(t, `POST /_aliases {"actions":[{"remove_index":{"index":"yorc_c_logs"}},{"add":{"alias":"yorc_c_logs","index":"yorc_c_logs_v6"}}]}`, f.requests[4])
+
+	// Indexes already reindexed are skipped
+	f.aliased = true
+	f.requests = nil
+	results, err = s.Reindex(context.Background(), "_yorc/logs", false)
+	require.NoError(t, err)
+	assert.Equal(t, []store.ReindexResult{{Index: "yorc_c_logs", SourceIndex: "yorc_c_logs_v6", TargetIndex: "yorc_c_logs_v6", UpToDate: true}}, results)
+	assert.Empty(t, f.requests)
+}
+
+func TestElasticStoreReindexDryRun(t *testing.T) {
+	// Some documents have been copied by a previous interrupted run
+	f := &fakeReindexCluster{targetDocs: 2}
+	s, closeFn := newReindexTestStore(t, f)
+	defer closeFn()
+
+	results, err := s.Reindex(context.Background(), "_yorc/logs", true)
+	require.NoError(t, err)
+	assert.Equal(t, []store.ReindexResult{{Index: "yorc_c_logs", SourceIndex: "yorc_c_logs", TargetIndex: "yorc_c_logs_v6", Documents: 3, Reindexed: 2}}, results)
+	assert.Empty(t, f.requests)
+}
+
+func TestElasticStoreReindexTaskFailure(t *testing.T) {
+	f := &fakeReindexCluster{taskError: `{"type":"search_phase_execution_exception"}`}
+	s, closeFn := newReindexTestStore(t, f)
+	defer closeFn()
+
+	_, err := s.Reindex(context.Background(), "_yorc/logs", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "search_phase_execution_exception")
+	// The alias is not swapped and writes are not blocked
+	require.Len(t, f.requests, 2)
+
+	_, err = s.Reindex(context.Background(), "_yorc/deployments", false)
+	assert.Error(t, err)
+}
+
+func TestGetReindexTargetName(t *testing.T) {
+	conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c"}
+	assert.Equal(t, "yorc_c_logs_v6", getReindexTargetName(conf, logsStoreType, "yorc_c_logs", 6))
+	assert.Equal(t, "yorc_c_logs_v6-2024.01", getReindexTargetName(conf, logsStoreType, "yorc_c_logs-2024.01", 6))
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/log"
+)
+
+// When the cluster is overloaded, the purge interval is doubled up to this factor
+const maxRetentionPurgeBackoffFactor = 8
+
+// The layout of the timestamp suffixing snapshot names, snapshot names should be lowercase
+const snapshotTimestampLayout = "2006.01.02-15.04.05"
+
+// Start a background routine that periodically deletes logs and events older than the configured retention period.
+// The routine stops once the done channel is closed.
+func startRetentionPurge(c *esClient, conf elasticStoreConf, done <-chan struct{}) {
+	log.Printf("\t- Logs and events older than %v will be purged every %v", conf.RetentionPeriod, conf.RetentionPurgeInterval)
+	if conf.RetentionSnapshotRepository != "" {
+		log.Printf("\t- Logs and events will be snapshotted into repository %s before being purged", conf.RetentionSnapshotRepository)
+	}
+	go func() {
+		factor := 1
+		for {
+			timer := time.NewTimer(time.Duration(factor) * conf.RetentionPurgeInterval)
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			}
+			overloaded := false
+			for _, storeType := range []string{"logs", "events"} {
+				statusCode, err := purgeExpiredDocuments(context.Background(), c, conf, storeType, time.Now())
+				if err != nil {
+					log.Printf("[WARN] Failed to purge expired %s: %+v", storeType, err)
+				}
+				overloaded = overloaded || bulkRetryableStatusCodes[statusCode]
+			}
+			if overloaded && factor < maxRetentionPurgeBackoffFactor {
+				factor *= 2
+				log.Printf("[WARN] ES cluster seems overloaded, next purge of expired logs and events will occur in %v", time.Duration(factor)*conf.RetentionPurgeInterval)
+			} else if !overloaded {
+				factor = 1
+			}
+		}
+	}()
+}
+
+// Delete the documents of the given store type older than the retention period.
+// If a snapshot repository is configured, the documents are only deleted once the snapshot of the indexes succeeded.
+// The status code of the response is returned (0 if no response was received).
+func purgeExpiredDocuments(ctx context.Context, c *esClient, conf elasticStoreConf, storeType string, now time.Time) (int, error) {
+	indexName := getReadIndexName(conf, storeType)
+	if conf.RetentionSnapshotRepository != "" {
+		if statusCode, err := snapshotIndexes(ctx, c, conf, storeType, now); err != nil {
+			return statusCode, errors.Wrapf(err, "purge of index %s aborted, expired documents are kept as they have not been snapshotted", indexName)
+		}
+	}
+	query := newESQuery().timeRange(time.Time{}, now.Add(-conf.RetentionPeriod)).String()
+	req := esapi.DeleteByQueryRequest{
+		Index:     []string{indexName},
+		Body:      strings.NewReader(query),
+		Conflicts: "proceed",
+	}
+	start := time.Now()
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("DeleteByQueryRequest:"+indexName, res)
+	if err = handleESResponseError(res, "DeleteByQueryRequest:"+indexName, query, err); err != nil {
+		if res != nil {
+			return res.StatusCode, err
+		}
+		return 0, err
+	}
+	var r struct {
+		Deleted int `json:"deleted"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return res.StatusCode, errors.Wrapf(err, "Not able to decode the response of the purge of index %s", indexName)
+	}
+	log.Printf("%d expired documents have been purged from %s, took %v", r.Deleted, indexName, time.Since(start))
+	return res.StatusCode, nil
+}
+
+// Snapshot the indexes of the given store type into the configured repository and wait for the snapshot completion.
+// An error is returned if the repository is misconfigured or if the snapshot did not fully succeed.
+// The status code of the response is returned (0 if no response was received).
+func snapshotIndexes(ctx context.Context, c *esClient, conf elasticStoreConf, storeType string, now time.Time) (int, error) {
+	indexName := getReadIndexName(conf, storeType)
+	snapshotName := strings.ToLower(getIndexName(conf, storeType) + "-" + now.UTC().Format(snapshotTimestampLayout))
+	body, _ := json.Marshal(map[string]interface{}{
+		"indices":              indexName,
+		"ignore_unavailable":   true,
+		"include_global_state": false,
+	})
+	waitForCompletion := true
+	req := esapi.SnapshotCreateRequest{
+		Repository:        conf.RetentionSnapshotRepository,
+		Snapshot:          snapshotName,
+		Body:              bytes.NewReader(body),
+		WaitForCompletion: &waitForCompletion,
+	}
+	start := time.Now()
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("SnapshotCreateRequest:"+snapshotName, res)
+	if err = handleESResponseError(res, "SnapshotCreateRequest:"+snapshotName, string(body), err); err != nil {
+		if res != nil {
+			return res.StatusCode, errors.Wrapf(err, "failed to snapshot index %s into repository %s", indexName, conf.RetentionSnapshotRepository)
+		}
+		return 0, errors.Wrapf(err, "failed to snapshot index %s into repository %s", indexName, conf.RetentionSnapshotRepository)
+	}
+	var r struct {
+		Snapshot struct {
+			State    string            `json:"state"`
+			Failures []json.RawMessage `json:"failures"`
+		} `json:"snapshot"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return res.StatusCode, errors.Wrapf(err, "Not able to decode the response of the snapshot of index %s", indexName)
+	}
+	if r.Snapshot.State != "SUCCESS" {
+		return res.StatusCode, errors.Errorf("snapshot %s of index %s into repository %s ended with state %q and %d shard failure(s)",
+			snapshotName, indexName, conf.RetentionSnapshotRepository, r.Snapshot.State, len(r.Snapshot.Failures))
+	}
+	log.Printf("Index %s has been snapshotted into %s/%s, took %v", indexName, conf.RetentionSnapshotRepository, snapshotName, time.Since(start))
+	return res.StatusCode, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeExpiredDocuments(t *testing.T) {
+	now := time.Unix(0, 1700000000000000000)
+	tests := []struct {
+		name       string
+		status     int
+		response   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"Success", http.StatusOK, `{"took":12,"deleted":42}`, http.StatusOK, false},
+		{"Overloaded", http.StatusTooManyRequests, `{"error":"es_rejected_execution_exception"}`, http.StatusTooManyRequests, true},
+		{"InvalidResponse", http.StatusOK, `not json`, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", RetentionPeriod: time.Hour, IndexRolloverPeriod: "daily"}
+
+			status, err := purgeExpiredDocuments(context.Background(), &esClient{Transport: t6, majorVersion: 7}, conf, "logs", now)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, "/yorc_c_logs,yorc_c_logs-*/_delete_by_query", path)
+			assert.Equal(t, `{"query":{"range":{"iid":{"lt":"1699996400000000000"}}}}`, body)
+		})
+	}
+}
+
+func TestPurgeExpiredDocumentsWithSnapshot(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	tests := []struct {
+		name           string
+		snapshotStatus int
+		snapshot       string
+		wantStatus     int
+		wantErr        bool
+		wantPurge      bool
+	}{
+		{"Success", http.StatusOK, `{"snapshot":{"snapshot":"yorc_c_logs-2023.11.14-22.13.20","state":"SUCCESS","failures":[]}}`, http.StatusOK, false, true},
+		{"PartialSnapshot", http.StatusOK, `{"snapshot":{"snapshot":"yorc_c_logs-2023.11.14-22.13.20","state":"PARTIAL","failures":[{"index":"yorc_c_logs"}]}}`, http.StatusOK, true, false},
+		{"MissingRepository", http.StatusNotFound, `{"error":{"type":"repository_missing_exception","reason":"[backup] missing"},"status":404}`, http.StatusNotFound, true, false},
+		{"Overloaded", http.StatusServiceUnavailable, `{"error":"unavailable"}`, http.StatusServiceUnavailable, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var snapshotPath, snapshotQuery, snapshotBody string
+			purged := false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/yorc_c_logs,yorc_c_logs-*/_delete_by_query" {
+					purged = true
+					w.Write([]byte(`{"took":12,"deleted":42}`))
+					return
+				}
+				snapshotPath, snapshotQuery = r.URL.Path, r.URL.RawQuery
+				b, _ := ioutil.ReadAll(r.Body)
+				snapshotBody = string(b)
+				w.WriteHeader(tt.snapshotStatus)
+				w.Write([]byte(tt.snapshot))
+			}))
+			defer srv.Close()
+			t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+			require.NoError(t, err)
+			conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", RetentionPeriod: time.Hour, IndexRolloverPeriod: "daily", RetentionSnapshotRepository: "backup"}
+
+			status, err := purgeExpiredDocuments(context.Background(), &esClient{Transport: t6, majorVersion: 7}, conf, "logs", now)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantPurge, purged)
+			assert.Equal(t, "/_snapshot/backup/yorc_c_logs-2023.11.14-22.13.20", snapshotPath)
+			assert.Equal(t, "wait_for_completion=true", snapshotQuery)
+			assert.JSONEq(t, `{"indices":"yorc_c_logs,yorc_c_logs-*","ignore_unavailable":true,"include_global_state":false}`, snapshotBody)
+		})
+	}
+}
+
+func TestRetentionPurgeStops(t *testing.T) {
+	var purges int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&purges, 1)
+		w.Write([]byte(`{"took":1,"deleted":0}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", RetentionPeriod: time.Hour, RetentionPurgeInterval: 10 * time.Millisecond}
+	done := make(chan struct{})
+	startRetentionPurge(&esClient{Transport: t6, majorVersion: 7}, conf, done)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&purges) > 0 }, time.Second, 10*time.Millisecond)
+
+	// No more purges are run once done is closed
+	close(done)
+	time.Sleep(30 * time.Millisecond)
+	stopped := atomic.LoadInt32(&purges)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&purges))
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+
+	"github.com/ystia/yorc/v4/log"
+)
+
+// Index settings that can only be set at index creation (or on a closed index)
+var staticIndexSettings = map[string]bool{
+	"number_of_shards":                  true,
+	"number_of_routing_shards":          true,
+	"codec":                             true,
+	"routing_partition_size":            true,
+	"soft_deletes.enabled":              true,
+	"load_fixed_bitset_filters_eagerly": true,
+	"shard.check_on_startup":            true,
+}
+
+func isStaticIndexSetting(name string) bool {
+	return staticIndexSettings[name] || strings.HasPrefix(name, "sort.") || strings.HasPrefix(name, "analysis.")
+}
+
+// Flattens index settings defined as nested maps into dotted names without the "index." prefix
+// (ex: {"index": {"translog": {"durability": "async"}}} becomes {"translog.durability": "async"}).
+func flattenIndexSettings(value interface{}) (map[string]interface{}, error) {
+	settings, err := cast.ToStringMapE(value)
+	if err != nil {
+		return nil, err
+	}
+	flat := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		switch v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			nested, err := flattenIndexSettings(v)
+			if err != nil {
+				return nil, err
+			}
+			for nk, nv := range nested {
+				flat[k+"."+nk] = nv
+			}
+		default:
+			flat[k] = v
+		}
+	}
+	for k, v := range flat {
+		if strings.HasPrefix(k, "index.") {
+			delete(flat, k)
+			flat[strings.TrimPrefix(k, "index.")] = v
+		}
+	}
+	return flat, nil
+}
+
+// Returns the settings of the indexes created by the store for the given store type: the dedicated shards, replicas and
+// refresh interval properties merged with the additional index settings and then with the store type ones.
+func buildIndexSettings(conf elasticStoreConf, storeType string) map[string]interface{} {
+	typeSettings := conf.indexConf(storeType).Settings
+	settings := make(map[string]interface{}, len(conf.IndexSettings)+len(typeSettings)+3)
+	for k, v := range conf.IndexSettings {
+		settings[k] = v
+	}
+	for k, v := range typeSettings {
+		settings[k] = v
+	}
+	if conf.InitialShards != -1 {
+		settings["number_of_shards"] = conf.InitialShards
+	}
+	if conf.InitialReplicas != -1 {
+		settings["number_of_replicas"] = conf.InitialReplicas
+	}
+	refreshInterval := conf.RefreshInterval
+	if refreshInterval == "" {
+		refreshInterval = "1s"
+	}
+	settings["refresh_interval"] = refreshInterval
+	return settings
+}
+
+// Compares the expected index settings to the current ones of an existing index (flat settings, "index." prefixed).
+// Returns the dynamic settings that should be updated and the names of the static settings that differ.
+func diffIndexSettings(expected map[string]interface{}, current map[string]string) (map[string]interface{}, []string) {
+	updates := make(map[string]interface{})
+	static := make([]string, 0)
+	for k, v := range expected {
+		if currentValue, ok := current["index."+k]; ok && currentValue == fmt.Sprint(v) {
+			continue
+		}
+		if isStaticIndexSetting(k) {
+			static = append(static, k)
+			continue
+		}
+		updates[k] = v
+	}
+	sort.Strings(static)
+	return updates, static
+}
+
+// Applies the configured dynamic settings to an existing index, static settings are left as is with a warning if they differ.
+func ensureIndexSettings(ctx context.Context, c *esClient, conf elasticStoreConf, storeType, indexName string) error {
+	req := esapi.IndicesGetSettingsRequest{
+		Index:        []string{indexName},
+		FlatSettings: &ptrue,
+	}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("IndicesGetSettingsRequest:"+indexName, res)
+	if err = handleESResponseError(res, "IndicesGetSettingsRequest:"+indexName, "", err); err != nil {
+		return err
+	}
+	var rsp map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&rsp); err != nil {
+		return errors.Wrapf(err, "failed to decode settings of index %q", indexName)
+	}
+	current := make(map[string]string)
+	for _, index := range rsp {
+		for k, v := range index.Settings {
+			current[k] = fmt.Sprint(v)
+		}
+	}
+
+	updates, static := diffIndexSettings(buildIndexSettings(conf, storeType), current)
+	if len(static) > 0 {
+		log.Printf("[WARN] Static settings %s of index %s differ from the store configuration, they can only be applied to new indexes", strings.Join(static, ", "), indexName)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"index": updates})
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal settings of index %q", indexName)
+	}
+	log.Printf("Updating settings of index %s: %s", indexName, body)
+	putReq := esapi.IndicesPutSettingsRequest{
+		Index: []string{indexName},
+		Body:  strings.NewReader(string(body)),
+	}
+	putRes, err := putReq.Do(ctx, c)
+	defer closeResponseBody("IndicesPutSettingsRequest:"+indexName, putRes)
+	return handleESResponseError(putRes, "IndicesPutSettingsRequest:"+indexName, string(body), err)
+}
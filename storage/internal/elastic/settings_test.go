@@ -0,0 +1,165 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+)
+
+func TestFlattenIndexSettings(t *testing.T) {
+	settings, err := flattenIndexSettings(map[interface{}]interface{}{
+		"codec": "best_compression",
+		"index": map[interface{}]interface{}{
+			"translog": map[string]interface{}{"durability": "async"},
+		},
+		"index.max_result_window": 20000,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"codec":               "best_compression",
+		"translog.durability": "async",
+		"max_result_window":   20000,
+	}, settings)
+
+	_, err = flattenIndexSettings("not a map")
+	assert.Error(t, err)
+}
+
+func TestBuildIndexSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		conf elasticStoreConf
+		want map[string]interface{}
+	}{
+		{"Defaults", elasticStoreConf{InitialShards: -1, InitialReplicas: -1},
+			map[string]interface{}{"refresh_interval": "1s"}},
+		{"DedicatedProperties", elasticStoreConf{InitialShards: 3, InitialReplicas: 1, RefreshInterval: "30s"},
+			map[string]interface{}{"number_of_shards": 3, "number_of_replicas": 1, "refresh_interval": "30s"}},
+		{"AdditionalSettings", elasticStoreConf{InitialShards: -1, InitialReplicas: 2, RefreshInterval: "5s", IndexSettings: map[string]interface{}{"codec": "best_compression"}},
+			map[string]interface{}{"number_of_replicas": 2, "refresh_interval": "5s", "codec": "best_compression"}},
+		{"LogsSettings", elasticStoreConf{InitialShards: -1, InitialReplicas: -1, IndexSettings: map[string]interface{}{"codec": "best_compression", "translog.durability": "request"},
+			LogsIndexSettings: map[string]interface{}{"translog.durability": "async"}, EventsIndexSettings: map[string]interface{}{"max_result_window": 50000}},
+			map[string]interface{}{"refresh_interval": "1s", "codec": "best_compression", "translog.durability": "async"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, buildIndexSettings(tt.conf, "logs"))
+
+			// Settings are merged into the index creation request
+			var index map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(buildInitLogsIndexQuery(tt.conf, false)), &index))
+			expected, err := json.Marshal(tt.want)
+			require.NoError(t, err)
+			actual, err := json.Marshal(index["settings"])
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestDiffIndexSettings(t *testing.T) {
+	expected := map[string]interface{}{"number_of_shards": 3, "number_of_replicas": 1, "refresh_interval": "30s", "codec": "best_compression"}
+	tests := []struct {
+		name        string
+		current     map[string]string
+		wantUpdates map[string]interface{}
+		wantStatic  []string
+	}{
+		{"UpToDate", map[string]string{"index.number_of_shards": "3", "index.number_of_replicas": "1", "index.refresh_interval": "30s", "index.codec": "best_compression"},
+			map[string]interface{}{}, []string{}},
+		{"DynamicSettingsDiffer", map[string]string{"index.number_of_shards": "3", "index.number_of_replicas": "0", "index.codec": "best_compression"},
+			map[string]interface{}{"number_of_replicas": 1, "refresh_interval": "30s"}, []string{}},
+		{"StaticSettingsDiffer", map[string]string{"index.number_of_shards": "5", "index.number_of_replicas": "1", "index.refresh_interval": "30s"},
+			map[string]interface{}{}, []string{"codec", "number_of_shards"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates, static := diffIndexSettings(expected, tt.current)
+			assert.Equal(t, tt.wantUpdates, updates)
+			assert.Equal(t, tt.wantStatic, static)
+		})
+	}
+}
+
+func TestEnsureIndexSettings(t *testing.T) {
+	var updates []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/yorc_logs/_settings", r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "true", r.URL.Query().Get("flat_settings"))
+			w.Write([]byte(`{"yorc_logs": {"settings": {"index.number_of_shards": "5", "index.number_of_replicas": "1", "index.refresh_interval": "1s"}}}`))
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			updates = append(updates, string(body))
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	c := &esClient{Transport: t6, majorVersion: 7}
+
+	// Only dynamic settings are updated, the number of shards can't be changed
+	conf := elasticStoreConf{InitialShards: 3, InitialReplicas: 2, RefreshInterval: "1s"}
+	require.NoError(t, ensureIndexSettings(context.Background(), c, conf, "logs", "yorc_logs"))
+	require.Len(t, updates, 1)
+	assert.JSONEq(t, `{"index": {"number_of_replicas": 2}}`, updates[0])
+
+	// Nothing to update
+	conf.InitialReplicas = 1
+	require.NoError(t, ensureIndexSettings(context.Background(), c, conf, "logs", "yorc_logs"))
+	assert.Len(t, updates, 1)
+}
+
+func TestGetElasticStoreConfigIndexSettings(t *testing.T) {
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	storeConfig := config.Store{Properties: config.DynamicMap{
+		"es_urls":          []string{"http://localhost:9200"},
+		"refresh_interval": "10s",
+		"index_settings":   map[string]interface{}{"index": map[string]interface{}{"codec": "best_compression"}},
+	}}
+	conf, err := getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "10s", conf.RefreshInterval)
+	assert.Equal(t, map[string]interface{}{"codec": "best_compression"}, conf.IndexSettings)
+
+	storeConfig.Properties["index_settings"] = map[string]interface{}{"number_of_shards": 3}
+	_, err = getElasticStoreConfig(cfg, storeConfig)
+	assert.Error(t, err)
+
+	delete(storeConfig.Properties, "index_settings")
+	storeConfig.Properties["events_index_settings"] = map[string]interface{}{"index.max_result_window": 50000}
+	conf, err = getElasticStoreConfig(cfg, storeConfig)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"max_result_window": 50000}, conf.EventsIndexSettings)
+	assert.Nil(t, conf.LogsIndexSettings)
+
+	storeConfig.Properties["logs_index_settings"] = map[string]interface{}{"refresh_interval": "30s"}
+	_, err = getElasticStoreConfig(cfg, storeConfig)
+	assert.Error(t, err)
+}
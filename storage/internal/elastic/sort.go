@@ -0,0 +1,88 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// The default sort field, it is also used as tiebreaker when sorting on another field
+const defaultSortField = "iid"
+
+// The fields query results can be sorted by, only mapped fields can be sorted.
+// Documents missing the field are sorted as if they had the associated value, so that they can be paginated.
+var sortFields = map[string]interface{}{
+	defaultSortField: nil,
+	"@timestamp":     0,
+	"level":          "",
+	"deploymentId":   "",
+	"nodeId":         "",
+}
+
+// Ensure the sort field is allowed, an empty field means the default sort field.
+func checkSortField(sortField string) (string, error) {
+	if sortField == "" {
+		return defaultSortField, nil
+	}
+	if _, ok := sortFields[sortField]; !ok {
+		return sortField, errors.Errorf("Invalid sort field %q for ES query, expecting iid, @timestamp, level, deploymentId or nodeId", sortField)
+	}
+	return sortField, nil
+}
+
+// Add the sort clause to the query, results are sorted by the given field and then by iid.
+// The query is unchanged when sorting by iid, the sort is then passed as a request parameter.
+func addSort(query, sortField, order string) (string, error) {
+	if sortField == defaultSortField {
+		return query, nil
+	}
+	q, err := decodeQuery(query)
+	if err != nil {
+		return "", errors.Wrapf(err, "Not able to add sort to query %s", query)
+	}
+	q["sort"] = []interface{}{
+		map[string]interface{}{sortField: map[string]interface{}{"order": order, "missing": sortFields[sortField]}},
+		map[string]interface{}{defaultSortField: map[string]interface{}{"order": order}},
+	}
+	b, err := json.Marshal(q)
+	if err != nil {
+		return "", errors.Wrapf(err, "Not able to add sort to query %s", query)
+	}
+	return string(b), nil
+}
+
+// Returns the JSON value of the sort field of the given document, or the value used for documents missing the field.
+func sortValue(kv store.KeyValueOut, sortField string) string {
+	v := kv.Value[sortField]
+	if v == nil {
+		v = sortFields[sortField]
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func decodeQuery(query string) (map[string]interface{}, error) {
+	var q map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(query))
+	// Keep numbers as is
+	d.UseNumber()
+	err := d.Decode(&q)
+	return q, err
+}
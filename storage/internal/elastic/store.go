@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package elastic provides an implementation of a storage that index/get documents to/from Elasticsearch 6.x.
+// Package elastic provides an implementation of a storage that index/get documents to/from Elasticsearch 6.x, 7.x or 8.x.
 // This store can only manage logs and events for the moment. It will fail if you try to use it for other store types.
 package elastic
 
@@ -21,7 +21,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
 	"github.com/elastic/go-elasticsearch/v6/esapi"
 	"github.com/pkg/errors"
 	"github.com/ystia/yorc/v4/config"
@@ -30,14 +29,42 @@ import (
 	"github.com/ystia/yorc/v4/storage/store"
 	"github.com/ystia/yorc/v4/storage/utils"
 	"math"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Default and max timeouts of blocking queries, as defined by the store interface
+const (
+	defaultBlockingQueryTimeout = 5 * time.Minute
+	maxBlockingQueryTimeout     = 10 * time.Minute
+)
+
+// The max iid aggregation is returned as a float64 which precision is 256ns for current timestamps
+const lastIndexPrecision = 1024
+
 type elasticStore struct {
-	codec    encoding.Codec
-	esClient *elasticsearch6.Client
+	codec encoding.Codec
+	// The client matching the ES cluster version
+	esClient *esClient
 	cfg      elasticStoreConf
+	// Buffers logs and events when buffer_max_documents is set
+	buffer *bulkBuffer
+	// Keeps the logs and events that ES fails to index when dead_letter_path is set
+	deadLetter *deadLetterSink
+	// Protects the fields below, esClient and buffer are set when the store is initialized
+	mu sync.RWMutex
+	// True until the store is initialized when ES was not reachable at startup
+	degraded bool
+	closed   bool
+	// The last initialization error while running in degraded mode
+	initErr error
+	// Logs and events kept in memory while running in degraded mode, and the number of dropped ones
+	pending []store.KeyValueIn
+	dropped int
+	// Closed when the store is closed to stop the background initialization, node health checks and retention purge
+	closeCh chan struct{}
 }
 
 // NewStore returns a new Elastic store.
@@ -58,21 +85,25 @@ func NewStore(cfg config.Configuration, storeConfig config.Store) (store.Store,
 		return nil, err
 	}
 
-	esClient, err := prepareEsClient(elasticStoreConfig)
+	deadLetter, err := newDeadLetterSink(elasticStoreConfig)
 	if err != nil {
 		return nil, err
 	}
-
-	err = initStorageIndex(esClient, elasticStoreConfig, "logs")
+	s := &elasticStore{codec: encoding.JSON, cfg: elasticStoreConfig, deadLetter: deadLetter, closeCh: make(chan struct{})}
+	esClient, err := initStore(context.Background(), elasticStoreConfig)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Not able to init index for eventType <%s>", "logs")
-	}
-	err = initStorageIndex(esClient, elasticStoreConfig, "events")
-	if err != nil {
-		return nil, errors.Wrapf(err, "Not able to init index for eventType <%s>", "events")
+		// A wrong certificate configuration won't be fixed by retrying
+		if !elasticStoreConfig.DegradedStartup || isCertificateError(err) {
+			deadLetter.close()
+			return nil, err
+		}
+		log.Printf("[WARN] Elastic store is not able to initialize, it will run in degraded mode until ES is reachable, at most %d logs and events will be kept in memory: %v",
+			elasticStoreConfig.DegradedMaxDocuments, err)
+		s.startBackgroundInit(err)
+		return s, nil
 	}
-
-	return &elasticStore{encoding.JSON, esClient, elasticStoreConfig}, nil
+	s.setInitialized(esClient)
+	return s, nil
 }
 
 // Set index a document (log or event) into ES.
@@ -83,29 +114,88 @@ func (s *elasticStore) Set(ctx context.Context, k string, v interface{}) error {
 		return err
 	}
 
-	storeType, body, err := buildElasticDocument(k, v)
+	if s.keepIfNotInitialized(store.KeyValueIn{Key: k, Value: v}) {
+		return nil
+	}
+
+	if s.buffer != nil {
+		return s.buffer.add(ctx, store.KeyValueIn{Key: k, Value: v})
+	}
+
+	storeType, documentDate, body, err := buildElasticDocument(k, v)
 	if err != nil {
 		return err
 	}
 
-	indexName := getIndexName(s.cfg, storeType)
+	indexName := getWriteIndexName(s.cfg, storeType, documentDate)
 	if log.IsDebug() {
 		log.Debugf("About to index this document into ES index <%s> : %+v", indexName, string(body))
 	}
 
+	documentID, err := buildDocumentID(s.cfg, k, v)
+	if err != nil {
+		return err
+	}
+	version, err := getDocumentVersion(s.cfg, k)
+	if err != nil {
+		return err
+	}
+	limitedBody, err := limitDocumentSize(s.cfg, k, body, s.cfg.MaxDocumentSize*1024)
+	if err != nil {
+		if werr := s.deadLetter.write([][]byte{buildBulkOperation(`{"index":{"_index":"`+indexName+`"}}`, body)}); werr != nil {
+			log.Printf("[ERROR] Dropped document %s is lost: %+v", k, werr)
+		}
+		return nil
+	}
+	body = limitedBody
 	// Prepare ES request
 	req := esapi.IndexRequest{
 		Index:        indexName,
 		DocumentType: "_doc",
+		DocumentID:   documentID,
 		Body:         bytes.NewReader(body),
+		Pipeline:     s.esClient.ingestPipeline,
+		Routing:      getDocumentRouting(s.cfg, k),
+	}
+	if version != 0 {
+		req.Version = &version
+		req.VersionType = "external"
+	} else if documentID != "" {
+		req.OpType = "create"
+	}
+	if err = s.esClient.writeBlock.allow(); err == nil {
+		err = s.esClient.breaker.allow()
 	}
-	res, err := req.Do(context.Background(), s.esClient)
+	if err != nil {
+		// The document is kept to be re-ingested once ES recovers
+		if werr := s.deadLetter.write([][]byte{buildBulkOperation(`{"index":{"_index":"`+indexName+`"}}`, body)}); werr != nil {
+			log.Printf("[ERROR] Document %s not sent to ES is lost: %+v", k, werr)
+		}
+		return err
+	}
+	ctx, cancel := withRequestTimeout(ctx, s.cfg)
+	defer cancel()
+	res, err := req.Do(ctx, s.esClient)
 	defer closeResponseBody("IndexRequest:"+indexName, res)
+	if err == nil && documentID != "" && res.StatusCode == http.StatusConflict {
+		log.Debugf("Document %s already indexed into ES index <%s>", documentID, indexName)
+		s.esClient.breaker.record(nil)
+		s.esClient.writeBlock.record(nil, nil)
+		return nil
+	}
 	if err != nil || res.IsError() {
 		err = handleESResponseError(res, "Index:"+indexName, string(body), err)
-		return err
 	}
-	return nil
+	s.esClient.breaker.record(err)
+	if s.esClient.writeBlock.record(err, nil) {
+		logNodesDiskUsage(ctx, s.esClient, s.cfg)
+	}
+	if isDiskWatermarkBlockError(err) {
+		if werr := s.deadLetter.write([][]byte{buildBulkOperation(`{"index":{"_index":"`+indexName+`"}}`, body)}); werr != nil {
+			log.Printf("[ERROR] Document %s rejected by ES is lost: %+v", k, werr)
+		}
+	}
+	return err
 }
 
 // SetCollection index collections using ES bulk requests.
@@ -118,6 +208,10 @@ func (s *elasticStore) SetCollection(ctx context.Context, keyValues []store.KeyV
 	if keyValues == nil || totalDocumentCount == 0 {
 		return nil
 	}
+	// Collections are used to migrate data, they are not kept in memory as the caller may remove its copy once stored
+	if err := s.checkInitialized(); err != nil {
+		return err
+	}
 
 	// Just estimate the iteration count
 	iterationCount := int(math.Ceil(float64(totalDocumentCount) / float64(s.cfg.maxBulkCount)))
@@ -130,6 +224,10 @@ func (s *elasticStore) SetCollection(ctx context.Context, keyValues []store.KeyV
 	var kvi = 0
 	// The number of iterations
 	var i = 0
+	// The operations that ES was not able to handle
+	var failures []bulkOperationFailure
+	// The number of documents dropped as they are too big
+	var dropped int
 	// Iterate over the []keyValues
 	for {
 		if kvi == totalDocumentCount {
@@ -149,8 +247,11 @@ func (s *elasticStore) SetCollection(ctx context.Context, keyValues []store.KeyV
 				// We have reached the end of []keyValues OR the max items allowed in a single bulk request (max_bulk_count)
 				break
 			}
-			added, err := eventuallyAppendValueToBulkRequest(s.cfg, &body, keyValues[kvi], maxBulkSizeInBytes)
-			if err != nil {
+			added, err := eventuallyAppendValueToBulkRequest(s.cfg, s.esClient, s.deadLetter, &body, keyValues[kvi], maxBulkSizeInBytes)
+			if errors.Is(err, errDocumentDropped) {
+				kvi++
+				dropped++
+			} else if err != nil {
 				return err
 			} else if !added {
 				// The document hasn't been added (too big), let's include it in next bulk
@@ -160,31 +261,99 @@ func (s *elasticStore) SetCollection(ctx context.Context, keyValues []store.KeyV
 				opeCount++
 			}
 		}
-		// The bulk request must be terminated by a newline
-		body = append(body, "\n"...)
+		if opeCount == 0 {
+			// All the remaining documents have been dropped
+			break
+		}
 		// Send the request
-		err := sendBulkRequest(s.esClient, opeCount, &body)
+		bulkFailures, err := sendBulkRequest(ctx, s.esClient, s.cfg, opeCount, &body)
 		if err != nil {
+			s.deadLetter.writeBody(body)
 			return err
 		}
+		failures = append(failures, bulkFailures...)
 		// Increment the number of iterations
 		i++
 	}
 	elapsed := time.Since(start)
+	if len(failures) > 0 {
+		for _, f := range failures {
+			log.Printf("[WARN] Document not indexed, status was %d (%s: %s), bulk operation was: %s", f.status, f.errType, f.errReason, string(f.operation))
+		}
+		s.deadLetter.writeFailures(failures)
+		return errors.Errorf("%d of the %d documents have not been indexed using %d bulk requests, took %v", len(failures), kvi, i, elapsed)
+	}
 	log.Printf("A total of %d documents have been successfully indexed using %d bulk requests, took %v", kvi, i, elapsed)
 	return nil
 }
 
+// Check returns the health of the ES cluster, the check doesn't last more than the configured health_check_timeout.
+// The check is not short-circuited by the circuit breaker, whose state is reported if enabled.
+func (s *elasticStore) Check(ctx context.Context) store.HealthStatus {
+	if health, degraded := s.degradedHealth(); degraded {
+		return health
+	}
+	breakerState := s.esClient.breaker.currentState()
+	writeBlocked := s.esClient.writeBlock.isBlocked()
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.HealthCheckTimeout)
+	defer cancel()
+	info, err := getClusterInfo(ctx, s.esClient)
+	if err != nil {
+		// ES is reachable if it answered with an error (ie. 401 or 403)
+		return store.HealthStatus{Reachable: esErrorStatusCode(err) != 0, Error: err.Error(), CircuitBreaker: breakerState, WriteBlocked: writeBlocked}
+	}
+	health, err := getClusterHealth(ctx, s.esClient)
+	if err != nil {
+		return store.HealthStatus{Reachable: true, Version: info.Version.Number, Error: err.Error(), CircuitBreaker: breakerState, WriteBlocked: writeBlocked}
+	}
+	return store.HealthStatus{
+		Reachable:      true,
+		Status:         health.Status,
+		Version:        info.Version.Number,
+		Nodes:          health.NumberOfNodes,
+		CircuitBreaker: breakerState,
+		WriteBlocked:   writeBlocked,
+	}
+}
+
+// Close sends the buffered documents if any, and stops the node health checks or the background initialization
+// in degraded mode.
+func (s *elasticStore) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		close(s.closeCh)
+	}
+	if !s.closed && s.degraded {
+		if len(s.pending) > 0 {
+			log.Printf("[WARN] Elastic store closed before being initialized, %d logs and events kept in memory are lost", len(s.pending))
+		}
+	}
+	s.closed = true
+	buffer := s.buffer
+	s.mu.Unlock()
+	if buffer != nil {
+		buffer.close(context.Background())
+	}
+	return s.deadLetter.close()
+}
+
 // Delete removes ES documents using a deleteByRequest query.
 func (s *elasticStore) Delete(ctx context.Context, k string, recursive bool) error {
+	if err := s.checkInitialized(); err != nil {
+		return err
+	}
 	log.Debugf("Delete called k: %s, recursive: %t", k, recursive)
 
 	// Extract index name and deploymentID by parsing the key
 	storeType, deploymentID := extractStoreTypeAndDeploymentID(k)
-	indexName := getIndexName(s.cfg, storeType)
+	indexName := getReadIndexName(s.cfg, storeType)
 	log.Debugf("storeType is: %s, indexName is %s, deploymentID is: %s", storeType, indexName, deploymentID)
 
-	query := `{"query" : { "term": { "deploymentId" : "` + deploymentID + `" }}}`
+	if deploymentID == "" {
+		// Documents are only deleted by deployment
+		return nil
+	}
+	query := newESQuery().deployment(deploymentID).String()
 	log.Debugf("query is : %s", query)
 
 	var MaxInt = 1024000
@@ -195,7 +364,11 @@ func (s *elasticStore) Delete(ctx context.Context, k string, recursive bool) err
 		Body:      strings.NewReader(query),
 		Conflicts: "proceed",
 	}
-	res, err := req.Do(context.Background(), s.esClient)
+	if routing := getDeploymentRouting(s.cfg, deploymentID); routing != "" {
+		req.Routing = []string{routing}
+	}
+	// No request timeout here as deleting all the documents of a deployment may take a while
+	res, err := req.Do(ctx, s.esClient)
 	defer closeResponseBody("DeleteByQueryRequest:"+indexName, res)
 	err = handleESResponseError(res, "DeleteByQueryRequest:"+indexName, query, err)
 	return err
@@ -203,30 +376,44 @@ func (s *elasticStore) Delete(ctx context.Context, k string, recursive bool) err
 
 // GetLastModifyIndex return the last index which is found by querying ES using aggregation and a 0 size request.
 func (s *elasticStore) GetLastModifyIndex(k string) (lastIndex uint64, e error) {
+	if e = s.checkInitialized(); e != nil {
+		return
+	}
 	log.Debugf("GetLastModifyIndex called k: %s", k)
 
 	// Extract index name and deploymentID by parsing the key
 	storeType, deploymentID := extractStoreTypeAndDeploymentID(k)
-	indexName := getIndexName(s.cfg, storeType)
+	indexName := getReadIndexName(s.cfg, storeType)
 	log.Debugf("storeType is: %s, indexName is: %s, deploymentID is: %s", storeType, indexName, deploymentID)
 
 	// The lastIndex is query by using ES aggregation query ~= MAX(iid) HAVING deploymentId
-	query := buildLastModifiedIndexQuery(deploymentID)
-	log.Debugf("buildLastModifiedIndexQuery is : %s", query)
-
-	resSearch, err := s.esClient.Search(
-		s.esClient.Search.WithContext(context.Background()),
-		s.esClient.Search.WithIndex(indexName),
-		s.esClient.Search.WithSize(0),
-		s.esClient.Search.WithBody(strings.NewReader(query)),
-	)
+	query := newESQuery().deployment(deploymentID).lastIndex().String()
+	log.Debugf("LastModifiedIndex query is : %s", query)
+
+	size := 0
+	req := esapi.SearchRequest{
+		Index: []string{indexName},
+		Size:  &size,
+		Body:  strings.NewReader(query),
+	}
+	if routing := getDeploymentRouting(s.cfg, deploymentID); routing != "" {
+		req.Routing = []string{routing}
+	}
+	// The store interface doesn't provide a context here
+	ctx, cancel := withRequestTimeout(context.Background(), s.cfg)
+	defer cancel()
+	if e = s.esClient.breaker.allow(); e != nil {
+		return
+	}
+	resSearch, err := req.Do(ctx, s.esClient)
 	defer closeResponseBody("LastModifiedIndexQuery for "+k, resSearch)
 	e = handleESResponseError(resSearch, "LastModifiedIndexQuery for "+k, query, err)
+	s.esClient.breaker.record(e)
 	if e != nil {
 		return
 	}
 
-	var r map[string]interface{}
+	var r lastIndexResponse
 	if err := json.NewDecoder(resSearch.Body).Decode(&r); err != nil {
 		e = errors.Wrapf(
 			err,
@@ -236,15 +423,14 @@ func (s *elasticStore) GetLastModifyIndex(k string) (lastIndex uint64, e error)
 		return
 	}
 
-	total := r["hits"].(map[string]interface{})["total"].(float64)
-	if total > 0 {
+	if r.Hits.Total > 0 {
 		// ES returns aggregations as float, we have a precision loss of few ns
-		lastIndexR := r["aggregations"].(map[string]interface{})["max_iid"].(map[string]interface{})["last_index"].(map[string]interface{})["value"].(float64)
+		lastIndexR := r.Aggregations.LogsOrEvents.LastIndex.Value
 		log.Debugf("Received lastIndexReceived: %v, lastIndex: %v", lastIndexR, lastIndex)
 		lastIndex = uint64(lastIndexR)
 		// The ES max result was a float, there is a risk that this is not really the lastIndex
 		// We need to verify
-		lastIndex = s.verifyLastIndex(indexName, deploymentID, lastIndex)
+		lastIndex = s.verifyLastIndex(ctx, indexName, deploymentID, lastIndex)
 	}
 	return lastIndex, nil
 }
@@ -252,15 +438,21 @@ func (s *elasticStore) GetLastModifyIndex(k string) (lastIndex uint64, e error)
 // We need to ensure the lastIndex returned by the aggregation query is really the last
 // Actually, when elasticsearch aggregates, it returns a float so we loss precession (few ns).
 // We request the docs with iid > waitIndex to ensure the returned lastIndex is REALLY the last.
-func (s *elasticStore) verifyLastIndex(indexName string, deploymentID string, estimatedLastIndex uint64) uint64 {
-	query := getListQuery(deploymentID, estimatedLastIndex, 0)
+func (s *elasticStore) verifyLastIndex(ctx context.Context, indexName string, deploymentID string, estimatedLastIndex uint64) uint64 {
+	// The estimated value may have been rounded up or down, so the actual last index is searched from slightly below it
+	var fromIndex uint64
+	if estimatedLastIndex > lastIndexPrecision {
+		fromIndex = estimatedLastIndex - lastIndexPrecision
+	}
+	query := newESQuery().deployment(deploymentID).iidRange(fromIndex, 0).String()
 	// size = 1 no need for the documents
-	hits, _, lastIndex, err := doQueryEs(context.Background(), s.esClient, s.cfg, indexName, query, estimatedLastIndex, 1, "desc")
-	if err != nil {
-		log.Printf("An error occurred while verifying lastIndex, returning the initial value %d, error was : %+v",
-			estimatedLastIndex, err)
+	hits, _, lastIndex, err := doQueryEs(ctx, s.esClient, s.cfg, indexName, getDeploymentRouting(s.cfg, deploymentID), query, fromIndex, 1, "desc")
+	if err != nil || hits == 0 {
+		log.Printf("Not able to verify lastIndex (%d hits), returning the initial value %d, error was : %+v",
+			hits, estimatedLastIndex, err)
+		return estimatedLastIndex
 	}
-	log.Printf("%d hits while searching %s (%s) using the estimated lastIndex %d, lastIndex is now %d",
+	log.Debugf("%d hits while searching %s (%s) using the estimated lastIndex %d, lastIndex is now %d",
 		hits, indexName, deploymentID, estimatedLastIndex, lastIndex)
 	return lastIndex
 }
@@ -273,17 +465,38 @@ func (s *elasticStore) verifyLastIndex(indexName string, deploymentID string, es
 //
 // - if no result if found after the the given 'timeout', return empty slice
 func (s *elasticStore) List(ctx context.Context, k string, waitIndex uint64, timeout time.Duration) ([]store.KeyValueOut, uint64, error) {
-	log.Debugf("List called k: %s, waitIndex: %d, timeout: %v", k, waitIndex, timeout)
+	return s.list(ctx, k, nil, waitIndex, timeout)
+}
+
+// ListLevels works as List but only the log entries having one of the given levels are returned by ES.
+func (s *elasticStore) ListLevels(ctx context.Context, k string, levels []string, waitIndex uint64, timeout time.Duration) ([]store.KeyValueOut, uint64, error) {
+	return s.list(ctx, k, levels, waitIndex, timeout)
+}
+
+// Returns the documents having one of the given levels, or all documents if levels is empty.
+func (s *elasticStore) list(ctx context.Context, k string, levels []string, waitIndex uint64, timeout time.Duration) ([]store.KeyValueOut, uint64, error) {
+	log.Debugf("List called k: %s, levels: %v, waitIndex: %d, timeout: %v", k, levels, waitIndex, timeout)
 	if err := utils.CheckKey(k); err != nil {
 		return nil, 0, err
 	}
+	if err := s.checkInitialized(); err != nil {
+		return nil, waitIndex, err
+	}
 
 	// Extract indice name by parsing the key
 	storeType, deploymentID := extractStoreTypeAndDeploymentID(k)
-	indexName := getIndexName(s.cfg, storeType)
+	indexName := getReadIndexName(s.cfg, storeType)
 	log.Debugf("storeType is: %s, indexName is: %s, deploymentID is: %s", storeType, indexName, deploymentID)
 
-	query := getListQuery(deploymentID, waitIndex, 0)
+	query := newESQuery().deployment(deploymentID).iidRange(waitIndex, 0).levels(levels...).String()
+
+	// Apply the blocking queries timeouts defined by the store interface
+	if waitIndex > 0 && timeout == 0 {
+		timeout = defaultBlockingQueryTimeout
+	}
+	if timeout > maxBlockingQueryTimeout {
+		timeout = maxBlockingQueryTimeout
+	}
 
 	now := time.Now()
 	end := now.Add(timeout - s.cfg.esRefreshWaitTimeout)
@@ -294,7 +507,7 @@ func (s *elasticStore) List(ctx context.Context, k string, waitIndex uint64, tim
 	var err error
 	for {
 		// first just query to know if they is something to fetch, we just want the max iid (so order desc, size 1)
-		hits, values, lastIndex, err = doQueryEs(ctx, s.esClient, s.cfg, indexName, query, waitIndex, 1, "desc")
+		hits, values, lastIndex, err = doQueryEs(ctx, s.esClient, s.cfg, indexName, getDeploymentRouting(s.cfg, deploymentID), query, waitIndex, 1, "desc")
 		if err != nil {
 			return values, waitIndex, errors.Wrapf(err, "Failed to request ES logs or events, error was: %+v", err)
 		}
@@ -314,14 +527,14 @@ func (s *elasticStore) List(ctx context.Context, k string, waitIndex uint64, tim
 	if hits > 0 {
 		// we do have something to retrieve, we will just wait esRefreshWaitTimeout to let any document that has just been stored to be indexed
 		// then we just retrieve this 'time window' (between waitIndex and lastIndex)
-		query := getListQuery(deploymentID, waitIndex, lastIndex)
+		query := newESQuery().deployment(deploymentID).iidRange(waitIndex, lastIndex).levels(levels...).String()
 		if s.cfg.esForceRefresh {
 			// force refresh for this index
-			refreshIndex(s.esClient, indexName)
+			refreshIndex(ctx, s.esClient, s.cfg, indexName)
 		}
 		time.Sleep(s.cfg.esRefreshWaitTimeout)
 		oldHits := hits
-		hits, values, lastIndex, err = doQueryEs(ctx, s.esClient, s.cfg, indexName, query, waitIndex, 10000, "asc")
+		hits, values, lastIndex, err = doQueryEs(ctx, s.esClient, s.cfg, indexName, getDeploymentRouting(s.cfg, deploymentID), query, waitIndex, s.cfg.MaxQuerySize, "asc")
 		if err != nil {
 			return values, waitIndex, errors.Wrapf(err, "Failed to request ES logs or events (after waiting for refresh)")
 		}
@@ -344,12 +557,66 @@ func (s *elasticStore) Get(k string, v interface{}) (bool, error) {
 	return false, errors.Errorf("Function Get(string, interface{}) not yet implemented for Elastic store !")
 }
 
-// Exist is not used for logs nor events: fails in FATAL.
-func (s *elasticStore) Exist(k string) (bool, error) {
-	if err := utils.CheckKey(k); err != nil {
+// Exist checks if a log or event exists using a single document search.
+func (s *elasticStore) Exist(ctx context.Context, k string) (bool, error) {
+	values, err := s.GetBatch(ctx, []string{k})
+	if err != nil {
 		return false, err
 	}
-	return false, errors.Errorf("Function Exist(string) not yet implemented for Elastic store !")
+	_, found := values[k]
+	return found, nil
+}
+
+// GetBatch retrieves logs or events by their keys.
+// Documents are searched by deploymentId and iid using one request per store type (and per max_query_size keys)
+// rather than fetched by ID using _mget: with the auto document_id_strategy IDs are generated by ES and with the
+// deterministic one they depend on the document content, so they can't be built from the keys. Moreover reads target
+// all the date based indexes when index_rollover_period is set, while _mget needs the index holding each document.
+func (s *elasticStore) GetBatch(ctx context.Context, keys []string) (map[string]store.KeyValueOut, error) {
+	if err := s.checkInitialized(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]store.KeyValueOut, len(keys))
+	refsByStoreType := make(map[string][]documentRef)
+	keysByRef := make(map[documentRef]string, len(keys))
+	for _, k := range keys {
+		if err := utils.CheckKey(k); err != nil {
+			return nil, err
+		}
+		storeType, ref, err := parseDocumentKey(k)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keysByRef[ref]; !ok {
+			refsByStoreType[storeType] = append(refsByStoreType[storeType], ref)
+		}
+		keysByRef[ref] = k
+	}
+
+	for storeType, refs := range refsByStoreType {
+		indexName := getReadIndexName(s.cfg, storeType)
+		for start := 0; start < len(refs); start += s.cfg.MaxQuerySize {
+			end := start + s.cfg.MaxQuerySize
+			if end > len(refs) {
+				end = len(refs)
+			}
+			query := newESQuery().documents(refs[start:end]).String()
+			_, hits, _, err := doQueryEs(ctx, s.esClient, s.cfg, indexName, "", query, 0, end-start, "asc")
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to get ES logs or events")
+			}
+			for _, kv := range hits {
+				deploymentID, _ := kv.Value["deploymentId"].(string)
+				k, ok := keysByRef[documentRef{DeploymentID: deploymentID, IID: kv.LastModifyIndex}]
+				if !ok {
+					continue
+				}
+				kv.Key = k
+				values[k] = kv
+			}
+		}
+	}
+	return values, nil
 }
 
 // Keys is not used for logs nor events: fails in FATAL.
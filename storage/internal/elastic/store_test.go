@@ -0,0 +1,195 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+func TestElasticStoreCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`{"cluster_name":"yorc","version":{"number":"7.17.1"}}`))
+		case "/_cluster/health":
+			w.Write([]byte(`{"cluster_name":"yorc","status":"yellow","number_of_nodes":3}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{HealthCheckTimeout: time.Second}}
+
+	health := s.Check(context.Background())
+	assert.Equal(t, store.HealthStatus{Reachable: true, Status: "yellow", Version: "7.17.1", Nodes: 3}, health)
+
+	srv.Close()
+	health = s.Check(context.Background())
+	assert.False(t, health.Reachable)
+	assert.NotEmpty(t, health.Error)
+}
+
+func TestElasticStoreGetLastModifyIndex(t *testing.T) {
+	// The max aggregation of this iid is returned as 1.5846567385913344E18 which is rounded up
+	const actualLastIndex = 1584656738591334123
+	var searches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "aggs") {
+			fmt.Fprintf(w, `{"hits": {"total": {"value": 3}, "hits": []},
+  "aggregations": {"max_iid": {"doc_count": 3, "last_index": {"value": %v}}}}`, float64(actualLastIndex))
+			return
+		}
+		searches = append(searches, string(body))
+		var query struct {
+			Query struct {
+				Bool struct {
+					Must []struct {
+						Range struct {
+							IID struct {
+								Gt string `json:"gt"`
+							} `json:"iid"`
+						} `json:"range"`
+					} `json:"must"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		require.NoError(t, json.Unmarshal(body, &query))
+		gt, err := strconv.ParseUint(query.Query.Bool.Must[1].Range.IID.Gt, 10, 64)
+		require.NoError(t, err)
+		hits := ""
+		total := 0
+		if actualLastIndex > gt {
+			total = 1
+			hits = fmt.Sprintf(`{"_id": "1", "_source": {"deploymentId": "MyApp", "iidStr": "%d"}}`, uint64(actualLastIndex))
+		}
+		fmt.Fprintf(w, `{"took": 1, "_shards": {"total": 1, "successful": 1}, "hits": {"total": {"value": %d}, "hits": [%s]}}`, total, hits)
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{MaxQuerySize: 1000}}
+
+	lastIndex, err := s.GetLastModifyIndex("_yorc/logs/MyApp")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(actualLastIndex), lastIndex)
+	assert.Len(t, searches, 1)
+}
+
+func TestElasticStoreListLevels(t *testing.T) {
+	var searches []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		searches = append(searches, string(body))
+		fmt.Fprint(w, `{"took": 1, "_shards": {"total": 1, "successful": 1}, "hits": {"total": {"value": 1},
+  "hits": [{"_id": "1", "_source": {"deploymentId": "MyApp", "iidStr": "1584656738591334123", "level": "WARN"}}]}}`)
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{MaxQuerySize: 1000}}
+
+	values, lastIndex, err := s.ListLevels(context.Background(), "_yorc/logs/MyApp/", []string{"WARN", "ERROR"}, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, values, 1)
+	assert.Equal(t, uint64(1584656738591334123), lastIndex)
+	// Both the polling and the retrieval requests filter levels
+	require.Len(t, searches, 2)
+	for _, search := range searches {
+		assert.Contains(t, search, `{"terms":{"level":["WARN","ERROR"]}}`)
+	}
+}
+
+func TestElasticStoreListRouting(t *testing.T) {
+	var routings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routings = append(routings, r.URL.Query().Get("routing"))
+		fmt.Fprint(w, `{"took": 1, "_shards": {"total": 1, "successful": 1}, "hits": {"total": {"value": 0}, "hits": []}}`)
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	s := &elasticStore{esClient: &esClient{Transport: t6, majorVersion: 7}, cfg: elasticStoreConf{MaxQuerySize: 1000, RoutingByDeployment: true}}
+
+	// Only the shard of the deployment is searched
+	_, _, err = s.List(context.Background(), "_yorc/logs/MyApp/", 0, 0)
+	require.NoError(t, err)
+	// All shards are searched when listing the logs of all deployments
+	_, _, err = s.List(context.Background(), "_yorc/logs/", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"MyApp", ""}, routings)
+}
+
+// The URL of an ES cluster the store contract tests are run against, they are skipped if not set
+const testESURLEnvVar = "YORC_TEST_ES_URL"
+
+func TestElasticStoreLastIndex(t *testing.T) {
+	esURL := os.Getenv(testESURLEnvVar)
+	if esURL == "" {
+		t.Skipf("%s is not set, no ES cluster to run the store contract tests against", testESURLEnvVar)
+	}
+	cfg := config.Configuration{}
+	cfg.Consul.Datacenter = "dc1"
+	// Indexes and templates are created using a prefix unique to this test run, they are removed once done
+	prefix := fmt.Sprintf("yorc_test_%d_", time.Now().UnixNano())
+	storeConfig := config.Store{Types: []string{"Log"}, Properties: config.DynamicMap{
+		"es_urls":                 []string{esURL},
+		"index_prefix":            prefix,
+		"bulk_refresh":            "wait_for",
+		"es_query_period":         "100ms",
+		"es_refresh_wait_timeout": "100ms",
+		"health_check_interval":   "0s",
+		"degraded_startup":        false,
+	}}
+	st, err := NewStore(cfg, storeConfig)
+	require.NoError(t, err)
+	s := st.(*elasticStore)
+	defer func() {
+		ctx := context.Background()
+		indexes := []string{getIndexName(s.cfg, "logs"), getIndexName(s.cfg, "events")}
+		res, err := esapi.IndicesDeleteRequest{Index: []string{prefix + "*"}}.Do(ctx, s.esClient)
+		closeResponseBody("IndicesDeleteRequest", res)
+		assert.NoError(t, err)
+		res, err = esapi.IndicesDeleteTemplateRequest{Name: strings.Join(indexes, ",")}.Do(ctx, s.esClient)
+		closeResponseBody("IndicesDeleteTemplateRequest", res)
+		assert.NoError(t, err)
+		s.Close()
+	}()
+
+	rootKey := "_yorc/logs/lastIndexTest"
+	store.CommonStoreTestLastIndex(t, s, rootKey, func() string {
+		return path.Join(rootKey, time.Now().UTC().Format(time.RFC3339Nano))
+	})
+}
@@ -15,11 +15,15 @@
 package elastic
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/ystia/yorc/v4/log"
 	"github.com/ystia/yorc/v4/storage/store"
 	"github.com/ystia/yorc/v4/storage/utils"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -58,17 +62,67 @@ func extractStoreTypeAndDeploymentID(k string) (storeType string, deploymentID s
 }
 
 // We need to append JSON directly into []byte to avoid useless and costly marshaling / unmarshaling.
+// documentRef identifies a log or event document by its deployment and iid
+type documentRef struct {
+	DeploymentID string
+	IID          uint64
+}
+
+// Parse a document key of form "_yorc/logs/MyApp/2020-06-07T21:03:17.812178429Z" to get the store type and the document reference.
+func parseDocumentKey(k string) (string, documentRef, error) {
+	parts := strings.Split(strings.TrimPrefix(k, "_yorc/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", documentRef{}, errors.Errorf("invalid log or event key %q, expecting _yorc/<logs|events>/<deploymentID>/<timestamp>", k)
+	}
+	date, err := time.Parse(time.RFC3339Nano, parts[2])
+	if err != nil {
+		return "", documentRef{}, errors.Wrapf(err, "failed to parse timestamp of key %q", k)
+	}
+	return parts[0], documentRef{DeploymentID: parts[1], IID: uint64(date.UnixNano())}, nil
+}
+
+// Returns the ID of a log or event document when the deterministic or versioned document_id_strategy is used, an empty string
+// otherwise (the ID is then generated by ES). The ID is derived from the document iid and a hash of its deployment and, for
+// the deterministic strategy, of its content, so that sending the same document twice (ie. retrying a bulk request that
+// actually succeeded) doesn't create duplicates.
+func buildDocumentID(c elasticStoreConf, k string, v interface{}) (string, error) {
+	if c.DocumentIDStrategy != deterministicDocumentIDs && c.DocumentIDStrategy != versionedDocumentIDs {
+		return "", nil
+	}
+	_, ref, err := parseDocumentKey(k)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(ref.DeploymentID))
+	if c.DocumentIDStrategy == deterministicDocumentIDs {
+		h.Write([]byte{0})
+		h.Write(v.(json.RawMessage))
+	}
+	return getSortableStringFromUint64(ref.IID) + "-" + hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// Returns the external version of a log or event document when the versioned document_id_strategy is used, 0 otherwise.
+// The version is the document iid: indexing the same document again is rejected by ES with a conflict, which is
+// considered as a success, and an older version of the document can't replace a newer one.
+func getDocumentVersion(c elasticStoreConf, k string) (int, error) {
+	if c.DocumentIDStrategy != versionedDocumentIDs {
+		return 0, nil
+	}
+	_, ref, err := parseDocumentKey(k)
+	if err != nil {
+		return 0, err
+	}
+	return int(ref.IID), nil
+}
+
 func appendJSONInBytes(a []byte, v []byte) []byte {
 	last := len(a) - 1
-	lastByte := a[last]
-	// just append v at the end
-	a = append(a, v...)
-	// then slice
-	for i, s := range v {
-		a[last+i] = s
-	}
-	a[last+len(v)] = lastByte
-	return a
+	// A new slice is allocated so that the given one, which may be reused by the caller, is left unchanged
+	res := make([]byte, 0, len(a)+len(v))
+	res = append(res, a[:last]...)
+	res = append(res, v...)
+	return append(res, a[last])
 }
 
 func _parseInt64StringToInt64(value string) int64 {
@@ -88,6 +142,36 @@ func parseInt64StringToUint64(value string) (uint64, error) {
 	return result, nil
 }
 
+// parseIID converts an iid decoded from a JSON document to an uint64.
+// The iid is usually a string but it is a number if the document has been indexed by another tool.
+// Numbers decoded as float64 may have lost a few ns of precision.
+func parseIID(iid interface{}) (uint64, error) {
+	switch v := iid.(type) {
+	case string:
+		return parseInt64StringToUint64(v)
+	case json.Number:
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, errors.Wrapf(err, "Not able to parse number %s as iid", v)
+		}
+		return parseFloatIID(f)
+	case float64:
+		return parseFloatIID(v)
+	default:
+		return 0, errors.Errorf("Unexpected iid %v of type %T", iid, iid)
+	}
+}
+
+func parseFloatIID(f float64) (uint64, error) {
+	if f < 0 || f >= math.MaxInt64 || f != math.Trunc(f) {
+		return 0, errors.Errorf("Not able to parse number %v as iid, it should be a positive integer", f)
+	}
+	return uint64(f), nil
+}
+
 func _getTimestampFromUint64(nanoTimestamp uint64) time.Time {
 	nanoTimestampStr := strconv.FormatUint(nanoTimestamp, 10)
 	ts := _parseInt64StringToInt64(nanoTimestampStr)
@@ -107,7 +191,8 @@ func getSortableStringFromUint64(nanoTimestamp uint64) string {
 
 // The document is enriched by adding 'clusterId' and 'iid' properties.
 // This addition is done by directly manipulating the []byte in order to avoid costly successive marshal / unmarshal operations.
-func buildElasticDocument(k string, rawMessage interface{}) (string, []byte, error) {
+// The date of the document is also returned.
+func buildElasticDocument(k string, rawMessage interface{}) (string, time.Time, []byte, error) {
 	// Extract indice name and timestamp by parsing the key
 	storeType, timestamp := extractStoreTypeAndTimestamp(k)
 	log.Debugf("storeType is: %s, timestamp: %s", storeType, timestamp)
@@ -115,7 +200,7 @@ func buildElasticDocument(k string, rawMessage interface{}) (string, []byte, err
 	// Convert timestamp to an int64
 	eventDate, err := time.Parse(time.RFC3339Nano, timestamp)
 	if err != nil {
-		return storeType, nil, errors.Wrapf(err, "failed to parse timestamp %+v as time, error was: %+v", timestamp, err)
+		return storeType, eventDate, nil, errors.Wrapf(err, "failed to parse timestamp %+v as time, error was: %+v", timestamp, err)
 	}
 	// Convert to UnixNano int64
 	iid := eventDate.UnixNano()
@@ -126,42 +211,68 @@ func buildElasticDocument(k string, rawMessage interface{}) (string, []byte, err
 	raw := rawMessage.(json.RawMessage)
 	raw = appendJSONInBytes(raw, []byte(a))
 
-	return storeType, raw, nil
+	return storeType, eventDate, raw, nil
 }
 
-// An error is returned if :
-// - it's not valid (key or value nil)
-// - the size of the resulting bulk operation exceed the maximum authorized for a bulk request
+// An error is returned if it's not valid (key or value nil).
+// A document that doesn't fit in a bulk request or exceeds max_document_size is truncated or dropped regarding oversized_document_policy,
+// a dropped document is written to the dead letter sink and errDocumentDropped is returned.
 // The value is not added if it's size + the current body size exceed the maximum authorized for a bulk request.
 // Return a bool indicating if the value has been added to the bulk request body.
-func eventuallyAppendValueToBulkRequest(c elasticStoreConf, body *[]byte, kv store.KeyValueIn, maxBulkSizeInBytes int) (bool, error) {
+func eventuallyAppendValueToBulkRequest(c elasticStoreConf, esClient *esClient, deadLetter *deadLetterSink, body *[]byte, kv store.KeyValueIn, maxBulkSizeInBytes int) (bool, error) {
 	if err := utils.CheckKeyAndValue(kv.Key, kv.Value); err != nil {
 		return false, err
 	}
 
-	storeType, document, err := buildElasticDocument(kv.Key, kv.Value)
+	storeType, documentDate, document, err := buildElasticDocument(kv.Key, kv.Value)
 	if err != nil {
 		return false, err
 	}
 	log.Debugf("About to add a document of size %d bytes to bulk request", len(document))
 
-	// The bulk action
-	index := `{"index":{"_index":"` + getIndexName(c, storeType) + `","_type":"_doc"}}`
-	bulkOperation := make([]byte, 0)
-	bulkOperation = append(bulkOperation, index...)
-	bulkOperation = append(bulkOperation, "\n"...)
-	bulkOperation = append(bulkOperation, document...)
-	bulkOperation = append(bulkOperation, "\n"...)
+	documentID, err := buildDocumentID(c, kv.Key, kv.Value)
+	if err != nil {
+		return false, err
+	}
+	version, err := getDocumentVersion(c, kv.Key)
+	if err != nil {
+		return false, err
+	}
+	// The bulk action, mapping type is only accepted by ES 6.x
+	indexName := getWriteIndexName(c, storeType, documentDate)
+	action, metadata := "index", `"_index":"`+indexName+`"`
+	if esClient.hasMappingTypes() {
+		metadata += `,"_type":"_doc"`
+	}
+	if version != 0 {
+		// Sending the same document twice fails with a version conflict rather than creating a duplicate
+		metadata += `,"_id":"` + documentID + `","version":` + strconv.Itoa(version) + `,"version_type":"external"`
+	} else if documentID != "" {
+		// Sending the same document twice fails with a conflict rather than creating a duplicate
+		action, metadata = "create", metadata+`,"_id":"`+documentID+`"`
+	}
+	if routing := getDocumentRouting(c, kv.Key); routing != "" {
+		b, _ := json.Marshal(routing)
+		metadata += `,"routing":` + string(b)
+	}
+	index := `{"` + action + `":{` + metadata + `}}`
+	// 3 = len("\n") * 3 the newlines terminating the action, the document and the bulk request
+	maxDocumentSize := maxBulkSizeInBytes - len(index) - 3
+	if c.MaxDocumentSize > 0 && c.MaxDocumentSize*1024 < maxDocumentSize {
+		maxDocumentSize = c.MaxDocumentSize * 1024
+	}
+	limitedDocument, err := limitDocumentSize(c, kv.Key, document, maxDocumentSize)
+	if err != nil {
+		if werr := deadLetter.write([][]byte{buildBulkOperation(index, document)}); werr != nil {
+			log.Printf("[ERROR] Dropped document %s is lost: %+v", kv.Key, werr)
+		}
+		return false, err
+	}
+	bulkOperation := buildBulkOperation(index, limitedDocument)
 	log.Debugf("About to add a bulk operation of size %d bytes to bulk request, current size of bulk request body is %d bytes", len(bulkOperation), len(*body))
 
 	// 1 = len("\n") the last newline that will be appended to terminate the bulk request
 	estimatedBodySize := len(*body) + len(bulkOperation) + 1
-	if len(bulkOperation)+1 > maxBulkSizeInBytes {
-		return false, errors.Errorf(
-			"A bulk operation size (order + document %s) is greater than the maximum bulk size authorized (%dkB) : %d > %d, this document can't be sent to ES, please adapt your configuration !",
-			kv.Key, c.maxBulkSize, len(bulkOperation)+1, maxBulkSizeInBytes,
-		)
-	}
 	if estimatedBodySize > maxBulkSizeInBytes {
 		log.Printf(
 			"The limit of bulk size (%d kB) will be reached (%d > %d), the current document will be sent in the next bulk request",
@@ -175,7 +286,63 @@ func eventuallyAppendValueToBulkRequest(c elasticStoreConf, body *[]byte, kv sto
 	return true, nil
 }
 
+// Returns the bulk operation made of the given action and document lines.
+func buildBulkOperation(index string, document []byte) []byte {
+	bulkOperation := make([]byte, 0, len(index)+len(document)+2)
+	bulkOperation = append(bulkOperation, index...)
+	bulkOperation = append(bulkOperation, "\n"...)
+	bulkOperation = append(bulkOperation, document...)
+	bulkOperation = append(bulkOperation, "\n"...)
+	return bulkOperation
+}
+
+// Returns the routing of the documents of the given deployment, so that they are all stored in the same shard.
+// Documents are routed by deployment only if routing_by_deployment is set, an empty routing is returned otherwise.
+func getDeploymentRouting(c elasticStoreConf, deploymentID string) string {
+	if !c.RoutingByDeployment {
+		return ""
+	}
+	return deploymentID
+}
+
+// Returns the routing of the document stored under the given key (see getDeploymentRouting).
+func getDocumentRouting(c elasticStoreConf, k string) string {
+	_, ref, err := parseDocumentKey(k)
+	if err != nil {
+		return ""
+	}
+	return getDeploymentRouting(c, ref.DeploymentID)
+}
+
 // The index name are prefixed to avoid index name collisions.
+// The name of the index of each store type defaults to the store type and can be configured.
 func getIndexName(c elasticStoreConf, storeType string) string {
-	return c.indicePrefix + strings.ToLower(c.clusterID) + "_" + storeType
+	return c.indicePrefix + strings.ToLower(c.clusterID) + "_" + c.indexConf(storeType).Name
+}
+
+// Returns the name of the index in which a document of the given date should be written.
+// When index rollover is enabled, the index name is suffixed by the period of the document date (ie yorc_logs-2024.01 for monthly rollover).
+func getWriteIndexName(c elasticStoreConf, storeType string, date time.Time) string {
+	indexName := getIndexName(c, storeType)
+	date = date.UTC()
+	switch c.IndexRolloverPeriod {
+	case "daily":
+		return indexName + "-" + date.Format("2006.01.02")
+	case "weekly":
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%s-%04d.w%02d", indexName, year, week)
+	case "monthly":
+		return indexName + "-" + date.Format("2006.01")
+	}
+	return indexName
+}
+
+// Returns the index expression used to read documents of the given store type.
+// When index rollover is enabled, it targets the initial index as well as all the rollover indexes.
+func getReadIndexName(c elasticStoreConf, storeType string) string {
+	indexName := getIndexName(c, storeType)
+	if c.IndexRolloverPeriod != "" {
+		return indexName + "," + indexName + "-*"
+	}
+	return indexName
 }
@@ -0,0 +1,218 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetWriteAndReadIndexNames(t *testing.T) {
+	date := time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		period    string
+		wantWrite string
+		wantRead  string
+	}{
+		{"", "yorc_mycluster_logs", "yorc_mycluster_logs"},
+		{"daily", "yorc_mycluster_logs-2024.01.01", "yorc_mycluster_logs,yorc_mycluster_logs-*"},
+		// 2024-01-01 is a Monday, so the first day of ISO week 1
+		{"weekly", "yorc_mycluster_logs-2024.w01", "yorc_mycluster_logs,yorc_mycluster_logs-*"},
+		{"monthly", "yorc_mycluster_logs-2024.01", "yorc_mycluster_logs,yorc_mycluster_logs-*"},
+	}
+	for _, tt := range tests {
+		t.Run("Period"+tt.period, func(t *testing.T) {
+			conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "MyCluster", IndexRolloverPeriod: tt.period}
+			assert.Equal(t, tt.wantWrite, getWriteIndexName(conf, "logs", date))
+			assert.Equal(t, tt.wantRead, getReadIndexName(conf, "logs"))
+		})
+	}
+}
+
+func TestGetIndexNamePerStoreType(t *testing.T) {
+	conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", EventsIndexName: "audit_events"}
+	assert.Equal(t, "yorc_c_logs", getIndexName(conf, "logs"))
+	assert.Equal(t, "yorc_c_audit_events", getIndexName(conf, "events"))
+	assert.Equal(t, "yorc_c_audit_events,yorc_c_audit_events-*", getReadIndexName(elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", EventsIndexName: "audit_events", IndexRolloverPeriod: "daily"}, "events"))
+}
+
+func TestGetWriteIndexNameUsesUTC(t *testing.T) {
+	conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", IndexRolloverPeriod: "daily"}
+	// 2023-12-31 23:30 in UTC-2 is 2024-01-01 01:30 UTC
+	date := time.Date(2023, time.December, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600))
+	assert.Equal(t, "yorc_c_events-2024.01.01", getWriteIndexName(conf, "events", date))
+	// Week 52 of 2023 ends on 2023-12-31 (Sunday)
+	conf.IndexRolloverPeriod = "weekly"
+	assert.Equal(t, "yorc_c_events-2023.w52", getWriteIndexName(conf, "events", date.Add(-2*time.Hour)))
+}
+
+func TestParseDocumentKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		wantStoreType string
+		wantRef       documentRef
+		wantErr       bool
+	}{
+		{"Log", "_yorc/logs/MyApp/2020-06-07T21:03:17.812178429Z", "logs", documentRef{"MyApp", 1591563797812178429}, false},
+		{"Event", "_yorc/events/MyApp/2020-06-07T21:03:17.812178429Z", "events", documentRef{"MyApp", 1591563797812178429}, false},
+		{"MissingTimestamp", "_yorc/logs/MyApp", "", documentRef{}, true},
+		{"InvalidTimestamp", "_yorc/logs/MyApp/yesterday", "", documentRef{}, true},
+		{"NotADocument", "_yorc/deployments/MyApp/topology/nodes", "", documentRef{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storeType, ref, err := parseDocumentKey(tt.key)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStoreType, storeType)
+			assert.Equal(t, tt.wantRef, ref)
+		})
+	}
+}
+
+func TestBuildDocumentID(t *testing.T) {
+	key := "_yorc/events/MyApp/2020-06-07T21:03:17.812178429Z"
+	value := json.RawMessage(`{"type":"instance","status":"started"}`)
+	conf := elasticStoreConf{DocumentIDStrategy: deterministicDocumentIDs}
+
+	id, err := buildDocumentID(conf, key, value)
+	require.NoError(t, err)
+	assert.Regexp(t, `^1591563797812178429-[0-9a-f]{32}$`, id)
+	// The same document always gets the same ID
+	sameID, err := buildDocumentID(conf, key, json.RawMessage(string(value)))
+	require.NoError(t, err)
+	assert.Equal(t, id, sameID)
+	// Documents of other deployments or with another content get another ID
+	otherID, err := buildDocumentID(conf, "_yorc/events/OtherApp/2020-06-07T21:03:17.812178429Z", value)
+	require.NoError(t, err)
+	assert.NotEqual(t, id, otherID)
+	otherID, err = buildDocumentID(conf, key, json.RawMessage(`{"type":"instance","status":"stopped"}`))
+	require.NoError(t, err)
+	assert.NotEqual(t, id, otherID)
+
+	// The ID is the key of the documents returned by queries
+	_, _, document, err := buildElasticDocument(key, value)
+	require.NoError(t, err)
+	var source map[string]interface{}
+	require.NoError(t, json.Unmarshal(document, &source))
+	kv, ok := decodeEsHit(map[string]interface{}{"_id": id, "_source": source})
+	require.True(t, ok)
+	assert.Equal(t, id, kv.Key)
+	assert.Equal(t, uint64(1591563797812178429), kv.LastModifyIndex)
+
+	// Versioned documents IDs are derived from their key only, their version being their iid
+	conf.DocumentIDStrategy = versionedDocumentIDs
+	versionedID, err := buildDocumentID(conf, key, value)
+	require.NoError(t, err)
+	assert.Regexp(t, `^1591563797812178429-[0-9a-f]{32}$`, versionedID)
+	otherID, err = buildDocumentID(conf, key, json.RawMessage(`{"type":"instance","status":"stopped"}`))
+	require.NoError(t, err)
+	assert.Equal(t, versionedID, otherID)
+	version, err := getDocumentVersion(conf, key)
+	require.NoError(t, err)
+	assert.Equal(t, 1591563797812178429, version)
+	conf.DocumentIDStrategy = deterministicDocumentIDs
+
+	// IDs are generated by ES by default
+	id, err = buildDocumentID(elasticStoreConf{DocumentIDStrategy: autoDocumentIDs}, key, value)
+	require.NoError(t, err)
+	assert.Empty(t, id)
+	_, err = buildDocumentID(conf, "_yorc/events/MyApp", value)
+	assert.Error(t, err)
+}
+
+func TestBulkOperationRouting(t *testing.T) {
+	kv := testLogKeyValue(0)
+	for _, routingByDeployment := range []bool{false, true} {
+		conf := elasticStoreConf{indicePrefix: "yorc_", clusterID: "c", RoutingByDeployment: routingByDeployment}
+		body := make([]byte, 0)
+		added, err := eventuallyAppendValueToBulkRequest(conf, &esClient{majorVersion: 7}, nil, &body, kv, 1024*1024)
+		require.NoError(t, err)
+		require.True(t, added)
+		var action map[string]map[string]string
+		require.NoError(t, json.Unmarshal(bytes.SplitN(body, []byte("\n"), 2)[0], &action))
+		if routingByDeployment {
+			assert.Equal(t, "dep", action["index"]["routing"])
+		} else {
+			assert.NotContains(t, action["index"], "routing")
+		}
+	}
+}
+
+func TestParseIID(t *testing.T) {
+	tests := []struct {
+		name    string
+		iid     interface{}
+		want    uint64
+		wantErr bool
+	}{
+		{"String", "1591563797812178429", 1591563797812178429, false},
+		{"Number", json.Number("1591563797812178429"), 1591563797812178429, false},
+		{"ExponentNumber", json.Number("1.5e9"), 1500000000, false},
+		{"Float", float64(1591563797812178432), 1591563797812178432, false},
+		{"InvalidString", "abc", 0, true},
+		{"NegativeFloat", float64(-1), 0, true},
+		{"DecimalFloat", 1.5, 0, true},
+		{"InvalidNumber", json.Number("1.5"), 0, true},
+		{"Nil", nil, 0, true},
+		{"Bool", true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iid, err := parseIID(tt.iid)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, iid)
+		})
+	}
+}
+
+func TestDecodeEsHit(t *testing.T) {
+	tests := []struct {
+		name    string
+		hit     string
+		wantIID uint64
+		wantOK  bool
+	}{
+		{"StringIID", `{"_id":"1","_source":{"iid":"1591563797812178429","iidStr":"1591563797812178429"}}`, 1591563797812178429, true},
+		{"NumberIID", `{"_id":"1","_source":{"iid":1591563797812178429}}`, 1591563797812178432, true},
+		{"NumberIIDStr", `{"_id":"1","_source":{"iidStr":42}}`, 42, true},
+		{"InvalidIID", `{"_id":"1","_source":{"iidStr":"abc"}}`, 0, false},
+		{"MissingIID", `{"_id":"1","_source":{"content":"log"}}`, 0, false},
+		{"MissingSource", `{"_id":"1"}`, 0, false},
+		{"NotAnObject", `"hit"`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hit interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.hit), &hit))
+			kv, ok := decodeEsHit(hit)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantIID, kv.LastModifyIndex)
+		})
+	}
+}
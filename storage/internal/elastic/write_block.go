@@ -0,0 +1,187 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/elastic/go-elasticsearch/v6/esapi"
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/helper/metricsutil"
+	"github.com/ystia/yorc/v4/log"
+)
+
+// errWriteBlocked is returned when a write is not sent to ES as its indexes are read-only
+var errWriteBlocked = errors.New("ES indexes are read-only")
+
+// The message explaining the likely cause of a disk watermark block
+const diskWatermarkBlockMsg = "ES indexes are read-only (index.blocks.read_only_allow_delete), the disk usage of an ES node has most likely exceeded the flood-stage watermark, free some disk space"
+
+// The parts of the cluster_block_exception reasons of a disk watermark block, depending on the ES version
+var diskWatermarkBlockReasons = []string{"read_only_allow_delete", "read-only-allow-delete", "read-only / allow delete", "flood-stage watermark"}
+
+// Returns true if the ES error type and reason are the ones of the block set by ES on indexes once the disk usage of
+// a node exceeds the flood-stage watermark.
+func isDiskWatermarkBlock(errType, reason string) bool {
+	if errType != "cluster_block_exception" {
+		return false
+	}
+	reason = strings.ToLower(reason)
+	for _, r := range diskWatermarkBlockReasons {
+		if strings.Contains(reason, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the error wraps an ES error caused by a disk watermark block.
+func isDiskWatermarkBlockError(err error) bool {
+	var esErr *ESError
+	return errors.As(err, &esErr) && isDiskWatermarkBlock(esErr.Type, esErr.Reason)
+}
+
+// writeBlock tracks the block set by ES on indexes once the disk usage of a node exceeds the flood-stage watermark.
+// While the block persists, writes fail immediately with errWriteBlocked so that documents are written to the dead
+// letter sink. A single write is let through every probe interval to check whether the block has been released.
+// A nil writeBlock lets all the writes through.
+type writeBlock struct {
+	probeInterval time.Duration
+	// Returns the current time, replaced by tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	blocked   bool
+	blockedAt time.Time
+	lastProbe time.Time
+	// True while the probe write is in flight
+	probing bool
+}
+
+// Returns a write block tracker probing the block every probeInterval, nil if probeInterval is not positive.
+func newWriteBlock(probeInterval time.Duration) *writeBlock {
+	if probeInterval <= 0 {
+		return nil
+	}
+	emitWriteBlockMetric(false)
+	return &writeBlock{probeInterval: probeInterval, now: time.Now}
+}
+
+// allow returns an error wrapping errWriteBlocked if the write should not be sent to ES.
+// Once allowed, the write outcome has to be recorded.
+func (w *writeBlock) allow() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.blocked {
+		return nil
+	}
+	if !w.probing && w.now().Sub(w.lastProbe) >= w.probeInterval {
+		w.probing = true
+		w.lastProbe = w.now()
+		return nil
+	}
+	metrics.IncrCounter(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "write_block", "rejected"}), 1)
+	return errors.Wrapf(errWriteBlocked, "%s since %s, write not sent", diskWatermarkBlockMsg, w.blockedAt.Format(time.RFC3339))
+}
+
+// record updates the block state according to the outcome of an allowed write: the error of the request and the
+// operations that failed. It returns true if the indexes have just been detected as blocked.
+func (w *writeBlock) record(err error, failures []bulkOperationFailure) bool {
+	if w == nil {
+		return false
+	}
+	blocked := isDiskWatermarkBlockError(err)
+	for _, f := range failures {
+		if isDiskWatermarkBlock(f.errType, f.errReason) {
+			blocked = true
+			break
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.probing = false
+	switch {
+	case blocked && !w.blocked:
+		log.Printf("[ERROR] %s. Logs and events are written to the dead letter sink until the block is released, it is checked every %v", diskWatermarkBlockMsg, w.probeInterval)
+		w.blocked = true
+		w.blockedAt, w.lastProbe = w.now(), w.now()
+		emitWriteBlockMetric(true)
+		return true
+	case !blocked && w.blocked && err == nil:
+		// Other errors don't tell whether the block has been released
+		log.Printf("ES indexes are writable again after being read-only since %s", w.blockedAt.Format(time.RFC3339))
+		w.blocked = false
+		emitWriteBlockMetric(false)
+	}
+	return false
+}
+
+// Returns true if ES indexes are known to be read-only.
+func (w *writeBlock) isBlocked() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.blocked
+}
+
+// The write block gauge is 1 while ES indexes are read-only, 0 otherwise
+func emitWriteBlockMetric(blocked bool) {
+	var value float32
+	if blocked {
+		value = 1
+	}
+	metrics.SetGauge(metricsutil.CleanupMetricKey([]string{elasticMetricsPrefix, "write_block", "blocked"}), value)
+}
+
+// Logs the disk usage of the ES nodes, to confirm that a write block is due to a lack of disk space.
+func logNodesDiskUsage(ctx context.Context, c *esClient, conf elasticStoreConf) {
+	ctx, cancel := withRequestTimeout(ctx, conf)
+	defer cancel()
+	req := esapi.CatAllocationRequest{Format: "json", H: []string{"node", "disk.percent", "disk.avail", "disk.total"}}
+	res, err := req.Do(ctx, c)
+	defer closeResponseBody("CatAllocationRequest", res)
+	if err = handleESResponseError(res, "CatAllocationRequest", "", err); err != nil {
+		log.Printf("[WARN] Not able to retrieve the disk usage of ES nodes: %v", err)
+		return
+	}
+	var nodes []struct {
+		Node        string `json:"node"`
+		DiskPercent string `json:"disk.percent"`
+		DiskAvail   string `json:"disk.avail"`
+		DiskTotal   string `json:"disk.total"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&nodes); err != nil {
+		log.Printf("[WARN] Not able to decode the disk usage of ES nodes: %v", err)
+		return
+	}
+	for _, n := range nodes {
+		// Unassigned shards are reported as a node without disk usage
+		if n.DiskPercent == "" {
+			continue
+		}
+		log.Printf("[ERROR] Disk usage of ES node %s is %s%%, %s available out of %s", n.Node, n.DiskPercent, n.DiskAvail, n.DiskTotal)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elastic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	elasticsearch6 "github.com/elastic/go-elasticsearch/v6"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The bulk item errors returned by ES 6.x and 7.x once the flood-stage disk watermark is exceeded
+const (
+	es6DiskWatermarkBlockItem = `{"index":{"status":403,"error":{"type":"cluster_block_exception","reason":"blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];"}}}`
+	es7DiskWatermarkBlockItem = `{"index":{"status":429,"error":{"type":"cluster_block_exception","reason":"index [yorc_logs] blocked by: [TOO_MANY_REQUESTS/12/disk usage exceeded flood-stage watermark, index has read-only-allow-delete block];"}}}`
+)
+
+func TestIsDiskWatermarkBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		errType string
+		reason  string
+		want    bool
+	}{
+		{"ES6", "cluster_block_exception", "blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];", true},
+		{"ES7", "cluster_block_exception", "index [yorc_logs] blocked by: [TOO_MANY_REQUESTS/12/disk usage exceeded flood-stage watermark, index has read-only-allow-delete block];", true},
+		{"ReadOnlyIndex", "cluster_block_exception", "blocked by: [FORBIDDEN/5/index read-only (api)];", false},
+		{"OtherError", "mapper_parsing_exception", "read_only_allow_delete", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isDiskWatermarkBlock(tt.errType, tt.reason))
+		})
+	}
+}
+
+func TestWriteBlockDisabled(t *testing.T) {
+	w := newWriteBlock(0)
+	assert.Nil(t, w)
+	assert.False(t, w.record(&ESError{StatusCode: http.StatusForbidden, Type: "cluster_block_exception", Reason: "read_only_allow_delete"}, nil))
+	require.NoError(t, w.allow())
+	assert.False(t, w.isBlocked())
+}
+
+func TestWriteBlockStates(t *testing.T) {
+	now := time.Now()
+	w := newWriteBlock(time.Minute)
+	w.now = func() time.Time { return now }
+	blockFailure := bulkOperationFailure{status: http.StatusForbidden, errType: "cluster_block_exception", errReason: "blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];"}
+
+	// Other failures don't block writes
+	require.NoError(t, w.allow())
+	assert.False(t, w.record(&ESError{StatusCode: http.StatusServiceUnavailable}, []bulkOperationFailure{{status: http.StatusBadRequest, errType: "mapper_parsing_exception"}}))
+	require.NoError(t, w.allow())
+	assert.True(t, w.record(nil, []bulkOperationFailure{blockFailure}))
+	assert.True(t, w.isBlocked())
+
+	// Writes are short-circuited until the probe interval elapsed
+	err := w.allow()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errWriteBlocked), "unexpected error %v", err)
+	now = now.Add(time.Minute)
+	require.NoError(t, w.allow())
+	assert.Error(t, w.allow())
+	assert.False(t, w.record(nil, []bulkOperationFailure{blockFailure}))
+	assert.Error(t, w.allow())
+
+	// A probe failing for another reason doesn't release the block, a successful one does
+	now = now.Add(time.Minute)
+	require.NoError(t, w.allow())
+	w.record(&ESError{StatusCode: http.StatusServiceUnavailable}, nil)
+	assert.True(t, w.isBlocked())
+	now = now.Add(time.Minute)
+	require.NoError(t, w.allow())
+	w.record(nil, nil)
+	assert.False(t, w.isBlocked())
+	require.NoError(t, w.allow())
+}
+
+func TestWriteBlockShortCircuitsBulkRequests(t *testing.T) {
+	for _, item := range []string{es6DiskWatermarkBlockItem, es7DiskWatermarkBlockItem} {
+		var mu sync.Mutex
+		var bulks, allocations int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Path {
+			case "/_bulk":
+				bulks++
+				w.Write([]byte(`{"took":1,"errors":true,"items":[` + item + `]}`))
+			case "/_cat/allocation":
+				allocations++
+				w.Write([]byte(`[{"node":"es-1","disk.percent":"96","disk.avail":"2gb","disk.total":"50gb"},{"node":"UNASSIGNED"}]`))
+			default:
+				w.Write([]byte(`{"cluster_name":"yorc","status":"green","number_of_nodes":1,"version":{"number":"7.17.1"}}`))
+			}
+		}))
+		t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+		require.NoError(t, err)
+		cfg := elasticStoreConf{
+			BulkRetryBaseDelay:      time.Millisecond,
+			BulkMaxRetries:          5,
+			BulkRetryMaxElapsedTime: time.Second,
+			HealthCheckTimeout:      time.Second,
+		}
+		c := &esClient{Transport: t6, majorVersion: 7, writeBlock: newWriteBlock(time.Minute)}
+		body := []byte(`{"index":{"_index":"yorc_logs"}}` + "\n" + `{"content":"log"}` + "\n")
+
+		// The block is detected by the first request, ES 7.x rejected operations are not retried once detected,
+		// and the disk usage is checked once
+		sendBulkRequest(context.Background(), c, cfg, 1, &body)
+		_, err = sendBulkRequest(context.Background(), c, cfg, 1, &body)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, errWriteBlocked), "unexpected error %v", err)
+		assert.Equal(t, 1, bulks)
+		assert.Equal(t, 1, allocations)
+
+		s := &elasticStore{esClient: c, cfg: cfg}
+		assert.True(t, s.Check(context.Background()).WriteBlocked)
+		srv.Close()
+	}
+}
+
+func TestHandleESResponseErrorDiskWatermarkBlock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"type":"cluster_block_exception","reason":"blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];"},"status":403}`))
+	}))
+	defer srv.Close()
+	t6, err := elasticsearch6.NewClient(elasticsearch6.Config{Addresses: []string{srv.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	res, err := t6.Index("yorc_logs", nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	err = handleESResponseError(res, "Index:yorc_logs", "{}", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "flood-stage watermark")
+	assert.True(t, isDiskWatermarkBlockError(err))
+	assert.Equal(t, http.StatusForbidden, esErrorStatusCode(err))
+}
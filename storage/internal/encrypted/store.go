@@ -0,0 +1,113 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encrypted provides a store decorator encrypting values before storing them into another store.
+package encrypted
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/storage/encryption"
+	"github.com/ystia/yorc/v4/storage/internal/transformed"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// Name of the field holding the encrypted value in stored documents
+const encryptedField = "_encrypted"
+
+// encryptedValue is the stored representation of an encrypted value.
+// The key ID allows to select the key to use for decryption after a key rotation.
+type encryptedValue struct {
+	KeyID string `json:"keyId"`
+	Data  []byte `json:"data"`
+}
+
+// StoreOptions defines the keys used by an encrypted store
+type StoreOptions struct {
+	// Keys are the 32-bits length passphrases used to generate the encryption keys, indexed by key ID
+	Keys map[string]string
+	// KeyID is the ID of the key used to encrypt new values, it can be omitted if only one key is defined
+	KeyID string
+	// ClearFields are the top-level fields of values that are stored unencrypted along with the encrypted value,
+	// so that they can still be queried (ie. deploymentId for logs and events)
+	ClearFields []string
+}
+
+type encryptedStore struct {
+	*transformed.Store
+	encryptors map[string]*encryption.Encryptor
+	keyID      string
+}
+
+// NewStore returns a store encrypting values with AES-GCM before storing them into the given store.
+// Values are decrypted on read, values that were stored unencrypted remain readable.
+// Keys, indexes and clear fields are not encrypted so that queries relying only on them do not need decryption.
+func NewStore(underlying store.Store, options StoreOptions) (store.Store, error) {
+	if len(options.Keys) == 0 {
+		return nil, errors.New("missing encryption keys")
+	}
+	s := &encryptedStore{
+		encryptors: make(map[string]*encryption.Encryptor, len(options.Keys)),
+		keyID:      options.KeyID,
+	}
+	for id, passphrase := range options.Keys {
+		if len(passphrase) != 32 {
+			return nil, errors.Errorf("The passphrase of encryption key with ID:%q must be 32-bits length", id)
+		}
+		encryptor, err := encryption.NewEncryptor(hex.EncodeToString([]byte(passphrase)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to build encryption key with ID:%q", id)
+		}
+		s.encryptors[id] = encryptor
+		if options.KeyID == "" && len(options.Keys) == 1 {
+			s.keyID = id
+		}
+	}
+	if _, ok := s.encryptors[s.keyID]; !ok {
+		return nil, errors.Errorf("unknown encryption key ID:%q, it should be one of the defined keys IDs", s.keyID)
+	}
+	s.Store = transformed.NewStore(underlying, transformed.Transform{
+		Name:        "encrypted",
+		Field:       encryptedField,
+		Encode:      s.encrypt,
+		Decode:      s.decrypt,
+		ClearFields: options.ClearFields,
+	})
+	return s, nil
+}
+
+// encrypt returns the encrypted value of the given data
+func (s *encryptedStore) encrypt(data []byte) (interface{}, error) {
+	encrypted, err := s.encryptors[s.keyID].Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedValue{KeyID: s.keyID, Data: encrypted}, nil
+}
+
+// decrypt returns the decrypted value of the encrypted field of a stored document
+func (s *encryptedStore) decrypt(field json.RawMessage) ([]byte, error) {
+	var value encryptedValue
+	if err := json.Unmarshal(field, &value); err != nil {
+		return nil, errors.Wrap(err, "invalid encrypted value")
+	}
+	encryptor, ok := s.encryptors[value.KeyID]
+	if !ok {
+		return nil, errors.Errorf("unknown encryption key ID:%q", value.KeyID)
+	}
+	return encryptor.Decrypt(value.Data)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypted
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/internal/transformed"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+const (
+	testKey1 = "myverystrongpasswordo32bitlength"
+	testKey2 = "anotherstrongpasswordof32bitslen"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	transformed.CommonTransformedStoreTest(t, encryptedField, func(underlying store.Store) (store.Store, error) {
+		return NewStore(underlying, StoreOptions{Keys: map[string]string{"k1": testKey1}})
+	})
+}
+
+func TestNewStoreErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options StoreOptions
+	}{
+		{"NoKeys", StoreOptions{}},
+		{"InvalidPassphrase", StoreOptions{Keys: map[string]string{"k1": "tooshort"}}},
+		{"MissingKeyID", StoreOptions{Keys: map[string]string{"k1": testKey1, "k2": testKey2}}},
+		{"UnknownKeyID", StoreOptions{Keys: map[string]string{"k1": testKey1}, KeyID: "k2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStore(nil, tt.options)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEncryptedStoreKeyRotation(t *testing.T) {
+	cfg := store.SetupTestConfig(t)
+	defer os.RemoveAll(cfg.WorkingDirectory)
+	ctx := context.Background()
+	underlying := transformed.NewTestFileStore(t, cfg)
+
+	oldStore, err := NewStore(underlying, StoreOptions{Keys: map[string]string{"k1": testKey1}})
+	require.NoError(t, err)
+	require.NoError(t, oldStore.Set(ctx, "values/old", map[string]interface{}{"content": "secret"}))
+
+	// After a key rotation, values encrypted with the previous key remain readable
+	newStore, err := NewStore(underlying, StoreOptions{Keys: map[string]string{"k1": testKey1, "k2": testKey2}, KeyID: "k2"})
+	require.NoError(t, err)
+	require.NoError(t, newStore.Set(ctx, "values/new", map[string]interface{}{"content": "new secret"}))
+
+	for k, expected := range map[string]string{"values/old": "secret", "values/new": "new secret"} {
+		var value map[string]interface{}
+		found, err := newStore.Get(k, &value)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, expected, value["content"])
+	}
+	kvs, _, err := newStore.List(ctx, "values", 0, 0)
+	require.NoError(t, err)
+	require.Len(t, kvs, 2)
+
+	// Values encrypted with a removed key can't be read
+	var value map[string]interface{}
+	_, err = oldStore.Get("values/new", &value)
+	assert.Error(t, err)
+}
@@ -276,7 +276,11 @@ func (s *fileStore) getValueFromFile(filePath string, v interface{}) (bool, []by
 	return true, data, errors.Wrapf(s.codec.Unmarshal(data, v), "failed to unmarshal data:%q", string(data))
 }
 
-func (s *fileStore) Exist(k string) (bool, error) {
+func (s *fileStore) Exist(ctx context.Context, k string) (bool, error) {
+	if err := utils.CheckKey(k); err != nil {
+		return false, err
+	}
+
 	filePath := s.buildFilePath(k, true)
 
 	_, err := os.Stat(filePath)
@@ -290,6 +294,29 @@ func (s *fileStore) Exist(k string) (bool, error) {
 	return true, nil
 }
 
+func (s *fileStore) GetBatch(ctx context.Context, keys []string) (map[string]store.KeyValueOut, error) {
+	values := make(map[string]store.KeyValueOut, len(keys))
+	for _, k := range keys {
+		if err := utils.CheckKey(k); err != nil {
+			return nil, err
+		}
+		filePath := s.buildFilePath(k, true)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get value for key:%q", k)
+		}
+		kv, err := s.addKeyValueToList(info, filePath, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = *kv
+	}
+	return values, nil
+}
+
 func (s *fileStore) Keys(k string) ([]string, error) {
 	filePath := s.buildFilePath(k, false)
 
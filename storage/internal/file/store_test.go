@@ -17,7 +17,9 @@ package file
 import (
 	"os"
 	"path"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -48,6 +50,12 @@ func TestRunFileStoragePackageTests(t *testing.T) {
 		t.Run("testFileStoreTypesWithCache", func(t *testing.T) {
 			testFileStoreTypesWithCache(t, cfg)
 		})
+		t.Run("testFileStoreExistAndGetBatchWithEncryption", func(t *testing.T) {
+			testFileStoreExistAndGetBatchWithEncryption(t, cfg)
+		})
+		t.Run("testFileStoreLastIndex", func(t *testing.T) {
+			testFileStoreLastIndex(t, cfg)
+		})
 	})
 }
 
@@ -93,3 +101,24 @@ func testFileStoreTypesWithCache(t *testing.T, cfg config.Configuration) {
 	require.NoError(t, err, "failed to instantiate new store")
 	store.CommonStoreTestAllTypes(t, fileStore)
 }
+
+func testFileStoreExistAndGetBatchWithEncryption(t *testing.T, cfg config.Configuration) {
+	props := config.DynamicMap{
+		"passphrase": "myverystrongpasswordo32bitlength",
+		"root_dir":   path.Join(cfg.WorkingDirectory, t.Name()),
+	}
+	fileStore, err := NewStore(cfg, "testStoreID", props, false, true)
+	require.NoError(t, err, "failed to instantiate new store")
+	store.CommonStoreTestExistAndGetBatch(t, fileStore)
+}
+
+func testFileStoreLastIndex(t *testing.T, cfg config.Configuration) {
+	props := config.DynamicMap{
+		"root_dir": path.Join(cfg.WorkingDirectory, t.Name()),
+	}
+	fileStore, err := NewStore(cfg, "testStoreID", props, false, false)
+	require.NoError(t, err, "failed to instantiate new store")
+	store.CommonStoreTestLastIndex(t, fileStore, "lastIndex", func() string {
+		return path.Join("lastIndex", strconv.FormatInt(time.Now().UnixNano(), 10))
+	})
+}
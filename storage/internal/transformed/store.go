@@ -0,0 +1,199 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transformed provides the base of store decorators transforming values (ie. to compress or encrypt them)
+// before storing them into another store.
+package transformed
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ystia/yorc/v4/storage/encoding"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// Transform defines how a decorator transforms values
+type Transform struct {
+	// Name qualifies transformed values in error messages (ie. compressed)
+	Name string
+	// Field is the name of the field holding the transformed value in stored documents
+	Field string
+	// Encode returns the transformed value of the given marshaled value, it is stored in Field.
+	// A nil transformed value means that the value is stored as is.
+	Encode func(data []byte) (interface{}, error)
+	// Decode returns the marshaled value from the JSON content of Field
+	Decode func(field json.RawMessage) ([]byte, error)
+	// ClearFields are the top-level fields of values that are stored as is along with the transformed value,
+	// so that they can still be queried (ie. deploymentId for logs and events)
+	ClearFields []string
+}
+
+// Store transforms values before storing them into the underlying store, and reverts the transformation on read.
+// Values that were stored untransformed remain readable. Keys, indexes and clear fields are not transformed so that
+// queries relying only on them do not need to read values.
+type Store struct {
+	store.Store
+	transform Transform
+}
+
+// NewStore returns a store transforming values before storing them into the given store
+func NewStore(underlying store.Store, transform Transform) *Store {
+	return &Store{Store: underlying, transform: transform}
+}
+
+// Unwrap returns the store in which transformed values are stored
+func (s *Store) Unwrap() store.Store {
+	return s.Store
+}
+
+func (s *Store) Set(ctx context.Context, k string, v interface{}) error {
+	value, err := s.encode(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to build %s value for key:%q", s.transform.Name, k)
+	}
+	return s.Store.Set(ctx, k, value)
+}
+
+func (s *Store) SetCollection(ctx context.Context, keyValues []store.KeyValueIn) error {
+	transformed := make([]store.KeyValueIn, len(keyValues))
+	for i, kv := range keyValues {
+		value, err := s.encode(kv.Value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to build %s value for key:%q", s.transform.Name, kv.Key)
+		}
+		transformed[i] = store.KeyValueIn{Key: kv.Key, Value: value}
+	}
+	return s.Store.SetCollection(ctx, transformed)
+}
+
+func (s *Store) Get(k string, v interface{}) (bool, error) {
+	var raw json.RawMessage
+	found, err := s.Store.Get(k, &raw)
+	if err != nil || !found {
+		return found, err
+	}
+	data, _, err := s.decode(raw)
+	if err != nil {
+		return true, errors.Wrapf(err, "failed to read %s value for key:%q", s.transform.Name, k)
+	}
+	return true, errors.Wrapf(encoding.JSON.Unmarshal(data, v), "failed to unmarshal data for key:%q", k)
+}
+
+func (s *Store) GetBatch(ctx context.Context, keys []string) (map[string]store.KeyValueOut, error) {
+	values, err := s.Store.GetBatch(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for k, kv := range values {
+		values[k], err = s.decodeKeyValue(kv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *Store) List(ctx context.Context, k string, waitIndex uint64, timeout time.Duration) ([]store.KeyValueOut, uint64, error) {
+	values, lastIndex, err := s.Store.List(ctx, k, waitIndex, timeout)
+	if err != nil {
+		return values, lastIndex, err
+	}
+	for i := range values {
+		values[i], err = s.decodeKeyValue(values[i])
+		if err != nil {
+			return nil, lastIndex, err
+		}
+	}
+	return values, lastIndex, nil
+}
+
+// encode returns the document stored for the given value: the transformed value and its clear fields,
+// or the value itself if it is not transformed
+func (s *Store) encode(v interface{}) (json.RawMessage, error) {
+	data, err := encoding.JSON.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal value")
+	}
+	transformed, err := s.transform.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	if transformed == nil {
+		return data, nil
+	}
+
+	document := make(map[string]interface{}, len(s.transform.ClearFields)+1)
+	if len(s.transform.ClearFields) > 0 {
+		var fields map[string]interface{}
+		// Values which are not objects have no fields to keep
+		if json.Unmarshal(data, &fields) == nil {
+			for _, field := range s.transform.ClearFields {
+				if fieldValue, ok := fields[field]; ok {
+					document[field] = fieldValue
+				}
+			}
+		}
+	}
+	document[s.transform.Field] = transformed
+	return json.Marshal(document)
+}
+
+// decode returns the value of a stored document, and whether it was transformed
+func (s *Store) decode(raw []byte) ([]byte, bool, error) {
+	var document map[string]json.RawMessage
+	if json.Unmarshal(raw, &document) != nil {
+		return raw, false, nil
+	}
+	field, ok := document[s.transform.Field]
+	if !ok || string(field) == "null" {
+		return raw, false, nil
+	}
+	data, err := s.transform.Decode(field)
+	return data, true, err
+}
+
+// decodeKeyValue decodes a listed value.
+// Fields added to the document by the underlying store (ie. the elastic iid) are kept in the decoded value.
+func (s *Store) decodeKeyValue(kv store.KeyValueOut) (store.KeyValueOut, error) {
+	data, transformed, err := s.decode(kv.RawValue)
+	if err != nil {
+		return kv, errors.Wrapf(err, "failed to read %s value for key:%q", s.transform.Name, kv.Key)
+	}
+	if !transformed {
+		return kv, nil
+	}
+	var value map[string]interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return kv, errors.Wrapf(err, "failed to unmarshal %s value for key:%q", s.transform.Name, kv.Key)
+	}
+	kv.RawValue = data
+	for field, fieldValue := range kv.Value {
+		if _, ok := value[field]; !ok && field != s.transform.Field {
+			value[field] = fieldValue
+			kv.RawValue = nil
+		}
+	}
+	if kv.RawValue == nil {
+		kv.RawValue, err = json.Marshal(value)
+		if err != nil {
+			return kv, errors.Wrapf(err, "failed to marshal %s value for key:%q", s.transform.Name, kv.Key)
+		}
+	}
+	kv.Value = value
+	return kv, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformed
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+const testField = "_reversed"
+
+// Returns a store reversing values of at least minSize bytes
+func newReversedStore(underlying store.Store, minSize int, clearFields ...string) *Store {
+	return NewStore(underlying, Transform{
+		Name:  "reversed",
+		Field: testField,
+		Encode: func(data []byte) (interface{}, error) {
+			if len(data) < minSize {
+				return nil, nil
+			}
+			return reverse(data), nil
+		},
+		Decode: func(field json.RawMessage) ([]byte, error) {
+			var data []byte
+			if err := json.Unmarshal(field, &data); err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.New("empty reversed value")
+			}
+			return reverse(data), nil
+		},
+		ClearFields: clearFields,
+	})
+}
+
+func reverse(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed
+}
+
+func TestTransformedStore(t *testing.T) {
+	CommonTransformedStoreTest(t, testField, func(underlying store.Store) (store.Store, error) {
+		return newReversedStore(underlying, 0), nil
+	})
+}
+
+func TestTransformedStoreDocuments(t *testing.T) {
+	cfg := store.SetupTestConfig(t)
+	defer os.RemoveAll(cfg.WorkingDirectory)
+	ctx := context.Background()
+	underlying := NewTestFileStore(t, cfg)
+	s := newReversedStore(underlying, 32, "deploymentId")
+
+	require.NoError(t, s.SetCollection(ctx, []store.KeyValueIn{
+		{Key: "values/big", Value: map[string]interface{}{"deploymentId": "MyApp", "content": "some long enough content"}},
+		{Key: "values/small", Value: map[string]interface{}{"content": "small"}},
+	}))
+
+	// Values are transformed in the underlying store except clear fields and values the transform skips
+	var raw map[string]interface{}
+	found, err := underlying.Get("values/big", &raw)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "MyApp", raw["deploymentId"])
+	assert.NotContains(t, raw, "content")
+	assert.Contains(t, raw, testField)
+	var small map[string]interface{}
+	found, err = underlying.Get("values/small", &small)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, map[string]interface{}{"content": "small"}, small)
+
+	kvs, err := s.GetBatch(ctx, []string{"values/big", "values/small"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"deploymentId": "MyApp", "content": "some long enough content"}, kvs["values/big"].Value)
+	assert.Equal(t, map[string]interface{}{"content": "small"}, kvs["values/small"].Value)
+
+	// Values which can't be decoded are reported
+	require.NoError(t, underlying.Set(ctx, "values/invalid", map[string]interface{}{testField: ""}))
+	_, err = s.Get("values/invalid", &raw)
+	assert.Error(t, err)
+	_, _, err = s.List(ctx, "values", 0, 0)
+	assert.Error(t, err)
+}
+
+func TestTransformedStoreKeepsUnderlyingFields(t *testing.T) {
+	s := newReversedStore(nil, 0, "deploymentId")
+
+	document, err := s.encode(json.RawMessage(`{"deploymentId":"MyApp","content":"secret"}`))
+	require.NoError(t, err)
+	// Simulate a store adding its own fields to the document, as the elastic store does with iid
+	var value map[string]interface{}
+	require.NoError(t, json.Unmarshal(document, &value))
+	value["iid"] = "42"
+	kv, err := s.decodeKeyValue(store.KeyValueOut{Key: "k", RawValue: document, Value: value})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"deploymentId": "MyApp", "content": "secret", "iid": "42"}, kv.Value)
+	assert.JSONEq(t, `{"deploymentId":"MyApp","content":"secret","iid":"42"}`, string(kv.RawValue))
+}
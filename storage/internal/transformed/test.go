@@ -0,0 +1,91 @@
+// Copyright 2019 Bull S.A.S. Atos Technologies - Bull, Rue Jean Jaures, B.P.68, 78340, Les Clayes-sous-Bois, France.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transformed
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ystia/yorc/v4/config"
+	"github.com/ystia/yorc/v4/storage/internal/file"
+	"github.com/ystia/yorc/v4/storage/store"
+)
+
+// NewTestFileStore returns a file store to be decorated in tests
+func NewTestFileStore(t testing.TB, cfg config.Configuration) store.Store {
+	props := config.DynamicMap{
+		"root_dir": path.Join(cfg.WorkingDirectory, t.Name()),
+	}
+	fileStore, err := file.NewStore(cfg, "testStoreID", props, false, false)
+	require.NoError(t, err, "failed to instantiate new store")
+	return fileStore
+}
+
+// CommonTransformedStoreTest runs the common store tests against the stores returned by newStore decorating a file
+// store, and checks that values stored untransformed remain readable
+func CommonTransformedStoreTest(t *testing.T, field string, newStore func(underlying store.Store) (store.Store, error)) {
+	cfg := store.SetupTestConfig(t)
+	defer os.RemoveAll(cfg.WorkingDirectory)
+
+	t.Run("AllTypes", func(t *testing.T) {
+		s, err := newStore(NewTestFileStore(t, cfg))
+		require.NoError(t, err)
+		store.CommonStoreTestAllTypes(t, s)
+	})
+	t.Run("ExistAndGetBatch", func(t *testing.T) {
+		s, err := newStore(NewTestFileStore(t, cfg))
+		require.NoError(t, err)
+		store.CommonStoreTestExistAndGetBatch(t, s)
+	})
+	t.Run("UntransformedValues", func(t *testing.T) {
+		ctx := context.Background()
+		underlying := NewTestFileStore(t, cfg)
+		require.NoError(t, underlying.Set(ctx, "values/legacy", map[string]interface{}{"content": "legacy"}))
+		s, err := newStore(underlying)
+		require.NoError(t, err)
+		require.NoError(t, s.Set(ctx, "values/new", map[string]interface{}{"content": "new"}))
+
+		var raw map[string]interface{}
+		found, err := underlying.Get("values/new", &raw)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.NotContains(t, raw, "content")
+		assert.Contains(t, raw, field)
+
+		for k, expected := range map[string]string{"values/legacy": "legacy", "values/new": "new"} {
+			var value map[string]interface{}
+			found, err = s.Get(k, &value)
+			require.NoError(t, err)
+			require.True(t, found)
+			assert.Equal(t, expected, value["content"])
+		}
+		kvs, _, err := s.List(ctx, "values", 0, 0)
+		require.NoError(t, err)
+		require.Len(t, kvs, 2)
+		for _, kv := range kvs {
+			assert.NotContains(t, kv.Value, field)
+			assert.Contains(t, kv.Value, "content")
+			var value map[string]interface{}
+			require.NoError(t, json.Unmarshal(kv.RawValue, &value))
+			assert.Equal(t, kv.Value, value)
+		}
+	})
+}
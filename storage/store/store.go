@@ -16,9 +16,19 @@ package store
 
 import (
 	"context"
+	"io"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// ErrBusy is returned by stores which can't accept writes for now, for instance while their backend is too slow.
+// The write may be retried later, callers should slow down.
+var ErrBusy = errors.New("store is busy")
+
+// ErrUnavailable is returned when the service used by a store is considered unavailable and requests are not sent to it
+var ErrUnavailable = errors.New("storage unavailable")
+
 // Store is an abstraction for different key-value store implementations.
 // A store must be able to store, retrieve and delete key-value pairs,
 // with the key being a string and the value being any Go interface{}.
@@ -45,8 +55,13 @@ type Store interface {
 	Get(k string, v interface{}) (bool, error)
 	// Exist returns true if the key exists in the store
 	// If no value is found it returns (false, nil).
-	// The key must not be "" and the pointer must not be nil.
-	Exist(k string) (bool, error)
+	// The key must not be "".
+	Exist(ctx context.Context, k string) (bool, error)
+	// GetBatch retrieves the values of the given keys in as few requests as the implementation allows.
+	// The values are returned in the same generic and raw formats as List, indexed by key.
+	// Missing keys are not an error, they are just absent of the returned map.
+	// Keys must not be "".
+	GetBatch(ctx context.Context, keys []string) (map[string]KeyValueOut, error)
 	// Keys returns all the sub-keys of a specified one.
 	// The key must not be "".
 	// If no sub-key is found, it returns an empty slice.
@@ -57,7 +72,9 @@ type Store interface {
 	// The key must not be "".
 	// If recursive is true, all sub-keys are deleted too.
 	Delete(ctx context.Context, k string, recursive bool) error
-	// GetLastModifyIndex returns the last index that modified the key k
+	// GetLastModifyIndex returns the last index that modified the key k or any of its sub-keys.
+	// Indexes are monotonic: any write of k or of one of its sub-keys makes the returned index strictly greater than
+	// the index returned before the write. Indexes are only comparable within a store.
 	GetLastModifyIndex(k string) (uint64, error)
 	// List allows to lookup all sub-keys recursively under the defined key k and provided associated values.
 	// The key must not be "" and v must not be nil.
@@ -66,6 +83,53 @@ type Store interface {
 	// ctx can be useful to cancel a blocking query
 	// The values are retrieved in a KeyValueOut collection.
 	// It allows to return values in raw format without decode and in decoded generic format (map[string]interface{}
-	// The lastIndex is returned to perform new blocking query.
+	// The lastIndex is returned to perform new blocking query, all the stores implement the same contract:
+	//  - only values with a LastModifyIndex strictly greater than waitIndex are returned
+	//  - the returned lastIndex is greater or equal than waitIndex and than the LastModifyIndex of all returned values
+	//  - if no value is written before the timeout, no value is returned and lastIndex is equal to waitIndex (or to the
+	//    current last index if waitIndex is 0)
+	// See CommonStoreTestLastIndex for the tests asserting this contract.
 	List(ctx context.Context, k string, waitIndex uint64, timeout time.Duration) ([]KeyValueOut, uint64, error)
 }
+
+// Wrapper is implemented by stores decorating another store (to encrypt values for instance).
+type Wrapper interface {
+	// Unwrap returns the decorated store
+	Unwrap() Store
+}
+
+// HealthChecker is implemented by stores relying on an external service, so that its health can be checked.
+type HealthChecker interface {
+	// Check returns the health of the service used by the store.
+	// The check should not last more than the given context allows.
+	Check(ctx context.Context) HealthStatus
+}
+
+// LevelLister is implemented by stores able to filter log entries on their level when listing them.
+type LevelLister interface {
+	// ListLevels works as List but only returns the values having one of the given levels.
+	ListLevels(ctx context.Context, k string, levels []string, waitIndex uint64, timeout time.Duration) ([]KeyValueOut, uint64, error)
+}
+
+// Exporter is implemented by stores able to export all their documents at once (for compliance purposes for instance).
+type Exporter interface {
+	// Export writes the values stored under the given key to w as NDJSON, one value per line,
+	// and returns the number of exported values.
+	Export(ctx context.Context, k string, w io.Writer) (int, error)
+}
+
+// Reindexer is implemented by stores able to migrate their values into new indexes when their mapping changes.
+type Reindexer interface {
+	// Reindex copies the values stored under the given key (ie. "_yorc/logs") into new indexes using the current mapping,
+	// then replaces the former indexes by the new ones. The operation can be resumed if it is interrupted.
+	// If dryRun is true, the number of values to copy is reported but nothing is changed.
+	Reindex(ctx context.Context, k string, dryRun bool) ([]ReindexResult, error)
+}
+
+// Aggregator is implemented by stores able to compute statistics on their values without returning them.
+type Aggregator interface {
+	// Aggregate counts the values stored under the given key grouped as defined by the request.
+	// Buckets of the most frequent field values come first, buckets of a given field value are ordered by time.
+	// Buckets without values are not returned.
+	Aggregate(ctx context.Context, k string, request AggregationRequest) ([]AggregationBucket, error)
+}
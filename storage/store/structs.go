@@ -14,6 +14,8 @@
 
 package store
 
+import "time"
+
 // KeyValueIn describes a Key-Value representation Input for storing data
 type KeyValueIn struct {
 	Key   string
@@ -30,3 +32,60 @@ type KeyValueOut struct {
 	// RawValue is the raw value representation without decode
 	RawValue []byte
 }
+
+// HealthStatus represents the health of the service used by a store
+type HealthStatus struct {
+	// Reachable is true if the service can be reached
+	Reachable bool `json:"reachable"`
+	// Status is the service specific health status (green, yellow or red for Elasticsearch)
+	Status string `json:"status,omitempty"`
+	// Version is the version of the service
+	Version string `json:"version,omitempty"`
+	// Nodes is the number of reachable nodes of the service
+	Nodes int `json:"nodes,omitempty"`
+	// Error describes why the check failed
+	Error string `json:"error,omitempty"`
+	// CircuitBreaker is the state of the circuit breaker protecting the service (closed, open or half-open), if any
+	CircuitBreaker string `json:"circuit_breaker,omitempty"`
+	// WriteBlocked is true while the service refuses writes, because its disk is full for instance
+	WriteBlocked bool `json:"write_blocked,omitempty"`
+}
+
+// AggregationRequest defines how the values counted by an Aggregator are grouped
+type AggregationRequest struct {
+	// Field is the name of the field values are grouped by (ie. level, deploymentId or nodeId), values are not grouped by field if empty
+	Field string
+	// Size is the maximum number of field values returned, the most frequent ones are returned first
+	Size int
+	// Interval is the duration of the time buckets values are grouped by, values are not grouped by time if 0
+	Interval time.Duration
+	// From and To restrict the counted values to the ones created from From (inclusive) until To (exclusive), a zero time is not used
+	From time.Time
+	To   time.Time
+}
+
+// AggregationBucket is the number of values having a given field value and created during a given time bucket
+type AggregationBucket struct {
+	// Key is the field value, it is empty if values are not grouped by field
+	Key string `json:"key,omitempty"`
+	// Time is the start of the time bucket, it is zero if values are not grouped by time
+	Time time.Time `json:"time,omitempty"`
+	// Count is the number of values of the bucket
+	Count int `json:"count"`
+}
+
+// ReindexResult reports the reindexing of a store index into a new index using the current mapping
+type ReindexResult struct {
+	// Index is the name used by the store to read and write the index values
+	Index string `json:"index"`
+	// SourceIndex is the index the values are copied from
+	SourceIndex string `json:"source_index"`
+	// TargetIndex is the index the values are copied to, it replaces the source index once the values are copied
+	TargetIndex string `json:"target_index"`
+	// Documents is the number of values of the source index
+	Documents int `json:"documents"`
+	// Reindexed is the number of values copied to the target index, including the ones copied by a previous interrupted run
+	Reindexed int `json:"reindexed"`
+	// UpToDate is true if the index already uses the current mapping, nothing is copied then
+	UpToDate bool `json:"up_to_date,omitempty"`
+}
@@ -16,6 +16,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -329,6 +330,130 @@ func CommonStoreTest(t *testing.T, store Store) {
 	require.Nil(t, kvs)
 }
 
+// CommonStoreTestExistAndGetBatch allows to test existence checks and batch reads that all stores must support.
+// Missing keys must be reported as not found rather than as errors.
+func CommonStoreTestExistAndGetBatch(t *testing.T, store Store) {
+	ctx := context.Background()
+	prefix := strconv.FormatInt(rand.Int63(), 10)
+	key1 := prefix + "/batch/one"
+	key2 := prefix + "/batch/two"
+	missingKey := prefix + "/batch/missing"
+
+	found, err := store.Exist(ctx, key1)
+	require.NoError(t, err)
+	require.False(t, found, "key %q should not exist yet", key1)
+
+	values, err := store.GetBatch(ctx, []string{key1, key2})
+	require.NoError(t, err)
+	require.Len(t, values, 0)
+
+	err = store.SetCollection(ctx, []KeyValueIn{
+		{Key: key1, Value: Foo{Bar: "one"}},
+		{Key: key2, Value: Foo{Bar: "two"}},
+	})
+	require.NoError(t, err)
+
+	found, err = store.Exist(ctx, key1)
+	require.NoError(t, err)
+	require.True(t, found, "key %q should exist", key1)
+	found, err = store.Exist(ctx, missingKey)
+	require.NoError(t, err)
+	require.False(t, found, "key %q should not exist", missingKey)
+
+	values, err = store.GetBatch(ctx, []string{key1, missingKey, key2})
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	for k, expected := range map[string]string{key1: "one", key2: "two"} {
+		kv, ok := values[k]
+		require.True(t, ok, "key %q should be found", k)
+		assert.Equal(t, k, kv.Key)
+		assert.NotZero(t, kv.LastModifyIndex)
+		assert.NotEmpty(t, kv.RawValue)
+		actual := Foo{}
+		require.NoError(t, mapstructure.Decode(kv.Value, &actual))
+		assert.Equal(t, expected, actual.Bar)
+	}
+	_, ok := values[missingKey]
+	assert.False(t, ok, "key %q should not be found", missingKey)
+
+	values, err = store.GetBatch(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, values, 0)
+
+	_, err = store.Exist(ctx, "")
+	require.Error(t, err, "an empty key should be rejected")
+	_, err = store.GetBatch(ctx, []string{key1, ""})
+	require.Error(t, err, "an empty key should be rejected")
+
+	require.NoError(t, store.Delete(ctx, prefix, true))
+	found, err = store.Exist(ctx, key2)
+	require.NoError(t, err)
+	require.False(t, found, "key %q should have been deleted", key2)
+}
+
+// CommonStoreTestLastIndex allows to test the last modify index contract that all stores must fulfill, as described in the Store interface.
+// rootKey is the key under which values are stored using keys provided by newKey, they must be
+// of the form "_yorc/logs/<deploymentID>" and "_yorc/logs/<deploymentID>/<timestamp>" for stores managing only logs and events.
+func CommonStoreTestLastIndex(t *testing.T, store Store, rootKey string, newKey func() string) {
+	ctx := context.Background()
+	value := func(content string) json.RawMessage {
+		return json.RawMessage(`{"deploymentId":"lastIndexTest","content":"` + content + `"}`)
+	}
+
+	// A write increments the last index
+	initialIndex, err := store.GetLastModifyIndex(rootKey)
+	require.NoError(t, err)
+	key1 := newKey()
+	require.NoError(t, store.Set(ctx, key1, value("first write")))
+	index1, err := store.GetLastModifyIndex(rootKey)
+	require.NoError(t, err)
+	require.True(t, index1 > initialIndex, "last index %d should be greater than %d after a write", index1, initialIndex)
+
+	// A non-blocking List returns all values and a last index greater or equal than their indexes
+	kvs, lastIndex, err := store.List(ctx, rootKey, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, kvs, 1)
+	// Stores managing logs and events return document IDs as keys, so values are identified by their content
+	assert.Equal(t, "first write", kvs[0].Value["content"])
+	assert.True(t, kvs[0].LastModifyIndex > 0 && kvs[0].LastModifyIndex <= lastIndex,
+		"value index %d should be positive and lower or equal than the last index %d", kvs[0].LastModifyIndex, lastIndex)
+	assert.True(t, lastIndex >= index1, "List last index %d should be greater or equal than %d", lastIndex, index1)
+
+	// A blocking List without any write returns no value when the timeout is reached, the last index doesn't change
+	start := time.Now()
+	kvs, nextIndex, err := store.List(ctx, rootKey, lastIndex, 500*time.Millisecond)
+	require.NoError(t, err)
+	assert.Len(t, kvs, 0)
+	assert.Equal(t, lastIndex, nextIndex)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "a blocking List should wait for the timeout")
+
+	// A blocking List returns only the values written after waitIndex, with greater indexes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		kvs, nextIndex, err = store.List(ctx, rootKey, lastIndex, 10*time.Second)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	key2 := newKey()
+	require.NoError(t, store.Set(ctx, key2, value("second write")))
+	<-done
+	require.NoError(t, err)
+	require.Len(t, kvs, 1)
+	assert.Equal(t, "second write", kvs[0].Value["content"])
+	assert.True(t, kvs[0].LastModifyIndex > lastIndex, "value index %d should be greater than waitIndex %d", kvs[0].LastModifyIndex, lastIndex)
+	assert.True(t, nextIndex >= kvs[0].LastModifyIndex, "List last index %d should be greater or equal than the value index %d", nextIndex, kvs[0].LastModifyIndex)
+	index2, err := store.GetLastModifyIndex(rootKey)
+	require.NoError(t, err)
+	assert.True(t, index2 > index1, "last index %d should be greater than %d after a write", index2, index1)
+
+	// A blocking List with an index lower than the last index returns immediately
+	start = time.Now()
+	kvs, _, err = store.List(ctx, rootKey, lastIndex, 10*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, kvs, 1)
+	assert.True(t, time.Since(start) < 5*time.Second, "a blocking List should not wait when values are newer than waitIndex")
+}
+
 // CommonStoreTestAllTypes allows to test storage of all types
 func CommonStoreTestAllTypes(t *testing.T, store Store) {
 	ctx := context.Background()
@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"path"
 	"strings"
@@ -25,13 +26,16 @@ import (
 
 	"github.com/matryer/resync"
 	"github.com/pkg/errors"
+	"github.com/spf13/cast"
 
 	"github.com/ystia/yorc/v4/config"
 	"github.com/ystia/yorc/v4/helper/collections"
 	"github.com/ystia/yorc/v4/helper/consulutil"
 	"github.com/ystia/yorc/v4/log"
+	"github.com/ystia/yorc/v4/storage/internal/compressed"
 	"github.com/ystia/yorc/v4/storage/internal/consul"
 	"github.com/ystia/yorc/v4/storage/internal/elastic"
+	"github.com/ystia/yorc/v4/storage/internal/encrypted"
 	"github.com/ystia/yorc/v4/storage/internal/file"
 	"github.com/ystia/yorc/v4/storage/store"
 	"github.com/ystia/yorc/v4/storage/types"
@@ -63,6 +67,9 @@ const defaultCacheMaxCost = "1e7"
 
 const defaultCacheBufferItems = "64"
 
+// Size in bytes under which values are not compressed
+const defaultCompressionMinSize = 1024
+
 var once resync.Once
 
 // stores implementations provided with GetStore(types.StoreType)
@@ -372,9 +379,62 @@ func createStoreImpl(cfg config.Configuration, configStore config.Store) (store.
 	default:
 		log.Printf("[WARNING] unknown store implementation:%q. This will be ignored.", impl)
 	}
+	if storeImpl != nil && configStore.Properties.IsSet("encryption_keys") {
+		storeImpl, err = newEncryptedStore(configStore, storeImpl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Values are compressed before being encrypted as encrypted data can't be compressed
+	if storeImpl != nil && configStore.Properties.IsSet("compression") {
+		return newCompressedStore(configStore, storeImpl)
+	}
 	return storeImpl, nil
 }
 
+// Wrap a store implementation to compress its values with the codec defined in the store properties
+func newCompressedStore(configStore config.Store, storeImpl store.Store) (store.Store, error) {
+	storeImpl, err := compressed.NewStore(storeImpl, compressed.StoreOptions{
+		Codec:       compressed.Codec(configStore.Properties.GetString("compression")),
+		MinSize:     configStore.Properties.GetIntOrDefault("compression_min_size", defaultCompressionMinSize),
+		ClearFields: getClearFields(configStore.Properties, "compression_clear_fields"),
+	})
+	return storeImpl, errors.Wrapf(err, "failed to setup compression for store with name:%q", configStore.Name)
+}
+
+// Returns the fields of values that should be stored as is to be queried, deploymentId by default
+func getClearFields(properties config.DynamicMap, name string) []string {
+	if properties.IsSet(name) {
+		return properties.GetStringSlice(name)
+	}
+	return []string{"deploymentId"}
+}
+
+// Wrap a store implementation to encrypt its values with the keys defined in the store properties
+func newEncryptedStore(configStore config.Store, storeImpl store.Store) (store.Store, error) {
+	keys, err := cast.ToStringMapStringE(configStore.Properties.Get("encryption_keys"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption_keys property for store with name:%q, expecting a map of keys IDs to passphrases", configStore.Name)
+	}
+	storeImpl, err = encrypted.NewStore(storeImpl, encrypted.StoreOptions{
+		Keys:        keys,
+		KeyID:       configStore.Properties.GetString("encryption_key_id"),
+		ClearFields: getClearFields(configStore.Properties, "encryption_clear_fields"),
+	})
+	return storeImpl, errors.Wrapf(err, "failed to setup encryption for store with name:%q", configStore.Name)
+}
+
+// Returns the store implementation eventually decorated by the given store
+func unwrapStore(s store.Store) store.Store {
+	for {
+		wrapper, ok := s.(store.Wrapper)
+		if !ok {
+			return s
+		}
+		s = wrapper.Unwrap()
+	}
+}
+
 // this allows to migrate log or events from Consul to new store implementations (other than Consul)
 func migrateData(storeName string, storeType types.StoreType, storeImpl store.Store) error {
 
@@ -421,3 +481,80 @@ func GetStore(tType types.StoreType) store.Store {
 	}
 	return store
 }
+
+// CheckStoresHealth returns the health of the stores relying on an external service, indexed by store type.
+func CheckStoresHealth(ctx context.Context) map[string]store.HealthStatus {
+	healths := make(map[string]store.HealthStatus)
+	for storeType, s := range stores {
+		if checker, ok := unwrapStore(s).(store.HealthChecker); ok {
+			healths[storeType.String()] = checker.Check(ctx)
+		}
+	}
+	return healths
+}
+
+// ExportStore writes the values stored under the given key by the store of the given type to w as NDJSON.
+// Values are exported as stored by the underlying store, an error is returned if this store doesn't support exports.
+func ExportStore(ctx context.Context, tType types.StoreType, k string, w io.Writer) (int, error) {
+	exporter, ok := unwrapStore(GetStore(tType)).(store.Exporter)
+	if !ok {
+		return 0, errors.Errorf("the store used for %s doesn't support exports", tType.String())
+	}
+	return exporter.Export(ctx, k, w)
+}
+
+// ReindexStore migrates the values stored under the given key by the store of the given type into new indexes using
+// the current mapping, an error is returned if this store doesn't support reindexing.
+func ReindexStore(ctx context.Context, tType types.StoreType, k string, dryRun bool) ([]store.ReindexResult, error) {
+	reindexer, ok := unwrapStore(GetStore(tType)).(store.Reindexer)
+	if !ok {
+		return nil, errors.Errorf("the store used for %s doesn't support reindexing", tType.String())
+	}
+	return reindexer.Reindex(ctx, k, dryRun)
+}
+
+// ListLevels lists the values stored under the given key by the store of the given type, as store.Store.List does,
+// keeping only the values having one of the given levels. The filter is applied by the store if it supports it,
+// values are filtered once retrieved otherwise. All values are returned if levels is empty.
+func ListLevels(ctx context.Context, tType types.StoreType, k string, levels []string, waitIndex uint64, timeout time.Duration) ([]store.KeyValueOut, uint64, error) {
+	s := GetStore(tType)
+	if len(levels) == 0 {
+		return s.List(ctx, k, waitIndex, timeout)
+	}
+	// Wrapped stores are not unwrapped as values may not be readable by the underlying store (if encrypted for instance)
+	if lister, ok := s.(store.LevelLister); ok {
+		return lister.ListLevels(ctx, k, levels, waitIndex, timeout)
+	}
+	kvps, lastIndex, err := s.List(ctx, k, waitIndex, timeout)
+	if err != nil {
+		return kvps, lastIndex, err
+	}
+	filtered := make([]store.KeyValueOut, 0, len(kvps))
+	for _, kvp := range kvps {
+		if collections.ContainsString(levels, cast.ToString(kvp.Value["level"])) {
+			filtered = append(filtered, kvp)
+		}
+	}
+	return filtered, lastIndex, nil
+}
+
+// CloseStores closes the stores that need it, for instance to send buffered data.
+func CloseStores() {
+	for storeType, s := range stores {
+		if closer, ok := unwrapStore(s).(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("[WARN] Failed to close store for %s: %+v", storeType.String(), err)
+			}
+		}
+	}
+}
+
+// AggregateStore counts the values stored under the given key by the store of the given type, grouped as defined by the request.
+// An error is returned if this store doesn't support aggregations.
+func AggregateStore(ctx context.Context, tType types.StoreType, k string, request store.AggregationRequest) ([]store.AggregationBucket, error) {
+	aggregator, ok := unwrapStore(GetStore(tType)).(store.Aggregator)
+	if !ok {
+		return nil, errors.Errorf("the store used for %s doesn't support aggregations", tType.String())
+	}
+	return aggregator.Aggregate(ctx, k, request)
+}
@@ -441,3 +441,73 @@ func testLoadStoresWithGeneratedName(t *testing.T, srv1 *testutil.TestServer, cf
 		})
 	}
 }
+
+func TestCreateStoreImplWithEncryption(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties config.DynamicMap
+		wantErr    bool
+	}{
+		{"SingleKey", config.DynamicMap{"encryption_keys": map[string]interface{}{"k1": "myverystrongpasswordo32bitlength"}}, false},
+		{"RotatedKeys", config.DynamicMap{
+			"encryption_keys":   map[string]interface{}{"k1": "myverystrongpasswordo32bitlength", "k2": "anotherstrongpasswordof32bitslen"},
+			"encryption_key_id": "k2",
+		}, false},
+		{"MissingKeyID", config.DynamicMap{
+			"encryption_keys": map[string]interface{}{"k1": "myverystrongpasswordo32bitlength", "k2": "anotherstrongpasswordof32bitslen"},
+		}, true},
+		{"InvalidKeys", config.DynamicMap{"encryption_keys": "myverystrongpasswordo32bitlength"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configStore := config.Store{Name: "myStore", Implementation: consulStoreImpl, Properties: tt.properties}
+			s, err := createStoreImpl(config.Configuration{}, configStore)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_, ok := s.(store.Wrapper)
+			require.True(t, ok, "store should be wrapped to encrypt values")
+			require.Equal(t, "*consul.consulStore", reflect.TypeOf(unwrapStore(s)).String())
+		})
+	}
+}
+
+func TestCreateStoreImplWithCompression(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties config.DynamicMap
+		wantTypes  []string
+		wantErr    bool
+	}{
+		{"Gzip", config.DynamicMap{"compression": "gzip"}, []string{"*compressed.compressedStore", "*consul.consulStore"}, false},
+		{"ZstdAndEncryption", config.DynamicMap{
+			"compression":     "zstd",
+			"encryption_keys": map[string]interface{}{"k1": "myverystrongpasswordo32bitlength"},
+		}, []string{"*compressed.compressedStore", "*encrypted.encryptedStore", "*consul.consulStore"}, false},
+		{"UnsupportedCodec", config.DynamicMap{"compression": "lz4"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configStore := config.Store{Name: "myStore", Implementation: consulStoreImpl, Properties: tt.properties}
+			s, err := createStoreImpl(config.Configuration{}, configStore)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			// Values should be compressed before being encrypted
+			var types []string
+			for {
+				types = append(types, reflect.TypeOf(s).String())
+				wrapper, ok := s.(store.Wrapper)
+				if !ok {
+					break
+				}
+				s = wrapper.Unwrap()
+			}
+			require.Equal(t, tt.wantTypes, types)
+		})
+	}
+}
@@ -158,7 +158,7 @@ func (s *step) isRunnable(ctx context.Context) (bool, error) {
 // run allows to execute a workflow step
 func (s *step) run(ctx context.Context, cfg config.Configuration, deploymentID string, bypassErrors bool, workflowName string, w *worker) error {
 	// Fill log optional fields for log registration
-	ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{events.WorkFlowID: workflowName, events.NodeID: s.Target, events.TaskExecutionID: s.t.id})
+	ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{events.WorkFlowID: workflowName, events.WorkflowStepID: s.Name, events.NodeID: s.Target, events.TaskExecutionID: s.t.id})
 	// First: we check if Step is runnable
 	if runnable, err := s.isRunnable(ctx); err != nil {
 		return err
@@ -454,9 +454,10 @@ func (w *worker) runAction(ctx context.Context, t *taskExecution) error {
 	if action.AsyncOperation.TaskID != "" {
 		ctx = operations.SetOperationLogFields(ctx, action.AsyncOperation.Operation)
 		ctx = events.AddLogOptionalFields(ctx, events.LogOptionalFields{
-			events.ExecutionID: action.AsyncOperation.TaskID,
-			events.WorkFlowID:  action.AsyncOperation.WorkflowName,
-			events.NodeID:      action.AsyncOperation.NodeName,
+			events.ExecutionID:    action.AsyncOperation.TaskID,
+			events.WorkFlowID:     action.AsyncOperation.WorkflowName,
+			events.WorkflowStepID: action.AsyncOperation.StepName,
+			events.NodeID:         action.AsyncOperation.NodeName,
 		})
 		// Monitor parent task for failure & cancellation
 		var cf context.CancelFunc
@@ -17,7 +17,9 @@ package types
 // SlurmExecutionOptions is a yorc.datatypes.slurm.ExecutionOptions
 type SlurmExecutionOptions struct {
 	Command         string   `mapstructure:"command" json:"command,omitempty"`
+	CommandLine     []string `mapstructure:"command_line" json:"command_line,omitempty"`
 	Args            []string `mapstructure:"args" json:"args,omitempty"`
 	EnvVars         []string `mapstructure:"env_vars" json:"env_vars,omitempty"`
 	InScriptOptions []string `mapstructure:"in_script_options" json:"in_script_options,omitempty"`
+	ExportEnv       string   `mapstructure:"export_env" json:"export_env,omitempty"`
 }